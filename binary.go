@@ -0,0 +1,636 @@
+package main
+
+// See https://webassembly.github.io/spec/core/binary/index.html
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+const binaryVersion = 1
+
+// Section IDs.
+const (
+	sectionType     byte = 1
+	sectionImport   byte = 2
+	sectionFunction byte = 3
+	sectionTable    byte = 4
+	sectionMemory   byte = 5
+	sectionGlobal   byte = 6
+	sectionExport   byte = 7
+	sectionStart    byte = 8
+	sectionElement  byte = 9
+	sectionCode     byte = 10
+	sectionData     byte = 11
+)
+
+const (
+	blockTypeEmpty byte = 0x40
+	typeFunc       byte = 0x60
+	typeFuncRef    byte = 0x70
+	externFunc     byte = 0x00
+	externMemory   byte = 0x02
+)
+
+var valueTypeCodes = map[string]byte{
+	"i32": 0x7f,
+	"i64": 0x7e,
+	"f32": 0x7d,
+	"f64": 0x7c,
+}
+
+var opcodes = map[string]byte{
+	"unreachable":   0x00,
+	"nop":           0x01,
+	"block":         0x02,
+	"loop":          0x03,
+	"if":            0x04,
+	"else":          0x05,
+	"end":           0x0b,
+	"br":            0x0c,
+	"br_if":         0x0d,
+	"br_table":      0x0e,
+	"return":        0x0f,
+	"call":          0x10,
+	"call_indirect": 0x11,
+	"drop":          0x1a,
+	"select":        0x1b,
+	"local.get":     0x20,
+	"local.set":     0x21,
+	"local.tee":     0x22,
+	"global.get":    0x23,
+	"global.set":    0x24,
+
+	"i32.load":     0x28,
+	"i64.load":     0x29,
+	"f32.load":     0x2a,
+	"f64.load":     0x2b,
+	"i32.load8_s":  0x2c,
+	"i32.load8_u":  0x2d,
+	"i32.load16_s": 0x2e,
+	"i32.load16_u": 0x2f,
+	"i64.load8_s":  0x30,
+	"i64.load8_u":  0x31,
+	"i64.load16_s": 0x32,
+	"i64.load16_u": 0x33,
+	"i64.load32_s": 0x34,
+	"i64.load32_u": 0x35,
+	"i32.store":    0x36,
+	"i64.store":    0x37,
+	"f32.store":    0x38,
+	"f64.store":    0x39,
+	"i32.store8":   0x3a,
+	"i32.store16":  0x3b,
+	"i64.store8":   0x3c,
+	"i64.store16":  0x3d,
+	"i64.store32":  0x3e,
+	"memory.size":  0x3f,
+	"memory.grow":  0x40,
+
+	"i32.const": 0x41,
+	"i64.const": 0x42,
+	"f32.const": 0x43,
+	"f64.const": 0x44,
+
+	"i32.eqz":  0x45,
+	"i32.eq":   0x46,
+	"i32.ne":   0x47,
+	"i32.lt_s": 0x48,
+	"i32.lt_u": 0x49,
+	"i32.gt_s": 0x4a,
+	"i32.gt_u": 0x4b,
+	"i32.le_s": 0x4c,
+	"i32.le_u": 0x4d,
+	"i32.ge_s": 0x4e,
+	"i32.ge_u": 0x4f,
+	"i64.eqz":  0x50,
+	"i64.eq":   0x51,
+	"i64.ne":   0x52,
+	"i64.lt_s": 0x53,
+	"i64.lt_u": 0x54,
+	"i64.gt_s": 0x55,
+	"i64.gt_u": 0x56,
+	"i64.le_s": 0x57,
+	"i64.le_u": 0x58,
+	"i64.ge_s": 0x59,
+	"i64.ge_u": 0x5a,
+	"f32.eq":   0x5b,
+	"f32.ne":   0x5c,
+	"f32.lt":   0x5d,
+	"f32.gt":   0x5e,
+	"f32.le":   0x5f,
+	"f32.ge":   0x60,
+	"f64.eq":   0x61,
+	"f64.ne":   0x62,
+	"f64.lt":   0x63,
+	"f64.gt":   0x64,
+	"f64.le":   0x65,
+	"f64.ge":   0x66,
+
+	"i32.clz":    0x67,
+	"i32.ctz":    0x68,
+	"i32.popcnt": 0x69,
+	"i32.add":    0x6a,
+	"i32.sub":    0x6b,
+	"i32.mul":    0x6c,
+	"i32.div_s":  0x6d,
+	"i32.div_u":  0x6e,
+	"i32.rem_s":  0x6f,
+	"i32.rem_u":  0x70,
+	"i32.and":    0x71,
+	"i32.or":     0x72,
+	"i32.xor":    0x73,
+	"i32.shl":    0x74,
+	"i32.shr_s":  0x75,
+	"i32.shr_u":  0x76,
+	"i32.rotl":   0x77,
+	"i32.rotr":   0x78,
+	"i64.clz":    0x79,
+	"i64.ctz":    0x7a,
+	"i64.popcnt": 0x7b,
+	"i64.add":    0x7c,
+	"i64.sub":    0x7d,
+	"i64.mul":    0x7e,
+	"i64.div_s":  0x7f,
+	"i64.div_u":  0x80,
+	"i64.rem_s":  0x81,
+	"i64.rem_u":  0x82,
+	"i64.and":    0x83,
+	"i64.or":     0x84,
+	"i64.xor":    0x85,
+	"i64.shl":    0x86,
+	"i64.shr_s":  0x87,
+	"i64.shr_u":  0x88,
+	"i64.rotl":   0x89,
+	"i64.rotr":   0x8a,
+
+	"f32.abs":      0x8b,
+	"f32.neg":      0x8c,
+	"f32.ceil":     0x8d,
+	"f32.floor":    0x8e,
+	"f32.trunc":    0x8f,
+	"f32.nearest":  0x90,
+	"f32.sqrt":     0x91,
+	"f32.add":      0x92,
+	"f32.sub":      0x93,
+	"f32.mul":      0x94,
+	"f32.div":      0x95,
+	"f32.min":      0x96,
+	"f32.max":      0x97,
+	"f32.copysign": 0x98,
+	"f64.abs":      0x99,
+	"f64.neg":      0x9a,
+	"f64.ceil":     0x9b,
+	"f64.floor":    0x9c,
+	"f64.trunc":    0x9d,
+	"f64.nearest":  0x9e,
+	"f64.sqrt":     0x9f,
+	"f64.add":      0xa0,
+	"f64.sub":      0xa1,
+	"f64.mul":      0xa2,
+	"f64.div":      0xa3,
+	"f64.min":      0xa4,
+	"f64.max":      0xa5,
+	"f64.copysign": 0xa6,
+
+	"i32.wrap_i64":        0xa7,
+	"i32.trunc_f32_s":     0xa8,
+	"i32.trunc_f32_u":     0xa9,
+	"i32.trunc_f64_s":     0xaa,
+	"i32.trunc_f64_u":     0xab,
+	"i64.extend_i32_s":    0xac,
+	"i64.extend_i32_u":    0xad,
+	"i64.trunc_f32_s":     0xae,
+	"i64.trunc_f32_u":     0xaf,
+	"i64.trunc_f64_s":     0xb0,
+	"i64.trunc_f64_u":     0xb1,
+	"f32.convert_i32_s":   0xb2,
+	"f32.convert_i32_u":   0xb3,
+	"f32.convert_i64_s":   0xb4,
+	"f32.convert_i64_u":   0xb5,
+	"f32.demote_f64":      0xb6,
+	"f64.convert_i32_s":   0xb7,
+	"f64.convert_i32_u":   0xb8,
+	"f64.convert_i64_s":   0xb9,
+	"f64.convert_i64_u":   0xba,
+	"f64.promote_f32":     0xbb,
+	"i32.reinterpret_f32": 0xbc,
+	"i64.reinterpret_f64": 0xbd,
+	"f32.reinterpret_i32": 0xbe,
+	"f64.reinterpret_i64": 0xbf,
+	"i32.extend8_s":       0xc0,
+	"i32.extend16_s":      0xc1,
+	"i64.extend8_s":       0xc2,
+	"i64.extend16_s":      0xc3,
+	"i64.extend32_s":      0xc4,
+}
+
+// prefixSaturating is the prefix of the saturating truncations, which are
+// followed by their index in satOpcodes.
+const prefixSaturating byte = 0xfc
+
+var satOpcodes = map[string]uint32{
+	"i32.trunc_sat_f32_s": 0,
+	"i32.trunc_sat_f32_u": 1,
+	"i32.trunc_sat_f64_s": 2,
+	"i32.trunc_sat_f64_u": 3,
+	"i64.trunc_sat_f32_s": 4,
+	"i64.trunc_sat_f32_u": 5,
+	"i64.trunc_sat_f64_s": 6,
+	"i64.trunc_sat_f64_u": 7,
+}
+
+// WasmBinaryWriter encodes a module in the WebAssembly binary format.
+type WasmBinaryWriter struct {
+	b           *bytes.Buffer
+	module      *WasmModule
+	typeIndex   map[*WasmTypeFunc]int
+	importIndex map[*WasmImport]int
+	funcIndex   map[*WasmFunc]int
+	localIndex  map[string]int
+	labels      []string
+}
+
+func NewWasmBinaryWriter() *WasmBinaryWriter {
+	return &WasmBinaryWriter{
+		b:           &bytes.Buffer{},
+		typeIndex:   make(map[*WasmTypeFunc]int),
+		importIndex: make(map[*WasmImport]int),
+		funcIndex:   make(map[*WasmFunc]int),
+	}
+}
+
+func (w *WasmBinaryWriter) WriteToFile(name string) (int, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.Write(w.b.Bytes())
+}
+
+func (w *WasmBinaryWriter) writeByte(b byte) {
+	w.b.WriteByte(b)
+}
+
+func (w *WasmBinaryWriter) writeBytes(b []byte) {
+	w.b.Write(b)
+}
+
+func (w *WasmBinaryWriter) writeU32(v uint32) {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			b |= 0x80
+		}
+		w.writeByte(b)
+		if v == 0 {
+			return
+		}
+	}
+}
+
+func (w *WasmBinaryWriter) writeS64(v int64) {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		done := (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0)
+		if !done {
+			b |= 0x80
+		}
+		w.writeByte(b)
+		if done {
+			return
+		}
+	}
+}
+
+func (w *WasmBinaryWriter) writeS32(v int32) {
+	w.writeS64(int64(v))
+}
+
+func (w *WasmBinaryWriter) writeF32(v float32) {
+	bits := math.Float32bits(v)
+	for i := 0; i < 4; i++ {
+		w.writeByte(byte(bits >> uint(8*i)))
+	}
+}
+
+func (w *WasmBinaryWriter) writeF64(v float64) {
+	bits := math.Float64bits(v)
+	for i := 0; i < 8; i++ {
+		w.writeByte(byte(bits >> uint(8*i)))
+	}
+}
+
+func (w *WasmBinaryWriter) writeName(name string) {
+	w.writeU32(uint32(len(name)))
+	w.writeBytes([]byte(name))
+}
+
+func (w *WasmBinaryWriter) writeOpcode(name string) {
+	if sub, ok := satOpcodes[name]; ok {
+		w.writeByte(prefixSaturating)
+		w.writeU32(sub)
+		return
+	}
+	op, ok := opcodes[name]
+	if !ok {
+		panic(fmt.Errorf("unknown opcode: %s", name))
+	}
+	w.writeByte(op)
+}
+
+func (w *WasmBinaryWriter) writeValueType(t WasmType) {
+	name := wasmTypeName(t)
+	code, ok := valueTypeCodes[name]
+	if !ok {
+		panic(fmt.Errorf("type %s is not a WASM value type", t.getName()))
+	}
+	w.writeByte(code)
+}
+
+// writeMemArg writes the alignment hint, as a power of 2, and the offset of a
+// load or a store.
+func (w *WasmBinaryWriter) writeMemArg(align int, offset uint32) {
+	log := 0
+	for 1<<(log+1) <= align {
+		log++
+	}
+	w.writeU32(uint32(log))
+	w.writeU32(offset)
+}
+
+// section writes the section header followed by the contents produced by body.
+func (w *WasmBinaryWriter) section(id byte, body func()) {
+	outer := w.b
+	w.b = &bytes.Buffer{}
+	body()
+	content := w.b
+	w.b = outer
+	w.writeByte(id)
+	w.writeU32(uint32(content.Len()))
+	w.writeBytes(content.Bytes())
+}
+
+// encodeStmt encodes an expression in a statement position, i.e., values it
+// produces are dropped.
+func (w *WasmBinaryWriter) encodeStmt(e WasmExpression) {
+	e.encode(w)
+	for i := numValues(e); i > 0; i-- {
+		w.writeOpcode("drop")
+	}
+}
+
+func (w *WasmBinaryWriter) pushLabel(label string) {
+	w.labels = append(w.labels, label)
+}
+
+func (w *WasmBinaryWriter) popLabel() {
+	w.labels = w.labels[:len(w.labels)-1]
+}
+
+func (w *WasmBinaryWriter) labelDepth(label string) uint32 {
+	for i := len(w.labels) - 1; i >= 0; i-- {
+		if w.labels[i] == label {
+			return uint32(len(w.labels) - 1 - i)
+		}
+	}
+	panic(fmt.Errorf("undefined label: %s", label))
+}
+
+func (w *WasmBinaryWriter) getLocalIndex(name string) uint32 {
+	idx, ok := w.localIndex[name]
+	if !ok {
+		panic(fmt.Errorf("undefined local: %s", name))
+	}
+	return uint32(idx)
+}
+
+// numValues returns the number of values e leaves on the operand stack.
+func numValues(e WasmExpression) int {
+	switch e := e.(type) {
+	default:
+		return 0
+	case *WasmValue, *WasmGetLocal, *WasmGetGlobal, *WasmBinOp, *WasmSelect, *WasmLoad, *WasmIntrinsic, *WasmConvert:
+		return 1
+	case *WasmSequence:
+		return numValues(e.value)
+	case *WasmCall:
+		if e.def == nil {
+			return 0
+		}
+		return len(e.def.results)
+	case *WasmCallIndirect:
+		return len(e.signature.results)
+	case *WasmUnwindCheck:
+		return numValues(e.call)
+	case *WasmCallImport:
+		if e.i.result == nil {
+			return 0
+		}
+		return 1
+	}
+}
+
+// wasmTypeName returns the name of the WASM value type used to represent t.
+func wasmTypeName(t WasmType) string {
+	switch t := t.(type) {
+	default:
+		return "i32"
+	case *WasmTypeScalar:
+		return t.getName()
+	}
+}
+
+func parseIntValue(value string) (int64, error) {
+	i, err := strconv.ParseInt(value, 0, 64)
+	if err == nil {
+		return i, nil
+	}
+	u, err := strconv.ParseUint(value, 0, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(u), nil
+}
+
+func (m *WasmModule) sortedImports() []*WasmImport {
+	names := make([]string, 0, len(m.imports))
+	for name := range m.imports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]*WasmImport, 0, len(names))
+	for _, name := range names {
+		result = append(result, m.imports[name])
+	}
+	return result
+}
+
+func (m *WasmModule) encode(w *WasmBinaryWriter) {
+	w.module = m
+	imports := m.sortedImports()
+	for i, t := range m.signatures.order {
+		w.typeIndex[t] = i
+	}
+	for i, imp := range imports {
+		w.importIndex[imp] = i
+	}
+	for i, f := range m.functions {
+		w.funcIndex[f] = len(imports) + i
+	}
+
+	w.writeBytes([]byte{0x00, 0x61, 0x73, 0x6d}) // "\0asm"
+	w.writeBytes([]byte{binaryVersion, 0, 0, 0})
+
+	w.section(sectionType, func() {
+		w.writeU32(uint32(len(m.signatures.order)))
+		for _, t := range m.signatures.order {
+			t.encodeType(w)
+		}
+	})
+	if len(imports) > 0 {
+		w.section(sectionImport, func() {
+			w.writeU32(uint32(len(imports)))
+			for _, imp := range imports {
+				imp.encode(w)
+			}
+		})
+	}
+	w.section(sectionFunction, func() {
+		w.writeU32(uint32(len(m.functions)))
+		for _, f := range m.functions {
+			if f.signature == nil {
+				panic(fmt.Errorf("function %s has no signature", f.name))
+			}
+			w.writeU32(uint32(w.typeIndex[f.signature]))
+		}
+	})
+	m.funcPtrTable.encodeTable(w)
+	w.section(sectionMemory, func() {
+		w.writeU32(1)
+		m.memory.encodeLimits(w)
+	})
+	m.encodeExports(w)
+	if m.start != nil {
+		w.section(sectionStart, func() {
+			w.writeU32(uint32(w.funcIndex[m.start]))
+		})
+	}
+	m.funcPtrTable.encodeElements(w)
+	w.section(sectionCode, func() {
+		w.writeU32(uint32(len(m.functions)))
+		for _, f := range m.functions {
+			f.encode(w)
+		}
+	})
+	m.memory.encodeData(w)
+}
+
+func (m *WasmModule) encodeExports(w *WasmBinaryWriter) {
+	exports := make([]*WasmFunc, 0, len(m.functions))
+	for _, f := range m.functions {
+		if f.isExported() {
+			exports = append(exports, f)
+		}
+	}
+	w.section(sectionExport, func() {
+		w.writeU32(uint32(len(exports) + 1))
+		for _, f := range exports {
+			w.writeName(f.origName)
+			w.writeByte(externFunc)
+			if f.entry != nil {
+				w.writeU32(uint32(w.funcIndex[f.entry]))
+			} else {
+				w.writeU32(uint32(w.funcIndex[f]))
+			}
+		}
+		w.writeName(memoryExportName)
+		w.writeByte(externMemory)
+		w.writeU32(0)
+	})
+}
+
+func (tab *WasmFunctionTable) sorted() []*WasmFunc {
+	sorted := make([]*WasmFunc, len(tab.funcIndex))
+	for fn, i := range tab.funcIndex {
+		sorted[i] = fn
+	}
+	return sorted
+}
+
+func (tab *WasmFunctionTable) encodeTable(w *WasmBinaryWriter) {
+	length := uint32(len(tab.funcIndex))
+	if length == 0 && !tab.indirect {
+		return
+	}
+	w.section(sectionTable, func() {
+		w.writeU32(1)
+		w.writeByte(typeFuncRef)
+		w.writeByte(0x01) // limits with a maximum
+		w.writeU32(length)
+		w.writeU32(length)
+	})
+}
+
+func (tab *WasmFunctionTable) encodeElements(w *WasmBinaryWriter) {
+	if len(tab.funcIndex) == 0 {
+		return
+	}
+	w.section(sectionElement, func() {
+		w.writeU32(1)
+		w.writeU32(0) // active segment for table 0
+		w.writeOpcode("i32.const")
+		w.writeS32(0)
+		w.writeOpcode("end")
+		sorted := tab.sorted()
+		w.writeU32(uint32(len(sorted)))
+		for _, fn := range sorted {
+			w.writeU32(uint32(w.funcIndex[fn]))
+		}
+	})
+}
+
+func (t *WasmTypeFunc) encodeType(w *WasmBinaryWriter) {
+	w.writeByte(typeFunc)
+	w.writeU32(uint32(len(t.params)))
+	for _, p := range t.params {
+		w.writeValueType(p)
+	}
+	w.writeU32(uint32(len(t.results)))
+	for _, r := range t.results {
+		w.writeValueType(r)
+	}
+}
+
+func (memory *WasmMemory) encodeLimits(w *WasmBinaryWriter) {
+	if memory.maxPages != 0 {
+		w.writeByte(0x01) // limits with a maximum
+		w.writeU32(uint32(memory.pages()))
+		w.writeU32(uint32(memory.maxPages))
+		return
+	}
+	w.writeByte(0x00) // limits without a maximum
+	w.writeU32(uint32(memory.pages()))
+}
+
+func (memory *WasmMemory) encodeData(w *WasmBinaryWriter) {
+	if len(memory.content) == 0 {
+		return
+	}
+	w.section(sectionData, func() {
+		w.writeU32(1)
+		w.writeU32(0) // active segment for memory 0
+		w.writeOpcode("i32.const")
+		w.writeS32(0)
+		w.writeOpcode("end")
+		w.writeU32(uint32(len(memory.content)))
+		w.writeBytes(memory.content)
+	})
+}
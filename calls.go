@@ -3,14 +3,9 @@ package main
 import (
 	"fmt"
 	"go/ast"
+	"go/types"
 )
 
-type WasmFuncPtr struct {
-	WasmExprBase
-	def *WasmFunc
-	idx WasmExpression
-}
-
 type WasmCallBase struct {
 	WasmExprBase
 	args []WasmExpression
@@ -30,20 +25,61 @@ type WasmCallIndirect struct {
 	name      string
 	signature *WasmTypeFunc
 	index     WasmExpression
+	before    WasmExpression // stores an operand used twice, see useTwice
 }
 
 func (s *WasmScope) parseArgs(args []ast.Expr, indent int) ([]WasmExpression, error) {
 	result := make([]WasmExpression, 0, len(args))
 	for i, arg := range args {
-		e, err := s.parseExpr(arg, nil, indent) // TODO: Should the hint be nil?
+		e, err := s.parseExpr(arg, indent)
 		if err != nil {
-			return nil, fmt.Errorf("couldn't parse arg #%d: %v", i, err)
+			return nil, fmt.Errorf("couldn't parse arg #%d: %w", i, err)
 		}
 		result = append(result, e)
 	}
 	return result, nil
 }
 
+// parseCallArgs parses the arguments of a call of a function with signature
+// sig. Arguments passed to interface parameters are converted to them.
+func (s *WasmScope) parseCallArgs(args []ast.Expr, sig *types.Signature, indent int) ([]WasmExpression, error) {
+	params := sig.Params()
+	result := make([]WasmExpression, 0, len(args))
+	for i, arg := range args {
+		var t types.Type
+		if i < params.Len() && !(sig.Variadic() && i >= params.Len()-1) {
+			t = params.At(i).Type()
+		}
+		e, err := s.parseExprAs(arg, t, indent)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse arg #%d: %w", i, err)
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// resultTypes returns the types of the values produced by e. A call may
+// produce multiple values.
+func resultTypes(e WasmExpression) []WasmType {
+	switch e := e.(type) {
+	case *WasmCall:
+		types := make([]WasmType, len(e.def.results))
+		for i, r := range e.def.results {
+			types[i] = r.t
+		}
+		return types
+	case *WasmCallIndirect:
+		return e.signature.results
+	case *WasmUnwindCheck:
+		return resultTypes(e.call)
+	}
+	if numValues(e) == 0 {
+		return nil
+	}
+	return []WasmType{e.getType()}
+}
+
 func (s *WasmScope) createCallExprWithArgs(call *ast.CallExpr, name string, fn *WasmFunc, args []WasmExpression, indent int) (WasmExpression, error) {
 	c := &WasmCall{
 		name: name,
@@ -54,86 +90,106 @@ func (s *WasmScope) createCallExprWithArgs(call *ast.CallExpr, name string, fn *
 	c.setIndent(indent)
 	c.setNode(call)
 	c.setScope(s)
-	if fn.result != nil {
-		c.setFullType(fn.result.t)
+	if len(fn.results) > 0 {
+		c.setFullType(fn.results[0].t)
 	}
 	return c, nil
 }
 
 func (s *WasmScope) createCallExpr(call *ast.CallExpr, name string, fn *WasmFunc, indent int) (WasmExpression, error) {
-	args, err := s.parseArgs(call.Args, indent+1)
+	args, err := s.parseCallArgs(call.Args, s.f.file.info.TypeOf(call.Fun).(*types.Signature), indent+1)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing args to function %s: %v", name, err)
+		return nil, fmt.Errorf("error parsing args to function %s: %w", name, err)
 	}
 	return s.createCallExprWithArgs(call, name, fn, args, indent)
 }
 
-func (s *WasmScope) createIndirectCallExpr(call *ast.CallExpr, name string, ident *ast.Ident, indent int) (WasmExpression, error) {
-	idx, err := s.parseIdent(ident, indent+1)
-	if err != nil {
-		return nil, fmt.Errorf("call_indirect, couldn't create expression for the table index")
-	}
-	args, err := s.parseArgs(call.Args, indent+1)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing args to function %s: %v", name, err)
-	}
-	c := &WasmCallIndirect{
-		name:  name,
-		index: idx,
-	}
-	c.args = args
-	c.call = call
-	c.setIndent(indent)
-	c.setNode(call)
-	c.setScope(s)
-	switch ty := idx.getType().(type) {
-	default:
-		return nil, s.f.file.ErrorNode(call, "unimplemented expression type: %v", ty)
-	case *WasmTypeFunc:
-		c.signature = ty
-	}
-	return c, nil
-}
-
 func (s *WasmScope) parseCallExpr(call *ast.CallExpr, indent int) (WasmExpression, error) {
+	if tv := s.f.file.info.Types[call.Fun]; tv.IsType() {
+		// A conversion, e.g., T(x), (*T)(p) or pkg.T(x).
+		if isInterface(tv.Type) {
+			return s.parseExprAs(call.Args[0], tv.Type, indent)
+		}
+		typ, err := s.f.file.parseAstType(call.Fun)
+		if err != nil {
+			return nil, s.f.file.ErrorNode(call, "%v", err)
+		}
+		return s.parseConvertExpr(typ, call, indent)
+	}
 	switch fun := call.Fun.(type) {
-	default:
-		return nil, s.f.file.ErrorNode(call, "unimplemented function")
 	case *ast.Ident:
-		typ, err := s.f.file.parseAstType(fun)
-		if err == nil && len(call.Args) == 1 {
-			return s.parseConvertExpr(typ, call.Args[0], indent)
+		obj := s.f.file.objectOf(fun)
+		if b, ok := obj.(*types.Builtin); ok {
+			return s.parseBuiltinCall(call, b.Name(), indent)
 		}
-
-		var name string
-		fn, ok := s.f.module.functionMap2[fun.Obj]
-		if ok {
-			name = fn.name
-		} else {
-			name = astNameToWASM(fun.Name, nil)
+		if fn, ok := s.f.module.functionMap2[obj]; ok {
+			return s.createCallExpr(call, fn.name, fn, indent)
 		}
-
-		// TODO: Make it work for forward references.
-		decl, ok := fun.Obj.Decl.(*ast.FuncDecl)
-		if ok {
-			return s.createCallExpr(call, name, s.f.module.functionMap[decl], indent)
-		} else {
-			_, ok := s.f.module.variables[fun.Obj]
-			if !ok {
-				return nil, fmt.Errorf("function %s undefined (forward reference?)", name)
-			}
-			return s.createIndirectCallExpr(call, name, fun, indent)
+		if _, ok := s.f.module.variables[obj]; ok {
+			return s.createIndirectCallExpr(call, fun.Name, fun, indent)
 		}
+		return nil, s.f.file.ErrorNode(call, "unimplemented function: %s", fun.Name)
 	case *ast.ParenExpr:
-		typ, err := s.f.file.parseAstType(fun.X)
-		if err == nil && len(call.Args) == 1 {
-			return s.parseConvertExpr(typ, call.Args[0], indent)
+		if s.isFuncValue(fun) {
+			return s.createIndirectCallExpr(call, "", fun, indent)
 		}
 		return nil, s.f.file.ErrorNode(call, "unimplemented function: ParenExpr")
 	case *ast.SelectorExpr:
 		return s.parseCallExprSelector(call, fun, indent)
 	}
-	return nil, fmt.Errorf("unimplemented call expression at %s", positionString(call.Lparen, s.f.fset))
+	if s.isFuncValue(call.Fun) {
+		return s.createIndirectCallExpr(call, "", call.Fun, indent)
+	}
+	return nil, s.f.file.ErrorNode(call, "unimplemented call expression")
+}
+
+// parseBuiltinCall handles calls to the predeclared functions. Calls that the
+// type checker evaluated to constants, e.g., len of an array, don't get here.
+func (s *WasmScope) parseBuiltinCall(call *ast.CallExpr, name string, indent int) (WasmExpression, error) {
+	switch name {
+	case "append":
+		return s.parseAppendCall(call, indent)
+	case "cap":
+		if isSlice(s.f.file.info.TypeOf(call.Args[0])) {
+			return s.parseSliceLen(call, name, indent)
+		}
+		if isChan(s.f.file.info.TypeOf(call.Args[0])) {
+			return s.parseChanLen(call, name, indent)
+		}
+	case "close":
+		return s.parseChanClose(call, indent)
+	case "copy":
+		return s.parseCopyCall(call, indent)
+	case "delete":
+		return s.parseMapDelete(call, indent)
+	case "len":
+		t := s.f.file.info.TypeOf(call.Args[0])
+		if isString(t) {
+			return s.parseStringLen(call, indent)
+		}
+		if isSlice(t) {
+			return s.parseSliceLen(call, name, indent)
+		}
+		if isMap(t) {
+			return s.parseMapLen(call, indent)
+		}
+		if isChan(t) {
+			return s.parseChanLen(call, name, indent)
+		}
+	case "make":
+		if isMap(s.f.file.info.TypeOf(call)) {
+			return s.parseMapMake(call, indent)
+		}
+		if isChan(s.f.file.info.TypeOf(call)) {
+			return s.parseChanMake(call, indent)
+		}
+		return s.parseMakeCall(call, indent)
+	case "panic":
+		return s.parsePanicCall(call, indent)
+	case "recover":
+		return s.createTypedRuntimeCall("panics", "Recover", nil, call, indent)
+	}
+	return nil, s.f.file.ErrorNode(call, "unimplemented builtin function: %s", name)
 }
 
 func (s *WasmScope) parseUnsafePkgCall(ident *ast.Ident, call *ast.CallExpr, indent int) (WasmExpression, error) {
@@ -147,7 +203,7 @@ func (s *WasmScope) parseUnsafePkgCall(ident *ast.Ident, call *ast.CallExpr, ind
 			return nil, s.f.file.ErrorNode(call, "%v", err)
 		}
 		if len(call.Args) == 1 {
-			return s.parseConvertExpr(t, call.Args[0], indent)
+			return s.parseConvertExpr(t, call, indent)
 		} else {
 			return nil, s.f.file.ErrorNode(call, "unexpected number of arguments to unsafe.Pointer")
 		}
@@ -155,15 +211,21 @@ func (s *WasmScope) parseUnsafePkgCall(ident *ast.Ident, call *ast.CallExpr, ind
 }
 
 func (s *WasmScope) parseCallExprSelector(call *ast.CallExpr, se *ast.SelectorExpr, indent int) (WasmExpression, error) {
+	if sel, ok := s.f.file.info.Selections[se]; ok {
+		if sel.Kind() == types.FieldVal {
+			return s.createIndirectCallExpr(call, se.Sel.Name, se, indent)
+		}
+		return s.parseMethodCall(call, se, sel, indent)
+	}
 	switch x := se.X.(type) {
 	default:
 		return nil, fmt.Errorf("unimplemented X in selector: %v", x)
 	case *ast.Ident:
 		pkgShort := x.Name
-		switch pkgShort {
-		case "unsafe":
+		if pkgShort == "unsafe" {
 			return s.parseUnsafePkgCall(se.Sel, call, indent)
-		case "v8", "wasm":
+		}
+		if hostPackages[pkgShort] {
 			return s.parseWASMRuntimeCall(pkgShort, se.Sel, call, indent)
 		}
 		pkgLong, ok := s.f.file.imports[pkgShort]
@@ -179,35 +241,33 @@ func (s *WasmScope) parseCallExprSelector(call *ast.CallExpr, se *ast.SelectorEx
 	return nil, fmt.Errorf("unimplemented selector in a call expression, X: %v, sel: %v", se.X, se.Sel)
 }
 
+// parseFuncIdent returns the value of a top-level function or of a method
+// expression, whose closure is in static memory.
 func (s *WasmScope) parseFuncIdent(ident *ast.Ident, fn *WasmFunc, indent int) (WasmExpression, error) {
-	fn.prepareForIndirectCall()
-	idx, err := s.createLiteralInt32(int32(fn.tabIndex), indent+1)
+	addr, err := s.f.module.funcValue(fn)
 	if err != nil {
-		return nil, fmt.Errorf("error creating table index: %v", err)
+		return nil, s.f.file.ErrorNode(ident, "%v", err)
 	}
-	idx.setComment(fmt.Sprintf("function index for %s", fn.name))
-	idx.setNode(ident)
-	idx.setScope(s)
-	fptr := &WasmFuncPtr{
-		def: fn,
-		idx: idx,
+	v, err := s.createLiteralInt32(addr, indent)
+	if err != nil {
+		return nil, err
 	}
-	fptr.setNode(ident)
-	fptr.setScope(s)
-	return fptr, nil
-}
-
-func (f *WasmFuncPtr) getType() WasmType {
-	return f.def.signature
-}
-
-func (f *WasmFuncPtr) print(writer FormattingWriter) {
-	f.idx.print(writer)
+	v.setComment(fmt.Sprintf("function value %s", fn.name))
+	v.setNode(ident)
+	v.setScope(s)
+	v.setFullType(fn.signature)
+	return v, nil
 }
 
+// getType returns the type of the first result, unless a conversion or the
+// compiler set the type of the value, e.g., for a runtime call that returns
+// a uintptr which holds a value of another type.
 func (c *WasmCall) getType() WasmType {
-	if c.def != nil {
-		return c.def.result.t
+	if c.ty != nil {
+		return c.ty
+	}
+	if c.def != nil && len(c.def.results) > 0 {
+		return c.def.results[0].t
 	}
 	return nil
 }
@@ -220,19 +280,55 @@ func (c *WasmCall) print(writer FormattingWriter) {
 	writer.PrintfIndent(c.getIndent(), ") ;; call %s\n", c.name)
 }
 
+func (c *WasmCall) encode(writer *WasmBinaryWriter) {
+	for _, arg := range c.args {
+		arg.encode(writer)
+	}
+	writer.writeOpcode("call")
+	writer.writeU32(uint32(writer.funcIndex[c.def]))
+}
+
+// getType returns the type of the first result, if any.
 func (c *WasmCallIndirect) getType() WasmType {
-	return c.signature
+	if len(c.signature.results) > 0 {
+		return c.signature.results[0]
+	}
+	return nil
 }
 
 func (c *WasmCallIndirect) print(writer FormattingWriter) {
-	writer.PrintfIndent(c.getIndent(), "(call_indirect %s%s\n", c.signature.wasmName, c.getComment())
-	c.index.print(writer)
+	if c.before != nil {
+		printStmt(writer, c.before)
+	}
+	if legacySyntax {
+		writer.PrintfIndent(c.getIndent(), "(call_indirect %s%s\n", c.signature.wasmName, c.getComment())
+		c.index.print(writer)
+	} else {
+		writer.PrintfIndent(c.getIndent(), "(call_indirect (type %s)%s\n", c.signature.wasmName, c.getComment())
+	}
 	for _, arg := range c.args {
 		arg.print(writer)
 	}
+	if !legacySyntax {
+		// The table index is the last operand.
+		c.index.print(writer)
+	}
 	writer.PrintfIndent(c.getIndent(), ") ;; call_indirect %s\n", c.name)
 }
 
+func (c *WasmCallIndirect) encode(writer *WasmBinaryWriter) {
+	if c.before != nil {
+		writer.encodeStmt(c.before)
+	}
+	for _, arg := range c.args {
+		arg.encode(writer)
+	}
+	c.index.encode(writer)
+	writer.writeOpcode("call_indirect")
+	writer.writeU32(uint32(writer.typeIndex[c.signature]))
+	writer.writeByte(0x00) // table index
+}
+
 func (c *WasmCall) getNode() ast.Node {
 	if c.call != nil {
 		return c.call
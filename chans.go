@@ -0,0 +1,430 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// The operations on channels are implemented by the runtime package rt/sched.
+// Elements are passed to it as single words, so channels of floats, 64-bit
+// integers, arrays and structs are unsupported. Sends, receives and select
+// statements may block, see goroutines.go.
+
+func isChan(t types.Type) bool {
+	if t == nil {
+		return false
+	}
+	_, ok := t.Underlying().(*types.Chan)
+	return ok
+}
+
+// isReceive returns whether expr is a receive operation.
+func isReceive(expr ast.Expr) bool {
+	u, ok := ast.Unparen(expr).(*ast.UnaryExpr)
+	return ok && u.Op == token.ARROW
+}
+
+// chanType returns the type of a channel-valued expression. It reports an
+// error if the runtime doesn't support its elements.
+func (s *WasmScope) chanType(expr ast.Expr) (*WasmTypeChan, error) {
+	ty, err := s.f.file.convertType(s.f.file.info.TypeOf(expr))
+	if err != nil {
+		return nil, s.f.file.ErrorNode(expr, "%v", err)
+	}
+	c, ok := ty.(*WasmTypeChan)
+	if !ok {
+		return nil, s.f.file.ErrorNode(expr, "not a channel: %s", ty.getName())
+	}
+	switch c.elementType.(type) {
+	case *WasmTypeArray, *WasmTypeStruct:
+		return nil, s.f.file.ErrorNode(expr, "unimplemented channel of element type %s", c.elementType.getName())
+	}
+	if c.elementType.isFloat() || c.elementType.getSize() > 4 {
+		return nil, s.f.file.ErrorNode(expr, "unimplemented channel of element type %s", c.elementType.getName())
+	}
+	return c, nil
+}
+
+func (s *WasmScope) parseChanMake(call *ast.CallExpr, indent int) (WasmExpression, error) {
+	if _, err := s.chanType(call); err != nil {
+		return nil, err
+	}
+	var size WasmExpression
+	var err error
+	if len(call.Args) > 1 {
+		size, err = s.parseExpr(call.Args[1], indent+1)
+	} else {
+		size, err = s.createLiteralInt32(0, indent+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.createTypedRuntimeCall("sched", "ChanMake", []WasmExpression{size}, call, indent)
+}
+
+// parseChanLen handles len and cap of a channel.
+func (s *WasmScope) parseChanLen(call *ast.CallExpr, name string, indent int) (WasmExpression, error) {
+	args, err := s.parseArgs(call.Args, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	if name == "cap" {
+		return s.createRuntimeCall("sched", "ChanCap", args, call, indent)
+	}
+	return s.createRuntimeCall("sched", "ChanLen", args, call, indent)
+}
+
+func (s *WasmScope) parseChanClose(call *ast.CallExpr, indent int) (WasmExpression, error) {
+	if _, err := s.chanType(call.Args[0]); err != nil {
+		return nil, err
+	}
+	args, err := s.parseArgs(call.Args, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createRuntimeCall("sched", "ChanClose", args, call, indent)
+}
+
+// createChanTemp evaluates a channel into a temporary local, so that the
+// suspension point that uses it can be repeated.
+func (s *WasmScope) createChanTemp(expr ast.Expr, indent int) (WasmVariable, WasmExpression, error) {
+	ty, err := s.chanType(expr)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := s.parseExpr(expr, indent+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, err := s.createTempVar("chan", ty)
+	if err != nil {
+		return nil, nil, err
+	}
+	set, err := s.createSetVar(v, c, nil, indent)
+	if err != nil {
+		return nil, nil, err
+	}
+	v.setFullType(ty)
+	return v, set, nil
+}
+
+// parseSendStmt evaluates the channel and the value before the suspension
+// point that sends it.
+func (s *WasmScope) parseSendStmt(stmt *ast.SendStmt, indent int) ([]WasmExpression, error) {
+	c, setChan, err := s.createChanTemp(stmt.Chan, indent)
+	if err != nil {
+		return nil, err
+	}
+	elem := s.f.file.info.TypeOf(stmt.Chan).Underlying().(*types.Chan).Elem()
+	value, err := s.parseExprAs(stmt.Value, elem, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	v, err := s.createTempVar("send", value.getType())
+	if err != nil {
+		return nil, err
+	}
+	setValue, err := s.createSetVar(v, value, stmt, indent)
+	if err != nil {
+		return nil, err
+	}
+	args := []WasmExpression{s.createGetLocal(c, nil, indent+1), s.createGetLocal(v, nil, indent+1)}
+	send, err := s.createRuntimeCall("sched", "ChanSend", args, stmt, indent)
+	if err != nil {
+		return nil, err
+	}
+	return []WasmExpression{setChan, setValue, s.createSuspendPoint(send, indent)}, nil
+}
+
+// parseRecv returns the statements of a receive operation and the temporaries
+// holding the received value and whether it was received.
+func (s *WasmScope) parseRecv(expr *ast.UnaryExpr, indent int) ([]WasmExpression, []WasmVariable, error) {
+	c, setChan, err := s.createChanTemp(expr.X, indent)
+	if err != nil {
+		return nil, nil, err
+	}
+	elem := c.getFullType().(*WasmTypeChan).elementType
+	value, err := s.createTempVar("recv", elem)
+	if err != nil {
+		return nil, nil, err
+	}
+	value.setFullType(elem)
+	boolType, err := s.f.module.scalarType("bool")
+	if err != nil {
+		return nil, nil, err
+	}
+	ok, err := s.createTempVar("recv_ok", boolType)
+	if err != nil {
+		return nil, nil, err
+	}
+	recv, err := s.createRuntimeCall("sched", "ChanRecv", []WasmExpression{s.createGetLocal(c, nil, indent+1)}, expr, indent)
+	if err != nil {
+		return nil, nil, err
+	}
+	temps := []WasmVariable{value, ok}
+	point := s.createSuspendPoint(s.createTupleSet(recv, temps, nil, indent), indent)
+	return []WasmExpression{setChan, point}, temps, nil
+}
+
+// parseChanRangeStmt lowers a range loop over a channel onto a loop that
+// receives until the channel is closed.
+func (s *WasmScope) parseChanRangeStmt(stmt *ast.RangeStmt, indent int) (WasmExpression, error) {
+	outerScope := s.f.createScope("loop_block")
+	scope := s.f.createScope("loop")
+
+	c, setChan, err := outerScope.createChanTemp(stmt.X, indent+1)
+	if err != nil {
+		return nil, fmt.Errorf("error in the range expression of a loop: %w", err)
+	}
+	outerScope.expressions = append(outerScope.expressions, setChan)
+
+	elem := c.getFullType().(*WasmTypeChan).elementType
+	value, err := scope.createTempVar("recv", elem)
+	if err != nil {
+		return nil, err
+	}
+	boolType, err := s.f.module.scalarType("bool")
+	if err != nil {
+		return nil, err
+	}
+	ok, err := scope.createTempVar("recv_ok", boolType)
+	if err != nil {
+		return nil, err
+	}
+	call, err := scope.createRuntimeCall("sched", "ChanRecv", []WasmExpression{scope.createGetLocal(c, nil, indent+3)}, stmt.X, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	set := scope.createTupleSet(call, []WasmVariable{value, ok}, stmt, indent+2)
+	scope.expressions = append(scope.expressions, scope.createSuspendPoint(set, indent+2))
+
+	exitCond, err := scope.createNegation(scope.createGetLocal(ok, nil, indent+4), indent+3)
+	if err != nil {
+		return nil, err
+	}
+	scope.appendLoopExit(exitCond, indent+2)
+
+	if key := stmt.Key; key != nil && !isBlankIdent(key) {
+		v := scope.createGetLocal(value, nil, indent+3)
+		v.setFullType(elem)
+		set, err := scope.createRangeAssign(key, v, stmt, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		scope.expressions = append(scope.expressions, set)
+	}
+
+	err = scope.parseLoopBody(stmt.Body, indent+2)
+	if err != nil {
+		return nil, fmt.Errorf("error in the body of a loop: %w", err)
+	}
+	return s.createLoop(outerScope, scope, stmt, indent), nil
+}
+
+// parseSelectStmt lowers a select statement onto a suspension point that
+// selects one of the cases, followed by the body of the selected one:
+//
+//	(local.set $sel (call SelectMake (i32.const <n>)))
+//	<SelectSend or SelectRecv for each case but the default one>
+//	(local.set $index $value $ok (call Select $sel <n> <block>))
+//	(if (i32.eq $index 0) (then <case 0>) (else (if ... (else <default>))))
+//
+// The channels and the values to send are evaluated in source order.
+func (s *WasmScope) parseSelectStmt(stmt *ast.SelectStmt, indent int) (WasmExpression, error) {
+	outerScope := s.f.createScope("select")
+	labelBreak := outerScope.name + "_break"
+	s.f.pushBranchTarget(labelBreak, "")
+	defer s.f.popBranchTarget()
+
+	var clauses []*ast.CommClause
+	var defaultClause *ast.CommClause
+	for _, c := range stmt.Body.List {
+		clause := c.(*ast.CommClause)
+		if clause.Comm == nil {
+			defaultClause = clause
+			continue
+		}
+		clauses = append(clauses, clause)
+	}
+	n, err := s.createLiteralInt32(int32(len(clauses)), indent+2)
+	if err != nil {
+		return nil, err
+	}
+	cases, err := outerScope.createRuntimeCall("sched", "SelectMake", []WasmExpression{n}, nil, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	ptrType, err := s.f.module.scalarType("uintptr")
+	if err != nil {
+		return nil, err
+	}
+	sel, err := outerScope.createTempVar("select", ptrType)
+	if err != nil {
+		return nil, err
+	}
+	set, err := outerScope.createSetVar(sel, cases, stmt, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	outerScope.expressions = append(outerScope.expressions, set)
+
+	recvs := make([]*ast.UnaryExpr, len(clauses))
+	for i, clause := range clauses {
+		index, err := s.createLiteralInt32(int32(i), indent+2)
+		if err != nil {
+			return nil, err
+		}
+		args := []WasmExpression{outerScope.createGetLocal(sel, nil, indent+2), index}
+		var c WasmExpression
+		switch comm := clause.Comm.(type) {
+		case *ast.SendStmt:
+			if _, err := s.chanType(comm.Chan); err != nil {
+				return nil, err
+			}
+			elem := s.f.file.info.TypeOf(comm.Chan).Underlying().(*types.Chan).Elem()
+			ch, err := outerScope.parseExpr(comm.Chan, indent+2)
+			if err != nil {
+				return nil, err
+			}
+			value, err := outerScope.parseExprAs(comm.Value, elem, indent+2)
+			if err != nil {
+				return nil, err
+			}
+			c, err = outerScope.createRuntimeCall("sched", "SelectSend", append(args, ch, value), comm, indent+1)
+			if err != nil {
+				return nil, err
+			}
+		case *ast.ExprStmt:
+			recvs[i] = ast.Unparen(comm.X).(*ast.UnaryExpr)
+		case *ast.AssignStmt:
+			recvs[i] = ast.Unparen(comm.Rhs[0]).(*ast.UnaryExpr)
+		}
+		if recv := recvs[i]; recv != nil {
+			if _, err := s.chanType(recv.X); err != nil {
+				return nil, err
+			}
+			ch, err := outerScope.parseExpr(recv.X, indent+2)
+			if err != nil {
+				return nil, err
+			}
+			c, err = outerScope.createRuntimeCall("sched", "SelectRecv", append(args, ch), recv, indent+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		outerScope.expressions = append(outerScope.expressions, c)
+	}
+
+	intType, err := s.f.module.scalarType("int32")
+	if err != nil {
+		return nil, err
+	}
+	boolType, err := s.f.module.scalarType("bool")
+	if err != nil {
+		return nil, err
+	}
+	index, err := outerScope.createTempVar("select_index", intType)
+	if err != nil {
+		return nil, err
+	}
+	value, err := outerScope.createTempVar("select_value", ptrType)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := outerScope.createTempVar("select_ok", boolType)
+	if err != nil {
+		return nil, err
+	}
+	n, err = s.createLiteralInt32(int32(len(clauses)), indent+3)
+	if err != nil {
+		return nil, err
+	}
+	block := "1"
+	if defaultClause != nil {
+		block = "0"
+	}
+	blocks, err := s.createLiteral(block, boolType, indent+3)
+	if err != nil {
+		return nil, err
+	}
+	blocks.setComment("block")
+	args := []WasmExpression{outerScope.createGetLocal(sel, nil, indent+3), n, blocks}
+	call, err := outerScope.createRuntimeCall("sched", "Select", args, stmt, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	point := outerScope.createTupleSet(call, []WasmVariable{index, value, ok}, stmt, indent+1)
+	outerScope.expressions = append(outerScope.expressions, outerScope.createSuspendPoint(point, indent+1))
+
+	// The cases are dispatched by a chain of ifs, which is built from the
+	// last one, whose else branch is the default case.
+	var dispatch WasmExpression
+	if defaultClause != nil {
+		dispatch, err = outerScope.parseCommClause(defaultClause, nil, nil, indent+2)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := len(clauses) - 1; i >= 0; i-- {
+		body, err := outerScope.parseCommClause(clauses[i], value, ok, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		k, err := s.createLiteralInt32(int32(i), indent+3)
+		if err != nil {
+			return nil, err
+		}
+		cond, err := s.createBinaryExpr(outerScope.createGetLocal(index, nil, indent+3), k, binOpEq, intType, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		dispatch, err = s.createIf(cond, body, dispatch, indent+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if dispatch != nil {
+		outerScope.expressions = append(outerScope.expressions, dispatch)
+	}
+	b := s.createBlock(outerScope, stmt, indent)
+	b.label = labelBreak
+	return b, nil
+}
+
+// parseCommClause returns the body of a case of a select statement, preceded
+// by the assignment of the received value and ok, if any.
+func (s *WasmScope) parseCommClause(clause *ast.CommClause, value, ok WasmVariable, indent int) (WasmExpression, error) {
+	scope := s.f.createScope("select_case")
+	if assign, isAssign := clause.Comm.(*ast.AssignStmt); isAssign {
+		ty, err := s.chanType(ast.Unparen(assign.Rhs[0]).(*ast.UnaryExpr).X)
+		if err != nil {
+			return nil, err
+		}
+		for i, lhs := range assign.Lhs {
+			if isBlankIdent(lhs) {
+				continue
+			}
+			if err := scope.checkAssignable(lhs, assign.Rhs[0], i); err != nil {
+				return nil, err
+			}
+			v := scope.createGetLocal(value, nil, indent+2)
+			v.setFullType(ty.elementType)
+			t := ty.elementType
+			if i == 1 {
+				v = scope.createGetLocal(ok, nil, indent+2)
+				t = ok.getType()
+			}
+			set, err := scope.assignValue(lhs, nil, v, t, assign, indent+1)
+			if err != nil {
+				return nil, err
+			}
+			scope.expressions = append(scope.expressions, set)
+		}
+	}
+	if err := scope.parseStatementList(clause.Body, indent+1); err != nil {
+		return nil, err
+	}
+	return s.createBlock(scope, nil, indent), nil
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"strconv"
+)
+
+// packageImporter resolves imports of packages that are compiled into the
+// module to their already type-checked packages, so that all files see the
+// same objects. Other packages are type-checked from source.
+type packageImporter struct {
+	module *WasmModule
+	source types.Importer
+}
+
+func (imp *packageImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := imp.module.packages[path]; ok {
+		return pkg, nil
+	}
+	return imp.source.Import(path)
+}
+
+// wasmSizes are the sizes of Go types in the linear memory. The type checker
+// uses them for unsafe.Sizeof, unsafe.Alignof and unsafe.Offsetof and for the
+// range of int, uint and uintptr, which are 32 bits wide. Strings, slices,
+// interfaces, maps, channels and function values are single words, i.e., the
+// addresses of their headers. Structs and arrays are laid out as in Go, and the
+// compiler uses the same offsets for the fields of structs. An array variable
+// holds the address of its elements, though, see WasmTypeArray.
+type wasmSizes struct{}
+
+func (sz wasmSizes) Alignof(t types.Type) int64 {
+	switch t := t.Underlying().(type) {
+	case *types.Struct:
+		align := int64(1)
+		for i := 0; i < t.NumFields(); i++ {
+			if a := sz.Alignof(t.Field(i).Type()); a > align {
+				align = a
+			}
+		}
+		return align
+	case *types.Array:
+		return sz.Alignof(t.Elem())
+	}
+	return sz.Sizeof(t)
+}
+
+func (sz wasmSizes) Offsetsof(fields []*types.Var) []int64 {
+	offsets := make([]int64, len(fields))
+	var offset int64
+	for i, f := range fields {
+		offset = alignTo(offset, sz.Alignof(f.Type()))
+		offsets[i] = offset
+		offset += sz.Sizeof(f.Type())
+	}
+	return offsets
+}
+
+func (sz wasmSizes) Sizeof(t types.Type) int64 {
+	switch t := t.Underlying().(type) {
+	case *types.Basic:
+		switch t.Kind() {
+		case types.Bool, types.Int8, types.Uint8:
+			return 1
+		case types.Int16, types.Uint16:
+			return 2
+		case types.Int64, types.Uint64, types.Float64, types.Complex64:
+			return 8
+		case types.Complex128:
+			return 16
+		}
+	case *types.Struct:
+		n := t.NumFields()
+		if n == 0 {
+			return 0
+		}
+		fields := make([]*types.Var, n)
+		for i := range fields {
+			fields[i] = t.Field(i)
+		}
+		offsets := sz.Offsetsof(fields)
+		size := offsets[n-1] + sz.Sizeof(fields[n-1].Type())
+		return alignTo(size, sz.Alignof(t))
+	case *types.Array:
+		return t.Len() * sz.Sizeof(t.Elem())
+	}
+	return 4
+}
+
+func alignTo(x, align int64) int64 {
+	return (x + align - 1) / align * align
+}
+
+// addPackage type-checks the files of a package and adds them to the module.
+func (m *WasmModule) addPackage(path string, files []*ast.File, fset *token.FileSet) error {
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Implicits:  make(map[ast.Node]types.Object),
+	}
+	var errs GoWasmErrorList
+	conf := types.Config{
+		Importer: &packageImporter{
+			module: m,
+			source: importer.ForCompiler(fset, "source", nil),
+		},
+		Sizes: wasmSizes{},
+		Error: func(err error) {
+			if e, ok := err.(types.Error); ok {
+				errs = append(errs, &GoWasmError{pos: e.Fset.Position(e.Pos), msg: e.Msg})
+			} else {
+				errs.add(err)
+			}
+		},
+	}
+	pkg, _ := conf.Check(path, fset, files, info)
+	// Packages importing this one are checked against it even if it has errors,
+	// so that the errors are reported once.
+	m.packages[path] = pkg
+	if len(errs) > 0 {
+		return errs
+	}
+	// The functions are compiled even if there are errors in declarations,
+	// which finalize reports with theirs.
+	for _, f := range files {
+		m.declErrors.add(m.addAstFile(f, fset, path, info))
+	}
+	return nil
+}
+
+// objectOf returns the object denoted by an identifier, which is the key of
+// variables and functions in the module.
+func (file *WasmGoSourceFile) objectOf(ident *ast.Ident) types.Object {
+	return file.info.ObjectOf(ident)
+}
+
+// unqualified returns the identifier of a qualified identifier like
+// sub.Counter, which denotes a member of an imported package, or expr.
+func (file *WasmGoSourceFile) unqualified(expr ast.Expr) ast.Expr {
+	se, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return expr
+	}
+	x, ok := se.X.(*ast.Ident)
+	if !ok {
+		return expr
+	}
+	if _, ok := file.info.Uses[x].(*types.PkgName); !ok {
+		return expr
+	}
+	return se.Sel
+}
+
+// typeOfObject returns the type of the object declared by an identifier.
+func (file *WasmGoSourceFile) typeOfObject(ident *ast.Ident) (WasmType, error) {
+	obj := file.objectOf(ident)
+	if obj == nil {
+		return nil, file.ErrorNode(ident, "undeclared name: %s", ident.Name)
+	}
+	return file.convertType(obj.Type())
+}
+
+// scalarType returns the type for the name of a basic Go type, e.g., "int32".
+func (m *WasmModule) scalarType(name string) (WasmType, error) {
+	if t, ok := m.types[name]; ok {
+		return t, nil
+	}
+	t, err := m.convertAstTypeNameToWasmType(name)
+	if err != nil {
+		return nil, err
+	}
+	m.types[name] = t
+	return t, nil
+}
+
+// placeholderType returns the type of a parameter or a result whose type can't
+// be compiled, see parseType.
+func (m *WasmModule) placeholderType() WasmType {
+	t, _ := m.scalarType("int32")
+	return t
+}
+
+// convertType returns the WASM type of a Go type computed by the type checker.
+func (file *WasmGoSourceFile) convertType(t types.Type) (WasmType, error) {
+	switch t := t.(type) {
+	default:
+		return nil, fmt.Errorf("unsupported type: %v", t)
+	case *types.Basic:
+		switch t.Kind() {
+		case types.UnsafePointer, types.UntypedNil:
+			return file.module.scalarType("unsafe.Pointer")
+		}
+		if t.Info()&types.IsUntyped != 0 {
+			return file.convertType(types.Default(t))
+		}
+		// Aliases such as byte and rune have their own names.
+		return file.module.scalarType(types.Typ[t.Kind()].Name())
+	case *types.Pointer:
+		base, err := file.convertType(t.Elem())
+		if err != nil {
+			return nil, fmt.Errorf("error in a pointer type: %w", err)
+		}
+		return file.createPointerType(base)
+	case *types.Array:
+		element, err := file.convertType(t.Elem())
+		if err != nil {
+			return nil, fmt.Errorf("error in an array type: %w", err)
+		}
+		arr := &WasmTypeArray{
+			length:      uint32(t.Len()),
+			elementType: element,
+		}
+		arr.setName(fmt.Sprintf("[%d]%s", t.Len(), element.getName()))
+		arr.setAlign(4)
+		arr.setSize(4)
+		return arr, nil
+	case *types.Chan:
+		element, err := file.convertType(t.Elem())
+		if err != nil {
+			return nil, fmt.Errorf("error in a channel type: %w", err)
+		}
+		c := &WasmTypeChan{
+			elementType: element,
+		}
+		c.setName("chan " + element.getName())
+		c.setAlign(4)
+		c.setSize(4)
+		return c, nil
+	case *types.Interface:
+		i := &WasmTypeInterface{
+			iface: t,
+		}
+		i.setName(t.String())
+		i.setAlign(4)
+		i.setSize(4)
+		return i, nil
+	case *types.Map:
+		key, err := file.convertType(t.Key())
+		if err != nil {
+			return nil, fmt.Errorf("error in a map key type: %w", err)
+		}
+		value, err := file.convertType(t.Elem())
+		if err != nil {
+			return nil, fmt.Errorf("error in a map value type: %w", err)
+		}
+		m := &WasmTypeMap{
+			keyType:   key,
+			valueType: value,
+		}
+		m.setName(fmt.Sprintf("map[%s]%s", key.getName(), value.getName()))
+		m.setAlign(4)
+		m.setSize(4)
+		return m, nil
+	case *types.Slice:
+		element, err := file.convertType(t.Elem())
+		if err != nil {
+			return nil, fmt.Errorf("error in a slice type: %w", err)
+		}
+		slice := &WasmTypeSlice{
+			elementType: element,
+		}
+		slice.setName("[]" + element.getName())
+		slice.setAlign(4)
+		slice.setSize(4)
+		return slice, nil
+	case *types.Signature:
+		return file.convertSignature(t)
+	case *types.Struct:
+		st := &WasmTypeStruct{}
+		st.setName(t.String())
+		return file.convertStructType(st, t)
+	case *types.Named:
+		return file.convertNamedType(t)
+	}
+}
+
+func (file *WasmGoSourceFile) convertNamedType(t *types.Named) (WasmType, error) {
+	name := t.Obj().Name()
+	// Types with the same name may be declared in different packages.
+	key := name
+	if pkg := t.Obj().Pkg(); pkg != nil {
+		key = pkg.Path() + "." + name
+	}
+	if ty, ok := file.module.types[key]; ok {
+		return ty, nil
+	}
+	switch u := t.Underlying().(type) {
+	default:
+		return file.convertType(u)
+	case *types.Interface:
+		ty, err := file.convertType(u)
+		if err != nil {
+			return nil, err
+		}
+		ty.setName(name)
+		file.module.types[key] = ty
+		return ty, nil
+	case *types.Signature:
+		ty, err := file.convertSignature(u)
+		if err != nil {
+			return nil, err
+		}
+		ty.setName(name)
+		file.module.types[key] = ty
+		return ty, nil
+	case *types.Struct:
+		st := &WasmTypeStruct{}
+		st.setName(name)
+		// Insert incomplete the type declaration now to handle recursive types.
+		file.module.types[key] = st
+		return file.convertStructType(st, u)
+	}
+}
+
+// convertStructType converts the fields of a struct. The layout is computed
+// from the Go type, which is complete even while a recursive type is being
+// converted: each field is aligned for its type and the size is padded to a
+// multiple of the largest alignment of a field.
+func (file *WasmGoSourceFile) convertStructType(t *WasmTypeStruct, st *types.Struct) (WasmType, error) {
+	vars := make([]*types.Var, st.NumFields())
+	for i := range vars {
+		vars[i] = st.Field(i)
+	}
+	offsets := wasmSizes{}.Offsetsof(vars)
+	t.fields = make([]*WasmField, len(vars))
+	for i, v := range vars {
+		field := &WasmField{
+			name:   v.Name(),
+			offset: int(offsets[i]),
+		}
+		t.fields[i] = field
+		ty, err := file.convertType(v.Type())
+		if err != nil {
+			return nil, fmt.Errorf("error parsing type of field %s: %w", field.name, err)
+		}
+		if a, ok := v.Type().Underlying().(*types.Array); ok {
+			ty, err = file.inlineArrayType(a)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing type of field %s: %w", field.name, err)
+			}
+		}
+		field.t = ty
+	}
+	t.setAlign(int(wasmSizes{}.Alignof(st)))
+	t.setSize(int(wasmSizes{}.Sizeof(st)))
+	return t, nil
+}
+
+// inlineArrayType returns the type of an array that is laid out inline in a
+// struct, as are the arrays that are its elements.
+func (file *WasmGoSourceFile) inlineArrayType(t *types.Array) (*WasmTypeArray, error) {
+	element, err := file.convertType(t.Elem())
+	if err != nil {
+		return nil, fmt.Errorf("error in an array type: %w", err)
+	}
+	if a, ok := t.Elem().Underlying().(*types.Array); ok {
+		element, err = file.inlineArrayType(a)
+		if err != nil {
+			return nil, err
+		}
+	}
+	arr := &WasmTypeArray{
+		length:      uint32(t.Len()),
+		elementType: element,
+		inline:      true,
+	}
+	arr.setName(fmt.Sprintf("[%d]%s", t.Len(), element.getName()))
+	arr.setAlign(int(wasmSizes{}.Alignof(t)))
+	arr.setSize(int(wasmSizes{}.Sizeof(t)))
+	return arr, nil
+}
+
+func (file *WasmGoSourceFile) convertSignature(sig *types.Signature) (*WasmTypeFunc, error) {
+	t := &WasmTypeFunc{
+		indent: 1,
+	}
+	t.setAlign(4)
+	t.setSize(4)
+	params := make([]*types.Var, 0, sig.Params().Len()+1)
+	if recv := sig.Recv(); recv != nil {
+		// The receiver of a method is its first parameter.
+		params = append(params, recv)
+	}
+	for i := 0; i < sig.Params().Len(); i++ {
+		params = append(params, sig.Params().At(i))
+	}
+	for _, p := range params {
+		ty, err := file.convertType(p.Type())
+		if err != nil {
+			return nil, fmt.Errorf("error in function param type : %w", err)
+		}
+		if _, ok := ty.(*WasmTypeStruct); ok {
+			// Structs are passed by address.
+			ty, err = file.createPointerType(ty)
+			if err != nil {
+				return nil, err
+			}
+		}
+		t.params = append(t.params, ty)
+	}
+	for i := 0; i < sig.Results().Len(); i++ {
+		ty, err := file.convertType(sig.Results().At(i).Type())
+		if err != nil {
+			return nil, fmt.Errorf("error in function return type: %w", err)
+		}
+		t.results = append(t.results, ty)
+	}
+	return file.module.signatures.add(t), nil
+}
+
+// constantValue returns the text of a constant of type t in the WASM text
+// format. The value of a string constant is the address of a literal in
+// static memory.
+func (m *WasmModule) constantValue(v constant.Value, t WasmType) (string, error) {
+	switch v.Kind() {
+	case constant.String:
+		return strconv.Itoa(int(m.stringLiteral(constant.StringVal(v)))), nil
+	case constant.Bool:
+		if constant.BoolVal(v) {
+			return "1", nil
+		}
+		return "0", nil
+	case constant.Int, constant.Float:
+		if t.isFloat() {
+			f, _ := constant.Float64Val(constant.ToFloat(v))
+			return strconv.FormatFloat(f, 'g', -1, 64), nil
+		}
+		return constant.ToInt(v).ExactString(), nil
+	}
+	return "", fmt.Errorf("unsupported constant: %v", v)
+}
+
+// createConstant returns a literal for an expression that the type checker
+// evaluated to a constant. Its type is the type of the context the constant
+// is used in, e.g., 1 in x + 1 has the type of x.
+func (s *WasmScope) createConstant(expr ast.Expr, tv types.TypeAndValue, indent int) (WasmExpression, error) {
+	ty, err := s.f.file.convertType(tv.Type)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(expr, "%v", err)
+	}
+	value, err := s.f.module.constantValue(tv.Value, ty)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(expr, "%v", err)
+	}
+	lit, err := s.createLiteral(value, ty, indent)
+	if err != nil {
+		return nil, err
+	}
+	if src := s.f.file.getSingleLineGoSource(expr); src != value {
+		lit.setComment(src)
+	}
+	lit.setScope(s)
+	lit.setFullType(ty)
+	return lit, nil
+}
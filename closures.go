@@ -0,0 +1,691 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// A function value is the address of a closure object, see rt/closure.
+// Functions called through function values take the closure as an extra last
+// parameter. Function literals read the variables they capture from it. Other
+// functions are called through thunks, which drop it or, for method values,
+// pass the receiver stored in it.
+//
+// The variables captured by function literals are shared with the function
+// that declares them, so they live in cells on the heap. A cell is allocated
+// each time the declaration is executed and its address is held in a local.
+// Arrays and structs are captured directly, since their variables already
+// hold the address of their memory.
+
+// WasmCellVar is a variable in a cell on the heap.
+type WasmCellVar struct {
+	name     string
+	cell     *WasmLocal // the address of the cell
+	t        WasmType
+	fullType WasmType
+	declared bool // the first assignment, which allocates the cell, has been generated
+}
+
+func (v *WasmCellVar) print(writer FormattingWriter) {
+	v.cell.print(writer)
+}
+
+func (v *WasmCellVar) getType() WasmType {
+	return v.t
+}
+
+func (v *WasmCellVar) getFullType() WasmType {
+	return v.fullType
+}
+
+func (v *WasmCellVar) setFullType(t WasmType) {
+	v.fullType = t
+}
+
+func (v *WasmCellVar) getName() string {
+	return v.name
+}
+
+// isCaptureByCell returns whether a captured variable of type t lives in a
+// cell.
+func isCaptureByCell(t types.Type) bool {
+	switch t.Underlying().(type) {
+	case *types.Array, *types.Struct:
+		return false
+	}
+	return true
+}
+
+// freeVars returns the variables of enclosing functions used in a function
+// literal, in the order of their first use.
+func freeVars(info *types.Info, lit *ast.FuncLit) []types.Object {
+	var vars []types.Object
+	seen := make(map[types.Object]bool)
+	ast.Inspect(lit.Body, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		v, ok := info.Uses[ident].(*types.Var)
+		if !ok || v.IsField() || seen[v] || v.Parent() == nil || v.Parent() == v.Pkg().Scope() {
+			return true
+		}
+		if lit.Pos() <= v.Pos() && v.Pos() < lit.End() {
+			return true
+		}
+		seen[v] = true
+		vars = append(vars, v)
+		return true
+	})
+	return vars
+}
+
+// capturedVars returns the variables captured by the function literals in a
+// function body, including nested ones.
+func capturedVars(info *types.Info, body *ast.BlockStmt) map[types.Object]bool {
+	captured := make(map[types.Object]bool)
+	ast.Inspect(body, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.FuncLit); ok {
+			for _, v := range freeVars(info, lit) {
+				captured[v] = true
+			}
+		}
+		return true
+	})
+	return captured
+}
+
+// createCellVar creates a variable in a cell. The cell is allocated by the
+// first assignment, i.e., the declaration.
+func (s *WasmScope) createCellVar(ident *ast.Ident, ty WasmType) (*WasmCellVar, error) {
+	obj := s.f.file.objectOf(ident)
+	fullType, err := s.f.file.convertType(obj.Type())
+	if err != nil {
+		return nil, s.f.file.ErrorNode(ident, "%v", err)
+	}
+	cell, err := s.createCellLocal(ident, fullType)
+	if err != nil {
+		return nil, err
+	}
+	v := &WasmCellVar{
+		name:     cell.name,
+		cell:     cell,
+		t:        ty,
+		fullType: fullType,
+	}
+	s.f.module.variables[obj] = v
+	return v, nil
+}
+
+func (s *WasmScope) createCellLocal(ident *ast.Ident, ty WasmType) (*WasmLocal, error) {
+	ptrType, err := s.f.file.createPointerType(ty)
+	if err != nil {
+		return nil, err
+	}
+	cell := &WasmLocal{
+		astIdent: ident,
+		name:     astNameToWASM(ident.Name+"_cell", s),
+		t:        ptrType,
+		fullType: ptrType,
+	}
+	s.f.locals = append(s.f.locals, cell)
+	return cell, nil
+}
+
+// createCellSet returns the assignment to a variable in a cell. The first one
+// allocates the cell.
+func (s *WasmScope) createCellSet(v *WasmCellVar, rhs WasmExpression, stmt ast.Stmt, indent int) (WasmExpression, error) {
+	var addr WasmExpression = s.createGetLocal(v.cell, nil, indent+1)
+	if !v.declared {
+		v.declared = true
+		alloc, err := s.generateAlloc(int32(v.t.getSize()), int32(v.t.getAlign()), stmt, v.cell.t, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		addr = s.createTeeLocal(v.cell, alloc, indent+1)
+	}
+	store, err := s.createStore(addr, rhs, v.t, stmt, indent)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(stmt, "unimplemented captured variable %s: %v", v.getName(), err)
+	}
+	store.setComment(fmt.Sprintf("set %s", v.getName()))
+	return store, nil
+}
+
+// appendIterationCells gives the variables declared by the init statement of
+// a three-clause loop, which live in cells, new cells for the next iteration.
+// As in Go, each iteration has its own variables, initialized with the values
+// of the previous one before the post statement runs.
+func (s *WasmScope) appendIterationCells(init ast.Stmt, indent int) error {
+	assign, ok := init.(*ast.AssignStmt)
+	if !ok || assign.Tok != token.DEFINE {
+		return nil
+	}
+	for _, lhs := range assign.Lhs {
+		ident, ok := lhs.(*ast.Ident)
+		if !ok {
+			continue
+		}
+		v, ok := s.f.module.variables[s.f.file.info.Defs[ident]].(*WasmCellVar)
+		if !ok {
+			continue
+		}
+		tmp, err := s.createTempVar("iter", v.t)
+		if err != nil {
+			return err
+		}
+		value, err := s.createCellGet(v, ident, indent+1)
+		if err != nil {
+			return err
+		}
+		set, err := s.createSetVar(tmp, value, init, indent)
+		if err != nil {
+			return err
+		}
+		v.declared = false
+		next, err := s.createCellSet(v, s.createGetLocal(tmp, nil, indent+1), init, indent)
+		if err != nil {
+			return err
+		}
+		s.expressions = append(s.expressions, set, next)
+	}
+	return nil
+}
+
+func (s *WasmScope) createCellGet(v *WasmCellVar, node ast.Node, indent int) (WasmExpression, error) {
+	l, err := s.createLoad(s.createGetLocal(v.cell, nil, indent+1), v.t, indent)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(node, "unimplemented captured variable %s: %v", v.getName(), err)
+	}
+	l.setComment(fmt.Sprintf("get %s", v.getName()))
+	l.setNode(node)
+	l.setScope(s)
+	l.setFullType(v.getFullType())
+	return l, nil
+}
+
+// bindCaptured generates the prologue that binds the captured variables. A
+// function literal loads the variables it captures from its closure. The
+// parameters and named results captured by function literals in the body are
+// moved to cells.
+func (f *WasmFunc) bindCaptured(indent int) error {
+	s := f.scope
+	f.captured = capturedVars(f.file.info, f.body())
+	for i, obj := range f.env {
+		ty, err := f.file.convertType(obj.Type())
+		if err != nil {
+			return err
+		}
+		ident := ast.NewIdent(obj.Name())
+		var local *WasmLocal
+		if isCaptureByCell(obj.Type()) {
+			local, err = s.createCellLocal(ident, ty)
+			if err != nil {
+				return err
+			}
+			f.module.variables[obj] = &WasmCellVar{
+				name:     local.name,
+				cell:     local,
+				t:        ty,
+				fullType: ty,
+				declared: true,
+			}
+		} else {
+			local = &WasmLocal{
+				astIdent: ident,
+				name:     astNameToWASM(ident.Name, s),
+				t:        ty,
+				fullType: ty,
+			}
+			f.locals = append(f.locals, local)
+			f.module.variables[obj] = local
+		}
+		index, err := s.createLiteralInt32(int32(i), indent+2)
+		if err != nil {
+			return err
+		}
+		value, err := s.createRuntimeCall("closure", "ClosureEnv", []WasmExpression{s.createGetLocal(f.envParam, nil, indent+2), index}, nil, indent+1)
+		if err != nil {
+			return err
+		}
+		value.setComment(fmt.Sprintf("captured %s", obj.Name()))
+		set, err := s.createSetVar(local, value, nil, indent)
+		if err != nil {
+			return err
+		}
+		s.expressions = append(s.expressions, set)
+	}
+	for _, p := range f.params {
+		if p.astIdent == nil || p == f.envParam {
+			continue
+		}
+		obj := f.file.objectOf(p.astIdent)
+		if !f.captured[obj] || !isCaptureByCell(obj.Type()) {
+			continue
+		}
+		param := s.createGetLocal(p, nil, indent+1)
+		v, err := s.createCellVar(p.astIdent, p.t)
+		if err != nil {
+			return err
+		}
+		set, err := s.createSetVar(v, param, nil, indent)
+		if err != nil {
+			return err
+		}
+		s.expressions = append(s.expressions, set)
+	}
+	for _, r := range f.results {
+		if r.astIdent == nil {
+			continue
+		}
+		obj := f.file.objectOf(r.astIdent)
+		if !f.captured[obj] || !isCaptureByCell(obj.Type()) {
+			continue
+		}
+		zero, err := s.createNilLiteral(r.t, indent+1)
+		if err != nil {
+			return err
+		}
+		v, err := s.createCellVar(r.astIdent, r.t)
+		if err != nil {
+			return err
+		}
+		set, err := s.createSetVar(v, zero, nil, indent)
+		if err != nil {
+			return err
+		}
+		s.expressions = append(s.expressions, set)
+	}
+	return nil
+}
+
+// closureSignature returns the signature of sig with the closure as an extra
+// last parameter.
+func (m *WasmModule) closureSignature(sig *WasmTypeFunc) (*WasmTypeFunc, error) {
+	ptrType, err := m.scalarType("uintptr")
+	if err != nil {
+		return nil, err
+	}
+	t := &WasmTypeFunc{
+		indent:  sig.indent,
+		params:  append(append([]WasmType{}, sig.params...), ptrType),
+		results: sig.results,
+	}
+	t.setAlign(4)
+	t.setSize(4)
+	return m.signatures.add(t), nil
+}
+
+// addEnvParam adds the closure parameter to a function called through
+// function values.
+func (f *WasmFunc) addEnvParam() error {
+	sig, err := f.module.closureSignature(f.signature)
+	if err != nil {
+		return err
+	}
+	f.signature = sig
+	f.envParam = &WasmParam{
+		name:     "$closure.env",
+		t:        sig.params[len(sig.params)-1],
+		fullType: sig.params[len(sig.params)-1],
+	}
+	f.params = append(f.params, f.envParam)
+	return nil
+}
+
+// createFuncLit creates the function of a function literal in the function
+// outer. Its body is generated by generateFuncLits.
+func (file *WasmGoSourceFile) createFuncLit(lit *ast.FuncLit, outer *WasmFunc) (*WasmFunc, error) {
+	outer.numLits++
+	f := &WasmFunc{
+		funcLit:    lit,
+		fset:       outer.fset,
+		module:     file.module,
+		file:       file,
+		indent:     outer.indent,
+		origName:   fmt.Sprintf("%s.func%d", outer.origName, outer.numLits),
+		namePos:    lit.Pos(),
+		params:     make([]*WasmParam, 0, 10),
+		locals:     make([]*WasmLocal, 0, 10),
+		gotoLabels: make(map[string]string),
+		env:        freeVars(file.info, lit),
+	}
+	f.name = mangleFunctionName(file.pkgName, f.origName)
+	if err := f.parseType(lit.Type); err != nil {
+		return nil, fmt.Errorf("error parsing function %s: %w", f.origName, err)
+	}
+	if err := f.addEnvParam(); err != nil {
+		return nil, err
+	}
+	f.scope = f.createScope(fmt.Sprintf("function_%s", f.origName))
+	f.prepareForIndirectCall()
+	file.module.functions = append(file.module.functions, f)
+	file.module.funcSymTab[f.name] = f
+	file.module.funcLits = append(file.module.funcLits, f)
+	return f, nil
+}
+
+// generateFuncLits generates the bodies of pending function literals, which
+// may add more of them.
+func (file *WasmGoSourceFile) generateFuncLits() error {
+	var errs GoWasmErrorList
+	m := file.module
+	for len(m.funcLits) > 0 {
+		f := m.funcLits[0]
+		m.funcLits = m.funcLits[1:]
+		if _, err := f.parseAstFuncDecl(); err != nil {
+			file.addError(&errs, f.funcLit, err)
+		}
+	}
+	return errs.err()
+}
+
+// closureThunk returns the function that calls fn through a function value.
+// With bound, it passes the receiver stored in the closure to the method fn.
+func (m *WasmModule) closureThunk(fn *WasmFunc, bound bool) (*WasmFunc, error) {
+	thunks := m.thunks
+	suffix := "$value"
+	if bound {
+		thunks = m.boundThunks
+		suffix = "$bound"
+	}
+	if t, ok := thunks[fn]; ok {
+		return t, nil
+	}
+	f := &WasmFunc{
+		fset:       fn.fset,
+		module:     m,
+		file:       fn.file,
+		indent:     fn.indent,
+		name:       fn.name + suffix,
+		origName:   fn.origName + suffix,
+		namePos:    fn.namePos,
+		results:    fn.results,
+		gotoLabels: make(map[string]string),
+	}
+	params := fn.params
+	sig := *fn.signature
+	if bound {
+		if t := params[0].t; t.isFloat() || t.getSize() > 4 {
+			return nil, fmt.Errorf("unimplemented method value with a receiver of type %s", t.getName())
+		}
+		params = params[1:]
+		sig.params = sig.params[1:]
+	}
+	for i, p := range params {
+		f.params = append(f.params, &WasmParam{
+			name:     fmt.Sprintf("$p%d", i),
+			t:        p.t,
+			fullType: p.fullType,
+		})
+	}
+	f.signature = &sig
+	if err := f.addEnvParam(); err != nil {
+		return nil, err
+	}
+	f.scope = f.createScope(fmt.Sprintf("function_%s", f.origName))
+	s := f.scope
+	var args []WasmExpression
+	if bound {
+		index, err := s.createLiteralInt32(0, f.indent+4)
+		if err != nil {
+			return nil, err
+		}
+		recv, err := s.createRuntimeCall("closure", "ClosureEnv", []WasmExpression{s.createGetLocal(f.envParam, nil, f.indent+4), index}, nil, f.indent+3)
+		if err != nil {
+			return nil, err
+		}
+		recv.setComment("receiver")
+		args = append(args, recv)
+	}
+	for _, p := range f.params[:len(f.params)-1] {
+		args = append(args, s.createGetLocal(p, nil, f.indent+3))
+	}
+	call, err := s.createCallExprWithArgs(nil, fn.name, fn, args, f.indent+2)
+	if err != nil {
+		return nil, err
+	}
+	call.setNode(nil)
+	if len(fn.results) == 0 {
+		call.setIndent(f.indent + 1)
+		s.expressions = append(s.expressions, call)
+	} else {
+		r := &WasmReturn{
+			values: []WasmExpression{call},
+		}
+		r.setIndent(f.indent + 1)
+		r.setScope(s)
+		s.expressions = append(s.expressions, r)
+	}
+	f.prepareForIndirectCall()
+	m.functions = append(m.functions, f)
+	m.funcSymTab[f.name] = f
+	thunks[fn] = f
+	return f, nil
+}
+
+// funcValue returns the address of the closure of a top-level function or a
+// method expression, which is in static memory.
+func (m *WasmModule) funcValue(fn *WasmFunc) (int32, error) {
+	if addr, ok := m.funcValues[fn]; ok {
+		return addr, nil
+	}
+	thunk, err := m.closureThunk(fn, false)
+	if err != nil {
+		return 0, err
+	}
+	addr := int32(m.memory.allocGlobal(4, 4))
+	m.memory.writeInt32(int(addr), int32(thunk.tabIndex))
+	m.funcValues[fn] = addr
+	return addr, nil
+}
+
+// createClosure returns a chain of calls that creates a closure object and
+// sets its environment, i.e., ClosureSet(ClosureSet(ClosureMake(fn, 2), 0,
+// x), 1, y).
+func (s *WasmScope) createClosure(fn *WasmFunc, env []WasmExpression, node ast.Node, indent int) (WasmExpression, error) {
+	n := len(env)
+	index, err := s.createLiteralInt32(int32(fn.tabIndex), indent+n+1)
+	if err != nil {
+		return nil, err
+	}
+	index.setComment(fmt.Sprintf("function index for %s", fn.name))
+	size, err := s.createLiteralInt32(int32(n), indent+n+1)
+	if err != nil {
+		return nil, err
+	}
+	size.setComment("environment size")
+	result, err := s.createRuntimeCall("closure", "ClosureMake", []WasmExpression{index, size}, node, indent+n)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range env {
+		v.setIndent(indent + n - i)
+		i32, err := s.createLiteralInt32(int32(i), indent+n-i)
+		if err != nil {
+			return nil, err
+		}
+		result, err = s.createRuntimeCall("closure", "ClosureSet", []WasmExpression{result, i32, v}, node, indent+n-i-1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// parseFuncLit handles a function literal, which creates a closure with the
+// cells or the values of the variables it captures.
+func (s *WasmScope) parseFuncLit(lit *ast.FuncLit, indent int) (WasmExpression, error) {
+	fn, err := s.f.file.createFuncLit(lit, s.f)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(lit, "%v", err)
+	}
+	env := make([]WasmExpression, 0, len(fn.env))
+	for _, obj := range fn.env {
+		switch v := s.f.module.variables[obj].(type) {
+		case *WasmCellVar:
+			env = append(env, s.createGetLocal(v.cell, nil, indent))
+		case *WasmLocal, *WasmParam:
+			env = append(env, s.createGetLocal(v, nil, indent))
+		default:
+			return nil, s.f.file.ErrorNode(lit, "unimplemented capture of variable %s", obj.Name())
+		}
+	}
+	c, err := s.createClosure(fn, env, lit, indent)
+	if err != nil {
+		return nil, err
+	}
+	return s.setFuncValueType(c, lit)
+}
+
+func (s *WasmScope) setFuncValueType(x WasmExpression, expr ast.Expr) (WasmExpression, error) {
+	ty, err := s.f.file.convertType(s.f.file.info.TypeOf(expr))
+	if err != nil {
+		return nil, s.f.file.ErrorNode(expr, "%v", err)
+	}
+	x.setFullType(ty)
+	return x, nil
+}
+
+// parseMethodValue handles a method value x.M, which is a closure that holds
+// the receiver. A struct receiver is copied when the value is created.
+func (s *WasmScope) parseMethodValue(expr *ast.SelectorExpr, sel *types.Selection, indent int) (WasmExpression, error) {
+	if types.IsInterface(sel.Recv()) {
+		return nil, s.f.file.ErrorNode(expr, "unimplemented method value of an interface: %s", expr.Sel.Name)
+	}
+	fn, err := s.methodFunc(expr, sel)
+	if err != nil {
+		return nil, err
+	}
+	thunk, err := s.f.module.closureThunk(fn, true)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(expr, "%v", err)
+	}
+	var recv WasmExpression
+	if fn.copyRecv {
+		src, err := s.parseReceiverArg(expr.X, fn, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		size, err := s.createLiteralInt32(int32(fn.params[0].t.(*WasmTypePointer).base.getSize()), indent+2)
+		if err != nil {
+			return nil, err
+		}
+		size.setComment("receiver size")
+		recv, err = s.createRuntimeCall("closure", "ClosureCopy", []WasmExpression{src, size}, nil, indent+1)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		recv, err = s.parseReceiverArg(expr.X, fn, indent+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c, err := s.createClosure(thunk, []WasmExpression{recv}, expr, indent)
+	if err != nil {
+		return nil, err
+	}
+	return s.setFuncValueType(c, expr)
+}
+
+// useTwice returns two expressions with the value of expr, which has been
+// parsed into x. Unless expr is a variable, set stores the value in a
+// temporary local, which both read, so set has to be evaluated before them,
+// but they can be evaluated in any order, like the operands of call_indirect
+// in the two syntaxes.
+func (s *WasmScope) useTwice(expr ast.Expr, x WasmExpression, indent int) (set, first, second WasmExpression, err error) {
+	if _, ok := expr.(*ast.Ident); ok {
+		second, err = s.parseExpr(expr, indent)
+		return nil, x, second, err
+	}
+	tmp, err := s.createTempVar("tmp", x.getType())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tmp.setFullType(x.getFullType())
+	set, err = s.createSetVar(tmp, x, nil, indent)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return set, s.createGetLocal(tmp, nil, indent), s.createGetLocal(tmp, nil, indent), nil
+}
+
+// createIndirectCallExpr calls a function value. The table index is the last
+// operand of call_indirect, after the closure, except in the legacy syntax.
+func (s *WasmScope) createIndirectCallExpr(call *ast.CallExpr, name string, fun ast.Expr, indent int) (WasmExpression, error) {
+	sig := s.f.file.info.TypeOf(fun).Underlying().(*types.Signature)
+	ty, err := s.f.file.convertSignature(sig)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(call, "%v", err)
+	}
+	signature, err := s.f.module.closureSignature(ty)
+	if err != nil {
+		return nil, err
+	}
+	args, err := s.parseCallArgs(call.Args, sig, indent+1)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing args to function %s: %w", name, err)
+	}
+	x, err := s.parseExpr(fun, indent+2)
+	if err != nil {
+		return nil, fmt.Errorf("call_indirect, couldn't create expression for the function value: %w", err)
+	}
+	set, env, fnX, err := s.useTwice(fun, x, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	env.setIndent(indent + 1)
+	idx, err := s.createFuncIndex(fnX, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	s.f.module.funcPtrTable.indirect = true
+	c := &WasmCallIndirect{
+		name:      name,
+		signature: signature,
+		index:     idx,
+		before:    set,
+	}
+	c.args = append(args, env)
+	c.call = call
+	c.setIndent(indent)
+	c.setNode(call)
+	c.setScope(s)
+	if len(signature.results) > 0 {
+		c.setFullType(signature.results[0])
+	}
+	return c, nil
+}
+
+// createFuncIndex returns the function table index of the function value fn,
+// which is the first word of its closure. The call doesn't need rt/closure,
+// e.g., for a top-level function, whose closure is static. A nil function
+// value points to the word at address 0, which holds an invalid index, so that
+// calling it traps.
+func (s *WasmScope) createFuncIndex(fn WasmExpression, indent int) (WasmExpression, error) {
+	t, err := s.f.module.scalarType("int32")
+	if err != nil {
+		return nil, err
+	}
+	fn.setIndent(indent + 1)
+	idx, err := s.createLoad(fn, t, indent)
+	if err != nil {
+		return nil, err
+	}
+	idx.setComment("function table index")
+	return idx, nil
+}
+
+// isFuncValue returns whether a called expression is a function value rather
+// than a function, a method or a type.
+func (s *WasmScope) isFuncValue(fun ast.Expr) bool {
+	tv, ok := s.f.file.info.Types[fun]
+	if !ok || tv.IsType() || tv.IsBuiltin() {
+		return false
+	}
+	_, ok = tv.Type.Underlying().(*types.Signature)
+	return ok
+}
@@ -14,12 +14,28 @@ import (
 var dumpAST bool
 var verbose bool
 var outFile string
+var outFormat string
+var legacySyntax bool
+var mvpTarget bool
+var runAssertions bool
+var runtimeRoot string
+var memoryPages int
+var maxMemoryPages int
 
 func initFlags() {
 	flag.BoolVar(&dumpAST, "d", false, "print the Go AST to stdout")
 	flag.BoolVar(&verbose, "v", false, "print out extra information")
 	flag.StringVar(&outFile, "o", "out.wast", "output file")
+	flag.StringVar(&outFormat, "format", "", "output format: 'wast' (text) or 'wasm' (binary), derived from the output file extension by default")
+	flag.BoolVar(&legacySyntax, "legacy", false, "print the text format in the legacy ml-proto dialect")
+	flag.BoolVar(&mvpTarget, "mvp", false, "only use the instructions of the WebAssembly MVP, implied by -legacy: saturating conversions and sign extension are emulated")
+	flag.BoolVar(&runAssertions, "run", false, "run the assert_return, assert_trap and invoke pragmas in the built-in interpreter")
+	flag.StringVar(&runtimeRoot, "rt", "gowasm/rt", "import path of the directory with the runtime packages, which are linked into modules compiled from packages")
+	flag.IntVar(&memoryPages, "memory", 0, "initial size of the memory in 64 KiB pages, at least what the static data needs")
+	flag.IntVar(&maxMemoryPages, "max-memory", 0, "maximum size of the memory in 64 KiB pages, to which the heap can grow, or 0 for no limit")
 	flag.Parse()
+	// The legacy dialect has no names for the instructions added after the MVP.
+	mvpTarget = mvpTarget || legacySyntax
 }
 
 type FormattingWriter interface {
@@ -53,45 +69,74 @@ func (w *FormattingWriterImpl) WriteToFile(name string) (int, error) {
 	return f.Write(w.b.Bytes())
 }
 
-func Compile(fileName string, writer FormattingWriter, m WasmModuleLinker) {
+func Compile(fileName string, fset *token.FileSet) (*ast.File, error) {
 	fmt.Printf("Compiling file '%s'\n", fileName)
-	fset := token.NewFileSet()
 	f, err := parser.ParseFile(fset, fileName, nil, parser.ParseComments)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	if dumpAST {
 		ast.Print(fset, f)
 	}
+	return f, nil
+}
 
-	err = m.addAstFile(f, fset)
-	if err != nil {
-		panic(err)
+// exitOnError prints the diagnostics in err, one per line, and exits with a
+// nonzero status.
+func exitOnError(err error) {
+	if err == nil {
+		return
+	}
+	var errs GoWasmErrorList
+	errs.add(err)
+	for _, e := range errs {
+		fmt.Fprintln(os.Stderr, e)
 	}
+	os.Exit(1)
 }
 
 func main() {
 	initFlags()
 	writer := &FormattingWriterImpl{}
-	m := NewWasmModuleLinker()
-	for _, f := range flag.Args() {
-		Compile(f, writer, m)
+	m, err := link(flag.Args())
+	exitOnError(err)
+
+	switch outputFormat() {
+	default:
+		err = fmt.Errorf("unsupported output format: '%s'", outFormat)
+	case "wasm":
+		binWriter := NewWasmBinaryWriter()
+		m.encode(binWriter)
+		if verbose {
+			fmt.Printf("--- WASM binary output: %d bytes\n", binWriter.b.Len())
+		}
+		_, err = binWriter.WriteToFile(outFile)
+	case "wast":
+		m.print(writer)
+		if verbose {
+			fmt.Printf("--- begin WASM output\n%s\n--- end WASM output\n", writer.b.String())
+		}
+		_, err = writer.WriteToFile(outFile)
 	}
+	exitOnError(err)
+	fmt.Printf("Output written to '%s'\n", outFile)
 
-	if err := m.finalize(); err != nil {
-		panic(err)
+	if runAssertions {
+		failed, err := m.run()
+		exitOnError(err)
+		if failed > 0 {
+			os.Exit(1)
+		}
 	}
+}
 
-	m.print(writer)
-
-	if verbose {
-		fmt.Printf("--- begin WASM output\n%s\n--- end WASM output\n", writer.b.String())
+func outputFormat() string {
+	if outFormat != "" {
+		return outFormat
 	}
-
-	_, err := writer.WriteToFile(outFile)
-	if err != nil {
-		panic(err)
+	if strings.HasSuffix(outFile, ".wasm") {
+		return "wasm"
 	}
-	fmt.Printf("Output written to '%s'\n", outFile)
+	return "wast"
 }
@@ -0,0 +1,403 @@
+package main
+
+// Conversions between numeric types.
+//
+// Integers of up to 32 bits are i32 values, and the values of 8- and 16-bit
+// types are kept sign-extended or zero-extended to 32 bits, so that they
+// compare and convert like in Go. The results of arithmetic on them are
+// truncated to their size again, see wrapSmallInt. Conversions of floats to
+// integers saturate and convert NaN to 0, since they don't panic in Go, whose
+// result is implementation-dependent if the value doesn't fit.
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ( <type>.<op> <expr> ), e.g., ( i64.extend_i32_s <expr> )
+//
+// A conversion between types of the same representation, e.g., of the result
+// of a division to another signedness, has no instruction.
+type WasmConvert struct {
+	WasmExprBase
+	op string
+	x  WasmExpression
+}
+
+func (c *WasmConvert) getType() WasmType {
+	return c.ty
+}
+
+// legacyConvertName returns the name of a conversion in the legacy syntax,
+// e.g., "i64.extend_s/i32" for "i64.extend_i32_s".
+func legacyConvertName(op string) string {
+	ts, name, _ := strings.Cut(op, ".")
+	parts := strings.Split(name, "_")
+	switch len(parts) {
+	case 2:
+		return fmt.Sprintf("%s.%s/%s", ts, parts[0], parts[1])
+	case 3:
+		return fmt.Sprintf("%s.%s_%s/%s", ts, parts[0], parts[2], parts[1])
+	}
+	return op
+}
+
+func (c *WasmConvert) print(writer FormattingWriter) {
+	if c.op == "" {
+		c.x.print(writer)
+		return
+	}
+	op := c.op
+	if legacySyntax {
+		op = legacyConvertName(op)
+	}
+	writer.PrintfIndent(c.getIndent(), "(%s%s\n", op, c.getComment())
+	c.x.print(writer)
+	writer.PrintfIndent(c.getIndent(), ") ;; %s\n", op)
+}
+
+func (c *WasmConvert) encode(writer *WasmBinaryWriter) {
+	c.x.encode(writer)
+	if c.op != "" {
+		writer.writeOpcode(c.op)
+	}
+}
+
+func (s *WasmScope) createConvert(op string, x WasmExpression, t WasmType, indent int) *WasmConvert {
+	c := &WasmConvert{
+		op: op,
+		x:  x,
+	}
+	c.setType(t)
+	c.setFullType(t)
+	c.setIndent(indent)
+	c.setScope(s)
+	return c
+}
+
+func isNumeric(t types.Type) bool {
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Info()&(types.IsInteger|types.IsFloat) != 0
+}
+
+func isInteger(t types.Type) bool {
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Info()&types.IsInteger != 0
+}
+
+func isUnsafePointer(t types.Type) bool {
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Kind() == types.UnsafePointer
+}
+
+// isNopConversion returns whether a value of type from converted to type to
+// keeps its representation, e.g., a pointer converted to unsafe.Pointer or a
+// struct converted to a named type with the same underlying type.
+func isNopConversion(from, to types.Type) bool {
+	if b, ok := from.(*types.Basic); ok && b.Kind() == types.UntypedNil {
+		return true
+	}
+	fu, tu := from.Underlying(), to.Underlying()
+	if types.IdenticalIgnoreTags(fu, tu) {
+		return true
+	}
+	if isUnsafePointer(from) || isUnsafePointer(to) {
+		return true
+	}
+	fp, ok := fu.(*types.Pointer)
+	if !ok {
+		return false
+	}
+	tp, ok := tu.(*types.Pointer)
+	return ok && types.IdenticalIgnoreTags(fp.Elem().Underlying(), tp.Elem().Underlying())
+}
+
+func (s *WasmScope) parseConvertExpr(ty WasmType, call *ast.CallExpr, indent int) (WasmExpression, error) {
+	v := call.Args[0]
+	from, to := s.f.file.info.TypeOf(v), s.f.file.info.TypeOf(call)
+	switch {
+	case isNumeric(to) && isNumeric(from):
+		return s.parseNumericConversion(ty, v, indent)
+	case isNopConversion(from, to):
+	case isString(to) || isString(from):
+		return s.parseStringConversion(call, from, to, indent)
+	default:
+		return nil, s.f.file.ErrorNode(call, "unimplemented conversion from %v to %v", from, to)
+	}
+	// The other conversions, e.g., of pointers, are nops.
+	expr, err := s.parseExpr(v, indent)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ty.(type) {
+	default:
+		// The type of a load is the type of the value in memory, the
+		// conversion applies to the loaded value.
+		if _, ok := expr.(*WasmLoad); !ok {
+			expr.setType(ty)
+		}
+	case *WasmTypePointer:
+		// Pointer types are always treated as i32
+	}
+	expr.setFullType(ty)
+	return expr, nil
+}
+
+// parseNumericConversion converts the value of v to the integer or float type
+// ty.
+func (s *WasmScope) parseNumericConversion(ty WasmType, v ast.Expr, indent int) (WasmExpression, error) {
+	from, err := s.f.file.convertType(s.f.file.info.TypeOf(v))
+	if err != nil {
+		return nil, s.f.file.ErrorNode(v, "%v", err)
+	}
+	x, err := s.parseExpr(v, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	op, err := conversionOpName(from, ty)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(v, "%v", err)
+	}
+	switch {
+	case op != "" && mvpTarget && strings.Contains(op, ".trunc_sat_"):
+		x, err = s.createTruncSat(op, x, from, indent)
+		if err != nil {
+			return nil, err
+		}
+	case op != "":
+		if l, ok := x.(*WasmLoad); ok && strings.HasPrefix(op, "i64.extend_") {
+			// The load extends the value, e.g., i64.load32_s.
+			l.setType(ty)
+			l.setIndent(indent)
+			x = l
+			break
+		}
+		x = s.createConvert(op, x, ty, indent)
+	case !needsWrap(from, ty):
+		switch x.(type) {
+		case *WasmBinOp:
+			// The type of an operation determines its instruction.
+			x = s.createConvert("", x, ty, indent)
+		default:
+			// A load can be of another type than the value in memory of the
+			// same size, see loadOpName.
+			x.setType(ty)
+			x.setIndent(indent)
+		}
+	}
+	if needsWrap(from, ty) {
+		x, err = s.createWrap(x, ty, indent)
+		if err != nil {
+			return nil, err
+		}
+	}
+	x.setFullType(ty)
+	return x, nil
+}
+
+// conversionOpName returns the instruction that converts a value of type from
+// to type to, or "" if the values have the same representation on the stack.
+func conversionOpName(from, to WasmType) (string, error) {
+	fs, err := stackTypeName(from)
+	if err != nil {
+		return "", err
+	}
+	ts, err := stackTypeName(to)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case fs == ts:
+		return "", nil
+	case ts == "f64" && fs == "f32":
+		return "f64.promote_f32", nil
+	case ts == "f32" && fs == "f64":
+		return "f32.demote_f64", nil
+	case from.isFloat():
+		// The value of a small type is truncated to 32 bits, then wrapped.
+		return ts + ".trunc_sat_" + fs + "_" + signSuffix(to.isSigned() || to.getSize() < 4), nil
+	case to.isFloat():
+		return ts + ".convert_" + fs + "_" + signSuffix(from.isSigned()), nil
+	case ts == "i64":
+		return "i64.extend_i32_" + signSuffix(from.isSigned()), nil
+	}
+	return "i32.wrap_i64", nil
+}
+
+func signSuffix(signed bool) string {
+	if signed {
+		return "s"
+	}
+	return "u"
+}
+
+// needsWrap returns whether a value of type from that is converted to type to
+// must be truncated to the size of to, i.e., to is a small integer type that
+// doesn't hold all the values of from.
+func needsWrap(from, to WasmType) bool {
+	if to.isFloat() || to.getSize() >= 4 {
+		return false
+	}
+	if from.isFloat() || from.getSize() > to.getSize() {
+		return true
+	}
+	return from.isSigned() && !to.isSigned() || from.getSize() == to.getSize() && from.isSigned() != to.isSigned()
+}
+
+// wrapSmallInt truncates the result of an operation on an 8- or 16-bit integer
+// type to the size of the type, e.g., so that int8(127) + 1 is -128.
+func (s *WasmScope) wrapSmallInt(x WasmExpression, op BinOp, indent int) (WasmExpression, error) {
+	t := x.getType()
+	if t.isFloat() || t.getSize() >= 4 {
+		return x, nil
+	}
+	switch op {
+	default:
+		return x, nil
+	case binOpAdd, binOpSub, binOpMul, binOpDiv, binOpShl, binOpXor:
+	}
+	return s.createWrap(x, t, indent)
+}
+
+// createWrap truncates the i32 x to the size of the small integer type t and
+// sign-extends or zero-extends it to 32 bits again.
+func (s *WasmScope) createWrap(x WasmExpression, t WasmType, indent int) (WasmExpression, error) {
+	bits := t.getSize() * 8
+	if !t.isSigned() {
+		mask, err := s.createLiteral(fmt.Sprintf("%d", 1<<bits-1), t, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		return s.createBinaryExpr(x, mask, binOpAnd, t, indent)
+	}
+	if !mvpTarget {
+		return s.createConvert(fmt.Sprintf("i32.extend%d_s", bits), x, t, indent), nil
+	}
+	// The MVP has no sign extension instructions.
+	n, err := s.createLiteral(fmt.Sprintf("%d", 32-bits), t, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	shl, err := s.createBinaryExpr(x, n, binOpShl, t, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	n, err = s.createLiteral(fmt.Sprintf("%d", 32-bits), t, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createBinaryExpr(shl, n, binOpShr, t, indent)
+}
+
+// createTruncSat converts the float x of type from like the saturating
+// truncation op, e.g., i32.trunc_sat_f64_s, for the MVP, which only has the
+// truncations that trap. These are applied to the values in range only, the
+// others are selected: the smallest or largest integer, or 0 for NaN.
+func (s *WasmScope) createTruncSat(op string, x WasmExpression, from WasmType, indent int) (WasmExpression, error) {
+	signed := strings.HasSuffix(op, "_s")
+	intName, bits := "int32", 32
+	if strings.HasPrefix(op, "i64.") {
+		intName, bits = "int64", 64
+	}
+	if !signed {
+		intName = "u" + intName
+	}
+	t, err := s.f.module.scalarType(intName)
+	if err != nil {
+		return nil, err
+	}
+	i32, err := s.f.module.scalarType("int32")
+	if err != nil {
+		return nil, err
+	}
+	// The values in range are lo <= x < hi, or -1 < x < hi if unsigned.
+	hi := math.Ldexp(1, bits)
+	lo := -1.0
+	min, max := "0", strconv.FormatUint(math.MaxUint64>>(64-bits), 10)
+	if signed {
+		hi = math.Ldexp(1, bits-1)
+		lo = -hi
+		min = strconv.FormatInt(-1<<(bits-1), 10)
+		max = strconv.FormatInt(1<<(bits-1)-1, 10)
+	}
+	tmp, err := s.createTempVar("trunc", from)
+	if err != nil {
+		return nil, err
+	}
+	tmp.setFullType(from)
+	set, err := s.createSetVar(tmp, x, nil, indent)
+	if err != nil {
+		return nil, err
+	}
+	compare := func(op BinOp, bound float64, indent int) (WasmExpression, error) {
+		b, err := s.createLiteral(strconv.FormatFloat(bound, 'g', -1, 64), from, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		return s.createBinaryExpr(s.createGetLocal(tmp, nil, indent+1), b, op, from, indent)
+	}
+	inRange := func(indent int) (WasmExpression, error) {
+		op := binOpGe
+		if !signed {
+			op = binOpGt
+		}
+		lower, err := compare(op, lo, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		upper, err := compare(binOpLt, hi, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		return s.createBinaryExpr(lower, upper, binOpAnd, i32, indent)
+	}
+	selectOf := func(x, y, cond WasmExpression, t WasmType, indent int) WasmExpression {
+		sel := &WasmSelect{x: x, y: y, cond: cond}
+		sel.setType(t)
+		sel.setIndent(indent)
+		sel.setScope(s)
+		return sel
+	}
+	literal := func(value string, t WasmType) WasmExpression {
+		// Only a literal without a type is an error.
+		v, _ := s.createLiteral(value, t, indent+2)
+		return v
+	}
+
+	// The truncated value, or 0 if x isn't in range.
+	cond, err := inRange(indent + 3)
+	if err != nil {
+		return nil, err
+	}
+	safe := selectOf(s.createGetLocal(tmp, nil, indent+3), literal("0", from), cond, from, indent+2)
+	truncated := s.createConvert(strings.Replace(op, "trunc_sat_", "trunc_", 1), safe, t, indent+1)
+
+	// The value otherwise: NaN compares false to any bound.
+	low := literal("0", t)
+	if signed {
+		isNumber, err := s.createBinaryExpr(s.createGetLocal(tmp, nil, indent+3), s.createGetLocal(tmp, nil, indent+3), binOpEq, from, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		low = selectOf(literal(min, t), literal("0", t), isNumber, t, indent+2)
+	}
+	tooLarge, err := compare(binOpGe, hi, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	saturated := selectOf(literal(max, t), low, tooLarge, t, indent+1)
+
+	cond, err = inRange(indent + 1)
+	if err != nil {
+		return nil, err
+	}
+	return &WasmSequence{
+		stmts: []WasmExpression{set},
+		value: selectOf(truncated, saturated, cond, t, indent),
+	}, nil
+}
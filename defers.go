@@ -0,0 +1,486 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"strings"
+)
+
+// defer, panic and recover are implemented by rt/panics. A panic sets a flag
+// that is checked after each call that may panic, which is a call of a
+// function that isn't in the runtime. If the flag is set, a function without
+// deferred calls returns zero values. The body of a function with deferred
+// calls is a block, which return statements and the check branch out of after
+// storing the results, so that the deferred calls run before the function
+// returns:
+//
+//	(local.set $defer_frame (call DeferEnter))
+//	(block $defer_body
+//	  <body>
+//	)
+//	(call DeferReturn (local.get $defer_frame))
+//	(return <results>)
+//
+// The checks are only generated if a package of the module calls panic.
+
+// WasmUnwindCheck is a call followed by the check whether a panic unwinds the
+// stack.
+type WasmUnwindCheck struct {
+	WasmExprBase
+	call  WasmExpression
+	check *WasmIf
+}
+
+func (c *WasmUnwindCheck) print(writer FormattingWriter) {
+	c.call.print(writer)
+	c.check.print(writer)
+}
+
+func (c *WasmUnwindCheck) encode(writer *WasmBinaryWriter) {
+	c.call.encode(writer)
+	c.check.encode(writer)
+}
+
+func (c *WasmUnwindCheck) getType() WasmType {
+	return c.call.getType()
+}
+
+func (c *WasmUnwindCheck) setType(t WasmType) {
+	c.call.setType(t)
+}
+
+func (c *WasmUnwindCheck) getFullType() WasmType {
+	return c.call.getFullType()
+}
+
+func (c *WasmUnwindCheck) setFullType(t WasmType) {
+	c.call.setFullType(t)
+}
+
+func (c *WasmUnwindCheck) getNode() ast.Node {
+	return c.call.getNode()
+}
+
+func (c *WasmUnwindCheck) getIndent() int {
+	return c.call.getIndent()
+}
+
+func (c *WasmUnwindCheck) setIndent(indent int) {
+	c.call.setIndent(indent)
+	c.check.setIndent(indent)
+}
+
+// isRuntime returns whether the file is in one of the runtime packages, whose
+// calls of panic trap.
+func (file *WasmGoSourceFile) isRuntime() bool {
+	for _, pkg := range runtimePackages {
+		if file.pkgName == runtimePath(pkg) {
+			return true
+		}
+	}
+	return false
+}
+
+// callsPanic returns whether a file outside of the runtime calls panic.
+func (m *WasmModule) callsPanic() bool {
+	for _, file := range m.files {
+		if file.isRuntime() {
+			continue
+		}
+		found := false
+		ast.Inspect(file.astFile, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok {
+				if b, ok := file.info.Uses[ident].(*types.Builtin); ok && b.Name() == "panic" {
+					found = true
+				}
+			}
+			return !found
+		})
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDefer returns whether a function body has defer statements, not counting
+// the ones in function literals.
+func hasDefer(body *ast.BlockStmt) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.DeferStmt:
+			found = true
+		case *ast.FuncLit:
+			return false
+		}
+		return !found
+	})
+	return found
+}
+
+// parseDeferBody parses the body of a function with deferred calls into a
+// block followed by the code that runs them.
+func (f *WasmFunc) parseDeferBody(indent int) error {
+	s := f.scope
+	ptrType, err := f.module.scalarType("uintptr")
+	if err != nil {
+		return err
+	}
+	frame, err := s.createTempVar("defer_frame", ptrType)
+	if err != nil {
+		return err
+	}
+	enter, err := s.createRuntimeCall("panics", "DeferEnter", nil, nil, indent+1)
+	if err != nil {
+		return err
+	}
+	set, err := s.createSetVar(frame, enter, nil, indent)
+	if err != nil {
+		return err
+	}
+	s.expressions = append(s.expressions, set)
+	f.deferFrame = frame
+	for _, r := range f.results {
+		var v WasmVariable
+		if r.astIdent != nil {
+			v = f.module.variables[f.file.objectOf(r.astIdent)]
+		} else {
+			v, err = s.createTempVar("result", r.t)
+			if err != nil {
+				return err
+			}
+		}
+		f.deferResults = append(f.deferResults, v)
+	}
+
+	body := f.createScope("defer_body")
+	f.deferLabel = body.name
+	if err := body.parseStatementList(f.body().List, indent+1); err != nil {
+		return err
+	}
+	block := s.createBlock(body, nil, indent)
+	block.label = body.name
+	s.expressions = append(s.expressions, block)
+
+	ret, err := s.createRuntimeCall("panics", "DeferReturn", []WasmExpression{s.createGetLocal(frame, nil, indent+1)}, nil, indent)
+	if err != nil {
+		return err
+	}
+	s.expressions = append(s.expressions, ret)
+	if len(f.results) == 0 {
+		return nil
+	}
+	r := &WasmReturn{}
+	r.setIndent(indent)
+	r.setScope(s)
+	for _, v := range f.deferResults {
+		value, err := s.createGetVar(v, indent+1)
+		if err != nil {
+			return err
+		}
+		r.values = append(r.values, value)
+	}
+	s.expressions = append(s.expressions, r)
+	return nil
+}
+
+// createGetVar returns the value of a local variable, which may be in a cell.
+func (s *WasmScope) createGetVar(v WasmVariable, indent int) (WasmExpression, error) {
+	if c, ok := v.(*WasmCellVar); ok {
+		return s.createCellGet(c, nil, indent)
+	}
+	return s.createGetLocal(v, nil, indent), nil
+}
+
+// parseDeferredReturn handles a return statement in a function with deferred
+// calls. It stores the results and branches to the deferred calls.
+func (s *WasmScope) parseDeferredReturn(stmt *ast.ReturnStmt, indent int) (WasmExpression, error) {
+	scope := s.f.createScope("return")
+	if len(stmt.Results) > 0 {
+		r, err := s.createReturn(stmt, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		vars := s.f.deferResults
+		if len(r.values) > 1 || numValues(r.values[0]) > 1 {
+			// Named results may be used by the other values, so all values
+			// are computed before they are assigned.
+			vars = make([]WasmVariable, len(s.f.results))
+			for i, result := range s.f.results {
+				vars[i], err = s.createTempVar("ret", result.t)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		if len(r.values) == 1 && len(vars) > 1 {
+			scope.expressions = append(scope.expressions, s.createTupleSet(r.values[0], vars, stmt, indent+1))
+		} else {
+			for i, value := range r.values {
+				set, err := s.createSetVar(vars[i], value, stmt, indent+1)
+				if err != nil {
+					return nil, err
+				}
+				scope.expressions = append(scope.expressions, set)
+			}
+		}
+		if len(vars) > 1 {
+			for i, v := range s.f.deferResults {
+				set, err := s.createSetVar(v, s.createGetLocal(vars[i], nil, indent+2), stmt, indent+1)
+				if err != nil {
+					return nil, err
+				}
+				scope.expressions = append(scope.expressions, set)
+			}
+		}
+	}
+	br := &WasmBreak{
+		scope: scope,
+		label: s.f.deferLabel,
+	}
+	br.setIndent(indent + 1)
+	br.setComment("run deferred calls")
+	scope.expressions = append(scope.expressions, br)
+	return s.createBlock(scope, stmt, indent), nil
+}
+
+// parseDeferStmt pushes the deferred call as a function value without
+// parameters.
+func (s *WasmScope) parseDeferStmt(stmt *ast.DeferStmt, indent int) (WasmExpression, error) {
+	fn, err := s.createCallValue(stmt.Call, stmt, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createRuntimeCall("panics", "DeferPush", []WasmExpression{fn}, stmt, indent)
+}
+
+// createCallValue returns a function value without parameters or results that
+// makes the call of a defer or go statement. A call with arguments or results
+// is wrapped in a closure that holds the function value and the arguments,
+// which are evaluated now. close is called through its runtime function.
+func (s *WasmScope) createCallValue(call *ast.CallExpr, stmt ast.Stmt, indent int) (WasmExpression, error) {
+	var fn WasmExpression
+	var sig *types.Signature
+	tv := s.f.file.info.Types[call.Fun]
+	if id, ok := ast.Unparen(call.Fun).(*ast.Ident); ok && tv.IsBuiltin() && id.Name == "close" {
+		if _, err := s.chanType(call.Args[0]); err != nil {
+			return nil, err
+		}
+		def, ok := s.f.module.funcSymTab[mangleFunctionName(runtimePath("sched"), "ChanClose")]
+		if !ok {
+			return nil, fmt.Errorf("link error, couldn't find runtime function: ChanClose")
+		}
+		var err error
+		fn, err = s.parseFuncIdent(id, def, indent)
+		if err != nil {
+			return nil, err
+		}
+		sig = def.goSignature()
+	} else {
+		if tv.IsType() || tv.IsBuiltin() {
+			return nil, s.f.file.ErrorNode(stmt, "unimplemented %s of a builtin function or a conversion", stmtKind(stmt))
+		}
+		var ok bool
+		sig, ok = tv.Type.Underlying().(*types.Signature)
+		if !ok {
+			return nil, s.f.file.ErrorNode(stmt, "unimplemented %s", stmtKind(stmt))
+		}
+		var err error
+		fn, err = s.parseExpr(call.Fun, indent)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(call.Args) == 0 && sig.Results().Len() == 0 {
+		return fn, nil
+	}
+	thunk, err := s.f.module.callThunk(s.f.file, sig, stmt)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(stmt, "%v", err)
+	}
+	args, err := s.parseCallArgs(call.Args, sig, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createClosure(thunk, append([]WasmExpression{fn}, args...), stmt, indent)
+}
+
+// stmtKind returns the name of a defer or go statement for error messages.
+func stmtKind(stmt ast.Stmt) string {
+	if _, ok := stmt.(*ast.GoStmt); ok {
+		return "go statement"
+	}
+	return "deferred call"
+}
+
+// callThunk returns the function that makes the call of a defer or go
+// statement of a function with signature sig. Its closure holds the function
+// value followed by the arguments.
+func (m *WasmModule) callThunk(file *WasmGoSourceFile, sig *types.Signature, stmt ast.Stmt) (*WasmFunc, error) {
+	ty, err := file.convertSignature(sig)
+	if err != nil {
+		return nil, err
+	}
+	target, err := m.closureSignature(ty)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := m.callThunks[target]; ok {
+		return t, nil
+	}
+	if sig.Variadic() {
+		return nil, fmt.Errorf("unimplemented %s of a variadic function", stmtKind(stmt))
+	}
+	for i, t := range ty.params {
+		switch sig.Params().At(i).Type().Underlying().(type) {
+		case *types.Array, *types.Struct:
+			t = nil
+		}
+		if t == nil || t.isFloat() || t.getSize() > 4 {
+			return nil, fmt.Errorf("unimplemented %s with an argument of type %s", stmtKind(stmt), sig.Params().At(i).Type())
+		}
+	}
+	void := &WasmTypeFunc{
+		indent: ty.indent,
+	}
+	void.setAlign(4)
+	void.setSize(4)
+	f := &WasmFunc{
+		fset:       file.fset,
+		module:     m,
+		file:       file,
+		indent:     1,
+		name:       "$call" + strings.TrimPrefix(target.wasmName, "$"),
+		origName:   "call " + sig.String(),
+		namePos:    stmt.Pos(),
+		signature:  m.signatures.add(void),
+		gotoLabels: make(map[string]string),
+	}
+	if err := f.addEnvParam(); err != nil {
+		return nil, err
+	}
+	f.scope = f.createScope(fmt.Sprintf("function_%s", f.origName))
+	s := f.scope
+	env := func(i, indent int) (WasmExpression, error) {
+		index, err := s.createLiteralInt32(int32(i), indent+1)
+		if err != nil {
+			return nil, err
+		}
+		return s.createRuntimeCall("closure", "ClosureEnv", []WasmExpression{s.createGetLocal(f.envParam, nil, indent+1), index}, nil, indent)
+	}
+	m.funcPtrTable.indirect = true
+	c := &WasmCallIndirect{
+		name:      "call",
+		signature: target,
+	}
+	for i := range ty.params {
+		arg, err := env(i+1, f.indent+2)
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, arg)
+	}
+	fn, err := env(0, f.indent+2)
+	if err != nil {
+		return nil, err
+	}
+	c.args = append(c.args, fn)
+	fn, err = env(0, f.indent+3)
+	if err != nil {
+		return nil, err
+	}
+	c.index, err = s.createFuncIndex(fn, f.indent+2)
+	if err != nil {
+		return nil, err
+	}
+	c.setIndent(f.indent + 1)
+	c.setScope(s)
+	s.expressions = append(s.expressions, c)
+	f.prepareForIndirectCall()
+	m.functions = append(m.functions, f)
+	m.funcSymTab[f.name] = f
+	m.callThunks[target] = f
+	return f, nil
+}
+
+// parsePanicCall starts a panic with the value of the argument converted to
+// an empty interface, and unwinds the stack.
+func (s *WasmScope) parsePanicCall(call *ast.CallExpr, indent int) (WasmExpression, error) {
+	if s.f.file.isRuntime() {
+		// The runtime can't recover, so its panics trap.
+		return s.createUnreachable(call, indent), nil
+	}
+	v, err := s.parseExprAs(call.Args[0], types.NewInterfaceType(nil, nil), indent+1)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.createRuntimeCall("panics", "Panic", []WasmExpression{v}, call, indent)
+	if err != nil {
+		return nil, err
+	}
+	unwind, err := s.createUnwind(indent + 1)
+	if err != nil {
+		return nil, err
+	}
+	scope := s.f.createScope("panic")
+	scope.expressions = append(scope.expressions, p, unwind)
+	return s.createBlock(scope, nil, indent), nil
+}
+
+// checkUnwind adds the check whether a panic unwinds the stack after a call
+// that may panic.
+func (s *WasmScope) checkUnwind(e WasmExpression, indent int) (WasmExpression, error) {
+	if !s.f.module.panics || s.f.file.isRuntime() {
+		return e, nil
+	}
+	switch e := e.(type) {
+	default:
+		return e, nil
+	case *WasmCall:
+		if e.def == nil || e.def.file.isRuntime() {
+			return e, nil
+		}
+	case *WasmCallIndirect:
+	}
+	cond, err := s.createRuntimeCall("panics", "Unwinding", nil, nil, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	unwind, err := s.createUnwind(indent + 1)
+	if err != nil {
+		return nil, err
+	}
+	check, err := s.createIf(cond, unwind, nil, indent)
+	if err != nil {
+		return nil, err
+	}
+	return &WasmUnwindCheck{call: e, check: check}, nil
+}
+
+// createUnwind returns the branch to the deferred calls of the function or,
+// if it has none, a return of zero values.
+func (s *WasmScope) createUnwind(indent int) (WasmExpression, error) {
+	if s.f.deferFrame != nil {
+		br := &WasmBreak{
+			scope: s,
+			label: s.f.deferLabel,
+		}
+		br.setIndent(indent)
+		br.setComment("unwind")
+		return br, nil
+	}
+	r := &WasmReturn{}
+	r.setIndent(indent)
+	r.setScope(s)
+	r.setComment("unwind")
+	for _, result := range s.f.results {
+		zero, err := s.createNilLiteral(result.t, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		r.values = append(r.values, zero)
+	}
+	return r, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const badSource = `package bad
+
+func F(x int32) int32 {
+	y := x
+	x += 1
+	return x + y
+}
+
+func G(c complex128, n int32) int32 {
+	n += 2
+	return n
+}
+`
+
+// TestDiagnostics checks that each error is reported at the statement or the
+// declaration it is in, and that an error in a declaration doesn't hide the
+// errors in function bodies.
+func TestDiagnostics(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "e.go")
+	if err := os.WriteFile(name, []byte(badSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := link([]string{name})
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	want := []string{
+		"e.go:9:10: error in a function parameter type",
+		"e.go:5:2: unimplemented AssignStmt",
+		"e.go:10:2: unimplemented AssignStmt",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d errors, got:\n%v", len(want), err)
+	}
+	for i, w := range want {
+		if !strings.Contains(lines[i], w) {
+			t.Errorf("expected an error with %q, got %q", w, lines[i])
+		}
+	}
+}
+
+const arraySource = `package bad
+
+func F(a, b [2]int32) bool {
+	return a == b
+}
+`
+
+// TestArrayComparison checks that comparing arrays, whose values are their
+// addresses, is reported rather than comparing the addresses.
+func TestArrayComparison(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "e.go")
+	if err := os.WriteFile(name, []byte(arraySource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := link([]string{name})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if w := "e.go:4:9: unimplemented comparison of array values"; !strings.Contains(err.Error(), w) {
+		t.Errorf("expected an error with %q, got %v", w, err)
+	}
+}
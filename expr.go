@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"go/ast"
 	"go/token"
+	"go/types"
 	"strconv"
 	"strings"
 )
@@ -87,6 +88,7 @@ var binOpMapping = [...]BinOp{
 
 type WasmExpression interface {
 	print(writer FormattingWriter)
+	encode(writer *WasmBinaryWriter)
 	getType() WasmType
 	setType(t WasmType)
 	getFullType() WasmType
@@ -140,17 +142,31 @@ type WasmBinOp struct {
 	y  WasmExpression
 }
 
-// ( <type>.load((8|16)_<sign>)? <offset>? <align>? <expr> )
+// ( select <expr> <expr> <expr> )
+type WasmSelect struct {
+	WasmExprBase
+	x    WasmExpression
+	y    WasmExpression
+	cond WasmExpression
+}
+
+// ( <type>.load((8|16|32)_<sign>)? <offset>? <align>? <expr> )
+//
+// mem is the type of the value in memory, which may be narrower than the type
+// of the load.
 type WasmLoad struct {
 	WasmExprBase
-	addr WasmExpression
+	addr   WasmExpression
+	offset uint32
+	mem    WasmType
 }
 
-// ( <type>.store <offset>? <align>? <expr> <expr> )
+// ( <type>.store(8|16|32)? <offset>? <align>? <expr> <expr> )
 type WasmStore struct {
 	WasmExprBase
-	addr WasmExpression
-	val  WasmExpression
+	addr   WasmExpression
+	val    WasmExpression
+	offset uint32
 }
 
 func (e *WasmExprBase) getIndent() int {
@@ -220,28 +236,51 @@ func (e *WasmExprBase) setScope(scope *WasmScope) {
 	e.scope = scope
 }
 
-func (s *WasmScope) parseExpr(expr ast.Expr, typeHint WasmType, indent int) (WasmExpression, error) {
+func (s *WasmScope) parseExpr(expr ast.Expr, indent int) (WasmExpression, error) {
+	if tv, ok := s.f.file.info.Types[expr]; ok {
+		if tv.Value != nil {
+			return s.createConstant(expr, tv, indent)
+		}
+		if tv.IsNil() {
+			t, err := s.f.file.convertType(tv.Type)
+			if err != nil {
+				return nil, s.f.file.ErrorNode(expr, "%v", err)
+			}
+			return s.createNilLiteral(t, indent)
+		}
+	}
 	switch expr := expr.(type) {
 	default:
 		return nil, s.f.file.ErrorNode(expr, "unimplemented expression")
-	case *ast.BasicLit:
-		return s.parseBasicLit(expr, typeHint, indent)
 	case *ast.BinaryExpr:
-		return s.parseBinaryExpr(expr, typeHint, indent)
+		return s.parseBinaryExpr(expr, indent)
 	case *ast.CallExpr:
-		return s.parseCallExpr(expr, indent)
+		if s.isBlockingCall(expr) {
+			return nil, s.f.file.ErrorNode(expr, "unimplemented call of a function that may block in an expression")
+		}
+		call, err := s.parseCallExpr(expr, indent)
+		if err != nil {
+			return nil, err
+		}
+		return s.checkUnwind(call, indent)
 	case *ast.CompositeLit:
 		return s.parseCompositeLit(expr, indent)
+	case *ast.FuncLit:
+		return s.parseFuncLit(expr, indent)
 	case *ast.Ident:
 		return s.parseIdent(expr, indent)
 	case *ast.IndexExpr:
-		return s.parseIndexExpr(expr, typeHint, indent)
+		return s.parseIndexExpr(expr, indent)
 	case *ast.ParenExpr:
-		return s.parseParenExpr(expr, typeHint, indent)
+		return s.parseParenExpr(expr, indent)
 	case *ast.SelectorExpr:
-		return s.parseSelectorExpr(expr, typeHint, indent)
+		return s.parseSelectorExpr(expr, indent)
+	case *ast.SliceExpr:
+		return s.parseSliceExpr(expr, indent)
 	case *ast.StarExpr:
-		return s.parseStarExpr(expr, typeHint, indent)
+		return s.parseStarExpr(expr, indent)
+	case *ast.TypeAssertExpr:
+		return s.parseTypeAssertExpr(expr, indent)
 	case *ast.UnaryExpr:
 		return s.parseUnaryExpr(expr, indent)
 	}
@@ -250,7 +289,7 @@ func (s *WasmScope) parseExpr(expr ast.Expr, typeHint WasmType, indent int) (Was
 func (s *WasmScope) createLiteralForType(value int32, typ string, indent int) (WasmExpression, error) {
 	t, err := s.f.module.convertAstTypeNameToWasmType(typ)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't create type %v for a literal: %v", typ, err)
+		return nil, fmt.Errorf("couldn't create type %v for a literal: %w", typ, err)
 	}
 	return s.createLiteral(fmt.Sprintf("%d", value), t, indent)
 }
@@ -262,9 +301,15 @@ func (s *WasmScope) createLiteralInt32(value int32, indent int) (WasmExpression,
 func (s *WasmScope) createNilLiteral(t WasmType, indent int) (WasmExpression, error) {
 	switch ty := t.(type) {
 	default:
-		return s.createLiteral("0", ty, indent)
+		zero, err := s.createLiteral("0", ty, indent)
+		if err != nil {
+			return nil, err
+		}
+		zero.setFullType(ty)
+		return zero, nil
 	case *WasmTypeFunc:
-		zero, err := s.createLiteralInt32(-1, indent)
+		// Like a zeroed func variable in memory, see createFuncIndex.
+		zero, err := s.createLiteralInt32(0, indent)
 		if err != nil {
 			return nil, err
 		}
@@ -291,28 +336,6 @@ func (s *WasmScope) createLiteral(value string, ty WasmType, indent int) (WasmEx
 	return val, nil
 }
 
-func (s *WasmScope) parseBasicLit(lit *ast.BasicLit, typeHint WasmType, indent int) (WasmExpression, error) {
-	if typeHint == nil {
-		switch lit.Kind {
-		default:
-			return nil, s.f.file.ErrorNode(lit, "not implemented: BasicLit without type hint: %v", lit.Value)
-		case token.CHAR:
-			t, err := s.f.file.module.convertAstTypeNameToWasmType("byte")
-			if err != nil {
-				return nil, err
-			}
-			return s.parseBasicLit(lit, t, indent)
-		case token.INT:
-			t, err := s.f.file.module.convertAstTypeNameToWasmType("int")
-			if err != nil {
-				return nil, err
-			}
-			return s.parseBasicLit(lit, t, indent)
-		}
-	}
-	return s.createLiteral(lit.Value, typeHint, indent)
-}
-
 func isSupportedBinOp(tok token.Token) bool {
 	if int(tok) >= len(binOpMapping) {
 		return false
@@ -333,69 +356,183 @@ func (s *WasmScope) createBinaryExpr(x, y WasmExpression, op BinOp, ty WasmType,
 	return b, nil
 }
 
-func (s *WasmScope) parseBinaryExpr(expr *ast.BinaryExpr, typeHint WasmType, indent int) (WasmExpression, error) {
-	x, err := s.parseExpr(expr.X, typeHint, indent+1)
+func (s *WasmScope) parseBinaryExpr(expr *ast.BinaryExpr, indent int) (WasmExpression, error) {
+	if isString(s.f.file.info.TypeOf(expr.X)) {
+		return s.parseStringBinaryExpr(expr, indent)
+	}
+	if s.isInterfaceComparison(expr) {
+		return s.parseInterfaceComparison(expr, indent)
+	}
+	if isStruct(s.f.file.info.TypeOf(expr.X)) {
+		return nil, s.f.file.ErrorNode(expr, "unimplemented comparison of struct values")
+	}
+	if _, ok := s.f.file.info.TypeOf(expr.X).Underlying().(*types.Array); ok {
+		// The values of array variables are their addresses.
+		return nil, s.f.file.ErrorNode(expr, "unimplemented comparison of array values")
+	}
+	x, err := s.parseExpr(expr.X, indent+1)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't get operand X in a binary expression: %v", err)
+		return nil, fmt.Errorf("couldn't get operand X in a binary expression: %w", err)
 	}
-	y, err := s.parseExpr(expr.Y, x.getType(), indent+1)
+	y, err := s.parseExpr(expr.Y, indent+1)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't get operand Y in a binary expression: %v", err)
+		return nil, fmt.Errorf("couldn't get operand Y in a binary expression: %w", err)
 	}
 	if !isSupportedBinOp(expr.Op) {
 		return nil, fmt.Errorf("unsupported binary op: %v", expr.Op)
 	}
+	if expr.Op == token.SHL || expr.Op == token.SHR {
+		result, err := s.createShift(x, y, binOpMapping[expr.Op], indent)
+		if err != nil {
+			return nil, s.f.file.ErrorNode(expr, "%v", err)
+		}
+		result.setNode(expr)
+		return s.wrapSmallInt(result, binOpMapping[expr.Op], indent)
+	}
 	xt := x.getType()
 	result, err := s.createBinaryExpr(x, y, binOpMapping[expr.Op], xt, indent)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't create a binary expression: %v", err)
+		return nil, fmt.Errorf("couldn't create a binary expression: %w", err)
 	}
 	result.setNode(expr)
-	return result, nil
+	return s.wrapSmallInt(result, result.op, indent)
 }
 
-func (s *WasmScope) parseConvertExpr(ty WasmType, v ast.Expr, indent int) (WasmExpression, error) {
-	// TODO: Currently type conversions are nops. We need to check that types have the same size and representation.
-	expr, err := s.parseExpr(v, ty, indent)
+// createShift returns x shifted by the count n, which may have any integer
+// type. WASM takes the count modulo the width of x, but in Go a count of at
+// least the width shifts out all the bits, so the result is 0, or the sign of
+// x for a signed shift to the right. A negative count doesn't panic but is
+// taken as such a large count.
+func (s *WasmScope) createShift(x, n WasmExpression, op BinOp, indent int) (WasmExpression, error) {
+	xt := x.getType()
+	xs, err := stackTypeName(xt)
 	if err != nil {
 		return nil, err
 	}
-
-	switch ty.(type) {
-	default:
-		expr.setType(ty)
-	case *WasmTypePointer:
-		// Pointer types are always treated as i32
+	width := uint64(32)
+	if xs == "i64" {
+		width = 64
+	}
+	if v, ok := n.(*WasmValue); ok {
+		if c, err := strconv.ParseUint(v.value, 0, 64); err == nil && c < width {
+			v.setType(xt)
+			return s.createBinaryExpr(x, v, op, xt, indent)
+		}
+	}
+	ns, err := stackTypeName(n.getType())
+	if err != nil {
+		return nil, err
+	}
+	// The count is compared as an unsigned value of its own width before it is
+	// converted to the width of x.
+	nt, err := s.f.module.convertAstTypeNameToWasmType(map[string]string{"i32": "uint32", "i64": "uint64"}[ns])
+	if err != nil {
+		return nil, err
+	}
+	// x is evaluated before the count, as in Go.
+	xTmp, err := s.createTempVar("shift_x", xt)
+	if err != nil {
+		return nil, err
+	}
+	xTmp.setFullType(xt)
+	nTmp, err := s.createTempVar("shift_n", nt)
+	if err != nil {
+		return nil, err
 	}
-	expr.setFullType(ty)
-	return expr, nil
+	nTmp.setFullType(nt)
+	setX, err := s.createSetVar(xTmp, x, nil, indent)
+	if err != nil {
+		return nil, err
+	}
+	setN, err := s.createSetVar(nTmp, n, nil, indent)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := s.createLiteral(strconv.FormatUint(width, 10), nt, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	inRange, err := s.createBinaryExpr(s.createGetLocal(nTmp, nil, indent+2), limit, binOpLt, nt, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	conv, err := conversionOpName(nt, xt)
+	if err != nil {
+		return nil, err
+	}
+	var count WasmExpression = s.createGetLocal(nTmp, nil, indent+2)
+	if conv != "" {
+		count = s.createConvert(conv, count, xt, indent+2)
+	}
+	sel := &WasmSelect{cond: inRange}
+	sel.setIndent(indent)
+	sel.setScope(s)
+	if op == binOpShr && xt.isSigned() {
+		last, err := s.createLiteral(strconv.FormatUint(width-1, 10), xt, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		sel.x, sel.y = count, last
+		sel.setType(xt)
+		sel.setIndent(indent + 1)
+		shift, err := s.createBinaryExpr(s.createGetLocal(xTmp, nil, indent+1), sel, op, xt, indent)
+		if err != nil {
+			return nil, err
+		}
+		return &WasmSequence{stmts: []WasmExpression{setX, setN}, value: shift}, nil
+	}
+	shift, err := s.createBinaryExpr(s.createGetLocal(xTmp, nil, indent+2), count, op, xt, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	zero, err := s.createLiteral("0", xt, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	sel.x, sel.y = shift, zero
+	sel.setType(xt)
+	return &WasmSequence{stmts: []WasmExpression{setX, setN}, value: sel}, nil
 }
 
 func (s *WasmScope) parseCompositeLit(expr *ast.CompositeLit, indent int) (WasmExpression, error) {
-	ty, err := s.f.file.parseAstType(expr.Type)
+	if isMap(s.f.file.info.TypeOf(expr)) {
+		return s.parseMapLit(expr, indent)
+	}
+	// The type of an element of an array literal may be omitted.
+	ty, err := s.f.file.convertType(s.f.file.info.TypeOf(expr))
 	if err != nil {
-		return nil, fmt.Errorf("CompositeLit, type not found: %v", err)
+		return nil, fmt.Errorf("CompositeLit, type not found: %w", err)
 	}
-	switch ty := ty.(type) {
+	switch ty.(type) {
 	default:
+	case *WasmTypeStruct:
+		return s.parseStructLit(expr, indent)
+	case *WasmTypeSlice:
+		return s.parseSliceLit(expr, indent)
 	case *WasmTypeArray:
-		ty.length = uint32(len(expr.Elts))
-		size := int32(ty.length) * int32(ty.elementType.getSize())
-		align := ty.elementType.getAlign()
-		initValue, err := s.generateAlloc(size, int32(align), expr, ty, indent)
-		if err != nil {
-			return nil, fmt.Errorf("couldn't generate array alloc for CompositeLit: %v", err)
-		}
-		return initValue, nil
+		a := s.f.file.info.TypeOf(expr).Underlying().(*types.Array)
+		return s.createArrayLit(expr, a.Elem(), int(a.Len()), indent)
 	}
-	return nil, fmt.Errorf("unimplemented CompositeLit: %v", expr)
+	return nil, s.f.file.ErrorNode(expr, "unimplemented composite literal of type %s", ty.getName())
 }
 
 func (s *WasmScope) createLoad(addr WasmExpression, t WasmType, indent int) (WasmExpression, error) {
+	if _, ok := t.(*WasmTypeStruct); ok || isInlineArray(t) {
+		// A struct value is its address, as is an array in a struct.
+		addr.setFullType(t)
+		return addr, nil
+	}
+	if _, err := loadOpName(t, t); err != nil {
+		return nil, err
+	}
+	addr, offset := splitOffset(addr)
 	l := &WasmLoad{
-		addr: addr,
+		addr:   addr,
+		offset: offset,
+		mem:    t,
 	}
 	l.setType(t)
+	l.setFullType(t)
 	l.setIndent(indent)
 	return l, nil
 }
@@ -413,9 +550,10 @@ func (s *WasmScope) createGetLocal(v WasmVariable, node ast.Node, indent int) *W
 }
 
 func (s *WasmScope) parseIdent(ident *ast.Ident, indent int) (WasmExpression, error) {
-	v, ok := s.f.module.variables[ident.Obj]
+	obj := s.f.file.objectOf(ident)
+	v, ok := s.f.module.variables[obj]
 	if !ok {
-		fn, ok := s.f.module.functionMap2[ident.Obj]
+		fn, ok := s.f.module.functionMap2[obj]
 		if !ok {
 			return nil, s.f.file.ErrorNode(ident, "undefined identifier '%s'", ident.Name)
 		}
@@ -442,6 +580,8 @@ func (s *WasmScope) parseIdent(ident *ast.Ident, indent int) (WasmExpression, er
 		}
 		g.setIndent(indent)
 		return g, nil
+	case *WasmCellVar:
+		return s.createCellGet(v, ident, indent)
 	case *WasmLocal:
 	case *WasmParam:
 	}
@@ -450,7 +590,7 @@ func (s *WasmScope) parseIdent(ident *ast.Ident, indent int) (WasmExpression, er
 	return g, nil
 }
 
-func (s *WasmScope) createIndexExprLValue(index, x WasmExpression, node ast.Node, typeHint WasmType, indent int) (*LValue, error) {
+func (s *WasmScope) createIndexExprLValue(index, x WasmExpression, node ast.Node, indent int) (*LValue, error) {
 	ty := x.getFullType()
 	if ty == nil {
 		return nil, s.f.file.ErrorNode(node, "error in IndexExpr: full type of x is nil")
@@ -458,20 +598,44 @@ func (s *WasmScope) createIndexExprLValue(index, x WasmExpression, node ast.Node
 	switch ty := ty.(type) {
 	default:
 		return nil, fmt.Errorf("unsupported type in IndexExpr: %v", ty)
-	case *WasmTypeArray:
-		multiplier, err := s.createLiteralInt32(int32(ty.elementType.getSize()), indent+3)
+	case *WasmTypeMap:
+		return s.createMapAssignLValue(index, x, ty, node, indent)
+	case *WasmTypeSlice:
+		size, err := s.createLiteralInt32(int32(ty.elementType.getSize()), indent+2)
 		if err != nil {
-			return nil, fmt.Errorf("error in offset for index expression: %v", err)
+			return nil, fmt.Errorf("error in offset for index expression: %w", err)
 		}
-		multiplier.setComment("array element size")
-		offset, err := s.createBinaryExpr(index, multiplier, binOpMul, x.getType(), indent+2)
+		size.setComment("element size")
+		addr, err := s.createRuntimeCall("slice", "SliceElem", []WasmExpression{x, index, size}, node, indent+1)
 		if err != nil {
-			return nil, fmt.Errorf("error in offset for index expression: %v", err)
+			return nil, err
+		}
+		l := &LValue{
+			addr: addr,
+			t:    ty.elementType,
+		}
+		return l, nil
+	case *WasmTypeArray:
+		if _, ok := index.(*WasmValue); !ok {
+			// Constant indices were checked by the type checker.
+			n, err := s.createLiteralInt32(int32(ty.length), indent+4)
+			if err != nil {
+				return nil, err
+			}
+			n.setComment("array length")
+			index, err = s.createRuntimeCall("slice", "SliceCheckIndex", []WasmExpression{index, n}, nil, indent+3)
+			if err != nil {
+				return nil, err
+			}
+		}
+		offset, err := s.createArrayElementOffset(index, ty, x.getType(), indent+2)
+		if err != nil {
+			return nil, fmt.Errorf("error in offset for index expression: %w", err)
 		}
 		offset.setComment("array element offset")
 		addr, err := s.createBinaryExpr(x, offset, binOpAdd, x.getType(), indent+1)
 		if err != nil {
-			return nil, fmt.Errorf("error in address computation for index expression: %v", err)
+			return nil, fmt.Errorf("error in address computation for index expression: %w", err)
 		}
 		addr.setComment("array element address")
 		l := &LValue{
@@ -482,23 +646,47 @@ func (s *WasmScope) createIndexExprLValue(index, x WasmExpression, node ast.Node
 	}
 }
 
-func (s *WasmScope) parseIndexExprLValue(expr *ast.IndexExpr, typeHint WasmType, indent int) (*LValue, error) {
-	index, err := s.parseExpr(expr.Index, nil, indent+3)
+// createArrayElementOffset returns the offset of the element at index in an
+// array. The offset of a constant index is a constant, which loads and stores
+// use as their offset immediate, see splitOffset.
+func (s *WasmScope) createArrayElementOffset(index WasmExpression, ty *WasmTypeArray, t WasmType, indent int) (WasmExpression, error) {
+	size := int32(ty.elementType.getSize())
+	if c, ok := index.(*WasmValue); ok {
+		if i, err := strconv.ParseInt(c.value, 0, 32); err == nil {
+			return s.createLiteralInt32(int32(i)*size, indent)
+		}
+	}
+	multiplier, err := s.createLiteralInt32(size, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	multiplier.setComment("array element size")
+	return s.createBinaryExpr(index, multiplier, binOpMul, t, indent)
+}
+
+func (s *WasmScope) parseIndexExprLValue(expr *ast.IndexExpr, indent int) (*LValue, error) {
+	index, err := s.parseExpr(expr.Index, indent+3)
 	if err != nil {
-		return nil, fmt.Errorf("error in IndexExpr: %v", err)
+		return nil, fmt.Errorf("error in IndexExpr: %w", err)
 	}
 	index.setComment("array index")
-	x, err := s.parseExpr(expr.X, nil, indent+2)
+	x, err := s.parseExpr(expr.X, indent+2)
 	if err != nil {
-		return nil, fmt.Errorf("error in IndexExpr: %v", err)
+		return nil, fmt.Errorf("error in IndexExpr: %w", err)
 	}
-	return s.createIndexExprLValue(index, x, expr, typeHint, indent)
+	return s.createIndexExprLValue(index, x, expr, indent)
 }
 
-func (s *WasmScope) parseIndexExpr(expr *ast.IndexExpr, typeHint WasmType, indent int) (WasmExpression, error) {
-	lvalue, err := s.parseIndexExprLValue(expr, typeHint, indent)
+func (s *WasmScope) parseIndexExpr(expr *ast.IndexExpr, indent int) (WasmExpression, error) {
+	if isString(s.f.file.info.TypeOf(expr.X)) {
+		return s.parseStringIndex(expr, indent)
+	}
+	if isMap(s.f.file.info.TypeOf(expr.X)) {
+		return s.parseMapIndex(expr, indent)
+	}
+	lvalue, err := s.parseIndexExprLValue(expr, indent)
 	if err != nil {
-		return nil, fmt.Errorf("error in address computation for IndexExpr %v: %v", expr, err)
+		return nil, fmt.Errorf("error in address computation for IndexExpr %v: %w", expr, err)
 	}
 	l, err := s.createLoad(lvalue.addr, lvalue.t, indent)
 	if err != nil {
@@ -509,65 +697,58 @@ func (s *WasmScope) parseIndexExpr(expr *ast.IndexExpr, typeHint WasmType, inden
 	return l, nil
 }
 
-func (s *WasmScope) parseParenExpr(p *ast.ParenExpr, typeHint WasmType, indent int) (WasmExpression, error) {
-	return s.parseExpr(p.X, typeHint, indent)
+func (s *WasmScope) parseParenExpr(p *ast.ParenExpr, indent int) (WasmExpression, error) {
+	return s.parseExpr(p.X, indent)
 }
 
-func (s *WasmScope) createFieldAccessExpr(expr *ast.SelectorExpr, x WasmExpression, field *WasmField, indent int) (*LValue, error) {
+func (s *WasmScope) createFieldAccessExpr(x WasmExpression, field *WasmField, indent int) (*LValue, error) {
 	offset, err := s.createLiteralInt32(int32(field.offset), indent+2)
 	if err != nil {
-		return nil, fmt.Errorf("error in offset for field %s: %v", field.name, err)
+		return nil, fmt.Errorf("error in offset for field %s: %w", field.name, err)
 	}
 	offset.setComment(fmt.Sprintf("field %s, offset: %d", field.name, field.offset))
 	addr, err := s.createBinaryExpr(x, offset, binOpAdd, x.getType(), indent+1)
 	if err != nil {
-		return nil, fmt.Errorf("error in address computation for field %s: %v", field.name, err)
+		return nil, fmt.Errorf("error in address computation for field %s: %w", field.name, err)
 	}
-	ptr, err := s.f.file.createPointerType(x.getType())
+	ptr, err := s.f.file.createPointerType(field.t)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't create a type of pointer to: %v", x.getType().getName())
+		return nil, fmt.Errorf("couldn't create a type of pointer to: %v", field.t.getName())
 	}
 	addr.setFullType(ptr)
 	l := &LValue{
 		addr: addr,
-		t:    x.getType(),
+		t:    field.t,
 	}
 	return l, nil
 }
 
-func (s *WasmScope) parseSelectorExprLValue(expr *ast.SelectorExpr, typeHint WasmType, indent int) (*LValue, error) {
-	x, err := s.parseExpr(expr.X, nil, indent+2)
+func (s *WasmScope) parseSelectorExprLValue(expr *ast.SelectorExpr, indent int) (*LValue, error) {
+	sel, ok := s.f.file.info.Selections[expr]
+	if !ok {
+		return nil, fmt.Errorf("unimplemented SelectorExpr: %v", expr)
+	}
+	x, err := s.parseExpr(expr.X, indent+2)
 	if err != nil {
-		return nil, fmt.Errorf("error in SelectorExpr: %v", err)
+		return nil, fmt.Errorf("error in SelectorExpr: %w", err)
 	}
-	ty := x.getFullType()
-	if ty == nil {
+	if x.getFullType() == nil {
 		return nil, s.f.file.ErrorNode(expr, "error in SelectorExpr: full type of x is nil")
 	}
-	switch ty := ty.(type) {
-	default:
-		return nil, fmt.Errorf("unsupported type in SelectorExpr: %v", ty)
-	case *WasmTypePointer:
-		switch baseTy := ty.base.(type) {
-		default:
-			return nil, fmt.Errorf("unsupported base type in SelectorExpr: %v", baseTy)
-		case *WasmTypeStruct:
-			fName := expr.Sel.Name
-			for _, f := range baseTy.fields {
-				if fName == f.name {
-					return s.createFieldAccessExpr(expr, x, f, indent)
-				}
-			}
-			return nil, fmt.Errorf("field %s not found in struct: %v", fName, baseTy)
-		}
-	}
-	return nil, fmt.Errorf("unimplemented SelectorExpr: %v", expr)
+	// The path goes through the embedded fields of a promoted field.
+	return s.parseFieldPath(x, sel.Index(), indent)
 }
 
-func (s *WasmScope) parseSelectorExpr(expr *ast.SelectorExpr, typeHint WasmType, indent int) (WasmExpression, error) {
-	lvalue, err := s.parseSelectorExprLValue(expr, typeHint, indent)
+func (s *WasmScope) parseSelectorExpr(expr *ast.SelectorExpr, indent int) (WasmExpression, error) {
+	if sel, ok := s.f.file.info.Selections[expr]; ok && sel.Kind() != types.FieldVal {
+		return s.parseMethodExpr(expr, sel, indent)
+	}
+	if ident, ok := s.f.file.unqualified(expr).(*ast.Ident); ok {
+		return s.parseIdent(ident, indent)
+	}
+	lvalue, err := s.parseSelectorExprLValue(expr, indent)
 	if err != nil {
-		return nil, fmt.Errorf("error in address computation for SelectorExpr %v: %v", expr, err)
+		return nil, fmt.Errorf("error in address computation for SelectorExpr %v: %w", expr, err)
 	}
 	l, err := s.createLoad(lvalue.addr, lvalue.t, indent)
 	if err != nil {
@@ -578,7 +759,7 @@ func (s *WasmScope) parseSelectorExpr(expr *ast.SelectorExpr, typeHint WasmType,
 	return l, nil
 }
 
-func (s *WasmScope) parseExprLValue(expr ast.Expr, typeHint WasmType, indent int) (*LValue, error) {
+func (s *WasmScope) parseExprLValue(expr ast.Expr, indent int) (*LValue, error) {
 	switch expr := expr.(type) {
 	default:
 		return nil, s.f.file.ErrorNode(expr, "unimplemented L-Value expression")
@@ -589,24 +770,39 @@ func (s *WasmScope) parseExprLValue(expr ast.Expr, typeHint WasmType, indent int
 			return nil, err
 		}
 		ty := i.getType()
-		if ty.getName() == "i32" {
+		if wasmTypeName(ty) == "i32" {
 			// TODO: check this is really a pointer type
 			lvalue := &LValue{
 				addr: i,
 				t:    i.getFullType(),
 			}
+			if ptr, ok := lvalue.t.(*WasmTypePointer); ok {
+				lvalue.t = ptr.base
+			}
 			return lvalue, nil
 		}
 		return nil, s.f.file.ErrorNode(expr, "unimplemented L-Value Ident expression: %v", ty.getName())
 	}
 }
 
-func (s *WasmScope) parseStarExprLValue(expr *ast.StarExpr, typeHint WasmType, indent int) (*LValue, error) {
-	return s.parseExprLValue(expr.X, typeHint, indent+1)
+func (s *WasmScope) parseStarExprLValue(expr *ast.StarExpr, indent int) (*LValue, error) {
+	if _, ok := expr.X.(*ast.Ident); ok {
+		return s.parseExprLValue(expr.X, indent+1)
+	}
+	// Any other pointer, e.g., *f().
+	addr, err := s.parseExpr(expr.X, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.f.file.convertType(s.f.file.info.TypeOf(expr))
+	if err != nil {
+		return nil, s.f.file.ErrorNode(expr, "%v", err)
+	}
+	return &LValue{addr: addr, t: t}, nil
 }
 
-func (s *WasmScope) parseStarExpr(expr *ast.StarExpr, typeHint WasmType, indent int) (WasmExpression, error) {
-	lvalue, err := s.parseStarExprLValue(expr, typeHint, indent)
+func (s *WasmScope) parseStarExpr(expr *ast.StarExpr, indent int) (WasmExpression, error) {
+	lvalue, err := s.parseStarExprLValue(expr, indent)
 	if err != nil {
 		return nil, err
 	}
@@ -630,83 +826,125 @@ func (s *WasmScope) generateAlloc(sizeConst, alignConst int32, expr ast.Node, pt
 		return nil, fmt.Errorf("struct allocation, couldn't create int32 literal for: %v", alignConst)
 	}
 	align.setComment("alignment")
-	args := []WasmExpression{size, align}
-	allocFnName := mangleFunctionName("gowasm/rt/gc", "Alloc")
-	fn, ok := s.f.module.funcSymTab[allocFnName]
-	if !ok {
-		return nil, fmt.Errorf("link error, couldn't find alloc function: %s", allocFnName)
+	layout, err := s.createLiteralInt32(s.f.module.layout(ptrTy, sizeConst), indent+1)
+	if err != nil {
+		return nil, err
 	}
-	callExpr, err := s.createCallExprWithArgs(nil, allocFnName, fn, args, indent)
-	if callExpr != nil {
-		callExpr.setNode(expr)
-		callExpr.setFullType(ptrTy)
+	layout.setComment("layout")
+	callExpr, err := s.createRuntimeCall("gc", "AllocTyped", []WasmExpression{size, align, layout}, expr, indent)
+	if err != nil {
+		return nil, err
 	}
-	return callExpr, err
+	callExpr.setFullType(ptrTy)
+	return callExpr, nil
 }
 
 func (s *WasmScope) generateMemcpy(dst, src, n WasmExpression, node ast.Node, indent int) (WasmExpression, error) {
-	args := []WasmExpression{dst, src, n}
-	memcpyFnName := mangleFunctionName("gowasm/rt/gc", "Memcpy")
-	fn, ok := s.f.module.funcSymTab[memcpyFnName]
+	return s.createRuntimeCall("gc", "Memcpy", []WasmExpression{dst, src, n}, node, indent)
+}
+
+// createRuntimeCall returns a call to a function in one of the runtime
+// packages, e.g., gc.Alloc.
+func (s *WasmScope) createRuntimeCall(pkg, name string, args []WasmExpression, node ast.Node, indent int) (WasmExpression, error) {
+	fnName := mangleFunctionName(runtimePath(pkg), name)
+	fn, ok := s.f.module.funcSymTab[fnName]
 	if !ok {
-		return nil, fmt.Errorf("link error, couldn't find memcpy function: %s", memcpyFnName)
+		s.f.module.missingRT[pkg] = true
+		return nil, fmt.Errorf("link error, couldn't find runtime function: %s", fnName)
 	}
-	callExpr, err := s.createCallExprWithArgs(nil, memcpyFnName, fn, args, indent)
-	if callExpr != nil {
-		callExpr.setNode(node)
-		callExpr.setScope(s)
+	callExpr, err := s.createCallExprWithArgs(nil, fnName, fn, args, indent)
+	if err != nil {
+		return nil, err
 	}
-	return callExpr, err
+	callExpr.setNode(node)
+	return callExpr, nil
 }
 
-func (s *WasmScope) parseStructAlloc(expr *ast.CompositeLit, indent int) (WasmExpression, error) {
-	t, err := s.f.file.parseAstType(expr.Type)
+// createTypedRuntimeCall is createRuntimeCall for functions that return a
+// value of the type of expr, e.g., a string or a slice, which the runtime
+// declares as a pointer to its header.
+func (s *WasmScope) createTypedRuntimeCall(pkg, name string, args []WasmExpression, expr ast.Expr, indent int) (WasmExpression, error) {
+	call, err := s.createRuntimeCall(pkg, name, args, expr, indent)
 	if err != nil {
-		return nil, fmt.Errorf("struct allocation, type not found: %v", expr.Type)
+		return nil, err
 	}
-
-	ptrTy, err := s.f.file.createPointerType(t)
+	ty, err := s.f.file.convertType(s.f.file.info.TypeOf(expr))
 	if err != nil {
-		return nil, fmt.Errorf("struct allocation, couldn't create a pointer type: %v", err)
+		return nil, s.f.file.ErrorNode(expr, "%v", err)
 	}
-
-	return s.generateAlloc(int32(t.getSize()), int32(t.getAlign()), expr, ptrTy, indent)
+	call.setFullType(ty)
+	return call, nil
 }
 
 func (s *WasmScope) parseAddressOf(expr ast.Expr, indent int) (WasmExpression, error) {
-	switch expr := expr.(type) {
+	switch expr := s.f.file.unqualified(expr).(type) {
 	default:
 		return nil, fmt.Errorf("unsupported address-of operand: %v", expr)
 	case *ast.CompositeLit:
-		return s.parseStructAlloc(expr, indent)
+		lit, err := s.parseCompositeLit(expr, indent)
+		if err != nil {
+			return nil, err
+		}
+		// The address of a struct is its value, but it is stored as a pointer,
+		// e.g., into the cell of a captured variable.
+		ty, _ := s.f.file.createPointerType(lit.getFullType())
+		lit.setType(ty)
+		lit.setFullType(ty)
+		return lit, nil
 	case *ast.Ident:
-		lvalue, err := s.parseExprLValue(expr, nil, indent)
+		// The value of an array variable is already the address of the array.
+		if g, ok := s.f.module.variables[s.f.file.objectOf(expr)].(*WasmGlobalVar); ok && !isArrayVar(g) {
+			addr, err := s.createLiteralInt32(g.addr, indent)
+			if err != nil {
+				return nil, err
+			}
+			addr.setComment(fmt.Sprintf("address of global %s", g.getName()))
+			ty, _ := s.f.file.createPointerType(g.getFullType())
+			addr.setFullType(ty)
+			return addr, nil
+		}
+		if v, ok := s.f.module.variables[s.f.file.objectOf(expr)].(*WasmCellVar); ok {
+			addr := s.createGetLocal(v.cell, expr, indent)
+			addr.setFullType(v.cell.fullType)
+			return addr, nil
+		}
+		lvalue, err := s.parseExprLValue(expr, indent)
 		if err != nil {
-			return nil, fmt.Errorf("error in address computation for Ident %v: %v", expr.Name, err)
+			return nil, fmt.Errorf("error in address computation for Ident %v: %w", expr.Name, err)
+		}
+		if st, ok := lvalue.addr.getFullType().(*WasmTypeStruct); ok {
+			// The value of a struct variable is the address of the struct.
+			ty, _ := s.f.file.createPointerType(st)
+			lvalue.addr.setFullType(ty)
 		}
 		return lvalue.addr, nil
 	case *ast.IndexExpr:
-		lvalue, err := s.parseIndexExprLValue(expr, nil, indent)
+		lvalue, err := s.parseIndexExprLValue(expr, indent)
 		if err != nil {
-			return nil, fmt.Errorf("error in address computation for IndexExpr %v: %v", expr, err)
+			return nil, fmt.Errorf("error in address computation for IndexExpr %v: %w", expr, err)
 		}
 		ty, _ := s.f.file.createPointerType(lvalue.t)
 		lvalue.addr.setType(lvalue.t)
 		lvalue.addr.setFullType(ty)
 		return lvalue.addr, nil
 	case *ast.SelectorExpr:
-		lvalue, err := s.parseSelectorExprLValue(expr, nil, indent)
+		lvalue, err := s.parseSelectorExprLValue(expr, indent)
 		if err != nil {
-			return nil, fmt.Errorf("error in address computation for SelectorExpr %v: %v", expr, err)
+			return nil, fmt.Errorf("error in address computation for SelectorExpr %v: %w", expr, err)
 		}
 		return lvalue.addr, nil
 	}
 }
 
+func isArrayVar(v WasmVariable) bool {
+	_, ok := v.getFullType().(*WasmTypeArray)
+	return ok
+}
+
 func (s *WasmScope) parseBitwiseComplement(astExpr ast.Expr, indent int) (WasmExpression, error) {
-	expr, err := s.parseExpr(astExpr, nil, indent+1)
+	expr, err := s.parseExpr(astExpr, indent+1)
 	if err != nil {
-		return nil, fmt.Errorf("error in bitwise complement: %v", err)
+		return nil, fmt.Errorf("error in bitwise complement: %w", err)
 	}
 
 	// TODO: make it work for int64
@@ -719,9 +957,25 @@ func (s *WasmScope) parseBitwiseComplement(astExpr ast.Expr, indent int) (WasmEx
 
 	comp, err := s.createBinaryExpr(mask, expr, binOpXor, mask.getType(), indent)
 	if err != nil {
-		return nil, fmt.Errorf("error in bitwise complement: %v", err)
+		return nil, fmt.Errorf("error in bitwise complement: %w", err)
 	}
-	return comp, nil
+	return s.wrapSmallInt(comp, binOpXor, indent)
+}
+
+func (s *WasmScope) parseNegation(astExpr ast.Expr, indent int) (WasmExpression, error) {
+	expr, err := s.parseExpr(astExpr, indent+1)
+	if err != nil {
+		return nil, fmt.Errorf("error in negation: %w", err)
+	}
+	zero, err := s.createLiteral("0", expr.getType(), indent+1)
+	if err != nil {
+		return nil, err
+	}
+	neg, err := s.createBinaryExpr(zero, expr, binOpSub, expr.getType(), indent)
+	if err != nil {
+		return nil, fmt.Errorf("error in negation: %w", err)
+	}
+	return s.wrapSmallInt(neg, binOpSub, indent)
 }
 
 func (s *WasmScope) parseUnaryExpr(expr *ast.UnaryExpr, indent int) (WasmExpression, error) {
@@ -730,6 +984,16 @@ func (s *WasmScope) parseUnaryExpr(expr *ast.UnaryExpr, indent int) (WasmExpress
 		return nil, fmt.Errorf("unimplemented UnaryExpr, token='%v'", expr.Op)
 	case token.AND:
 		return s.parseAddressOf(expr.X, indent)
+	case token.ARROW:
+		return nil, s.f.file.ErrorNode(expr, "unimplemented receive in an expression")
+	case token.NOT:
+		x, err := s.parseExpr(expr.X, indent+1)
+		if err != nil {
+			return nil, fmt.Errorf("error in logical not: %w", err)
+		}
+		return s.createNegation(x, indent)
+	case token.SUB:
+		return s.parseNegation(expr.X, indent)
 	case token.XOR:
 		return s.parseBitwiseComplement(expr.X, indent)
 	}
@@ -741,6 +1005,33 @@ func (v *WasmValue) print(writer FormattingWriter) {
 	writer.Printf(".const %s)%s\n", v.value, v.getComment())
 }
 
+func (v *WasmValue) encode(writer *WasmBinaryWriter) {
+	ts := wasmTypeName(v.ty)
+	writer.writeOpcode(ts + ".const")
+	switch ts {
+	case "i32", "i64":
+		i, err := parseIntValue(v.value)
+		if err != nil {
+			panic(fmt.Errorf("couldn't encode %s constant '%s': %v", ts, v.value, err))
+		}
+		if ts == "i32" {
+			writer.writeS32(int32(i))
+		} else {
+			writer.writeS64(i)
+		}
+	case "f32", "f64":
+		f, err := strconv.ParseFloat(v.value, 64)
+		if err != nil {
+			panic(fmt.Errorf("couldn't encode %s constant '%s': %v", ts, v.value, err))
+		}
+		if ts == "f32" {
+			writer.writeF32(float32(f))
+		} else {
+			writer.writeF64(f)
+		}
+	}
+}
+
 func (v *WasmValue) getType() WasmType {
 	return v.ty
 }
@@ -753,91 +1044,216 @@ func (b *WasmBinOp) getType() WasmType {
 	return b.ty
 }
 
-func (b *WasmBinOp) print(writer FormattingWriter) {
-	writer.PrintfIndent(b.getIndent(), "(")
-	b.ty.print(writer)
-	writer.Printf(".%s", binOpNames[b.op])
+// opName returns the instruction name, e.g., "i32.div_s".
+func (b *WasmBinOp) opName() string {
+	name := wasmTypeName(b.ty) + "." + binOpNames[b.op]
 	if binOpWithSign[b.op] && !b.ty.isFloat() {
 		if b.ty.isSigned() {
-			writer.Printf("_s")
+			name += "_s"
 		} else {
-			writer.Printf("_u")
+			name += "_u"
 		}
 	}
-	writer.Printf("%s\n", b.getComment())
+	return name
+}
+
+func (b *WasmBinOp) print(writer FormattingWriter) {
+	writer.PrintfIndent(b.getIndent(), "(%s%s\n", b.opName(), b.getComment())
 	b.x.print(writer)
 	b.y.print(writer)
 	writer.PrintfIndent(b.getIndent(), ") ;; bin op %s\n", binOpNames[b.op])
 }
 
+func (b *WasmBinOp) encode(writer *WasmBinaryWriter) {
+	b.x.encode(writer)
+	b.y.encode(writer)
+	writer.writeOpcode(b.opName())
+}
+
 func (b *WasmBinOp) getNode() ast.Node {
 	return nil
 }
 
+func (v *WasmSelect) getType() WasmType {
+	return v.ty
+}
+
+func (v *WasmSelect) getNode() ast.Node {
+	return nil
+}
+
+func (v *WasmSelect) print(writer FormattingWriter) {
+	writer.PrintfIndent(v.getIndent(), "(select%s\n", v.getComment())
+	v.x.print(writer)
+	v.y.print(writer)
+	v.cond.print(writer)
+	writer.PrintfIndent(v.getIndent(), ") ;; select\n")
+}
+
+func (v *WasmSelect) encode(writer *WasmBinaryWriter) {
+	v.x.encode(writer)
+	v.y.encode(writer)
+	v.cond.encode(writer)
+	writer.writeOpcode("select")
+}
+
 func (l *WasmLoad) getType() WasmType {
 	return l.ty
 }
 
-func (l *WasmLoad) print(writer FormattingWriter) {
-	var ts string
-	var size string
-	if l.getType().isFloat() {
-		panic(fmt.Errorf("uimplemented float load: %v", l.getType()))
+// stackTypeName returns the type of the values of t on the stack, e.g., "i32"
+// for all integer types up to 32 bits and for pointers.
+func stackTypeName(t WasmType) (string, error) {
+	switch {
+	case t.isFloat() && t.getSize() == 4:
+		return "f32", nil
+	case t.isFloat() && t.getSize() == 8:
+		return "f64", nil
+	case t.isFloat():
+		return "", fmt.Errorf("unimplemented float type: %v", t.getName())
+	case t.getSize() == 8:
+		return "i64", nil
+	case t.getSize() == 1 || t.getSize() == 2 || t.getSize() == 4:
+		return "i32", nil
 	}
-	switch l.getType().getSize() {
-	default:
-		panic(fmt.Errorf("uimplemented load type: %v", l.getType()))
-	case 1:
-		ts = "i32"
-		size = "8"
-	case 2:
-		ts = "i32"
-		size = "16"
-	case 4:
-		ts = "i32"
+	return "", fmt.Errorf("unimplemented memory type: %v", t.getName())
+}
+
+// widthSuffix returns the suffix of a load or store that accesses fewer bytes
+// of memory than the value has, e.g., "16" for an int16 in an i32.
+func widthSuffix(ts string, mem WasmType) string {
+	if ts[0] == 'f' || (ts == "i32" && mem.getSize() == 4) || mem.getSize() == 8 {
+		return ""
 	}
+	return strconv.Itoa(mem.getSize() * 8)
+}
+
+// loadOpName returns the instruction that loads a value of type mem as a value
+// of type t, e.g., "i32.load8_s" or "i64.load32_u".
+func loadOpName(t, mem WasmType) (string, error) {
+	ts, err := stackTypeName(t)
+	if err != nil {
+		return "", fmt.Errorf("unimplemented load type: %w", err)
+	}
+	if ms, err := stackTypeName(mem); err != nil || (t.isFloat() && ms != ts) || mem.isFloat() != t.isFloat() || mem.getSize() > t.getSize() {
+		return "", fmt.Errorf("unimplemented load of %v as %v", mem.getName(), t.getName())
+	}
+	size := widthSuffix(ts, mem)
 	if size != "" {
-		if l.getType().isSigned() {
+		if mem.isSigned() {
 			size += "_s"
 		} else {
 			size += "_u"
 		}
 	}
-	writer.PrintfIndent(l.getIndent(), "(%s.load%s%s\n", ts, size, l.getComment())
+	return ts + ".load" + size, nil
+}
+
+// memAlign returns the alignment hint of an access to a value of type t, which
+// is the alignment of the type, but at most its size.
+func memAlign(t WasmType) int {
+	align := t.getAlign()
+	if align <= 0 || align > t.getSize() {
+		align = t.getSize()
+	}
+	return align
+}
+
+// splitOffset splits an address plus a constant into the address and the
+// offset immediate of a load or a store, e.g., for fields and for elements of
+// arrays at constant indices.
+func splitOffset(addr WasmExpression) (WasmExpression, uint32) {
+	b, ok := addr.(*WasmBinOp)
+	if !ok || b.op != binOpAdd {
+		return addr, 0
+	}
+	c, ok := b.y.(*WasmValue)
+	if !ok || c.getType().getName() != "i32" {
+		return addr, 0
+	}
+	offset, err := strconv.ParseInt(c.value, 0, 32)
+	if err != nil || offset < 0 {
+		return addr, 0
+	}
+	return b.x, uint32(offset)
+}
+
+// printMemArg prints the offset and the alignment immediates of a load or a
+// store.
+func printMemArg(writer FormattingWriter, offset uint32, align int) {
+	if offset != 0 {
+		writer.Printf(" offset=%d", offset)
+	}
+	writer.Printf(" align=%d", align)
+}
+
+// opName returns the instruction name. The types were checked by createLoad.
+func (l *WasmLoad) opName() string {
+	name, _ := loadOpName(l.getType(), l.mem)
+	return name
+}
+
+func (l *WasmLoad) print(writer FormattingWriter) {
+	writer.PrintfIndent(l.getIndent(), "(%s", l.opName())
+	printMemArg(writer, l.offset, memAlign(l.mem))
+	writer.Printf("%s\n", l.getComment())
 	l.addr.print(writer)
 	writer.PrintfIndent(l.getIndent(), ") ;; load%s\n", l.getComment())
 }
 
+func (l *WasmLoad) encode(writer *WasmBinaryWriter) {
+	l.addr.encode(writer)
+	writer.writeOpcode(l.opName())
+	writer.writeMemArg(memAlign(l.mem), l.offset)
+}
+
 func (s *WasmStore) getType() WasmType {
 	return s.ty
 }
 
-func (s *WasmStore) print(writer FormattingWriter) {
-	var ts string
-	var size string
-	if s.getType().isFloat() {
-		panic(fmt.Errorf("uimplemented float store: %v", s.getType()))
+// storeOpName returns the instruction that stores a value of type t, e.g.,
+// "i32.store8" or "f64.store".
+func storeOpName(t WasmType) (string, error) {
+	ts, err := stackTypeName(t)
+	if err != nil {
+		return "", fmt.Errorf("unimplemented store type: %w", err)
 	}
-	switch s.getType().getSize() {
-	default:
-		panic(fmt.Errorf("uimplemented store type: %v", s.getType()))
-	case 1:
-		ts = "i32"
-		size = "8"
-	case 2:
-		ts = "i32"
-		size = "16"
-	case 4:
-		ts = "i32"
-	}
-	writer.PrintfIndent(s.getIndent(), "(%s.store%s%s\n", ts, size, s.getComment())
+	return ts + ".store" + widthSuffix(ts, t), nil
+}
+
+// opName returns the instruction name. The type was checked by createStore.
+func (s *WasmStore) opName() string {
+	name, _ := storeOpName(s.getType())
+	return name
+}
+
+func (s *WasmStore) print(writer FormattingWriter) {
+	writer.PrintfIndent(s.getIndent(), "(%s", s.opName())
+	printMemArg(writer, s.offset, memAlign(s.getType()))
+	writer.Printf("%s\n", s.getComment())
 	s.addr.print(writer)
 	s.val.print(writer)
 	writer.PrintfIndent(s.getIndent(), ") ;; store%s\n", s.getComment())
 }
 
+func (s *WasmStore) encode(writer *WasmBinaryWriter) {
+	s.addr.encode(writer)
+	s.val.encode(writer)
+	writer.writeOpcode(s.opName())
+	writer.writeMemArg(memAlign(s.getType()), s.offset)
+}
+
 func (g *WasmGetLocal) print(writer FormattingWriter) {
-	writer.PrintfIndent(g.getIndent(), "(get_local %s)%s\n", g.def.getName(), g.getComment())
+	op := "local.get"
+	if legacySyntax {
+		op = "get_local"
+	}
+	writer.PrintfIndent(g.getIndent(), "(%s %s)%s\n", op, g.def.getName(), g.getComment())
+}
+
+func (g *WasmGetLocal) encode(writer *WasmBinaryWriter) {
+	writer.writeOpcode("local.get")
+	writer.writeU32(writer.getLocalIndex(g.def.getName()))
 }
 
 func (g *WasmGetLocal) getType() WasmType {
@@ -6,26 +6,57 @@ import (
 	"go/ast"
 	"go/printer"
 	"go/token"
+	"go/types"
 	"strings"
 )
 
-// func:   ( func <name>? <type>? <param>* <result>? <local>* <expr>* )
+// func:   ( func <name>? <type>? <param>* <result>* <local>* <expr>* )
 type WasmFunc struct {
 	funcDecl  *ast.FuncDecl
+	funcLit   *ast.FuncLit
 	fset      *token.FileSet
 	module    *WasmModule
 	file      *WasmGoSourceFile
 	indent    int
 	name      string
 	origName  string
+	recv      string // the name of the receiver type of a method
+	copyRecv  bool   // the receiver is a struct passed by address
 	namePos   token.Pos
 	signature *WasmTypeFunc
 	tabIndex  int
 	params    []*WasmParam
-	result    *WasmResult
+	results   []*WasmResult
 	locals    []*WasmLocal
 	scope     *WasmScope
 	nextScope int
+
+	// Variables of the function captured by function literals in its body.
+	captured map[types.Object]bool
+	// Variables of enclosing functions captured by a function literal, which
+	// are passed in its closure.
+	env      []types.Object
+	envParam *WasmParam
+	numLits  int
+
+	// Local holding the top of the stack of deferred calls on entry, if the
+	// function has deferred calls.
+	deferFrame WasmVariable
+	// Label of the block with the body, which is followed by the deferred
+	// calls, and the variables holding the results while they run.
+	deferLabel   string
+	deferResults []WasmVariable
+
+	// Enclosing loops and switches while parsing the body.
+	branchTargets []*WasmBranchTarget
+	// Label of the labeled statement being parsed, if it is a loop or a switch.
+	pendingLabel string
+	// Go labels that can be reached by a forward goto, mapped to WASM labels.
+	gotoLabels map[string]string
+
+	// The function that the host calls instead of an exported function, see
+	// addHostEntries.
+	entry *WasmFunc
 }
 
 // param:  ( param <type>* ) | ( param <name> <type> )
@@ -50,96 +81,131 @@ type WasmResult struct {
 	astType  ast.Expr
 	name     string
 	t        WasmType
+	v        *WasmLocal // local holding a named result
 }
 
 func (file *WasmGoSourceFile) parseAstFuncDeclPass1(funcDecl *ast.FuncDecl, fset *token.FileSet, indent int) (*WasmFunc, error) {
 	f := &WasmFunc{
-		funcDecl: funcDecl,
-		fset:     fset,
-		module:   file.module,
-		file:     file,
-		indent:   indent,
-		params:   make([]*WasmParam, 0, 10),
-		locals:   make([]*WasmLocal, 0, 10),
+		funcDecl:   funcDecl,
+		fset:       fset,
+		module:     file.module,
+		file:       file,
+		indent:     indent,
+		params:     make([]*WasmParam, 0, 10),
+		locals:     make([]*WasmLocal, 0, 10),
+		gotoLabels: make(map[string]string),
 	}
 	if ident := funcDecl.Name; ident != nil {
 		f.name = mangleFunctionName(file.pkgName, ident.Name)
 		f.origName = ident.Name
 		f.namePos = ident.NamePos
+		if funcDecl.Recv != nil {
+			f.recv = receiverTypeName(file.objectOf(ident).Type().(*types.Signature).Recv())
+			f.origName = f.recv + "." + ident.Name
+			f.name = mangleFunctionName(file.pkgName, f.origName)
+		}
+		if isInit(funcDecl) {
+			// init functions can't be referenced, so their names only need to
+			// be unique.
+			f.origName = fmt.Sprintf("init.%d", len(file.module.initFuncs[file.pkgName]))
+			f.name = mangleFunctionName(file.pkgName, f.origName)
+			file.module.initFuncs[file.pkgName] = append(file.module.initFuncs[file.pkgName], f)
+		}
 	}
+	var err error
 	if funcDecl.Type != nil {
-		err := f.parseType(funcDecl.Type)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing function %s: %v", f.origName, err)
+		// A list holds the errors in the types of parameters and results,
+		// which are returned with the function, so that its body is compiled.
+		err = f.parseType(funcDecl.Type)
+		if _, ok := err.(GoWasmErrorList); err != nil && !ok {
+			return nil, fmt.Errorf("error parsing function %s: %w", f.origName, err)
 		}
 	}
 	f.scope = f.createScope(fmt.Sprintf("function_%s", f.origName))
-	return f, nil
+	return f, err
 }
 
 func (f *WasmFunc) parseAstFuncDecl() (*WasmFunc, error) {
-	funcDecl := f.funcDecl
-	if cg := funcDecl.Doc; cg != nil {
-		for _, c := range cg.List {
-			f.file.parseComment(c.Text)
+	if f.funcDecl != nil && f.funcDecl.Doc != nil {
+		for _, c := range f.funcDecl.Doc.List {
+			if err := f.file.parseComment(c.Text); err != nil {
+				return f, f.file.ErrorNode(c, "%v", err)
+			}
 		}
 	}
-	err := f.scope.parseStatementList(funcDecl.Body.List, f.indent+1)
+	if err := f.initStructVars(f.indent + 1); err != nil {
+		return f, err
+	}
+	if err := f.bindCaptured(f.indent + 1); err != nil {
+		return f, err
+	}
+	var err error
+	if hasDefer(f.body()) {
+		err = f.parseDeferBody(f.indent + 1)
+	} else {
+		err = f.scope.parseStatementList(f.body().List, f.indent+1)
+	}
+	if err == nil && f.mayBlock() {
+		err = f.makeResumable()
+	}
 	return f, err
 }
 
-func (file *WasmGoSourceFile) parseAstFuncType(astType *ast.FuncType) (*WasmTypeFunc, error) {
-	t := &WasmTypeFunc{
-		indent: 1,
+func (f *WasmFunc) body() *ast.BlockStmt {
+	if f.funcLit != nil {
+		return f.funcLit.Body
 	}
-	t.setAlign(4)
-	t.setSize(4)
-	numParams := len(astType.Params.List)
-	if numParams > 0 {
-		t.params = make([]WasmType, 0, numParams)
-		for _, param := range astType.Params.List {
-			ty, err := file.parseAstType(param.Type)
-			if err != nil {
-				return nil, fmt.Errorf("error in function param type : %v", err)
-			}
-			if param.Names == nil {
-				t.params = append(t.params, ty)
-			} else {
-				for i := 0; i < len(param.Names); i++ {
-					t.params = append(t.params, ty)
-				}
-			}
-		}
+	return f.funcDecl.Body
+}
+
+// goSignature returns the Go signature of a declared function or a function
+// literal.
+func (f *WasmFunc) goSignature() *types.Signature {
+	if f.funcLit != nil {
+		return f.file.info.TypeOf(f.funcLit).(*types.Signature)
 	}
-	if astType.Results != nil && astType.Results.List != nil && len(astType.Results.List) > 0 {
-		list := astType.Results.List
-		if len(list) > 1 {
-			return nil, file.ErrorNode(astType, "function types with %d return values are not supported", len(list))
-		}
-		var err error
-		t.result, err = file.parseAstType(list[0].Type)
-		if err != nil {
-			return nil, fmt.Errorf("error in function return type: %v", err)
-		}
+	return f.file.objectOf(f.funcDecl.Name).Type().(*types.Signature)
+}
+
+// isExported returns whether the function is exported from the module. A
+// method is exported as T.M if both T and M are exported. Function literals
+// and thunks aren't exported, nor are the functions of the runtime, see
+// exportsRuntime.
+func (f *WasmFunc) isExported() bool {
+	if f.funcDecl == nil {
+		return false
 	}
-	return file.module.signatures.add(t), nil
+	if f.file.isRuntime() && !f.module.exportsRuntime() {
+		return false
+	}
+	if f.recv != "" {
+		return isSymbolPublic(f.recv) && isSymbolPublic(f.funcDecl.Name.Name)
+	}
+	return isSymbolPublic(f.origName) || f.origName == "main"
 }
 
 func (f *WasmFunc) prepareForIndirectCall() {
 	f.tabIndex = f.file.module.funcPtrTable.add(f)
 }
 
+// parseType adds the parameters and the results of a function. A parameter or
+// a result of a type that can't be compiled gets a placeholder type, so that
+// the body is still compiled to report its errors too.
 func (f *WasmFunc) parseType(t *ast.FuncType) error {
-	sig, err := f.file.parseAstFuncType(t)
-	if err != nil {
-		fmt.Printf("WARNING: Couldn't parse function signature: %v\n", err)
-	}
+	var errs GoWasmErrorList
+	sig, sigErr := f.file.convertSignature(f.goSignature())
 	f.signature = sig
+	if f.funcDecl != nil && f.funcDecl.Recv != nil {
+		if err := f.parseReceiver(f.funcDecl.Recv.List[0]); err != nil {
+			return err
+		}
+	}
 	if t.Params.List != nil {
 		for _, field := range t.Params.List {
 			paramType, err := f.file.parseAstType(field.Type)
 			if err != nil {
-				return fmt.Errorf("error in a function parameter type: %v", err)
+				errs.add(f.file.ErrorNode(field.Type, "error in a function parameter type: %v", err))
+				paramType = f.module.placeholderType()
 			}
 			for _, name := range field.Names {
 				p := &WasmParam{
@@ -147,28 +213,71 @@ func (f *WasmFunc) parseType(t *ast.FuncType) error {
 					astType:  field.Type,
 					name:     astNameToWASM(name.Name, nil),
 					t:        paramType,
+					fullType: paramType,
 				}
-				f.module.variables[name.Obj] = p
+				f.module.variables[f.file.objectOf(name)] = p
 				f.params = append(f.params, p)
 			}
 		}
 	}
 
 	if t.Results != nil {
-		if len(t.Results.List) != 1 {
-			return fmt.Errorf("functions returning %d values are not implemented", len(t.Results.List))
-		}
-		field := t.Results.List[0]
-		resultType, err := f.file.parseAstType(field.Type)
-		if err != nil {
-			return fmt.Errorf("error in a function result type: %v", err)
+		for _, field := range t.Results.List {
+			resultType, err := f.file.parseAstType(field.Type)
+			if err != nil {
+				errs.add(f.file.ErrorNode(field.Type, "error in a function result type: %v", err))
+				resultType = f.module.placeholderType()
+			}
+			if field.Names == nil || (len(field.Names) == 1 && field.Names[0].Name == "_") {
+				f.results = append(f.results, &WasmResult{
+					astType: field.Type,
+					t:       resultType,
+				})
+				continue
+			}
+			for _, name := range field.Names {
+				// Named results are locals, which are zero-initialized.
+				v := &WasmLocal{
+					astIdent: name,
+					name:     astNameToWASM(name.Name, nil),
+					t:        resultType,
+				}
+				f.module.variables[f.file.objectOf(name)] = v
+				f.locals = append(f.locals, v)
+				f.results = append(f.results, &WasmResult{
+					astIdent: name,
+					astType:  field.Type,
+					name:     v.name,
+					t:        resultType,
+					v:        v,
+				})
+			}
 		}
-		f.result = &WasmResult{
-			astType: field.Type,
-			t:       resultType,
+	}
+	if sigErr != nil {
+		if len(errs) == 0 {
+			return sigErr
 		}
+		f.signature = f.placeholderSignature()
+	}
+	return errs.err()
+}
+
+// placeholderSignature returns the signature of a function whose parameters
+// or results have placeholder types, which isn't emitted.
+func (f *WasmFunc) placeholderSignature() *WasmTypeFunc {
+	sig := &WasmTypeFunc{
+		indent: 1,
+	}
+	sig.setAlign(4)
+	sig.setSize(4)
+	for _, p := range f.params {
+		sig.params = append(sig.params, p.t)
 	}
-	return nil
+	for _, r := range f.results {
+		sig.results = append(sig.results, r.t)
+	}
+	return sig
 }
 
 func (f *WasmFunc) print(writer FormattingWriter) {
@@ -180,8 +289,8 @@ func (f *WasmFunc) print(writer FormattingWriter) {
 	for _, param := range f.params {
 		param.print(writer)
 	}
-	if f.result != nil {
-		f.result.print(writer)
+	for _, r := range f.results {
+		r.print(writer)
 	}
 	writer.Printf("\n")
 	bodyIndent := f.indent + 1
@@ -197,11 +306,61 @@ func (f *WasmFunc) print(writer FormattingWriter) {
 			writer.Printf("\n")
 		}
 		f.printGoSource(bodyIndent, expr.getNode(), writer)
-		expr.print(writer)
+		printStmt(writer, expr)
+	}
+	if len(f.results) > 0 && !legacySyntax {
+		// All paths that produce the result end with an explicit return.
+		writer.PrintfIndent(bodyIndent, "(unreachable)\n")
 	}
 	writer.PrintfIndent(f.indent, ") ;; func %s\n", f.name)
 }
 
+func (f *WasmFunc) encode(writer *WasmBinaryWriter) {
+	body := writer.b
+	writer.b = &bytes.Buffer{}
+	writer.localIndex = make(map[string]int)
+	numParams := len(f.params)
+	if f.signature != nil {
+		numParams = len(f.signature.params)
+	}
+	for i, p := range f.params {
+		writer.localIndex[p.name] = i
+	}
+	for i, v := range f.locals {
+		writer.localIndex[v.name] = numParams + i
+	}
+
+	// Consecutive locals of the same type are encoded as a single entry.
+	groups := make([][]*WasmLocal, 0, len(f.locals))
+	for _, v := range f.locals {
+		n := len(groups)
+		if n > 0 && wasmTypeName(groups[n-1][0].t) == wasmTypeName(v.t) {
+			groups[n-1] = append(groups[n-1], v)
+		} else {
+			groups = append(groups, []*WasmLocal{v})
+		}
+	}
+	writer.writeU32(uint32(len(groups)))
+	for _, g := range groups {
+		writer.writeU32(uint32(len(g)))
+		writer.writeValueType(g[0].t)
+	}
+
+	for _, expr := range f.scope.expressions {
+		writer.encodeStmt(expr)
+	}
+	if len(f.results) > 0 {
+		// All paths that produce the result end with an explicit return.
+		writer.writeOpcode("unreachable")
+	}
+	writer.writeOpcode("end")
+
+	code := writer.b
+	writer.b = body
+	writer.writeU32(uint32(code.Len()))
+	writer.writeBytes(code.Bytes())
+}
+
 func (f *WasmFunc) printGoSource(bodyIndent int, node ast.Node, writer FormattingWriter) {
 	if node == nil || node.Pos() == 0 {
 		return
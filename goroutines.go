@@ -0,0 +1,761 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// Goroutines and channels are implemented by rt/sched. A goroutine other than
+// the main one blocks by unwinding its stack: each function that may block,
+// i.e., that has channel operations or calls such a function directly, saves
+// its locals in a frame and returns when a call that may block suspends the
+// goroutine. When the goroutine is resumed, the functions are called again and
+// skip to the call that blocked after restoring their locals.
+//
+// Calls that may block are suspension points. They are statements, whose
+// operands are evaluated by preceding statements, so that they can be
+// repeated. The body of a function that may block is wrapped by
+// makeResumable, which numbers its suspension points.
+
+// WasmSuspendPoint is a statement with a call that may block, followed by the
+// check whether the goroutine is suspending.
+type WasmSuspendPoint struct {
+	WasmExprBase
+	stmt  WasmExpression
+	index int
+	check *WasmIf
+	done  WasmExpression // clears the rewinding flag of the function
+}
+
+func (p *WasmSuspendPoint) getType() WasmType {
+	return nil
+}
+
+func (p *WasmSuspendPoint) print(writer FormattingWriter) {
+	printStmt(writer, p.stmt)
+	p.check.print(writer)
+	p.done.print(writer)
+}
+
+func (p *WasmSuspendPoint) encode(writer *WasmBinaryWriter) {
+	writer.encodeStmt(p.stmt)
+	p.check.encode(writer)
+	p.done.encode(writer)
+}
+
+func (p *WasmSuspendPoint) getNode() ast.Node {
+	return p.stmt.getNode()
+}
+
+func (s *WasmScope) createSuspendPoint(stmt WasmExpression, indent int) *WasmSuspendPoint {
+	p := &WasmSuspendPoint{
+		stmt: stmt,
+	}
+	p.setIndent(indent)
+	p.setScope(s)
+	return p
+}
+
+// staticCallee returns the declaration of the function or concrete method
+// called by call, or nil for other calls.
+func (m *WasmModule) staticCallee(file *WasmGoSourceFile, call *ast.CallExpr) *ast.FuncDecl {
+	var obj types.Object
+	switch fun := ast.Unparen(call.Fun).(type) {
+	case *ast.Ident:
+		obj = file.info.Uses[fun]
+	case *ast.SelectorExpr:
+		if sel, ok := file.info.Selections[fun]; ok {
+			if sel.Kind() != types.MethodVal || isInterface(sel.Recv()) {
+				return nil
+			}
+			obj = sel.Obj()
+		} else {
+			obj = file.info.Uses[fun.Sel]
+		}
+	}
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return nil
+	}
+	if f, ok := m.functionMap2[fn]; ok {
+		return f.funcDecl
+	}
+	return nil
+}
+
+// findBlocking finds the functions and function literals outside of the
+// runtime that may block. Calls through function values and interfaces aren't
+// followed.
+func (m *WasmModule) findBlocking() {
+	m.blocking = make(map[ast.Node]bool)
+	callers := make(map[*ast.FuncDecl][]ast.Node)
+	var work []ast.Node
+	mark := func(fn ast.Node) {
+		if !m.blocking[fn] {
+			m.blocking[fn] = true
+			work = append(work, fn)
+		}
+	}
+	for _, file := range m.files {
+		if file.isRuntime() {
+			continue
+		}
+		var visit func(fn ast.Node, body *ast.BlockStmt)
+		visit = func(fn ast.Node, body *ast.BlockStmt) {
+			ast.Inspect(body, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.FuncLit:
+					visit(n, n.Body)
+					return false
+				case *ast.SendStmt, *ast.SelectStmt:
+					mark(fn)
+				case *ast.UnaryExpr:
+					if n.Op == token.ARROW {
+						mark(fn)
+					}
+				case *ast.RangeStmt:
+					if isChan(file.info.TypeOf(n.X)) {
+						mark(fn)
+					}
+				case *ast.CallExpr:
+					if callee := m.staticCallee(file, n); callee != nil {
+						callers[callee] = append(callers[callee], fn)
+					}
+				}
+				return true
+			})
+		}
+		for _, decl := range file.astFile.Decls {
+			if decl, ok := decl.(*ast.FuncDecl); ok && decl.Body != nil {
+				visit(decl, decl.Body)
+			}
+		}
+	}
+	for len(work) > 0 {
+		fn := work[len(work)-1]
+		work = work[:len(work)-1]
+		if decl, ok := fn.(*ast.FuncDecl); ok {
+			for _, caller := range callers[decl] {
+				mark(caller)
+			}
+		}
+	}
+}
+
+// mayBlock returns whether the function has suspension points.
+func (f *WasmFunc) mayBlock() bool {
+	if f.funcLit != nil {
+		return f.module.blocking[f.funcLit]
+	}
+	return f.funcDecl != nil && f.module.blocking[f.funcDecl]
+}
+
+// isBlockingCall returns whether call is a direct call of a function that may
+// block.
+func (s *WasmScope) isBlockingCall(call *ast.CallExpr) bool {
+	callee := s.f.module.staticCallee(s.f.file, call)
+	return callee != nil && s.f.module.blocking[callee]
+}
+
+// isBlocking returns whether expr is a receive operation or a call that may
+// block.
+func (s *WasmScope) isBlocking(expr ast.Expr) bool {
+	switch expr := ast.Unparen(expr).(type) {
+	case *ast.UnaryExpr:
+		return expr.Op == token.ARROW
+	case *ast.CallExpr:
+		return s.isBlockingCall(expr)
+	}
+	return false
+}
+
+// parseGoStmt starts a goroutine with a function value without parameters
+// that makes the call.
+func (s *WasmScope) parseGoStmt(stmt *ast.GoStmt, indent int) (WasmExpression, error) {
+	fn, err := s.createCallValue(stmt.Call, stmt, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createRuntimeCall("sched", "Go", []WasmExpression{fn}, stmt, indent)
+}
+
+// parseBlockingExpr returns the statements that evaluate a receive operation
+// or a call that may block, ending with its suspension point, and the
+// temporaries holding its results. A receive has the received value and
+// whether it was received as results.
+func (s *WasmScope) parseBlockingExpr(expr ast.Expr, indent int) ([]WasmExpression, []WasmVariable, error) {
+	expr = ast.Unparen(expr)
+	if recv, ok := expr.(*ast.UnaryExpr); ok {
+		return s.parseRecv(recv, indent)
+	}
+	call := expr.(*ast.CallExpr)
+	// The arguments are evaluated again when the goroutine is resumed, so
+	// they must not have side effects. The callee ignores their values then.
+	var err error
+	ast.Inspect(call, func(n ast.Node) bool {
+		if c, ok := n.(*ast.CallExpr); ok && c != call && err == nil {
+			if tv := s.f.file.info.Types[c.Fun]; !tv.IsType() && !tv.IsBuiltin() {
+				err = s.f.file.ErrorNode(c, "unimplemented call in the arguments of a call that may block")
+			}
+		}
+		_, lit := n.(*ast.FuncLit)
+		return !lit
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := s.parseCallExpr(call, indent+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err = s.checkUnwind(c, indent+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	var temps []WasmVariable
+	for _, ty := range resultTypes(c) {
+		v, err := s.createTempVar("result", ty)
+		if err != nil {
+			return nil, nil, err
+		}
+		v.setFullType(ty)
+		temps = append(temps, v)
+	}
+	var stmt WasmExpression = c
+	switch len(temps) {
+	case 0:
+		c.setIndent(indent)
+	case 1:
+		temps[0].setFullType(c.getFullType())
+		stmt, err = s.createSetVar(temps[0], c, nil, indent)
+		if err != nil {
+			return nil, nil, err
+		}
+	default:
+		stmt = s.createTupleSet(c, temps, nil, indent)
+	}
+	return []WasmExpression{s.createSuspendPoint(stmt, indent)}, temps, nil
+}
+
+// parseBlockingStmt handles an expression statement or an assignment whose
+// value is a receive operation or a call that may block.
+func (s *WasmScope) parseBlockingStmt(stmt ast.Stmt, indent int) ([]WasmExpression, error) {
+	assign, ok := stmt.(*ast.AssignStmt)
+	if !ok {
+		exprs, _, err := s.parseBlockingExpr(stmt.(*ast.ExprStmt).X, indent)
+		return exprs, err
+	}
+	if assign.Tok != token.ASSIGN && assign.Tok != token.DEFINE {
+		return nil, s.f.file.ErrorNode(stmt, "unimplemented assignment operation %v with a value that may block", assign.Tok)
+	}
+	exprs, temps, err := s.parseBlockingExpr(assign.Rhs[0], indent)
+	if err != nil {
+		return nil, err
+	}
+	if len(temps) < len(assign.Lhs) {
+		return nil, s.f.file.ErrorNode(stmt, "assignment mismatch: %d variables but %d values", len(assign.Lhs), len(temps))
+	}
+	for i, lhs := range assign.Lhs {
+		if isBlankIdent(lhs) {
+			continue
+		}
+		if err := s.checkAssignable(lhs, assign.Rhs[0], i); err != nil {
+			return nil, err
+		}
+		set, err := s.assignValue(lhs, nil, s.createGetLocal(temps[i], nil, indent+1), temps[i].getType(), assign, indent)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, set)
+	}
+	return exprs, nil
+}
+
+// checkAssignable reports an error if the i-th value of a receive operation or
+// a call would have to be converted to an interface to be assigned to lhs.
+func (s *WasmScope) checkAssignable(lhs ast.Expr, rhs ast.Expr, i int) error {
+	return s.checkConversion(lhs, s.f.file.info.TypeOf(lhs), rhs, i)
+}
+
+func (s *WasmScope) checkConversion(node ast.Node, target types.Type, rhs ast.Expr, i int) error {
+	t := s.f.file.info.TypeOf(rhs)
+	if tuple, ok := t.(*types.Tuple); ok {
+		t = tuple.At(i).Type()
+	}
+	if isInterface(target) && !isInterface(t) {
+		return s.f.file.ErrorNode(node, "unimplemented conversion to an interface of a value that may block")
+	}
+	return nil
+}
+
+// parseBlockingReturn handles return f() and return <-ch, where the call may
+// block.
+func (s *WasmScope) parseBlockingReturn(stmt *ast.ReturnStmt, indent int) ([]WasmExpression, error) {
+	exprs, temps, err := s.parseBlockingExpr(stmt.Results[0], indent)
+	if err != nil {
+		return nil, err
+	}
+	if isReceive(stmt.Results[0]) {
+		temps = temps[:1]
+	}
+	if len(temps) != len(s.f.results) {
+		return nil, s.f.file.ErrorNode(stmt, "wrong number of return values (have %d, want %d)", len(temps), len(s.f.results))
+	}
+	sig := s.f.goSignature()
+	for i := range temps {
+		if err := s.checkConversion(stmt, sig.Results().At(i).Type(), stmt.Results[0], i); err != nil {
+			return nil, err
+		}
+	}
+	if s.f.deferFrame == nil {
+		r := &WasmReturn{
+			stmt: stmt,
+		}
+		r.setIndent(indent)
+		r.setScope(s)
+		for _, v := range temps {
+			r.values = append(r.values, s.createGetLocal(v, nil, indent+1))
+		}
+		return append(exprs, r), nil
+	}
+	for i, v := range s.f.deferResults {
+		set, err := s.createSetVar(v, s.createGetLocal(temps[i], nil, indent+1), stmt, indent)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, set)
+	}
+	br := &WasmBreak{
+		scope: s,
+		label: s.f.deferLabel,
+	}
+	br.setIndent(indent)
+	br.setComment("run deferred calls")
+	return append(exprs, br), nil
+}
+
+// resumer numbers the suspension points of a function and instruments its
+// body.
+type resumer struct {
+	s       *WasmScope
+	rewind  WasmVariable // set while the function skips to a suspension point
+	resume  WasmVariable // the index of the suspension point
+	label   string       // the block that suspension points branch out of
+	i32     WasmType
+	ranges  map[WasmExpression][2]int
+	nextIdx int
+}
+
+// makeResumable wraps the body of a function that may block:
+//
+//	(if (call Rewinding)
+//	  (then <restore $resume and the locals from the frame> (local.set $rewind (i32.const 1))))
+//	(block $suspend
+//	  <body>
+//	)
+//	<save $resume and the locals in a new frame>
+//	(return <zero values>)
+//
+// A suspension point branches out of the block if the goroutine is suspending.
+// While $rewind is set, the statements of the body are skipped except for the
+// blocks, loops and ifs that contain the suspension point $resume and the
+// point itself. Conditions aren't evaluated then.
+func (f *WasmFunc) makeResumable() error {
+	s := f.scope
+	indent := f.indent + 1
+	i32, err := f.module.scalarType("int32")
+	if err != nil {
+		return err
+	}
+	r := &resumer{
+		s:      s,
+		i32:    i32,
+		ranges: make(map[WasmExpression][2]int),
+	}
+	r.rewind, err = s.createTempVar("rewind", i32)
+	if err != nil {
+		return err
+	}
+	r.resume, err = s.createTempVar("resume", i32)
+	if err != nil {
+		return err
+	}
+	body := f.createScope("suspend")
+	r.label = body.name
+	for _, e := range s.expressions {
+		if _, _, err := r.number(e); err != nil {
+			return err
+		}
+	}
+	body.expressions, err = r.instrument(s.expressions, indent+1)
+	if err != nil {
+		return err
+	}
+	if len(f.results) == 0 {
+		ret := &WasmReturn{}
+		ret.setIndent(indent + 1)
+		ret.setScope(s)
+		body.expressions = append(body.expressions, ret)
+	} else {
+		body.expressions = append(body.expressions, s.createUnreachable(nil, indent+1))
+	}
+	block := s.createBlock(body, nil, indent)
+	block.label = body.name
+
+	ptrType, err := f.module.scalarType("uintptr")
+	if err != nil {
+		return err
+	}
+	frame, err := s.createTempVar("frame", ptrType)
+	if err != nil {
+		return err
+	}
+	saved := []WasmVariable{r.resume}
+	for _, p := range f.params {
+		saved = append(saved, p)
+	}
+	for _, v := range f.locals {
+		if v != r.rewind && v != r.resume && v != frame {
+			saved = append(saved, v)
+		}
+	}
+	for _, v := range saved {
+		if wasmTypeName(v.getType()) != "i32" {
+			return fmt.Errorf("unimplemented local %s of type %s in a function that may block", v.getName(), v.getType().getName())
+		}
+	}
+	slot := func(i, indent int) (WasmExpression, error) {
+		offset, err := s.createLiteral(fmt.Sprintf("%d", 4*i), ptrType, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		return s.createBinaryExpr(s.createGetLocal(frame, nil, indent+1), offset, binOpAdd, ptrType, indent)
+	}
+
+	restore := f.createScope("restore")
+	pop, err := s.createRuntimeCall("sched", "FramePop", nil, nil, indent+3)
+	if err != nil {
+		return err
+	}
+	set, err := s.createSetVar(frame, pop, nil, indent+2)
+	if err != nil {
+		return err
+	}
+	restore.expressions = append(restore.expressions, set)
+	for i, v := range saved {
+		addr, err := slot(i, indent+4)
+		if err != nil {
+			return err
+		}
+		load, err := s.createLoad(addr, i32, indent+3)
+		if err != nil {
+			return err
+		}
+		load.setFullType(v.getFullType())
+		set, err := s.createSetVar(v, load, nil, indent+2)
+		if err != nil {
+			return err
+		}
+		restore.expressions = append(restore.expressions, set)
+	}
+	one, err := s.createLiteralInt32(1, indent+3)
+	if err != nil {
+		return err
+	}
+	set, err = s.createSetVar(r.rewind, one, nil, indent+2)
+	if err != nil {
+		return err
+	}
+	restore.expressions = append(restore.expressions, set)
+	rewinding, err := s.createRuntimeCall("sched", "Rewinding", nil, nil, indent+1)
+	if err != nil {
+		return err
+	}
+	enter, err := s.createIf(rewinding, s.createBlock(restore, nil, indent+1), nil, indent)
+	if err != nil {
+		return err
+	}
+
+	exprs := []WasmExpression{enter, block}
+	size, err := s.createLiteralInt32(int32(4*len(saved)), indent+2)
+	if err != nil {
+		return err
+	}
+	push, err := s.createRuntimeCall("sched", "FramePush", []WasmExpression{size}, nil, indent+1)
+	if err != nil {
+		return err
+	}
+	set, err = s.createSetVar(frame, push, nil, indent)
+	if err != nil {
+		return err
+	}
+	exprs = append(exprs, set)
+	for i, v := range saved {
+		addr, err := slot(i, indent+2)
+		if err != nil {
+			return err
+		}
+		store, err := s.createStore(addr, s.createGetLocal(v, nil, indent+1), i32, nil, indent)
+		if err != nil {
+			return err
+		}
+		exprs = append(exprs, store)
+	}
+	ret := &WasmReturn{}
+	ret.setIndent(indent)
+	ret.setScope(s)
+	ret.setComment("suspend")
+	for _, result := range f.results {
+		zero, err := s.createNilLiteral(result.t, indent+1)
+		if err != nil {
+			return err
+		}
+		ret.values = append(ret.values, zero)
+	}
+	s.expressions = append(exprs, ret)
+	return nil
+}
+
+// number numbers the suspension points in e in the order of the code and
+// records the range of the indices of the ones in each block, loop and if.
+// It returns the range for e.
+func (r *resumer) number(e WasmExpression) (lo, hi int, err error) {
+	lo = r.nextIdx
+	switch e := e.(type) {
+	case *WasmSuspendPoint:
+		if err := r.createCheck(e); err != nil {
+			return 0, 0, err
+		}
+	case *WasmBlock:
+		for _, x := range e.scope.expressions {
+			if _, _, err := r.number(x); err != nil {
+				return 0, 0, err
+			}
+		}
+	case *WasmLoop:
+		for _, x := range e.scope.expressions {
+			if _, _, err := r.number(x); err != nil {
+				return 0, 0, err
+			}
+		}
+	case *WasmIf:
+		if _, _, err := r.number(e.body); err != nil {
+			return 0, 0, err
+		}
+		if e.bodyElse != nil {
+			if _, _, err := r.number(e.bodyElse); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	hi = r.nextIdx - 1
+	if hi >= lo {
+		r.ranges[e] = [2]int{lo, hi}
+	}
+	return lo, hi, nil
+}
+
+// createCheck numbers a suspension point and creates its check:
+//
+//	(if (call Suspending)
+//	  (then (local.set $resume (i32.const <index>)) (br $suspend)))
+//	(local.set $rewind (i32.const 0))
+func (r *resumer) createCheck(p *WasmSuspendPoint) error {
+	s := r.s
+	indent := p.getIndent()
+	p.index = r.nextIdx
+	r.nextIdx++
+	cond, err := s.createRuntimeCall("sched", "Suspending", nil, nil, indent+1)
+	if err != nil {
+		return err
+	}
+	scope := s.f.createScope("suspending")
+	index, err := s.createLiteralInt32(int32(p.index), indent+3)
+	if err != nil {
+		return err
+	}
+	set, err := s.createSetVar(r.resume, index, nil, indent+2)
+	if err != nil {
+		return err
+	}
+	br := &WasmBreak{
+		scope: scope,
+		label: r.label,
+	}
+	br.setIndent(indent + 2)
+	br.setComment("suspend")
+	scope.expressions = append(scope.expressions, set, br)
+	p.check, err = s.createIf(cond, s.createBlock(scope, nil, indent+1), nil, indent)
+	if err != nil {
+		return err
+	}
+	zero, err := s.createLiteralInt32(0, indent+1)
+	if err != nil {
+		return err
+	}
+	p.done, err = s.createSetVar(r.rewind, zero, nil, indent)
+	return err
+}
+
+// instrument returns a statement list in which the statements without
+// suspension points are skipped while rewinding, and the others are skipped
+// unless they contain the suspension point to resume at.
+func (r *resumer) instrument(exprs []WasmExpression, indent int) ([]WasmExpression, error) {
+	var result, plain []WasmExpression
+	flush := func() error {
+		if len(plain) == 0 {
+			return nil
+		}
+		var body WasmExpression = plain[0]
+		if len(plain) > 1 {
+			scope := r.s.f.createScope("block")
+			scope.expressions = plain
+			body = r.s.createBlock(scope, nil, indent)
+		}
+		cond, err := r.s.createNegation(r.s.createGetLocal(r.rewind, nil, indent+2), indent+1)
+		if err != nil {
+			return err
+		}
+		skip, err := r.s.createIf(cond, body, nil, indent)
+		if err != nil {
+			return err
+		}
+		result = append(result, skip)
+		plain = nil
+		return nil
+	}
+	for _, e := range exprs {
+		rng, ok := r.ranges[e]
+		if !ok {
+			plain = append(plain, e)
+			continue
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+		e, err := r.enter(e, rng, indent)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// enter instruments a statement with suspension points in the range rng.
+func (r *resumer) enter(e WasmExpression, rng [2]int, indent int) (WasmExpression, error) {
+	s := r.s
+	switch e := e.(type) {
+	case *WasmBlock:
+		exprs, err := r.instrument(e.scope.expressions, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		e.scope.expressions = exprs
+	case *WasmLoop:
+		exprs, err := r.instrument(e.scope.expressions, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		e.scope.expressions = exprs
+	case *WasmIf:
+		// The branch is selected by the suspension point while rewinding.
+		c, err := s.createTempVar("cond", r.i32)
+		if err != nil {
+			return nil, err
+		}
+		inThen := r.inRange(e.body, indent+3)
+		rewinding, err := s.createSetVar(c, inThen, nil, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		running, err := s.createSetVar(c, e.cond, nil, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		sel, err := s.createIf(s.createGetLocal(r.rewind, nil, indent+2), rewinding, running, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		e.cond = s.createGetLocal(c, nil, indent+2)
+		if e.body, err = r.enterBranch(e.body, indent+2); err != nil {
+			return nil, err
+		}
+		if e.bodyElse != nil {
+			if e.bodyElse, err = r.enterBranch(e.bodyElse, indent+2); err != nil {
+				return nil, err
+			}
+		}
+		scope := s.f.createScope("block")
+		scope.expressions = []WasmExpression{sel, e}
+		return s.createBlock(scope, nil, indent), nil
+	}
+	cond, err := r.rangeCond(rng, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createIf(cond, e, nil, indent)
+}
+
+// enterBranch instruments a branch of an if.
+func (r *resumer) enterBranch(e WasmExpression, indent int) (WasmExpression, error) {
+	exprs, err := r.instrument([]WasmExpression{e}, indent)
+	if err != nil {
+		return nil, err
+	}
+	return exprs[0], nil
+}
+
+// inRange returns whether the suspension point to resume at is in e.
+func (r *resumer) inRange(e WasmExpression, indent int) WasmExpression {
+	rng, ok := r.ranges[e]
+	if !ok {
+		zero, _ := r.s.createLiteralInt32(0, indent)
+		return zero
+	}
+	cond, _ := r.resumeIn(rng, indent)
+	return cond
+}
+
+func (r *resumer) resumeIn(rng [2]int, indent int) (WasmExpression, error) {
+	s := r.s
+	lo, err := s.createLiteralInt32(int32(rng[0]), indent+2)
+	if err != nil {
+		return nil, err
+	}
+	if rng[0] == rng[1] {
+		return s.createBinaryExpr(s.createGetLocal(r.resume, nil, indent+1), lo, binOpEq, r.i32, indent)
+	}
+	hi, err := s.createLiteralInt32(int32(rng[1]), indent+2)
+	if err != nil {
+		return nil, err
+	}
+	ge, err := s.createBinaryExpr(s.createGetLocal(r.resume, nil, indent+2), lo, binOpGe, r.i32, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	le, err := s.createBinaryExpr(s.createGetLocal(r.resume, nil, indent+2), hi, binOpLe, r.i32, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createBinaryExpr(ge, le, binOpAnd, r.i32, indent)
+}
+
+// rangeCond returns the condition under which a statement with the suspension
+// points in rng is executed: when running, or when resuming at one of them.
+func (r *resumer) rangeCond(rng [2]int, indent int) (WasmExpression, error) {
+	running, err := r.s.createNegation(r.s.createGetLocal(r.rewind, nil, indent+2), indent+1)
+	if err != nil {
+		return nil, err
+	}
+	in, err := r.resumeIn(rng, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return r.s.createBinaryExpr(running, in, binOpOr, r.i32, indent)
+}
@@ -4,24 +4,35 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/printer"
+	"go/scanner"
 	"go/token"
+	"go/types"
 	"strings"
 	"unicode"
 	"unicode/utf8"
 )
 
+// GoWasmError is a diagnostic at a position in the Go source.
 type GoWasmError struct {
-	node ast.Node
-	msg  string
+	pos token.Position
+	msg string
 }
 
+// GoWasmErrorList is a list of diagnostics, which are reported together.
+type GoWasmErrorList []*GoWasmError
+
 type WasmModuleLinker interface {
-	addAstFile(f *ast.File, fset *token.FileSet) error
+	addPackage(path string, files []*ast.File, fset *token.FileSet) error
 	finalize() error
+	missingRuntime() []string
 	print(writer FormattingWriter)
+	encode(writer *WasmBinaryWriter)
+	run() (int, error)
+	runPragmas(report func(kind, pragma string, err error)) error
 }
 
 type WasmVariable interface {
@@ -40,27 +51,53 @@ type WasmModule struct {
 	files        []*WasmGoSourceFile
 	functions    []*WasmFunc
 	functionMap  map[*ast.FuncDecl]*WasmFunc
-	functionMap2 map[*ast.Object]*WasmFunc
+	functionMap2 map[types.Object]*WasmFunc
 	funcSymTab   map[string]*WasmFunc
 	funcPtrTable *WasmFunctionTable
 	signatures   *WasmSignatureTable
 	types        map[string]WasmType
-	variables    map[*ast.Object]WasmVariable
+	variables    map[types.Object]WasmVariable
 	imports      map[string]*WasmImport
 	assertReturn []string
+	assertTrap   []string
 	invoke       []string
+	rtPragmas    []string // the assertions in the runtime packages, see exportsRuntime
+	missingRT    map[string]bool
 	memory       *WasmMemory
 	freePtrAddr  int32
+	heapEndAddr  int32
+	rootsAddr    int32
+	layouts      map[string]int32 // descriptors of the pointers in objects, see rt/gc
+	packages     map[string]*types.Package
+	strings      map[string]int32
+	typeDescs    []*typeDescriptor
+	methodTabs   []*methodTable
+	ifaceDescs   []*interfaceDescriptor
+	declErrors   GoWasmErrorList // reported by finalize with the errors in function bodies
+	methodThunks []*methodThunk
+	funcLits     []*WasmFunc // function literals whose bodies are pending
+	thunks       map[*WasmFunc]*WasmFunc
+	boundThunks  map[*WasmFunc]*WasmFunc
+	funcValues   map[*WasmFunc]int32         // static closures of top-level functions
+	callThunks   map[*WasmTypeFunc]*WasmFunc // for defer and go statements
+	panics       bool                        // calls check whether a panic unwinds the stack
+	blocking     map[ast.Node]bool           // functions and function literals that may block
+	globalInits  map[ast.Expr]*globalInit    // initializers evaluated by the start function
+	initFuncs    map[string][]*WasmFunc      // init functions of each package
+	start        *WasmFunc                   // initializes the packages, if needed
 }
 
 // For function types
 type WasmSignatureTable struct {
 	signatures map[*WasmTypeFunc]string
+	order      []*WasmTypeFunc
 }
 
-// For indirect calls
+// For indirect calls. A module whose functions use call_indirect needs a table,
+// even if it is empty, e.g., for the calls of deferred functions in rt/panics.
 type WasmFunctionTable struct {
 	funcIndex map[*WasmFunc]int
+	indirect  bool
 }
 
 type WasmGoSourceFile struct {
@@ -69,6 +106,7 @@ type WasmGoSourceFile struct {
 	module  *WasmModule
 	pkgName string
 	imports map[string]string
+	info    *types.Info // shared by all files of the package
 }
 
 func NewWasmModuleLinker() WasmModuleLinker {
@@ -83,28 +121,39 @@ func NewWasmModuleLinker() WasmModuleLinker {
 		files:        make([]*WasmGoSourceFile, 0, 10),
 		functions:    make([]*WasmFunc, 0, 10),
 		functionMap:  make(map[*ast.FuncDecl]*WasmFunc),
-		functionMap2: make(map[*ast.Object]*WasmFunc),
+		functionMap2: make(map[types.Object]*WasmFunc),
 		funcSymTab:   make(map[string]*WasmFunc),
 		funcPtrTable: fnPtrTable,
 		signatures:   sigTable,
 		types:        make(map[string]WasmType),
-		variables:    make(map[*ast.Object]WasmVariable),
+		variables:    make(map[types.Object]WasmVariable),
+		thunks:       make(map[*WasmFunc]*WasmFunc),
+		boundThunks:  make(map[*WasmFunc]*WasmFunc),
+		funcValues:   make(map[*WasmFunc]int32),
+		callThunks:   make(map[*WasmTypeFunc]*WasmFunc),
+		globalInits:  make(map[ast.Expr]*globalInit),
+		initFuncs:    make(map[string][]*WasmFunc),
 		imports:      make(map[string]*WasmImport),
+		missingRT:    make(map[string]bool),
 		assertReturn: make([]string, 0, 10),
 		invoke:       make([]string, 0, 10),
-		memory:       createMemory(1024),
+		memory:       createMemory(memoryPages, maxMemoryPages),
+		packages:     make(map[string]*types.Package),
+		strings:      make(map[string]int32),
+		layouts:      make(map[string]int32),
 	}
 	return m
 }
 
-func (m *WasmModule) addAstFile(f *ast.File, fset *token.FileSet) error {
+func (m *WasmModule) addAstFile(f *ast.File, fset *token.FileSet, pkgName string, info *types.Info) error {
 	file := &WasmGoSourceFile{
 		astFile: f,
 		fset:    fset,
 		module:  m,
+		pkgName: pkgName,
 		imports: make(map[string]string),
+		info:    info,
 	}
-	file.setPackageName()
 	m.files = append(m.files, file)
 	if ident := f.Name; ident != nil {
 		m.name = ident.Name
@@ -112,82 +161,115 @@ func (m *WasmModule) addAstFile(f *ast.File, fset *token.FileSet) error {
 	}
 
 	fmt.Printf("Creating symbol tables for '%s'...\n", file.pkgName)
+	var errs GoWasmErrorList
+	if f.Doc != nil {
+		// The package comment may hold pragmas for the module, e.g., its
+		// memory size.
+		for _, c := range f.Doc.List {
+			if err := file.parseComment(c.Text); err != nil {
+				errs.add(file.ErrorNode(c, "%v", err))
+			}
+		}
+	}
 	for _, decl := range f.Decls {
 		switch decl := decl.(type) {
 		default:
-			return fmt.Errorf("unimplemented declaration type: %v at %s", decl, positionString(decl.Pos(), file.fset))
+			errs = append(errs, file.ErrorNode(decl, "unimplemented declaration type").(*GoWasmError))
 		case *ast.FuncDecl:
 			fn, err := file.parseAstFuncDeclPass1(decl, fset, m.indent+1)
 			if err != nil {
-				return err
+				file.addError(&errs, decl, err)
+			}
+			if fn == nil {
+				continue
 			}
 			m.functions = append(m.functions, fn)
 			m.functionMap[decl] = fn
-			m.functionMap2[decl.Name.Obj] = fn
+			m.functionMap2[file.objectOf(decl.Name)] = fn
 			m.funcSymTab[fn.name] = fn
 		case *ast.GenDecl:
+			var err error
 			switch decl.Tok {
 			default:
 				fmt.Printf("Ignoring GenDecl, token: %v\n", decl.Tok)
+			case token.CONST:
+				// Uses of constants are replaced by their values.
 			case token.IMPORT:
-				err := file.parseAstImportDecl(decl)
-				if err != nil {
-					return err
-				}
+				err = file.parseAstImportDecl(decl)
 			case token.TYPE:
-				_, err := file.parseAstTypeDecl(decl)
-				if err != nil {
-					return err
-				}
+				_, err = file.parseAstTypeDecl(decl)
 			case token.VAR:
-				_, err := file.parseAstVarDeclGlobal(decl, file.fset)
-				if err != nil {
-					return err
-				}
+				errs.add(file.parseAstVarDeclGlobal(decl))
+			}
+			if err != nil {
+				file.addError(&errs, decl, err)
 			}
 		}
 	}
 
-	return nil
+	return errs.err()
 }
 
 func (m *WasmModule) finalize() error {
+	errs := m.declErrors
+	m.panics = m.callsPanic()
+	m.findBlocking()
 	for _, file := range m.files {
 		fmt.Printf("Finalizing '%s'...\n", file.pkgName)
-		err := file.generateCode()
-		if err != nil {
-			return fmt.Errorf("error in finalizing file %s: %v", file.pkgName, err)
+		errs.add(file.generateCode())
+	}
+	if m.exportsRuntime() {
+		for _, p := range m.rtPragmas {
+			m.addPragma(p)
 		}
 	}
+	errs.add(m.generateStart())
+	if len(errs) > 0 {
+		return errs
+	}
+	if err := m.finalizeInterfaces(); err != nil {
+		return err
+	}
+	if err := m.addShadowFrames(); err != nil {
+		return err
+	}
+	if err := m.addHostEntries(); err != nil {
+		return err
+	}
+	m.memory.writeInt32(int(m.rootsAddr), m.globalRoots())
+	m.memory.writeInt32(int(m.heapEndAddr), int32(m.memory.pages()*wasmPageSize))
 	m.memory.writeInt32(int(m.freePtrAddr), int32(len(m.memory.content)))
-	return nil
+	return m.memory.checkPages()
 }
 
+// generateCode generates the bodies of all functions in the file. An error in
+// one function doesn't stop the others from being compiled.
 func (file *WasmGoSourceFile) generateCode() error {
+	var errs GoWasmErrorList
 	for _, decl := range file.astFile.Decls {
 		switch decl := decl.(type) {
 		case *ast.FuncDecl:
 			fn, ok := file.module.functionMap[decl]
 			if !ok {
-				return fmt.Errorf("couldn't find function %s in the symbol table", decl.Name.Name)
+				// Pass 1 has already reported the error.
+				continue
 			}
 			_, err := fn.parseAstFuncDecl()
 			if err != nil {
-				return err
+				file.addError(&errs, decl, err)
 			}
+			errs.add(file.generateFuncLits())
 		}
 	}
-	return nil
+	return errs.err()
 }
 
 func (file *WasmGoSourceFile) parseAstImportDecl(decl *ast.GenDecl) error {
-	if len(decl.Specs) != 1 {
-		return fmt.Errorf("unsupported import declaration with %d specs", len(decl.Specs))
-	}
-	switch spec := decl.Specs[0].(type) {
-	default:
-		return fmt.Errorf("unsupported import declaration with spec: %v at %s", spec, positionString(spec.Pos(), file.fset))
-	case *ast.ImportSpec:
+	for _, s := range decl.Specs {
+		spec, ok := s.(*ast.ImportSpec)
+		if !ok {
+			return file.ErrorNode(s, "unsupported import declaration")
+		}
 		path := strings.Trim(spec.Path.Value, "\"")
 		lastSlash := strings.LastIndex(path, "/")
 		if lastSlash <= 0 {
@@ -196,25 +278,68 @@ func (file *WasmGoSourceFile) parseAstImportDecl(decl *ast.GenDecl) error {
 			lastPart := path[lastSlash+1:]
 			file.imports[lastPart] = path
 		}
-		return nil
 	}
+	return nil
 }
 
-func (file *WasmGoSourceFile) parseComment(c string) {
+func (file *WasmGoSourceFile) parseComment(c string) error {
 	pragmaPrefix := "//wasm:"
 	if strings.HasPrefix(c, pragmaPrefix) {
-		file.parsePragma(strings.TrimPrefix(c, pragmaPrefix))
+		return file.parsePragma(strings.TrimPrefix(c, pragmaPrefix))
+	}
+	return nil
+}
+
+func (file *WasmGoSourceFile) parsePragma(p string) error {
+	memoryPrefix := "memory "
+	if strings.HasPrefix(p, memoryPrefix) {
+		return file.module.memory.setPages(strings.TrimPrefix(p, memoryPrefix))
 	}
+	if file.isRuntime() {
+		// The functions they call may not be exported.
+		file.module.rtPragmas = append(file.module.rtPragmas, p)
+		return nil
+	}
+	file.module.addPragma(p)
+	return nil
 }
 
-func (file *WasmGoSourceFile) parsePragma(p string) {
+func (m *WasmModule) addPragma(p string) {
 	assertReturnPrefix := "assert_return "
+	assertTrapPrefix := "assert_trap "
 	invokePrefix := "invoke "
 	if strings.HasPrefix(p, assertReturnPrefix) {
-		file.module.assertReturn = append(file.module.assertReturn, strings.TrimPrefix(p, assertReturnPrefix))
+		m.assertReturn = append(m.assertReturn, strings.TrimPrefix(p, assertReturnPrefix))
+	} else if strings.HasPrefix(p, assertTrapPrefix) {
+		m.assertTrap = append(m.assertTrap, strings.TrimPrefix(p, assertTrapPrefix))
 	} else if strings.HasPrefix(p, invokePrefix) {
-		file.module.invoke = append(file.module.invoke, strings.TrimPrefix(p, invokePrefix))
+		m.invoke = append(m.invoke, strings.TrimPrefix(p, invokePrefix))
+	}
+}
+
+// exportsRuntime returns whether the functions of the runtime packages are
+// exported, which they are only if the module has no other packages, e.g., to
+// run the assertions in the runtime packages. Otherwise, they could clash with
+// the exported functions of the program.
+func (m *WasmModule) exportsRuntime() bool {
+	for _, file := range m.files {
+		if !file.isRuntime() {
+			return false
+		}
+	}
+	return true
+}
+
+// missingRuntime returns the runtime packages that the generated code calls
+// but that are not in the module.
+func (m *WasmModule) missingRuntime() []string {
+	var pkgs []string
+	for _, pkg := range runtimePackages {
+		if m.missingRT[pkg] {
+			pkgs = append(pkgs, pkg)
+		}
 	}
+	return pkgs
 }
 
 func (m *WasmModule) printGlobalVars(writer FormattingWriter) {
@@ -233,8 +358,11 @@ func (m *WasmModule) printGlobalVars(writer FormattingWriter) {
 }
 
 func (m *WasmModule) printImports(writer FormattingWriter) {
+	if len(m.imports) == 0 {
+		return
+	}
 	writer.Printf("\n")
-	for _, i := range m.imports {
+	for _, i := range m.sortedImports() {
 		i.print(writer)
 	}
 }
@@ -246,10 +374,23 @@ func isSymbolPublic(name string) bool {
 
 func (m *WasmModule) printExports(writer FormattingWriter, indent int) {
 	for _, f := range m.functions {
-		if isSymbolPublic(f.origName) || f.origName == "main" {
-			writer.PrintfIndent(indent, "(export \"%s\" %s)\n", f.origName, f.name)
+		if f.isExported() {
+			name := f.name
+			if f.entry != nil {
+				name = f.entry.name
+			}
+			if legacySyntax {
+				writer.PrintfIndent(indent, "(export \"%s\" %s)\n", f.origName, name)
+			} else {
+				writer.PrintfIndent(indent, "(export \"%s\" (func %s))\n", f.origName, name)
+			}
 		}
 	}
+	if legacySyntax {
+		writer.PrintfIndent(indent, "(export \"%s\" memory)\n", memoryExportName)
+	} else {
+		writer.PrintfIndent(indent, "(export \"%s\" (memory 0))\n", memoryExportName)
+	}
 }
 
 func (file *WasmGoSourceFile) print(writer FormattingWriter) {
@@ -263,22 +404,29 @@ func (m *WasmModule) print(writer FormattingWriter) {
 	for _, f := range m.files {
 		f.print(writer)
 	}
+	// Imports have to precede all definitions in the standard syntax.
+	m.signatures.print(writer)
+	m.printImports(writer)
 	m.memory.print(writer)
 	m.printGlobalVars(writer)
-	m.signatures.print(writer)
 	m.funcPtrTable.print(writer)
-	m.printImports(writer)
 	for _, f := range m.functions {
 		writer.Printf("\n")
 		f.print(writer)
 	}
 	writer.Printf("\n")
 	m.printExports(writer, bodyIndent)
+	if m.start != nil {
+		writer.PrintfIndent(bodyIndent, "(start %s)\n", m.start.name)
+	}
 	writer.Printf(") ;; end Go package '%s'\n", m.name)
 	writer.Printf("\n")
 	for _, a := range m.assertReturn {
 		writer.PrintfIndent(m.indent, "(assert_return %s)\n", a)
 	}
+	for _, a := range m.assertTrap {
+		writer.PrintfIndent(m.indent, "(assert_trap %s)\n", a)
+	}
 	for _, a := range m.invoke {
 		writer.PrintfIndent(m.indent, "%s\n", a)
 	}
@@ -301,19 +449,6 @@ func positionString(pos token.Pos, fset *token.FileSet) string {
 	return fmt.Sprintf("[%v]", position)
 }
 
-func (file *WasmGoSourceFile) setPackageName() {
-	pos := file.astFile.Package
-	position := file.fset.File(pos).PositionFor(pos, false)
-	path := position.Filename
-	lastSlash := strings.LastIndex(path, "/")
-	path = path[:lastSlash]
-	// TODO: support other path patterns.
-	if strings.HasPrefix(path, "src/") {
-		path = path[4:]
-	}
-	file.pkgName = path
-}
-
 func (file *WasmGoSourceFile) getSingleLineGoSource(node ast.Node) string {
 	var buf bytes.Buffer
 	printer.Fprint(&buf, file.fset, node)
@@ -325,36 +460,92 @@ func (file *WasmGoSourceFile) getSingleLineGoSource(node ast.Node) string {
 	}
 }
 
+// Error returns the diagnostic in the file:line:col: message format.
 func (e *GoWasmError) Error() string {
-	return e.msg
+	if !e.pos.IsValid() {
+		return e.msg
+	}
+	return fmt.Sprintf("%v: %s", e.pos, e.msg)
+}
+
+func (l GoWasmErrorList) Error() string {
+	lines := make([]string, len(l))
+	for i, e := range l {
+		lines[i] = e.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// add adds err to the list, flattening lists of errors.
+func (l *GoWasmErrorList) add(err error) {
+	var e *GoWasmError
+	switch err := err.(type) {
+	case nil:
+	case GoWasmErrorList:
+		*l = append(*l, err...)
+	case scanner.ErrorList:
+		for _, se := range err {
+			*l = append(*l, &GoWasmError{pos: se.Pos, msg: se.Msg})
+		}
+	default:
+		if !errors.As(err, &e) {
+			e = &GoWasmError{msg: err.Error()}
+		}
+		*l = append(*l, e)
+	}
+}
+
+// err returns the list as an error, or nil if it is empty.
+func (l GoWasmErrorList) err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// addError adds err to the list. An error that doesn't carry a position in
+// the Go source is reported at node.
+func (file *WasmGoSourceFile) addError(errs *GoWasmErrorList, node ast.Node, err error) {
+	errs.add(file.errorAt(node, err))
+}
+
+// errorAt returns err if it carries a position in the Go source, otherwise an
+// error at node.
+func (file *WasmGoSourceFile) errorAt(node ast.Node, err error) error {
+	var e *GoWasmError
+	if _, ok := err.(GoWasmErrorList); ok || errors.As(err, &e) {
+		return err
+	}
+	return file.ErrorNode(node, "%v", err)
 }
 
 func (file *WasmGoSourceFile) ErrorNode(node ast.Node, format string, a ...interface{}) error {
-	pos := node.Pos()
-	position := file.fset.File(pos).PositionFor(pos, false)
 	s := fmt.Sprintf(format, a...)
 	src := file.getSingleLineGoSource(node)
 	if src != "" {
 		s = fmt.Sprintf("%s (src: %s)", s, src)
 	}
 	e := &GoWasmError{
-		msg: fmt.Sprintf("%s @ %v", s, position),
+		pos: file.fset.Position(node.Pos()),
+		msg: s,
 	}
 	return e
 }
 
 func (tab *WasmFunctionTable) print(writer FormattingWriter) {
-	length := len(tab.funcIndex)
-	if length > 0 {
+	if len(tab.funcIndex) > 0 || tab.indirect {
 		writer.Printf("\n")
-		writer.PrintfIndent(1, "(table\n")
-		sorted := make([]*WasmFunc, length, length)
-		for fn, i := range tab.funcIndex {
-			sorted[i] = fn
+		if legacySyntax {
+			writer.PrintfIndent(1, "(table\n")
+		} else {
+			writer.PrintfIndent(1, "(table funcref (elem\n")
 		}
-		for _, fn := range sorted {
+		for _, fn := range tab.sorted() {
 			writer.PrintfIndent(2, "%s\n", fn.name)
 		}
+		if !legacySyntax {
+			writer.PrintfIndent(1, ")\n")
+		}
 		writer.PrintfIndent(1, ") ;;table\n")
 	}
 }
@@ -372,15 +563,20 @@ func (tab *WasmSignatureTable) print(writer FormattingWriter) {
 	if len(tab.signatures) > 0 {
 		writer.Printf("\n")
 	}
-	for sig := range tab.signatures {
+	for _, sig := range tab.order {
 		sig.printType(writer)
 	}
 }
 
 func (tab *WasmSignatureTable) equivalent(a, b *WasmTypeFunc) bool {
-	if a.result != b.result {
+	if len(a.results) != len(b.results) {
 		return false
 	}
+	for i, t := range a.results {
+		if t != b.results[i] {
+			return false
+		}
+	}
 	if len(a.params) != len(b.params) {
 		return false
 	}
@@ -403,6 +599,7 @@ func (tab *WasmSignatureTable) add(ty *WasmTypeFunc) *WasmTypeFunc {
 		name = fmt.Sprintf("$F%d", len(tab.signatures))
 		ty.wasmName = name
 		tab.signatures[ty] = name
+		tab.order = append(tab.order, ty)
 	}
 	return ty
 }
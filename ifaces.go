@@ -0,0 +1,793 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// The operations on interface values are implemented by the runtime package
+// rt/iface. The compiler places the descriptors of the dynamic types, the
+// method tables and the descriptors of interfaces in static memory. The
+// descriptor of an interface lists all types converted to an interface that
+// implement it, so it is filled in after the code of all functions has been
+// generated.
+
+// Kinds of dynamic types, see rt/iface.
+const (
+	kindWord         = 0
+	kindString       = 1
+	kindMemory       = 2
+	kindIncomparable = 3
+	kindBox          = 4
+	kindFloat32      = 5
+	kindFloat64      = 6
+)
+
+type typeDescriptor struct {
+	t    types.Type
+	addr int32
+}
+
+type methodTable struct {
+	iface *types.Interface
+	t     types.Type
+	addr  int32
+}
+
+// methodThunk is the function in the method tables of type t for the method
+// fn, see methodOf.
+type methodThunk struct {
+	t     types.Type
+	fn    *WasmFunc
+	thunk *WasmFunc
+}
+
+type interfaceDescriptor struct {
+	iface *types.Interface
+	addr  int32
+}
+
+func isInterface(t types.Type) bool {
+	return t != nil && types.IsInterface(t)
+}
+
+func interfaceOf(t types.Type) *types.Interface {
+	return t.Underlying().(*types.Interface)
+}
+
+// typeDescriptor returns the address of the descriptor of a dynamic type.
+func (file *WasmGoSourceFile) typeDescriptor(t types.Type) (int32, error) {
+	m := file.module
+	for _, d := range m.typeDescs {
+		if types.Identical(d.t, t) {
+			return d.addr, nil
+		}
+	}
+	ty, err := file.convertType(t)
+	if err != nil {
+		return 0, err
+	}
+	kind := kindWord
+	if _, ok := ty.(*WasmTypeStruct); ok {
+		kind = kindMemory
+	} else if ty.isFloat() && ty.getSize() == 4 {
+		kind = kindFloat32
+	} else if ty.isFloat() {
+		kind = kindFloat64
+	} else if isBoxed(ty) {
+		kind = kindBox
+	} else if isString(t) {
+		kind = kindString
+	} else if !types.Comparable(t) {
+		kind = kindIncomparable
+	}
+	addr := m.memory.allocGlobal(8, 4)
+	m.memory.writeInt32(addr, int32(kind))
+	m.memory.writeInt32(addr+4, int32(ty.getSize()))
+	m.typeDescs = append(m.typeDescs, &typeDescriptor{t: t, addr: int32(addr)})
+	return int32(addr), nil
+}
+
+// methodTable returns the address of the method table of type t for an
+// interface, or 0 for an interface without methods.
+func (m *WasmModule) methodTable(iface *types.Interface, t types.Type) (int32, error) {
+	n := iface.NumMethods()
+	if n == 0 {
+		return 0, nil
+	}
+	for _, mt := range m.methodTabs {
+		if types.Identical(mt.iface, iface) && types.Identical(mt.t, t) {
+			return mt.addr, nil
+		}
+	}
+	addr := m.memory.allocGlobal(4*n, 4)
+	for i := 0; i < n; i++ {
+		fn, err := m.methodOf(t, iface.Method(i))
+		if err != nil {
+			return 0, err
+		}
+		fn.prepareForIndirectCall()
+		m.memory.writeInt32(addr+4*i, int32(fn.tabIndex))
+	}
+	m.methodTabs = append(m.methodTabs, &methodTable{iface: iface, t: t, addr: int32(addr)})
+	return int32(addr), nil
+}
+
+// methodOf returns the function that implements an interface method for the
+// dynamic type t. The data word of an interface value is passed as the
+// receiver, so a method that takes it differently, e.g., one promoted from an
+// embedded field or of a boxed type, is called through a thunk.
+func (m *WasmModule) methodOf(t types.Type, method *types.Func) (*WasmFunc, error) {
+	obj, index, _ := types.LookupFieldOrMethod(t, false, method.Pkg(), method.Name())
+	fn, ok := m.functionMap2[obj]
+	if !ok {
+		return nil, fmt.Errorf("method %s of %v not found", method.Name(), t)
+	}
+	if len(index) > 1 {
+		return m.methodThunk(t, method, fn, index[:len(index)-1])
+	}
+	_, ptrRecv := obj.Type().(*types.Signature).Recv().Type().(*types.Pointer)
+	_, ptrT := t.Underlying().(*types.Pointer)
+	if ptrT && !ptrRecv && !fn.copyRecv {
+		return m.methodThunk(t, method, fn, nil)
+	}
+	if !ptrRecv && isBoxed(fn.params[0].t) {
+		return m.methodThunk(t, method, fn, nil)
+	}
+	return fn, nil
+}
+
+// methodThunk returns the function in the method tables of t that takes the
+// data word and calls the method fn with its receiver, which is the embedded
+// field at path or, without a path, the value at the data word. It has the
+// signature of the interface method.
+func (m *WasmModule) methodThunk(t types.Type, method *types.Func, fn *WasmFunc, path []int) (*WasmFunc, error) {
+	for _, mt := range m.methodThunks {
+		if mt.fn == fn && types.Identical(mt.t, t) {
+			return mt.thunk, nil
+		}
+	}
+	sig, err := fn.file.convertSignature(method.Type().(*types.Signature))
+	if err != nil {
+		return nil, err
+	}
+	ty, err := fn.file.convertType(t)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ty.(*WasmTypePointer); !ok {
+		ty, err = fn.file.createPointerType(ty)
+		if err != nil {
+			return nil, err
+		}
+	}
+	suffix := fmt.Sprintf("$iface%d", len(m.methodThunks))
+	f := &WasmFunc{
+		fset:       fn.fset,
+		module:     m,
+		file:       fn.file,
+		indent:     fn.indent,
+		name:       fn.name + suffix,
+		origName:   fn.origName + suffix,
+		namePos:    fn.namePos,
+		results:    fn.results,
+		gotoLabels: make(map[string]string),
+	}
+	f.params = append(f.params, &WasmParam{name: "$p0", t: ty, fullType: ty})
+	for i, p := range fn.params[1:] {
+		f.params = append(f.params, &WasmParam{
+			name:     fmt.Sprintf("$p%d", i+1),
+			t:        p.t,
+			fullType: p.fullType,
+		})
+	}
+	f.signature = sig
+	f.scope = f.createScope(fmt.Sprintf("function_%s", f.origName))
+	s := f.scope
+	var recv WasmExpression
+	data := s.createGetLocal(f.params[0], nil, f.indent+4)
+	if path != nil {
+		recv, err = s.createPromotedReceiver(data, path, fn, f.indent+3)
+	} else {
+		recv, err = s.createLoad(data, fn.params[0].t, f.indent+3)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("method %s of %v in an interface: %w", fn.origName, t, err)
+	}
+	args := []WasmExpression{recv}
+	for _, p := range f.params[1:] {
+		args = append(args, s.createGetLocal(p, nil, f.indent+3))
+	}
+	call, err := s.createCallExprWithArgs(nil, fn.name, fn, args, f.indent+2)
+	if err != nil {
+		return nil, err
+	}
+	call.setNode(nil)
+	if len(fn.results) == 0 {
+		call.setIndent(f.indent + 1)
+		s.expressions = append(s.expressions, call)
+	} else {
+		r := &WasmReturn{
+			values: []WasmExpression{call},
+		}
+		r.setIndent(f.indent + 1)
+		r.setScope(s)
+		s.expressions = append(s.expressions, r)
+	}
+	m.functions = append(m.functions, f)
+	m.funcSymTab[f.name] = f
+	m.methodThunks = append(m.methodThunks, &methodThunk{t: t, fn: fn, thunk: f})
+	return f, nil
+}
+
+// interfaceDescriptor returns the address of the descriptor of an interface,
+// or 0 for an interface without methods.
+func (m *WasmModule) interfaceDescriptor(iface *types.Interface) int32 {
+	if iface.NumMethods() == 0 {
+		return 0
+	}
+	for _, d := range m.ifaceDescs {
+		if types.Identical(d.iface, iface) {
+			return d.addr
+		}
+	}
+	addr := m.memory.allocGlobal(8, 4)
+	m.ifaceDescs = append(m.ifaceDescs, &interfaceDescriptor{iface: iface, addr: int32(addr)})
+	return int32(addr)
+}
+
+// finalizeInterfaces fills in the descriptors of interfaces with the method
+// tables of the dynamic types that implement them.
+func (m *WasmModule) finalizeInterfaces() error {
+	var errs GoWasmErrorList
+	for _, d := range m.ifaceDescs {
+		var entries []int32
+		for _, td := range m.typeDescs {
+			if !types.Implements(td.t, d.iface) {
+				continue
+			}
+			methods, err := m.methodTable(d.iface, td.t)
+			if err != nil {
+				errs.add(err)
+				continue
+			}
+			entries = append(entries, td.addr, methods)
+		}
+		m.memory.writeInt32(int(d.addr), int32(len(entries)/2))
+		if len(entries) == 0 {
+			continue
+		}
+		addr := m.memory.allocGlobal(4*len(entries), 4)
+		for i, e := range entries {
+			m.memory.writeInt32(addr+4*i, e)
+		}
+		m.memory.writeInt32(int(d.addr)+4, int32(addr))
+	}
+	return errs.err()
+}
+
+func (s *WasmScope) createTypeDescriptor(t types.Type, node ast.Node, indent int) (WasmExpression, error) {
+	addr, err := s.f.file.typeDescriptor(t)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(node, "%v", err)
+	}
+	desc, err := s.createLiteralInt32(addr, indent)
+	if err != nil {
+		return nil, err
+	}
+	desc.setComment(fmt.Sprintf("type %v", t))
+	return desc, nil
+}
+
+func (s *WasmScope) createInterfaceDescriptor(t types.Type, indent int) (WasmExpression, error) {
+	desc, err := s.createLiteralInt32(s.f.module.interfaceDescriptor(interfaceOf(t)), indent)
+	if err != nil {
+		return nil, err
+	}
+	desc.setComment(fmt.Sprintf("interface %v", t))
+	return desc, nil
+}
+
+// isBoxed returns whether the data word of an interface holding a value of
+// type ty is the address of a box with the value, i.e., for floats and 8-byte
+// integers.
+func isBoxed(ty WasmType) bool {
+	_, ok := ty.(*WasmTypeScalar)
+	return ok && (ty.isFloat() || ty.getSize() > 4)
+}
+
+// valueType returns the type of a value of the dynamic type t, which is held
+// in a local. A struct is held by its address and a boxed value is loaded
+// from its box.
+func (s *WasmScope) valueType(t types.Type, node ast.Node) (WasmType, error) {
+	ty, err := s.f.file.convertType(t)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(node, "%v", err)
+	}
+	if _, ok := ty.(*WasmTypeStruct); ok {
+		return s.f.file.createPointerType(ty)
+	}
+	if !isBoxed(ty) && (ty.isFloat() || ty.getSize() > 4) {
+		return nil, s.f.file.ErrorNode(node, "unimplemented interface value of type %v", t)
+	}
+	return ty, nil
+}
+
+// createUnbox loads the value of a boxed type ty from the address returned by
+// IfaceAssertType.
+func (s *WasmScope) createUnbox(call WasmExpression, ty WasmType, indent int) (WasmExpression, error) {
+	call.setIndent(indent + 1)
+	return s.createLoad(call, ty, indent)
+}
+
+// parseExprAs parses an expression whose value is assigned to a variable, a
+// parameter or a result of type target. A value assigned to an interface is
+// converted to it.
+func (s *WasmScope) parseExprAs(expr ast.Expr, target types.Type, indent int) (WasmExpression, error) {
+	if !isInterface(target) {
+		return s.parseExpr(expr, indent)
+	}
+	x, err := s.parseInterfaceConversion(expr, target, indent)
+	if err != nil {
+		return nil, err
+	}
+	ty, err := s.f.file.convertType(target)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(expr, "%v", err)
+	}
+	x.setFullType(ty)
+	return x, nil
+}
+
+func (s *WasmScope) parseInterfaceConversion(expr ast.Expr, target types.Type, indent int) (WasmExpression, error) {
+	tv := s.f.file.info.Types[expr]
+	t := tv.Type
+	if tv.IsNil() || types.Identical(t, target) {
+		return s.parseExpr(expr, indent)
+	}
+	if isInterface(t) {
+		if interfaceOf(target).NumMethods() == 0 {
+			// The method table isn't used for an interface without methods.
+			return s.parseExpr(expr, indent)
+		}
+		x, err := s.parseExpr(expr, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		desc, err := s.createInterfaceDescriptor(target, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		return s.createRuntimeCall("iface", "IfaceConvert", []WasmExpression{x, desc}, expr, indent)
+	}
+	typ, err := s.createTypeDescriptor(t, expr, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := s.f.module.methodTable(interfaceOf(target), t)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(expr, "%v", err)
+	}
+	methods, err := s.createLiteralInt32(addr, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	methods.setComment(fmt.Sprintf("methods of %v", t))
+	ty, err := s.valueType(t, expr)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ty.(*WasmTypePointer); ok && !isPointer(t) {
+		src, err := s.parseValueAddress(expr, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		return s.createRuntimeCall("iface", "IfaceMakeCopy", []WasmExpression{typ, methods, src}, expr, indent)
+	}
+	if isBoxed(ty) {
+		return s.createBox(expr, ty, typ, methods, indent)
+	}
+	data, err := s.parseExpr(expr, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createRuntimeCall("iface", "IfaceMake", []WasmExpression{typ, methods, data}, expr, indent)
+}
+
+// createBox converts a float or an 8-byte integer to an interface with
+// IfaceMakeBox, which takes the bits of the value as an int64.
+func (s *WasmScope) createBox(expr ast.Expr, ty WasmType, typ, methods WasmExpression, indent int) (WasmExpression, error) {
+	depth := 1
+	if ty.isFloat() {
+		depth++
+	}
+	if ty.getSize() == 4 {
+		depth++
+	}
+	v, err := s.parseExpr(expr, indent+depth)
+	if err != nil {
+		return nil, err
+	}
+	bits, err := s.createFloatBits(v, indent+depth-1)
+	if err != nil {
+		return nil, err
+	}
+	if ty.getSize() == 4 {
+		int64Type, err := s.f.module.scalarType("int64")
+		if err != nil {
+			return nil, err
+		}
+		bits = s.createConvert("i64.extend_i32_u", bits, int64Type, indent+1)
+	}
+	return s.createRuntimeCall("iface", "IfaceMakeBox", []WasmExpression{typ, methods, bits}, expr, indent)
+}
+
+func isPointer(t types.Type) bool {
+	_, ok := t.Underlying().(*types.Pointer)
+	return ok
+}
+
+// parseValueAddress returns the address of a value, e.g., of a struct, which is
+// the value itself.
+func (s *WasmScope) parseValueAddress(x ast.Expr, indent int) (WasmExpression, error) {
+	if isStruct(s.f.file.info.TypeOf(x)) {
+		return s.parseExpr(x, indent)
+	}
+	if star, ok := x.(*ast.StarExpr); ok {
+		return s.parseExpr(star.X, indent)
+	}
+	return s.parseAddressOf(x, indent)
+}
+
+// parseInterfaceMethodCall calls a method of the dynamic type of an interface
+// value through the function table. The data word is passed as the receiver.
+func (s *WasmScope) parseInterfaceMethodCall(call *ast.CallExpr, se *ast.SelectorExpr, sel *types.Selection, indent int) (WasmExpression, error) {
+	if sel.Kind() != types.MethodVal {
+		return nil, s.f.file.ErrorNode(se, "unimplemented method expression of an interface: %s", se.Sel.Name)
+	}
+	iface := interfaceOf(sel.Recv())
+	method := sel.Obj().(*types.Func)
+	index := -1
+	for i := 0; i < iface.NumMethods(); i++ {
+		if iface.Method(i).Id() == method.Id() {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, s.f.file.ErrorNode(se, "method %s not found in %v", method.Name(), sel.Recv())
+	}
+	x, err := s.parseExpr(se.X, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	// The interface value is used for the receiver and for the method.
+	set, recvX, methodX, err := s.useTwice(se.X, x, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	recv, err := s.createRuntimeCall("iface", "IfaceData", []WasmExpression{recvX}, nil, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	i, err := s.createLiteralInt32(int32(index), indent+2)
+	if err != nil {
+		return nil, err
+	}
+	i.setComment(fmt.Sprintf("method %s", method.Name()))
+	idx, err := s.createRuntimeCall("iface", "IfaceMethod", []WasmExpression{methodX, i}, nil, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	sig := method.Type().(*types.Signature)
+	signature, err := s.f.file.convertSignature(sig)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(call, "%v", err)
+	}
+	args, err := s.parseCallArgs(call.Args, sig, indent+1)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing args to method %s: %w", method.Name(), err)
+	}
+	s.f.module.funcPtrTable.indirect = true
+	c := &WasmCallIndirect{
+		name:      method.Name(),
+		signature: signature,
+		index:     idx,
+		before:    set,
+	}
+	c.args = append([]WasmExpression{recv}, args...)
+	c.call = call
+	c.setIndent(indent)
+	c.setNode(call)
+	c.setScope(s)
+	if len(signature.results) > 0 {
+		c.setFullType(signature.results[0])
+	}
+	return c, nil
+}
+
+func (s *WasmScope) parseTypeAssertExpr(expr *ast.TypeAssertExpr, indent int) (WasmExpression, error) {
+	return s.createTypeAssert(expr, false, indent)
+}
+
+// createTypeAssert handles x.(T). With commaOk, the returned call produces the
+// value and ok.
+func (s *WasmScope) createTypeAssert(expr *ast.TypeAssertExpr, commaOk bool, indent int) (WasmExpression, error) {
+	t := s.f.file.info.TypeOf(expr.Type)
+	x, err := s.parseExpr(expr.X, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	var name string
+	var desc WasmExpression
+	var ty WasmType
+	if isInterface(t) {
+		name = "IfaceAssert"
+		desc, err = s.createInterfaceDescriptor(t, indent+1)
+		if err == nil {
+			ty, err = s.f.file.convertType(t)
+		}
+	} else {
+		name = "IfaceAssertType"
+		desc, err = s.createTypeDescriptor(t, expr, indent+1)
+		if err == nil {
+			ty, err = s.valueType(t, expr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if commaOk {
+		name += "2"
+	}
+	call, err := s.createRuntimeCall("iface", name, []WasmExpression{x, desc}, expr, indent)
+	if err != nil {
+		return nil, err
+	}
+	if !commaOk {
+		if isBoxed(ty) {
+			return s.createUnbox(call, ty, indent)
+		}
+		call.setType(ty)
+	}
+	call.setFullType(ty)
+	return call, nil
+}
+
+// parseInterfaceComparison compares interface values, or an interface value
+// and a value converted to its type, with IfaceEqual.
+func (s *WasmScope) parseInterfaceComparison(expr *ast.BinaryExpr, indent int) (WasmExpression, error) {
+	t := s.f.file.info.TypeOf(expr.X)
+	if !isInterface(t) {
+		t = s.f.file.info.TypeOf(expr.Y)
+	}
+	eqIndent := indent
+	if expr.Op == token.NEQ {
+		eqIndent++
+	}
+	x, err := s.parseExprAs(expr.X, t, eqIndent+1)
+	if err != nil {
+		return nil, err
+	}
+	y, err := s.parseExprAs(expr.Y, t, eqIndent+1)
+	if err != nil {
+		return nil, err
+	}
+	eq, err := s.createRuntimeCall("iface", "IfaceEqual", []WasmExpression{x, y}, expr, eqIndent)
+	if err != nil {
+		return nil, err
+	}
+	if expr.Op == token.NEQ {
+		return s.createNegation(eq, indent)
+	}
+	return eq, nil
+}
+
+// isInterfaceComparison returns whether expr compares interface values other
+// than to nil, which is a comparison of addresses.
+func (s *WasmScope) isInterfaceComparison(expr *ast.BinaryExpr) bool {
+	if expr.Op != token.EQL && expr.Op != token.NEQ {
+		return false
+	}
+	info := s.f.file.info
+	if !isInterface(info.TypeOf(expr.X)) && !isInterface(info.TypeOf(expr.Y)) {
+		return false
+	}
+	return !info.Types[expr.X].IsNil() && !info.Types[expr.Y].IsNil()
+}
+
+// parseTypeSwitchStmt lowers a type switch onto a block with an if for each
+// case clause but the default clause, whose body follows them. The body of
+// each clause ends with a branch out of the block.
+func (s *WasmScope) parseTypeSwitchStmt(stmt *ast.TypeSwitchStmt, indent int) (WasmExpression, error) {
+	outerScope := s.f.createScope("typeswitch")
+	labelBreak := outerScope.name + "_break"
+	if stmt.Init != nil {
+		if err := outerScope.parseStatementList([]ast.Stmt{stmt.Init}, indent+1); err != nil {
+			return nil, err
+		}
+	}
+	var assert *ast.TypeAssertExpr
+	switch a := stmt.Assign.(type) {
+	case *ast.AssignStmt:
+		assert = a.Rhs[0].(*ast.TypeAssertExpr)
+	case *ast.ExprStmt:
+		assert = a.X.(*ast.TypeAssertExpr)
+	}
+	x, err := outerScope.parseExpr(assert.X, indent+2)
+	if err != nil {
+		return nil, fmt.Errorf("error in the tag of a type switch: %w", err)
+	}
+	tag, err := outerScope.createTempVar("tag", x.getType())
+	if err != nil {
+		return nil, err
+	}
+	set, err := outerScope.createSetVar(tag, x, stmt, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	outerScope.expressions = append(outerScope.expressions, set)
+
+	s.f.pushBranchTarget(labelBreak, "")
+	defer s.f.popBranchTarget()
+
+	var defaultClause *ast.CaseClause
+	for _, c := range stmt.Body.List {
+		clause := c.(*ast.CaseClause)
+		if clause.List == nil {
+			defaultClause = clause
+			continue
+		}
+		var cond WasmExpression
+		for _, e := range clause.List {
+			c, err := outerScope.createTypeCaseCond(tag, e, indent+2)
+			if err != nil {
+				return nil, err
+			}
+			if cond == nil {
+				cond = c
+				continue
+			}
+			cond, err = outerScope.createBinaryExpr(cond, c, binOpOr, c.getType(), indent+2)
+			if err != nil {
+				return nil, err
+			}
+		}
+		scope := s.f.createScope("case")
+		if err := scope.parseTypeCaseBody(clause, tag, indent+2); err != nil {
+			return nil, err
+		}
+		b := &WasmBreak{
+			scope: scope,
+			label: labelBreak,
+		}
+		b.setIndent(indent + 2)
+		b.setScope(scope)
+		scope.expressions = append(scope.expressions, b)
+		i, err := outerScope.createIf(cond, s.createBlock(scope, stmt, indent+2), nil, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		outerScope.expressions = append(outerScope.expressions, i)
+	}
+	if defaultClause != nil {
+		if err := outerScope.parseTypeCaseBody(defaultClause, tag, indent+1); err != nil {
+			return nil, err
+		}
+	}
+	block := s.createBlock(outerScope, stmt, indent)
+	block.label = labelBreak
+	return block, nil
+}
+
+// createTypeCaseCond returns the condition of a type in a case clause of a
+// type switch.
+func (s *WasmScope) createTypeCaseCond(tag WasmVariable, e ast.Expr, indent int) (WasmExpression, error) {
+	x := s.createGetLocal(tag, nil, indent+1)
+	if s.f.file.info.Types[e].IsNil() {
+		return s.createNegation(x, indent)
+	}
+	t := s.f.file.info.TypeOf(e)
+	if isInterface(t) {
+		desc, err := s.createInterfaceDescriptor(t, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		return s.createRuntimeCall("iface", "IfaceImplements", []WasmExpression{x, desc}, e, indent)
+	}
+	desc, err := s.createTypeDescriptor(t, e, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createRuntimeCall("iface", "IfaceIs", []WasmExpression{x, desc}, e, indent)
+}
+
+// parseTypeCaseBody parses the body of a case clause of a type switch. The
+// variable declared by the switch is bound if the body uses it.
+func (s *WasmScope) parseTypeCaseBody(clause *ast.CaseClause, tag WasmVariable, indent int) error {
+	if obj := s.f.file.info.Implicits[clause]; obj != nil && s.usesObject(clause, obj) {
+		if err := s.bindTypeCaseVar(clause, obj, tag, indent); err != nil {
+			return err
+		}
+	}
+	return s.parseStatementList(clause.Body, indent)
+}
+
+func (s *WasmScope) usesObject(node ast.Node, obj types.Object) bool {
+	used := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok && s.f.file.info.Uses[ident] == obj {
+			used = true
+		}
+		return !used
+	})
+	return used
+}
+
+// bindTypeCaseVar assigns the value of the tag to the variable of a case
+// clause. It has the type in the clause if there is exactly one, otherwise
+// the type of the tag.
+func (s *WasmScope) bindTypeCaseVar(clause *ast.CaseClause, obj types.Object, tag WasmVariable, indent int) error {
+	t := obj.Type()
+	var value WasmExpression
+	var ty WasmType
+	var err error
+	x := s.createGetLocal(tag, nil, indent+2)
+	single := len(clause.List) == 1 && !s.f.file.info.Types[clause.List[0]].IsNil()
+	switch {
+	case !single:
+		value, ty = x, tag.getType()
+	case isInterface(t):
+		ty, err = s.f.file.convertType(t)
+		if err != nil {
+			return s.f.file.ErrorNode(clause, "%v", err)
+		}
+		if interfaceOf(t).NumMethods() == 0 {
+			value = x
+			break
+		}
+		desc, err := s.createInterfaceDescriptor(t, indent+2)
+		if err != nil {
+			return err
+		}
+		value, err = s.createRuntimeCall("iface", "IfaceConvert", []WasmExpression{x, desc}, clause, indent+1)
+		if err != nil {
+			return err
+		}
+	default:
+		ty, err = s.valueType(t, clause)
+		if err != nil {
+			return err
+		}
+		desc, err := s.createTypeDescriptor(t, clause, indent+2)
+		if err != nil {
+			return err
+		}
+		value, err = s.createRuntimeCall("iface", "IfaceAssertType", []WasmExpression{x, desc}, clause, indent+1)
+		if err != nil {
+			return err
+		}
+		if isBoxed(ty) {
+			value, err = s.createUnbox(value, ty, indent+1)
+			if err != nil {
+				return err
+			}
+		}
+		value.setType(ty)
+	}
+	value.setFullType(ty)
+	ident := ast.NewIdent(obj.Name())
+	s.f.file.info.Defs[ident] = obj
+	v, err := s.createLocalVar(ident, ty)
+	if err != nil {
+		return err
+	}
+	v.setFullType(ty)
+	set, err := s.createSetVar(v, value, nil, indent)
+	if err != nil {
+		return err
+	}
+	s.expressions = append(s.expressions, set)
+	return nil
+}
@@ -11,8 +11,10 @@ type WasmImport struct {
 	name       string
 	moduleName string
 	funcName   string
+	legacyName string
 	params     []WasmType
 	result     WasmType // TODO(cierniak): multiple values may be returned.
+	signature  *WasmTypeFunc
 	indent     int
 }
 
@@ -27,17 +29,49 @@ type WasmCallImport struct {
 type ImportName struct {
 	module    string
 	function  string
+	legacy    string // function name in the legacy ml-proto dialect, if different
 	signature string
 }
 
 var functionNames map[string]ImportName = map[string]ImportName{
-	"wasm.Print_int32": {"spectest", "print", "int32->"},
-	"wasm.Print_int64": {"spectest", "print", "int64->"},
-	"v8.Puts":          {"", "puts", "int32->int32"},
+	"wasm.Print_int32": {"spectest", "print_i32", "print", "int32->"},
+	"wasm.Print_int64": {"spectest", "print_i64", "print", "int64->"},
+	"v8.Puts":          {"", "puts", "", "int32->int32"},
 }
 
+// Functions of the host packages that are compiled to instructions instead of
+// imports.
+var intrinsics = map[string]string{
+	"wasm.MemorySize": "memory.size",
+	"wasm.MemoryGrow": "memory.grow",
+}
+
+// The names of the intrinsics in the legacy ml-proto dialect.
+var legacyIntrinsics = map[string]string{
+	"memory.size": "memory_size",
+	"memory.grow": "grow_memory",
+}
+
+// ( memory.size ) or ( memory.grow <expr> )
+type WasmIntrinsic struct {
+	WasmExprBase
+	op   string
+	args []WasmExpression
+	call *ast.CallExpr
+}
+
+// In the standard syntax:
+// import:  ( import "<module_name>" "<func_name>" ( func <name>? (param <type>* ) (result <type>)* ) )
 func (i *WasmImport) print(writer FormattingWriter) {
-	writer.PrintfIndent(i.indent, "(import %s \"%s\" \"%s\" (param", i.name, i.moduleName, i.funcName)
+	if legacySyntax {
+		funcName := i.funcName
+		if i.legacyName != "" {
+			funcName = i.legacyName
+		}
+		writer.PrintfIndent(i.indent, "(import %s \"%s\" \"%s\" (param", i.name, i.moduleName, funcName)
+	} else {
+		writer.PrintfIndent(i.indent, "(import \"%s\" \"%s\" (func %s (param", i.moduleName, i.funcName, i.name)
+	}
 	for _, p := range i.params {
 		writer.Printf(" ")
 		p.print(writer)
@@ -48,9 +82,19 @@ func (i *WasmImport) print(writer FormattingWriter) {
 		i.result.print(writer)
 		writer.Printf(")")
 	}
+	if !legacySyntax {
+		writer.Printf(")")
+	}
 	writer.Printf(")\n")
 }
 
+func (i *WasmImport) encode(writer *WasmBinaryWriter) {
+	writer.writeName(i.moduleName)
+	writer.writeName(i.funcName)
+	writer.writeByte(externFunc)
+	writer.writeU32(uint32(writer.typeIndex[i.signature]))
+}
+
 func (s *WasmScope) parseWASMRuntimeSignature(sig string) ([]WasmType, WasmType, error) {
 	partsTopLevel := strings.Split(sig, "->")
 	if len(partsTopLevel) != 2 {
@@ -60,10 +104,9 @@ func (s *WasmScope) parseWASMRuntimeSignature(sig string) ([]WasmType, WasmType,
 	result := make([]WasmType, 0, 10)
 	parts := strings.Split(params, ",")
 	for _, typeName := range parts {
-		t, ok := s.f.module.types[typeName]
-		if !ok {
-			// TODO(cierniak): Implement this case.
-			return nil, nil, fmt.Errorf("param type %s hasn't been used yet", typeName)
+		t, err := s.f.module.scalarType(typeName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("param type of an import: %w", err)
 		}
 		result = append(result, t)
 	}
@@ -71,11 +114,10 @@ func (s *WasmScope) parseWASMRuntimeSignature(sig string) ([]WasmType, WasmType,
 	ret := partsTopLevel[1]
 	var retType WasmType
 	if ret != "" {
-		var ok bool
-		retType, ok = s.f.module.types[ret]
-		if !ok {
-			// TODO(cierniak): Implement this case.
-			return nil, nil, fmt.Errorf("return type %s hasn't been used yet", ret)
+		var err error
+		retType, err = s.f.module.scalarType(ret)
+		if err != nil {
+			return nil, nil, fmt.Errorf("return type of an import: %w", err)
 		}
 	}
 
@@ -84,6 +126,9 @@ func (s *WasmScope) parseWASMRuntimeSignature(sig string) ([]WasmType, WasmType,
 
 func (s *WasmScope) parseWASMRuntimeCall(pkg string, ident *ast.Ident, call *ast.CallExpr, indent int) (WasmExpression, error) {
 	name := ident.Name
+	if op, ok := intrinsics[pkg+"."+name]; ok {
+		return s.parseIntrinsicCall(op, call, indent)
+	}
 	namesWASM, ok := functionNames[pkg+"."+name]
 	if !ok {
 		return nil, fmt.Errorf("couldn't find name mapping for import %s", name)
@@ -94,19 +139,28 @@ func (s *WasmScope) parseWASMRuntimeCall(pkg string, ident *ast.Ident, call *ast
 	}
 	i, ok := s.f.module.imports[name]
 	if !ok {
+		sig := &WasmTypeFunc{
+			params: params,
+			indent: 1,
+		}
+		if result != nil {
+			sig.results = []WasmType{result}
+		}
 		i = &WasmImport{
 			name:       astNameToWASM(name, nil),
 			moduleName: namesWASM.module,
 			funcName:   namesWASM.function,
+			legacyName: namesWASM.legacy,
 			params:     params,
 			result:     result,
+			signature:  s.f.module.signatures.add(sig),
 			indent:     s.f.module.indent + 1,
 		}
 		s.f.module.imports[name] = i
 	}
 	args, err := s.parseArgs(call.Args, indent+1)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing args to runtime function %s: %v", name, err)
+		return nil, fmt.Errorf("error parsing args to runtime function %s: %w", name, err)
 	}
 	c := &WasmCallImport{
 		i:    i,
@@ -122,13 +176,25 @@ func (c *WasmCallImport) getType() WasmType {
 }
 
 func (c *WasmCallImport) print(writer FormattingWriter) {
-	writer.PrintfIndent(c.getIndent(), "(call_import %s\n", c.i.name)
+	op := "call"
+	if legacySyntax {
+		op = "call_import"
+	}
+	writer.PrintfIndent(c.getIndent(), "(%s %s\n", op, c.i.name)
 	for _, arg := range c.args {
 		arg.print(writer)
 	}
 	writer.PrintfIndent(c.getIndent(), ")\n")
 }
 
+func (c *WasmCallImport) encode(writer *WasmBinaryWriter) {
+	for _, arg := range c.args {
+		arg.encode(writer)
+	}
+	writer.writeOpcode("call")
+	writer.writeU32(uint32(writer.importIndex[c.i]))
+}
+
 func (c *WasmCallImport) getNode() ast.Node {
 	if c.call == nil {
 		return nil
@@ -136,3 +202,49 @@ func (c *WasmCallImport) getNode() ast.Node {
 		return c.call
 	}
 }
+
+// parseIntrinsicCall returns the instruction op, which takes the arguments of
+// the call and returns an int32.
+func (s *WasmScope) parseIntrinsicCall(op string, call *ast.CallExpr, indent int) (WasmExpression, error) {
+	args, err := s.parseArgs(call.Args, indent+1)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing args to %s: %w", op, err)
+	}
+	t, err := s.f.module.scalarType("int32")
+	if err != nil {
+		return nil, err
+	}
+	i := &WasmIntrinsic{
+		op:   op,
+		args: args,
+		call: call,
+	}
+	i.setType(t)
+	i.setIndent(indent)
+	i.setNode(call)
+	return i, nil
+}
+
+func (i *WasmIntrinsic) getType() WasmType {
+	return i.ty
+}
+
+func (i *WasmIntrinsic) print(writer FormattingWriter) {
+	op := i.op
+	if legacySyntax {
+		op = legacyIntrinsics[op]
+	}
+	writer.PrintfIndent(i.getIndent(), "(%s\n", op)
+	for _, arg := range i.args {
+		arg.print(writer)
+	}
+	writer.PrintfIndent(i.getIndent(), ") ;; %s\n", op)
+}
+
+func (i *WasmIntrinsic) encode(writer *WasmBinaryWriter) {
+	for _, arg := range i.args {
+		arg.encode(writer)
+	}
+	writer.writeOpcode(i.op)
+	writer.writeByte(0x00) // memory 0
+}
@@ -0,0 +1,109 @@
+package main
+
+// Initialization of packages.
+//
+// Global variables whose initial values are constants, or composite literals of
+// arrays and structs with constant values, are written into the static memory,
+// see initializeGlobalVar. The other initializers and the init functions run in
+// the start function of the module when it is instantiated. Packages are
+// initialized after the packages they import, which are added to the module
+// first, and the variables of a package in the order computed by go/types.
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+)
+
+// globalInit is an initializer of global variables that is evaluated by the
+// start function. lhs are the names of the variables, which are assigned
+// together if the value is a call with multiple results.
+type globalInit struct {
+	file *WasmGoSourceFile
+	lhs  []ast.Expr
+}
+
+// isInit returns whether a function declaration is an init function of the
+// package, of which there may be several.
+func isInit(decl *ast.FuncDecl) bool {
+	return decl.Recv == nil && decl.Name.Name == "init"
+}
+
+// generateStart generates the start function of the module, if any package
+// has variables that aren't initialized statically or init functions.
+func (m *WasmModule) generateStart() error {
+	var errs GoWasmErrorList
+	for i, file := range m.files {
+		if i > 0 && m.files[i-1].pkgName == file.pkgName {
+			continue // the files of a package share the initialization order
+		}
+		for _, init := range file.info.InitOrder {
+			g, ok := m.globalInits[init.Rhs]
+			if !ok {
+				continue
+			}
+			f := m.startFunc(g.file)
+			// The initializer is parsed in the scope of its file, e.g., for the
+			// names of imported packages.
+			f.file = g.file
+			if f.scope.isBlocking(init.Rhs) {
+				errs.add(g.file.ErrorNode(init.Rhs, "unimplemented initializer of a global variable that may block"))
+				continue
+			}
+			var stmt ast.Stmt = &ast.AssignStmt{
+				Lhs:    g.lhs,
+				TokPos: init.Rhs.Pos(),
+				Tok:    token.ASSIGN,
+				Rhs:    []ast.Expr{init.Rhs},
+			}
+			if len(g.lhs) == 1 && isBlankIdent(g.lhs[0]) {
+				stmt = &ast.ExprStmt{X: init.Rhs}
+			}
+			if err := f.scope.parseStatementList([]ast.Stmt{stmt}, f.indent+1); err != nil {
+				g.file.addError(&errs, init.Rhs, err)
+			}
+		}
+		for _, fn := range m.initFuncs[file.pkgName] {
+			if fn.mayBlock() {
+				errs.add(fn.file.ErrorNode(fn.funcDecl, "unimplemented init function that may block"))
+				continue
+			}
+			f := m.startFunc(fn.file)
+			call, err := f.scope.createCallExprWithArgs(nil, fn.name, fn, nil, f.indent+1)
+			if err != nil {
+				return err
+			}
+			call.setNode(fn.funcDecl.Name)
+			f.scope.expressions = append(f.scope.expressions, call)
+		}
+	}
+	return errs.err()
+}
+
+// startFunc returns the start function of the module, which is created when
+// the first package needs it.
+func (m *WasmModule) startFunc(file *WasmGoSourceFile) *WasmFunc {
+	if m.start != nil {
+		return m.start
+	}
+	void := &WasmTypeFunc{
+		indent: 1,
+	}
+	void.setAlign(4)
+	void.setSize(4)
+	f := &WasmFunc{
+		fset:       file.fset,
+		module:     m,
+		file:       file,
+		indent:     1,
+		name:       "$init",
+		origName:   "init",
+		namePos:    file.astFile.Name.Pos(),
+		signature:  m.signatures.add(void),
+		gotoLabels: make(map[string]string),
+	}
+	f.scope = f.createScope(fmt.Sprintf("function_%s", f.origName))
+	m.functions = append(m.functions, f)
+	m.start = f
+	return f
+}
@@ -0,0 +1,1528 @@
+package main
+
+// A small interpreter for the WebAssembly binary format. It understands the
+// subset of the format produced by WasmBinaryWriter and is used to execute
+// the assertions collected from the //wasm: pragmas without external tools.
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxCallDepth   = 10000
+	nullTableEntry = -1
+)
+
+type wasmTrap struct {
+	msg string
+}
+
+func (t *wasmTrap) Error() string {
+	return "trap: " + t.msg
+}
+
+func trap(format string, a ...interface{}) {
+	panic(&wasmTrap{msg: fmt.Sprintf(format, a...)})
+}
+
+type interpFuncType struct {
+	params  []byte
+	results []byte
+}
+
+type hostFunc func(in *WasmInterpreter, args []uint64) []uint64
+
+type interpFunc struct {
+	name    string
+	typeIdx uint32
+	locals  []byte
+	code    []byte
+	host    hostFunc
+	ends    map[int]int // pc of block, loop or if -> pc of the matching end
+	elses   map[int]int // pc of if -> pc of the matching else
+	// indirect is whether the function uses call_indirect, which needs a table.
+	indirect bool
+}
+
+// WasmInterpreter is an instance of a decoded module.
+type WasmInterpreter struct {
+	types       []interpFuncType
+	funcs       []*interpFunc
+	table       []int64
+	memory      []byte
+	maxPages    int
+	globals     []uint64
+	globalTypes []byte
+	exports     map[string]uint32
+	depth       int
+}
+
+// Host implementations of the functions in functionNames.
+var hostFunctions = map[string]hostFunc{
+	"wasm.Print_int32": func(in *WasmInterpreter, args []uint64) []uint64 {
+		fmt.Printf("%d : i32\n", int32(args[0]))
+		return nil
+	},
+	"wasm.Print_int64": func(in *WasmInterpreter, args []uint64) []uint64 {
+		fmt.Printf("%d : i64\n", int64(args[0]))
+		return nil
+	},
+	"v8.Puts": func(in *WasmInterpreter, args []uint64) []uint64 {
+		addr := uint32(args[0])
+		end := addr
+		for int(end) < len(in.memory) && in.memory[end] != 0 {
+			end++
+		}
+		fmt.Printf("%s\n", in.memory[addr:end])
+		return []uint64{0}
+	},
+}
+
+func findHostFunction(module, name string) (hostFunc, bool) {
+	for goName, n := range functionNames {
+		if n.module == module && (n.function == name || n.legacy == name) {
+			f, ok := hostFunctions[goName]
+			return f, ok
+		}
+	}
+	return nil, false
+}
+
+// interpReader decodes the primitive values of the binary format.
+type interpReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *interpReader) eof() bool {
+	return r.pos >= len(r.b)
+}
+
+func (r *interpReader) byte() byte {
+	if r.pos >= len(r.b) {
+		panic(fmt.Errorf("unexpected end of binary at offset %d", r.pos))
+	}
+	b := r.b[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *interpReader) bytes(n int) []byte {
+	if r.pos+n > len(r.b) {
+		panic(fmt.Errorf("unexpected end of binary at offset %d", r.pos))
+	}
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *interpReader) u32() uint32 {
+	var result uint32
+	var shift uint
+	for {
+		b := r.byte()
+		result |= uint32(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return result
+		}
+		shift += 7
+	}
+}
+
+func (r *interpReader) s64() int64 {
+	var result int64
+	var shift uint
+	for {
+		b := r.byte()
+		result |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				result |= -1 << shift
+			}
+			return result
+		}
+	}
+}
+
+func (r *interpReader) s32() int32 {
+	return int32(r.s64())
+}
+
+func (r *interpReader) name() string {
+	n := r.u32()
+	return string(r.bytes(int(n)))
+}
+
+func (r *interpReader) limits() (uint32, int) {
+	flags := r.byte()
+	min := r.u32()
+	max := -1
+	if flags&1 != 0 {
+		max = int(r.u32())
+	}
+	return min, max
+}
+
+// constExpr evaluates a constant expression, e.g., the offset of a segment.
+func (r *interpReader) constExpr(in *WasmInterpreter) uint64 {
+	var v uint64
+	for {
+		op := r.byte()
+		switch op {
+		default:
+			panic(fmt.Errorf("unsupported instruction in a constant expression: 0x%02x", op))
+		case opcodes["end"]:
+			return v
+		case opcodes["i32.const"]:
+			v = uint64(uint32(r.s32()))
+		case opcodes["i64.const"]:
+			v = uint64(r.s64())
+		case opcodes["f32.const"]:
+			v = uint64(leUint32(r.bytes(4)))
+		case opcodes["f64.const"]:
+			v = leUint64(r.bytes(8))
+		case opcodes["global.get"]:
+			v = in.globals[r.u32()]
+		}
+	}
+}
+
+func leUint32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+func leUint64(b []byte) uint64 {
+	return uint64(leUint32(b[0:4])) | uint64(leUint32(b[4:8]))<<32
+}
+
+// NewWasmInterpreter decodes and instantiates a binary module.
+func NewWasmInterpreter(binary []byte) (in *WasmInterpreter, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch r := r.(type) {
+			default:
+				panic(r)
+			case error:
+				in, err = nil, r
+			}
+		}
+	}()
+	in = &WasmInterpreter{
+		maxPages: -1,
+		exports:  make(map[string]uint32),
+	}
+	r := &interpReader{b: binary}
+	if string(r.bytes(4)) != "\x00asm" {
+		return nil, fmt.Errorf("not a WASM binary")
+	}
+	if v := leUint32(r.bytes(4)); v != binaryVersion {
+		return nil, fmt.Errorf("unsupported WASM binary version: %d", v)
+	}
+	var funcTypes []uint32
+	start := -1
+	for !r.eof() {
+		id := r.byte()
+		size := r.u32()
+		sr := &interpReader{b: r.bytes(int(size))}
+		switch id {
+		default:
+			// Custom and unknown sections are ignored.
+		case sectionType:
+			for n := sr.u32(); n > 0; n-- {
+				if sr.byte() != typeFunc {
+					return nil, fmt.Errorf("malformed function type")
+				}
+				t := interpFuncType{}
+				t.params = sr.bytes(int(sr.u32()))
+				t.results = sr.bytes(int(sr.u32()))
+				in.types = append(in.types, t)
+			}
+		case sectionImport:
+			for n := sr.u32(); n > 0; n-- {
+				module := sr.name()
+				name := sr.name()
+				if kind := sr.byte(); kind != externFunc {
+					return nil, fmt.Errorf("unsupported import kind %d: %s.%s", kind, module, name)
+				}
+				host, ok := findHostFunction(module, name)
+				if !ok {
+					return nil, fmt.Errorf("unresolved import: %s.%s", module, name)
+				}
+				in.funcs = append(in.funcs, &interpFunc{
+					name:    module + "." + name,
+					typeIdx: sr.u32(),
+					host:    host,
+				})
+			}
+		case sectionFunction:
+			for n := sr.u32(); n > 0; n-- {
+				funcTypes = append(funcTypes, sr.u32())
+			}
+		case sectionTable:
+			for n := sr.u32(); n > 0; n-- {
+				sr.byte() // element type
+				min, _ := sr.limits()
+				in.table = make([]int64, min)
+				for i := range in.table {
+					in.table[i] = nullTableEntry
+				}
+			}
+		case sectionMemory:
+			for n := sr.u32(); n > 0; n-- {
+				min, max := sr.limits()
+				in.memory = make([]byte, int(min)*wasmPageSize)
+				in.maxPages = max
+			}
+		case sectionGlobal:
+			for n := sr.u32(); n > 0; n-- {
+				in.globalTypes = append(in.globalTypes, sr.byte())
+				sr.byte() // mutability
+				in.globals = append(in.globals, sr.constExpr(in))
+			}
+		case sectionExport:
+			names := make(map[string]bool)
+			for n := sr.u32(); n > 0; n-- {
+				name := sr.name()
+				kind := sr.byte()
+				idx := sr.u32()
+				// The names of all kinds of exports must be distinct.
+				if names[name] {
+					return nil, fmt.Errorf("invalid module: duplicate export name %q", name)
+				}
+				names[name] = true
+				if kind == externFunc {
+					in.exports[name] = idx
+				}
+			}
+		case sectionStart:
+			start = int(sr.u32())
+		case sectionElement:
+			for n := sr.u32(); n > 0; n-- {
+				if flags := sr.u32(); flags != 0 {
+					return nil, fmt.Errorf("unsupported element segment kind: %d", flags)
+				}
+				offset := uint32(sr.constExpr(in))
+				for i := sr.u32(); i > 0; i-- {
+					if int(offset) >= len(in.table) {
+						return nil, fmt.Errorf("element segment out of bounds")
+					}
+					in.table[offset] = int64(sr.u32())
+					offset++
+				}
+			}
+		case sectionCode:
+			numImports := len(in.funcs)
+			for i := sr.u32(); i > 0; i-- {
+				body := &interpReader{b: sr.bytes(int(sr.u32()))}
+				f := &interpFunc{
+					typeIdx: funcTypes[len(in.funcs)-numImports],
+				}
+				for n := body.u32(); n > 0; n-- {
+					count := body.u32()
+					t := body.byte()
+					for j := uint32(0); j < count; j++ {
+						f.locals = append(f.locals, t)
+					}
+				}
+				f.code = body.b[body.pos:]
+				f.scanBlocks()
+				in.funcs = append(in.funcs, f)
+			}
+		case sectionData:
+			for n := sr.u32(); n > 0; n-- {
+				if flags := sr.u32(); flags != 0 {
+					return nil, fmt.Errorf("unsupported data segment kind: %d", flags)
+				}
+				offset := uint32(sr.constExpr(in))
+				data := sr.bytes(int(sr.u32()))
+				if int(offset)+len(data) > len(in.memory) {
+					return nil, fmt.Errorf("data segment out of bounds")
+				}
+				copy(in.memory[offset:], data)
+			}
+		}
+	}
+	for _, f := range in.funcs {
+		// A table of length 0 isn't nil, see sectionTable.
+		if f.indirect && in.table == nil {
+			return nil, fmt.Errorf("invalid module: call_indirect without a table")
+		}
+	}
+	for name, idx := range in.exports {
+		if int(idx) < len(in.funcs) {
+			in.funcs[idx].name = name
+		}
+	}
+	in.validate()
+	if start >= 0 {
+		if _, err := in.Invoke(uint32(start), nil); err != nil {
+			return nil, err
+		}
+	}
+	return in, nil
+}
+
+// skipImmediates returns the pc of the instruction following the one at pc.
+func skipImmediates(code []byte, pc int) int {
+	r := &interpReader{b: code, pos: pc}
+	op := r.byte()
+	switch {
+	case op == opcodes["block"] || op == opcodes["loop"] || op == opcodes["if"]:
+		r.s64() // block type
+	case op == opcodes["br"] || op == opcodes["br_if"] || op == opcodes["call"]:
+		r.u32()
+	case op == opcodes["br_table"]:
+		for n := r.u32(); n > 0; n-- {
+			r.u32()
+		}
+		r.u32()
+	case op == opcodes["call_indirect"]:
+		r.u32()
+		r.u32()
+	case op >= opcodes["local.get"] && op <= opcodes["global.set"]:
+		r.u32()
+	case op >= opcodes["i32.load"] && op <= opcodes["i64.store32"]:
+		r.u32()
+		r.u32()
+	case op == opcodes["memory.size"] || op == opcodes["memory.grow"]:
+		r.byte()
+	case op == opcodes["i32.const"] || op == opcodes["i64.const"]:
+		r.s64()
+	case op == opcodes["f32.const"]:
+		r.bytes(4)
+	case op == opcodes["f64.const"]:
+		r.bytes(8)
+	case op == prefixSaturating:
+		r.u32()
+	}
+	return r.pos
+}
+
+// scanBlocks finds the matching else and end instructions of all blocks.
+func (f *interpFunc) scanBlocks() {
+	f.ends = make(map[int]int)
+	f.elses = make(map[int]int)
+	open := []int{}
+	for pc := 0; pc < len(f.code); pc = skipImmediates(f.code, pc) {
+		switch f.code[pc] {
+		case opcodes["block"], opcodes["loop"], opcodes["if"]:
+			open = append(open, pc)
+		case opcodes["call_indirect"]:
+			f.indirect = true
+		case opcodes["else"]:
+			f.elses[open[len(open)-1]] = pc
+		case opcodes["end"]:
+			if len(open) > 0 {
+				f.ends[open[len(open)-1]] = pc
+				open = open[:len(open)-1]
+			}
+		}
+	}
+}
+
+// blockArity returns the number of values a block type consumes and produces.
+func (in *WasmInterpreter) blockArity(code []byte, pc int) (int, int) {
+	r := &interpReader{b: code, pos: pc}
+	b := code[pc]
+	if b == blockTypeEmpty {
+		return 0, 0
+	}
+	if _, ok := valueTypeName(b); ok {
+		return 0, 1
+	}
+	t := in.types[r.s64()]
+	return len(t.params), len(t.results)
+}
+
+func valueTypeName(code byte) (string, bool) {
+	for name, c := range valueTypeCodes {
+		if c == code {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Invoke calls a function and converts a trap into an error.
+func (in *WasmInterpreter) Invoke(idx uint32, args []uint64) (results []uint64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch r := r.(type) {
+			default:
+				panic(r)
+			case *wasmTrap:
+				in.depth = 0
+				results, err = nil, r
+			}
+		}
+	}()
+	if int(idx) >= len(in.funcs) {
+		return nil, fmt.Errorf("function index out of range: %d", idx)
+	}
+	if n := len(in.types[in.funcs[idx].typeIdx].params); n != len(args) {
+		return nil, fmt.Errorf("function %s expects %d arguments, got %d", in.funcs[idx].name, n, len(args))
+	}
+	return in.call(idx, args), nil
+}
+
+// InvokeExport calls an exported function.
+func (in *WasmInterpreter) InvokeExport(name string, args []uint64) ([]uint64, error) {
+	idx, ok := in.exports[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown export: %s", name)
+	}
+	return in.Invoke(idx, args)
+}
+
+type interpLabel struct {
+	end    int // pc of the end instruction
+	cont   int // pc to continue at after a branch
+	height int // height of the operand stack at block entry
+	arity  int // number of values transferred by a branch
+}
+
+func (in *WasmInterpreter) call(idx uint32, args []uint64) []uint64 {
+	f := in.funcs[idx]
+	t := in.types[f.typeIdx]
+	if f.host != nil {
+		return f.host(in, args)
+	}
+	in.depth++
+	if in.depth > maxCallDepth {
+		trap("call stack exhausted")
+	}
+	defer func() { in.depth-- }()
+
+	locals := make([]uint64, len(args)+len(f.locals))
+	copy(locals, args)
+	stack := make([]uint64, 0, 16)
+	labels := make([]interpLabel, 0, 8)
+	code := f.code
+	pc := 0
+
+	push := func(v uint64) {
+		stack = append(stack, v)
+	}
+	pop := func() uint64 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	branch := func(depth uint32) {
+		l := labels[len(labels)-1-int(depth)]
+		results := stack[len(stack)-l.arity:]
+		stack = append(stack[:l.height], results...)
+		if l.cont == l.end+1 {
+			labels = labels[:len(labels)-1-int(depth)]
+		} else {
+			labels = labels[:len(labels)-int(depth)]
+		}
+		pc = l.cont
+	}
+
+	for {
+		r := &interpReader{b: code, pos: pc}
+		start := pc
+		op := r.byte()
+		pc = r.pos
+		switch op {
+		case prefixSaturating:
+			sub := r.u32()
+			pc = r.pos
+			in.execSaturating(sub, &stack)
+		default:
+			if op >= opcodes["i32.load"] && op <= opcodes["i64.store32"] {
+				r.u32() // alignment hint
+				offset := r.u32()
+				pc = r.pos
+				in.execMemory(op, offset, &stack)
+			} else if !in.execNumeric(op, &stack) {
+				trap("unsupported instruction 0x%02x in %s", op, f.name)
+			}
+		case opcodes["unreachable"]:
+			trap("unreachable executed in %s", f.name)
+		case opcodes["nop"]:
+		case opcodes["block"], opcodes["if"]:
+			params, results := in.blockArity(code, pc)
+			pc = skipImmediates(code, start)
+			end := f.ends[start]
+			l := interpLabel{end: end, cont: end + 1, height: len(stack) - params, arity: results}
+			if op == opcodes["if"] {
+				l.height--
+				if uint32(pop()) == 0 {
+					if elsePC, ok := f.elses[start]; ok {
+						pc = elsePC + 1
+					} else {
+						pc = end + 1
+						continue
+					}
+				}
+			}
+			labels = append(labels, l)
+		case opcodes["loop"]:
+			params, _ := in.blockArity(code, pc)
+			pc = skipImmediates(code, start)
+			labels = append(labels, interpLabel{end: f.ends[start], cont: pc, height: len(stack) - params, arity: params})
+		case opcodes["else"]:
+			l := labels[len(labels)-1]
+			labels = labels[:len(labels)-1]
+			pc = l.end + 1
+		case opcodes["end"]:
+			if len(labels) == 0 {
+				return stack[len(stack)-len(t.results):]
+			}
+			labels = labels[:len(labels)-1]
+		case opcodes["br"]:
+			branch(r.u32())
+		case opcodes["br_if"]:
+			depth := r.u32()
+			pc = r.pos
+			if uint32(pop()) != 0 {
+				branch(depth)
+			}
+		case opcodes["br_table"]:
+			targets := make([]uint32, r.u32())
+			for i := range targets {
+				targets[i] = r.u32()
+			}
+			def := r.u32()
+			i := uint32(pop())
+			if int(i) < len(targets) {
+				branch(targets[i])
+			} else {
+				branch(def)
+			}
+		case opcodes["return"]:
+			return stack[len(stack)-len(t.results):]
+		case opcodes["call"]:
+			callee := r.u32()
+			pc = r.pos
+			stack = in.callWithStack(callee, stack)
+		case opcodes["call_indirect"]:
+			typeIdx := r.u32()
+			r.u32() // table index
+			pc = r.pos
+			i := uint32(pop())
+			if int(i) >= len(in.table) {
+				trap("undefined element %d in %s", i, f.name)
+			}
+			callee := in.table[i]
+			if callee == nullTableEntry {
+				trap("uninitialized element %d in %s", i, f.name)
+			}
+			if !in.sameType(in.funcs[callee].typeIdx, typeIdx) {
+				trap("indirect call type mismatch in %s", f.name)
+			}
+			stack = in.callWithStack(uint32(callee), stack)
+		case opcodes["drop"]:
+			pop()
+		case opcodes["select"]:
+			c := uint32(pop())
+			b := pop()
+			a := pop()
+			if c != 0 {
+				push(a)
+			} else {
+				push(b)
+			}
+		case opcodes["local.get"]:
+			push(locals[r.u32()])
+			pc = r.pos
+		case opcodes["local.set"]:
+			locals[r.u32()] = pop()
+			pc = r.pos
+		case opcodes["local.tee"]:
+			locals[r.u32()] = stack[len(stack)-1]
+			pc = r.pos
+		case opcodes["global.get"]:
+			push(in.globals[r.u32()])
+			pc = r.pos
+		case opcodes["global.set"]:
+			in.globals[r.u32()] = pop()
+			pc = r.pos
+		case opcodes["memory.size"]:
+			r.byte()
+			pc = r.pos
+			push(uint64(len(in.memory) / wasmPageSize))
+		case opcodes["memory.grow"]:
+			r.byte()
+			pc = r.pos
+			delta := int(uint32(pop()))
+			old := len(in.memory) / wasmPageSize
+			if (in.maxPages >= 0 && old+delta > in.maxPages) || old+delta > 65536 {
+				push(uint64(uint32(0xffffffff)))
+			} else {
+				in.memory = append(in.memory, make([]byte, delta*wasmPageSize)...)
+				push(uint64(old))
+			}
+		case opcodes["i32.const"]:
+			push(uint64(uint32(r.s32())))
+			pc = r.pos
+		case opcodes["i64.const"]:
+			push(uint64(r.s64()))
+			pc = r.pos
+		case opcodes["f32.const"]:
+			push(uint64(leUint32(r.bytes(4))))
+			pc = r.pos
+		case opcodes["f64.const"]:
+			push(leUint64(r.bytes(8)))
+			pc = r.pos
+		}
+	}
+}
+
+func (in *WasmInterpreter) callWithStack(idx uint32, stack []uint64) []uint64 {
+	t := in.types[in.funcs[idx].typeIdx]
+	n := len(t.params)
+	args := make([]uint64, n)
+	copy(args, stack[len(stack)-n:])
+	stack = stack[:len(stack)-n]
+	return append(stack, in.call(idx, args)...)
+}
+
+func (in *WasmInterpreter) sameType(a, b uint32) bool {
+	ta := in.types[a]
+	tb := in.types[b]
+	return string(ta.params) == string(tb.params) && string(ta.results) == string(tb.results)
+}
+
+func (in *WasmInterpreter) effectiveAddress(addr uint64, offset uint32, size int) int {
+	ea := uint64(uint32(addr)) + uint64(offset)
+	if ea+uint64(size) > uint64(len(in.memory)) {
+		trap("out of bounds memory access at %d", ea)
+	}
+	return int(ea)
+}
+
+func (in *WasmInterpreter) load(ea, size int) uint64 {
+	var v uint64
+	for i := size - 1; i >= 0; i-- {
+		v = v<<8 | uint64(in.memory[ea+i])
+	}
+	return v
+}
+
+func (in *WasmInterpreter) store(ea, size int, v uint64) {
+	for i := 0; i < size; i++ {
+		in.memory[ea+i] = byte(v >> uint(8*i))
+	}
+}
+
+func signExtend(v uint64, size int) uint64 {
+	shift := uint(64 - 8*size)
+	return uint64(int64(v<<shift) >> shift)
+}
+
+// memoryAccess describes a load or store instruction: the access size in
+// bytes, whether the value is sign-extended and whether the value is an i32.
+type memoryAccess struct {
+	size   int
+	signed bool
+	i32    bool
+	store  bool
+}
+
+var memoryAccesses = map[byte]memoryAccess{
+	opcodes["i32.load"]:     {4, false, true, false},
+	opcodes["i64.load"]:     {8, false, false, false},
+	opcodes["f32.load"]:     {4, false, false, false},
+	opcodes["f64.load"]:     {8, false, false, false},
+	opcodes["i32.load8_s"]:  {1, true, true, false},
+	opcodes["i32.load8_u"]:  {1, false, true, false},
+	opcodes["i32.load16_s"]: {2, true, true, false},
+	opcodes["i32.load16_u"]: {2, false, true, false},
+	opcodes["i64.load8_s"]:  {1, true, false, false},
+	opcodes["i64.load8_u"]:  {1, false, false, false},
+	opcodes["i64.load16_s"]: {2, true, false, false},
+	opcodes["i64.load16_u"]: {2, false, false, false},
+	opcodes["i64.load32_s"]: {4, true, false, false},
+	opcodes["i64.load32_u"]: {4, false, false, false},
+	opcodes["i32.store"]:    {4, false, true, true},
+	opcodes["i64.store"]:    {8, false, false, true},
+	opcodes["f32.store"]:    {4, false, false, true},
+	opcodes["f64.store"]:    {8, false, false, true},
+	opcodes["i32.store8"]:   {1, false, true, true},
+	opcodes["i32.store16"]:  {2, false, true, true},
+	opcodes["i64.store8"]:   {1, false, false, true},
+	opcodes["i64.store16"]:  {2, false, false, true},
+	opcodes["i64.store32"]:  {4, false, false, true},
+}
+
+func (in *WasmInterpreter) execMemory(op byte, offset uint32, stack *[]uint64) {
+	s := *stack
+	a := memoryAccesses[op]
+	if a.store {
+		v := s[len(s)-1]
+		ea := in.effectiveAddress(s[len(s)-2], offset, a.size)
+		in.store(ea, a.size, v)
+		*stack = s[:len(s)-2]
+		return
+	}
+	ea := in.effectiveAddress(s[len(s)-1], offset, a.size)
+	v := in.load(ea, a.size)
+	if a.signed {
+		v = signExtend(v, a.size)
+	}
+	if a.i32 {
+		v = uint64(uint32(v))
+	}
+	s[len(s)-1] = v
+}
+
+func f32(v uint64) float32 {
+	return math.Float32frombits(uint32(v))
+}
+
+func f64(v uint64) float64 {
+	return math.Float64frombits(v)
+}
+
+func fromF32(f float32) uint64 {
+	return uint64(math.Float32bits(f))
+}
+
+func fromF64(f float64) uint64 {
+	return math.Float64bits(f)
+}
+
+func fromBool(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func truncFloat(f float64, min, max float64) float64 {
+	if math.IsNaN(f) {
+		trap("invalid conversion to integer")
+	}
+	t := math.Trunc(f)
+	if t < min || t >= max {
+		trap("integer overflow")
+	}
+	return t
+}
+
+// truncSat truncates f to an integer of the given number of bits, converting
+// NaN to 0 and values out of range to the nearest representable integer.
+func truncSat(f float64, signed bool, bits int) uint64 {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case signed && f <= -math.Ldexp(1, bits-1):
+		return ^uint64(0) << (bits - 1)
+	case signed && f >= math.Ldexp(1, bits-1):
+		return 1<<(bits-1) - 1
+	case signed:
+		return uint64(int64(f))
+	case f <= 0:
+		return 0
+	case f >= math.Ldexp(1, bits):
+		return ^uint64(0) >> (64 - bits)
+	}
+	return uint64(f)
+}
+
+// execSaturating executes the saturating truncation at index sub of satOpcodes.
+func (in *WasmInterpreter) execSaturating(sub uint32, stack *[]uint64) {
+	s := *stack
+	f := float64(f32(s[len(s)-1]))
+	if sub&2 != 0 {
+		f = f64(s[len(s)-1])
+	}
+	if sub&4 != 0 {
+		s[len(s)-1] = truncSat(f, sub&1 == 0, 64)
+	} else {
+		s[len(s)-1] = uint64(uint32(truncSat(f, sub&1 == 0, 32)))
+	}
+}
+
+// execNumeric executes a numeric instruction. It returns false if op is not a
+// numeric instruction.
+func (in *WasmInterpreter) execNumeric(op byte, stack *[]uint64) bool {
+	s := *stack
+	unary := func(f func(a uint64) uint64) {
+		s[len(s)-1] = f(s[len(s)-1])
+	}
+	binary := func(f func(a, b uint64) uint64) {
+		s[len(s)-2] = f(s[len(s)-2], s[len(s)-1])
+		*stack = s[:len(s)-1]
+	}
+	i32 := func(f func(a, b int32) int32) {
+		binary(func(a, b uint64) uint64 { return uint64(uint32(f(int32(a), int32(b)))) })
+	}
+	u32 := func(f func(a, b uint32) uint32) {
+		binary(func(a, b uint64) uint64 { return uint64(f(uint32(a), uint32(b))) })
+	}
+	i64 := func(f func(a, b int64) int64) {
+		binary(func(a, b uint64) uint64 { return uint64(f(int64(a), int64(b))) })
+	}
+	u64 := func(f func(a, b uint64) uint64) {
+		binary(f)
+	}
+	f32op := func(f func(a, b float32) float32) {
+		binary(func(a, b uint64) uint64 { return fromF32(f(f32(a), f32(b))) })
+	}
+	f64op := func(f func(a, b float64) float64) {
+		binary(func(a, b uint64) uint64 { return fromF64(f(f64(a), f64(b))) })
+	}
+	cmp := func(f func(a, b uint64) bool) {
+		binary(func(a, b uint64) uint64 { return fromBool(f(a, b)) })
+	}
+	checkDiv32 := func(b uint32) {
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+	}
+	checkDiv64 := func(b uint64) {
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+	}
+
+	switch op {
+	default:
+		return false
+
+	case opcodes["i32.eqz"]:
+		unary(func(a uint64) uint64 { return fromBool(uint32(a) == 0) })
+	case opcodes["i32.eq"]:
+		cmp(func(a, b uint64) bool { return uint32(a) == uint32(b) })
+	case opcodes["i32.ne"]:
+		cmp(func(a, b uint64) bool { return uint32(a) != uint32(b) })
+	case opcodes["i32.lt_s"]:
+		cmp(func(a, b uint64) bool { return int32(a) < int32(b) })
+	case opcodes["i32.lt_u"]:
+		cmp(func(a, b uint64) bool { return uint32(a) < uint32(b) })
+	case opcodes["i32.gt_s"]:
+		cmp(func(a, b uint64) bool { return int32(a) > int32(b) })
+	case opcodes["i32.gt_u"]:
+		cmp(func(a, b uint64) bool { return uint32(a) > uint32(b) })
+	case opcodes["i32.le_s"]:
+		cmp(func(a, b uint64) bool { return int32(a) <= int32(b) })
+	case opcodes["i32.le_u"]:
+		cmp(func(a, b uint64) bool { return uint32(a) <= uint32(b) })
+	case opcodes["i32.ge_s"]:
+		cmp(func(a, b uint64) bool { return int32(a) >= int32(b) })
+	case opcodes["i32.ge_u"]:
+		cmp(func(a, b uint64) bool { return uint32(a) >= uint32(b) })
+
+	case opcodes["i64.eqz"]:
+		unary(func(a uint64) uint64 { return fromBool(a == 0) })
+	case opcodes["i64.eq"]:
+		cmp(func(a, b uint64) bool { return a == b })
+	case opcodes["i64.ne"]:
+		cmp(func(a, b uint64) bool { return a != b })
+	case opcodes["i64.lt_s"]:
+		cmp(func(a, b uint64) bool { return int64(a) < int64(b) })
+	case opcodes["i64.lt_u"]:
+		cmp(func(a, b uint64) bool { return a < b })
+	case opcodes["i64.gt_s"]:
+		cmp(func(a, b uint64) bool { return int64(a) > int64(b) })
+	case opcodes["i64.gt_u"]:
+		cmp(func(a, b uint64) bool { return a > b })
+	case opcodes["i64.le_s"]:
+		cmp(func(a, b uint64) bool { return int64(a) <= int64(b) })
+	case opcodes["i64.le_u"]:
+		cmp(func(a, b uint64) bool { return a <= b })
+	case opcodes["i64.ge_s"]:
+		cmp(func(a, b uint64) bool { return int64(a) >= int64(b) })
+	case opcodes["i64.ge_u"]:
+		cmp(func(a, b uint64) bool { return a >= b })
+
+	case opcodes["f32.eq"]:
+		cmp(func(a, b uint64) bool { return f32(a) == f32(b) })
+	case opcodes["f32.ne"]:
+		cmp(func(a, b uint64) bool { return f32(a) != f32(b) })
+	case opcodes["f32.lt"]:
+		cmp(func(a, b uint64) bool { return f32(a) < f32(b) })
+	case opcodes["f32.gt"]:
+		cmp(func(a, b uint64) bool { return f32(a) > f32(b) })
+	case opcodes["f32.le"]:
+		cmp(func(a, b uint64) bool { return f32(a) <= f32(b) })
+	case opcodes["f32.ge"]:
+		cmp(func(a, b uint64) bool { return f32(a) >= f32(b) })
+	case opcodes["f64.eq"]:
+		cmp(func(a, b uint64) bool { return f64(a) == f64(b) })
+	case opcodes["f64.ne"]:
+		cmp(func(a, b uint64) bool { return f64(a) != f64(b) })
+	case opcodes["f64.lt"]:
+		cmp(func(a, b uint64) bool { return f64(a) < f64(b) })
+	case opcodes["f64.gt"]:
+		cmp(func(a, b uint64) bool { return f64(a) > f64(b) })
+	case opcodes["f64.le"]:
+		cmp(func(a, b uint64) bool { return f64(a) <= f64(b) })
+	case opcodes["f64.ge"]:
+		cmp(func(a, b uint64) bool { return f64(a) >= f64(b) })
+
+	case opcodes["i32.clz"]:
+		unary(func(a uint64) uint64 { return uint64(bits.LeadingZeros32(uint32(a))) })
+	case opcodes["i32.ctz"]:
+		unary(func(a uint64) uint64 { return uint64(bits.TrailingZeros32(uint32(a))) })
+	case opcodes["i32.popcnt"]:
+		unary(func(a uint64) uint64 { return uint64(bits.OnesCount32(uint32(a))) })
+	case opcodes["i32.add"]:
+		u32(func(a, b uint32) uint32 { return a + b })
+	case opcodes["i32.sub"]:
+		u32(func(a, b uint32) uint32 { return a - b })
+	case opcodes["i32.mul"]:
+		u32(func(a, b uint32) uint32 { return a * b })
+	case opcodes["i32.div_s"]:
+		i32(func(a, b int32) int32 {
+			checkDiv32(uint32(b))
+			if a == math.MinInt32 && b == -1 {
+				trap("integer overflow")
+			}
+			return a / b
+		})
+	case opcodes["i32.div_u"]:
+		u32(func(a, b uint32) uint32 {
+			checkDiv32(b)
+			return a / b
+		})
+	case opcodes["i32.rem_s"]:
+		i32(func(a, b int32) int32 {
+			checkDiv32(uint32(b))
+			if b == -1 {
+				return 0
+			}
+			return a % b
+		})
+	case opcodes["i32.rem_u"]:
+		u32(func(a, b uint32) uint32 {
+			checkDiv32(b)
+			return a % b
+		})
+	case opcodes["i32.and"]:
+		u32(func(a, b uint32) uint32 { return a & b })
+	case opcodes["i32.or"]:
+		u32(func(a, b uint32) uint32 { return a | b })
+	case opcodes["i32.xor"]:
+		u32(func(a, b uint32) uint32 { return a ^ b })
+	case opcodes["i32.shl"]:
+		u32(func(a, b uint32) uint32 { return a << (b & 31) })
+	case opcodes["i32.shr_s"]:
+		i32(func(a, b int32) int32 { return a >> uint32(b&31) })
+	case opcodes["i32.shr_u"]:
+		u32(func(a, b uint32) uint32 { return a >> (b & 31) })
+	case opcodes["i32.rotl"]:
+		u32(func(a, b uint32) uint32 { return bits.RotateLeft32(a, int(b&31)) })
+	case opcodes["i32.rotr"]:
+		u32(func(a, b uint32) uint32 { return bits.RotateLeft32(a, -int(b&31)) })
+
+	case opcodes["i64.clz"]:
+		unary(func(a uint64) uint64 { return uint64(bits.LeadingZeros64(a)) })
+	case opcodes["i64.ctz"]:
+		unary(func(a uint64) uint64 { return uint64(bits.TrailingZeros64(a)) })
+	case opcodes["i64.popcnt"]:
+		unary(func(a uint64) uint64 { return uint64(bits.OnesCount64(a)) })
+	case opcodes["i64.add"]:
+		u64(func(a, b uint64) uint64 { return a + b })
+	case opcodes["i64.sub"]:
+		u64(func(a, b uint64) uint64 { return a - b })
+	case opcodes["i64.mul"]:
+		u64(func(a, b uint64) uint64 { return a * b })
+	case opcodes["i64.div_s"]:
+		i64(func(a, b int64) int64 {
+			checkDiv64(uint64(b))
+			if a == math.MinInt64 && b == -1 {
+				trap("integer overflow")
+			}
+			return a / b
+		})
+	case opcodes["i64.div_u"]:
+		u64(func(a, b uint64) uint64 {
+			checkDiv64(b)
+			return a / b
+		})
+	case opcodes["i64.rem_s"]:
+		i64(func(a, b int64) int64 {
+			checkDiv64(uint64(b))
+			if b == -1 {
+				return 0
+			}
+			return a % b
+		})
+	case opcodes["i64.rem_u"]:
+		u64(func(a, b uint64) uint64 {
+			checkDiv64(b)
+			return a % b
+		})
+	case opcodes["i64.and"]:
+		u64(func(a, b uint64) uint64 { return a & b })
+	case opcodes["i64.or"]:
+		u64(func(a, b uint64) uint64 { return a | b })
+	case opcodes["i64.xor"]:
+		u64(func(a, b uint64) uint64 { return a ^ b })
+	case opcodes["i64.shl"]:
+		u64(func(a, b uint64) uint64 { return a << (b & 63) })
+	case opcodes["i64.shr_s"]:
+		i64(func(a, b int64) int64 { return a >> uint64(b&63) })
+	case opcodes["i64.shr_u"]:
+		u64(func(a, b uint64) uint64 { return a >> (b & 63) })
+	case opcodes["i64.rotl"]:
+		u64(func(a, b uint64) uint64 { return bits.RotateLeft64(a, int(b&63)) })
+	case opcodes["i64.rotr"]:
+		u64(func(a, b uint64) uint64 { return bits.RotateLeft64(a, -int(b&63)) })
+
+	case opcodes["f32.abs"]:
+		unary(func(a uint64) uint64 { return a &^ (1 << 31) })
+	case opcodes["f32.neg"]:
+		unary(func(a uint64) uint64 { return uint64(uint32(a) ^ (1 << 31)) })
+	case opcodes["f32.ceil"]:
+		unary(func(a uint64) uint64 { return fromF32(float32(math.Ceil(float64(f32(a))))) })
+	case opcodes["f32.floor"]:
+		unary(func(a uint64) uint64 { return fromF32(float32(math.Floor(float64(f32(a))))) })
+	case opcodes["f32.trunc"]:
+		unary(func(a uint64) uint64 { return fromF32(float32(math.Trunc(float64(f32(a))))) })
+	case opcodes["f32.nearest"]:
+		unary(func(a uint64) uint64 { return fromF32(float32(math.RoundToEven(float64(f32(a))))) })
+	case opcodes["f32.sqrt"]:
+		unary(func(a uint64) uint64 { return fromF32(float32(math.Sqrt(float64(f32(a))))) })
+	case opcodes["f32.add"]:
+		f32op(func(a, b float32) float32 { return a + b })
+	case opcodes["f32.sub"]:
+		f32op(func(a, b float32) float32 { return a - b })
+	case opcodes["f32.mul"]:
+		f32op(func(a, b float32) float32 { return a * b })
+	case opcodes["f32.div"]:
+		f32op(func(a, b float32) float32 { return a / b })
+	case opcodes["f32.min"]:
+		f32op(func(a, b float32) float32 { return float32(math.Min(float64(a), float64(b))) })
+	case opcodes["f32.max"]:
+		f32op(func(a, b float32) float32 { return float32(math.Max(float64(a), float64(b))) })
+	case opcodes["f32.copysign"]:
+		f32op(func(a, b float32) float32 { return float32(math.Copysign(float64(a), float64(b))) })
+
+	case opcodes["f64.abs"]:
+		unary(func(a uint64) uint64 { return a &^ (1 << 63) })
+	case opcodes["f64.neg"]:
+		unary(func(a uint64) uint64 { return a ^ (1 << 63) })
+	case opcodes["f64.ceil"]:
+		unary(func(a uint64) uint64 { return fromF64(math.Ceil(f64(a))) })
+	case opcodes["f64.floor"]:
+		unary(func(a uint64) uint64 { return fromF64(math.Floor(f64(a))) })
+	case opcodes["f64.trunc"]:
+		unary(func(a uint64) uint64 { return fromF64(math.Trunc(f64(a))) })
+	case opcodes["f64.nearest"]:
+		unary(func(a uint64) uint64 { return fromF64(math.RoundToEven(f64(a))) })
+	case opcodes["f64.sqrt"]:
+		unary(func(a uint64) uint64 { return fromF64(math.Sqrt(f64(a))) })
+	case opcodes["f64.add"]:
+		f64op(func(a, b float64) float64 { return a + b })
+	case opcodes["f64.sub"]:
+		f64op(func(a, b float64) float64 { return a - b })
+	case opcodes["f64.mul"]:
+		f64op(func(a, b float64) float64 { return a * b })
+	case opcodes["f64.div"]:
+		f64op(func(a, b float64) float64 { return a / b })
+	case opcodes["f64.min"]:
+		f64op(math.Min)
+	case opcodes["f64.max"]:
+		f64op(math.Max)
+	case opcodes["f64.copysign"]:
+		f64op(math.Copysign)
+
+	case opcodes["i32.wrap_i64"]:
+		unary(func(a uint64) uint64 { return uint64(uint32(a)) })
+	case opcodes["i32.trunc_f32_s"]:
+		unary(func(a uint64) uint64 { return uint64(uint32(int32(truncFloat(float64(f32(a)), -(1 << 31), 1<<31)))) })
+	case opcodes["i32.trunc_f32_u"]:
+		unary(func(a uint64) uint64 { return uint64(uint32(truncFloat(float64(f32(a)), 0, 1<<32))) })
+	case opcodes["i32.trunc_f64_s"]:
+		unary(func(a uint64) uint64 { return uint64(uint32(int32(truncFloat(f64(a), -(1 << 31), 1<<31)))) })
+	case opcodes["i32.trunc_f64_u"]:
+		unary(func(a uint64) uint64 { return uint64(uint32(truncFloat(f64(a), 0, 1<<32))) })
+	case opcodes["i64.extend_i32_s"]:
+		unary(func(a uint64) uint64 { return uint64(int64(int32(a))) })
+	case opcodes["i64.extend_i32_u"]:
+		unary(func(a uint64) uint64 { return uint64(uint32(a)) })
+	case opcodes["i64.trunc_f32_s"]:
+		unary(func(a uint64) uint64 { return uint64(int64(truncFloat(float64(f32(a)), -(1 << 63), 1<<63))) })
+	case opcodes["i64.trunc_f32_u"]:
+		unary(func(a uint64) uint64 { return uint64(truncFloat(float64(f32(a)), 0, 1<<64)) })
+	case opcodes["i64.trunc_f64_s"]:
+		unary(func(a uint64) uint64 { return uint64(int64(truncFloat(f64(a), -(1 << 63), 1<<63))) })
+	case opcodes["i64.trunc_f64_u"]:
+		unary(func(a uint64) uint64 { return uint64(truncFloat(f64(a), 0, 1<<64)) })
+	case opcodes["f32.convert_i32_s"]:
+		unary(func(a uint64) uint64 { return fromF32(float32(int32(a))) })
+	case opcodes["f32.convert_i32_u"]:
+		unary(func(a uint64) uint64 { return fromF32(float32(uint32(a))) })
+	case opcodes["f32.convert_i64_s"]:
+		unary(func(a uint64) uint64 { return fromF32(float32(int64(a))) })
+	case opcodes["f32.convert_i64_u"]:
+		unary(func(a uint64) uint64 { return fromF32(float32(a)) })
+	case opcodes["f32.demote_f64"]:
+		unary(func(a uint64) uint64 { return fromF32(float32(f64(a))) })
+	case opcodes["f64.convert_i32_s"]:
+		unary(func(a uint64) uint64 { return fromF64(float64(int32(a))) })
+	case opcodes["f64.convert_i32_u"]:
+		unary(func(a uint64) uint64 { return fromF64(float64(uint32(a))) })
+	case opcodes["f64.convert_i64_s"]:
+		unary(func(a uint64) uint64 { return fromF64(float64(int64(a))) })
+	case opcodes["f64.convert_i64_u"]:
+		unary(func(a uint64) uint64 { return fromF64(float64(a)) })
+	case opcodes["f64.promote_f32"]:
+		unary(func(a uint64) uint64 { return fromF64(float64(f32(a))) })
+	case opcodes["i32.reinterpret_f32"], opcodes["f32.reinterpret_i32"]:
+		unary(func(a uint64) uint64 { return uint64(uint32(a)) })
+	case opcodes["i64.reinterpret_f64"], opcodes["f64.reinterpret_i64"]:
+	case opcodes["i32.extend8_s"]:
+		unary(func(a uint64) uint64 { return uint64(uint32(int32(int8(a)))) })
+	case opcodes["i32.extend16_s"]:
+		unary(func(a uint64) uint64 { return uint64(uint32(int32(int16(a)))) })
+	case opcodes["i64.extend8_s"]:
+		unary(func(a uint64) uint64 { return uint64(int64(int8(a))) })
+	case opcodes["i64.extend16_s"]:
+		unary(func(a uint64) uint64 { return uint64(int64(int16(a))) })
+	case opcodes["i64.extend32_s"]:
+		unary(func(a uint64) uint64 { return uint64(int64(int32(a))) })
+	}
+	return true
+}
+
+// sexpr is a node of a parsed s-expression, e.g., an assertion.
+type sexpr struct {
+	atom   string
+	quoted bool
+	list   []*sexpr
+}
+
+func (e *sexpr) String() string {
+	if e.list == nil {
+		if e.quoted {
+			return strconv.Quote(e.atom)
+		}
+		return e.atom
+	}
+	parts := make([]string, len(e.list))
+	for i, x := range e.list {
+		parts[i] = x.String()
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func parseSexprs(src string) ([]*sexpr, error) {
+	var stack [][]*sexpr
+	var top []*sexpr
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			stack = append(stack, top)
+			top = []*sexpr{}
+			i++
+		case c == ')':
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("unbalanced ')' in: %s", src)
+			}
+			e := &sexpr{list: top}
+			top = append(stack[len(stack)-1], e)
+			stack = stack[:len(stack)-1]
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string in: %s", src)
+			}
+			s, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return nil, err
+			}
+			top = append(top, &sexpr{atom: s, quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for j < len(src) && !strings.ContainsRune(" \t\n\r()\"", rune(src[j])) {
+				j++
+			}
+			top = append(top, &sexpr{atom: src[i:j]})
+			i = j
+		}
+	}
+	if len(stack) != 0 {
+		return nil, fmt.Errorf("unbalanced '(' in: %s", src)
+	}
+	return top, nil
+}
+
+// parseConst converts an expression like (i32.const 5) to a value.
+func parseConst(e *sexpr) (string, uint64, error) {
+	if len(e.list) != 2 || !strings.HasSuffix(e.list[0].atom, ".const") {
+		return "", 0, fmt.Errorf("expected a constant, got: %v", e)
+	}
+	ts := strings.TrimSuffix(e.list[0].atom, ".const")
+	value := strings.Replace(e.list[1].atom, "_", "", -1)
+	switch ts {
+	default:
+		return "", 0, fmt.Errorf("unsupported constant type: %v", e)
+	case "i32", "i64":
+		i, err := parseIntValue(value)
+		if err != nil {
+			return "", 0, err
+		}
+		if ts == "i32" {
+			return ts, uint64(uint32(i)), nil
+		}
+		return ts, uint64(i), nil
+	case "f32":
+		if bits, ok, err := parseNaN(value, 32); ok || err != nil {
+			return ts, bits, err
+		}
+		f, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return "", 0, err
+		}
+		return ts, fromF32(float32(f)), nil
+	case "f64":
+		if bits, ok, err := parseNaN(value, 64); ok || err != nil {
+			return ts, bits, err
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", 0, err
+		}
+		return ts, fromF64(f), nil
+	}
+}
+
+// nanBits returns the bits of the exponent, the quiet bit and the payload of
+// a float of the given size.
+func nanBits(size int) (exp, quiet, payload uint64) {
+	if size == 32 {
+		return 0x7f800000, 0x00400000, 0x007fffff
+	}
+	return 0x7ff0000000000000, 0x0008000000000000, 0x000fffffffffffff
+}
+
+// parseNaN converts a NaN like nan, -nan or nan:0x200000 to the bits of a
+// float of the given size. It returns false if value isn't a NaN.
+func parseNaN(value string, size int) (uint64, bool, error) {
+	var sign uint64
+	switch value[0] {
+	case '-':
+		sign = 1 << (size - 1)
+		value = value[1:]
+	case '+':
+		value = value[1:]
+	}
+	if !strings.HasPrefix(value, "nan") {
+		return 0, false, nil
+	}
+	exp, quiet, payload := nanBits(size)
+	bits := exp | quiet
+	if p := strings.TrimPrefix(value, "nan"); p != "" {
+		if !strings.HasPrefix(p, ":0x") {
+			return 0, false, fmt.Errorf("unsupported NaN: %s", value)
+		}
+		v, err := strconv.ParseUint(p[3:], 16, 64)
+		if err != nil || v == 0 || v&^payload != 0 {
+			return 0, false, fmt.Errorf("invalid NaN payload: %s", value)
+		}
+		bits = exp | v
+	}
+	return sign | bits, true, nil
+}
+
+// matchConst returns whether a result of type code equals the expected
+// constant e. For floats, e may be nan:canonical, a NaN whose payload is only
+// the quiet bit, or nan:arithmetic, any NaN with the quiet bit set.
+func matchConst(e *sexpr, code byte, v uint64) (bool, error) {
+	if len(e.list) == 2 && (e.list[1].atom == "nan:canonical" || e.list[1].atom == "nan:arithmetic") {
+		ts := strings.TrimSuffix(e.list[0].atom, ".const")
+		size := 64
+		switch ts {
+		default:
+			return false, fmt.Errorf("unsupported constant type: %v", e)
+		case "f32":
+			size = 32
+		case "f64":
+		}
+		if valueTypeCodes[ts] != code {
+			return false, nil
+		}
+		exp, quiet, payload := nanBits(size)
+		if e.list[1].atom == "nan:canonical" {
+			return v&(exp|payload) == exp|quiet, nil
+		}
+		return v&(exp|quiet) == exp|quiet, nil
+	}
+	ts, want, err := parseConst(e)
+	if err != nil {
+		return false, err
+	}
+	return valueTypeCodes[ts] == code && want == v, nil
+}
+
+func formatValue(code byte, v uint64) string {
+	ts, _ := valueTypeName(code)
+	switch ts {
+	default:
+		return fmt.Sprintf("(%s.const %d)", ts, int64(v))
+	case "i32":
+		return fmt.Sprintf("(i32.const %d)", int32(v))
+	case "f32":
+		if math.IsNaN(float64(f32(v))) {
+			return formatNaN(ts, v, 32)
+		}
+		return fmt.Sprintf("(f32.const %v)", f32(v))
+	case "f64":
+		if math.IsNaN(f64(v)) {
+			return formatNaN(ts, v, 64)
+		}
+		return fmt.Sprintf("(f64.const %v)", f64(v))
+	}
+}
+
+// formatNaN formats a NaN with its sign and payload, e.g., -nan:0x400000.
+func formatNaN(ts string, v uint64, size int) string {
+	_, _, payload := nanBits(size)
+	sign := ""
+	if v>>(size-1)&1 != 0 {
+		sign = "-"
+	}
+	return fmt.Sprintf("(%s.const %snan:0x%x)", ts, sign, v&payload)
+}
+
+// runAction executes an action like (invoke "Fact" (i64.const 3)).
+func (in *WasmInterpreter) runAction(e *sexpr) ([]byte, []uint64, error) {
+	if len(e.list) < 2 || e.list[0].atom != "invoke" || !e.list[1].quoted {
+		return nil, nil, fmt.Errorf("unsupported action: %v", e)
+	}
+	name := e.list[1].atom
+	args := make([]uint64, 0, len(e.list)-2)
+	for _, a := range e.list[2:] {
+		_, v, err := parseConst(a)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, v)
+	}
+	idx, ok := in.exports[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown export: %s", name)
+	}
+	results, err := in.Invoke(idx, args)
+	return in.types[in.funcs[idx].typeIdx].results, results, err
+}
+
+// assertReturn checks an assertion like (invoke "Fact" (i64.const 3)) (i64.const 6).
+func (in *WasmInterpreter) assertReturn(assertion string) error {
+	exprs, err := parseSexprs(assertion)
+	if err != nil {
+		return err
+	}
+	if len(exprs) == 0 {
+		return fmt.Errorf("empty assertion")
+	}
+	types, results, err := in.runAction(exprs[0])
+	if err != nil {
+		return err
+	}
+	expected := exprs[1:]
+	if len(expected) != len(results) {
+		return fmt.Errorf("expected %d results, got %d", len(expected), len(results))
+	}
+	for i, e := range expected {
+		ok, err := matchConst(e, types[i], results[i])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("expected %v, got %s", e, formatValue(types[i], results[i]))
+		}
+	}
+	return nil
+}
+
+// assertTrap checks an assertion like (invoke "Div" (i32.const 0)) "divide",
+// where the message of the trap has to contain the given text.
+func (in *WasmInterpreter) assertTrap(assertion string) error {
+	exprs, err := parseSexprs(assertion)
+	if err != nil {
+		return err
+	}
+	if len(exprs) != 2 || !exprs[1].quoted {
+		return fmt.Errorf("expected an action and a message: %s", assertion)
+	}
+	_, results, err := in.runAction(exprs[0])
+	if err == nil {
+		return fmt.Errorf("expected a trap, got %d results", len(results))
+	}
+	t, ok := err.(*wasmTrap)
+	if !ok {
+		return err
+	}
+	if !strings.Contains(t.msg, exprs[1].atom) {
+		return fmt.Errorf("expected a trap with %q, got %v", exprs[1].atom, t)
+	}
+	return nil
+}
+
+// invoke runs an action like (invoke "PrintAll" (i64.const 3)).
+func (in *WasmInterpreter) invoke(action string) error {
+	exprs, err := parseSexprs(action)
+	if err != nil {
+		return err
+	}
+	if len(exprs) != 1 {
+		return fmt.Errorf("expected a single action: %s", action)
+	}
+	_, _, err = in.runAction(exprs[0])
+	return err
+}
+
+// run executes the assertions and actions collected from the pragmas and
+// returns the number of failures.
+func (m *WasmModule) run() (int, error) {
+	var passed, failed int
+	err := m.runPragmas(func(kind, s string, err error) {
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL: %s %s: %v\n", kind, s, err)
+		} else {
+			passed++
+			fmt.Printf("ok:   %s %s\n", kind, s)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+	return failed, nil
+}
+
+// runPragmas instantiates the module in the interpreter and passes the result
+// of each assertion and action to report.
+func (m *WasmModule) runPragmas(report func(kind, pragma string, err error)) error {
+	w := NewWasmBinaryWriter()
+	m.encode(w)
+	in, err := NewWasmInterpreter(w.b.Bytes())
+	if err != nil {
+		return fmt.Errorf("couldn't instantiate the module: %v", err)
+	}
+	for _, a := range m.assertReturn {
+		report("assert_return", a, in.assertReturn(a))
+	}
+	for _, a := range m.assertTrap {
+		report("assert_trap", a, in.assertTrap(a))
+	}
+	for _, a := range m.invoke {
+		report("invoke", a, in.invoke(a))
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// The header and the type section of a module with the type func().
+const moduleHeader = "\x00asm\x01\x00\x00\x00" + "\x01\x04\x01\x60\x00\x00"
+
+func TestInvalidModules(t *testing.T) {
+	tests := []struct {
+		name   string
+		module string
+		err    string
+	}{
+		{
+			name: "valid",
+			module: moduleHeader + "\x03\x02\x01\x00" +
+				"\x07\x05\x01\x01f\x00\x00" +
+				"\x0a\x04\x01\x02\x00\x0b",
+		},
+		{
+			name: "duplicate export",
+			module: moduleHeader + "\x03\x02\x01\x00" +
+				"\x07\x09\x02\x01f\x00\x00\x01f\x00\x00" +
+				"\x0a\x04\x01\x02\x00\x0b",
+			err: `duplicate export name "f"`,
+		},
+		{
+			name: "call_indirect without a table",
+			module: moduleHeader + "\x03\x02\x01\x00" +
+				"\x0a\x09\x01\x07\x00\x41\x00\x11\x00\x00\x0b",
+			err: "call_indirect without a table",
+		},
+		{
+			name: "type mismatch",
+			module: moduleHeader + "\x03\x02\x01\x00" +
+				"\x0a\x08\x01\x06\x00\x42\x00\x45\x1a\x0b",
+			err: "type mismatch: expected i32, got i64",
+		},
+		{
+			name: "value left on the stack",
+			module: moduleHeader + "\x03\x02\x01\x00" +
+				"\x0a\x06\x01\x04\x00\x41\x00\x0b",
+			err: "values left on the operand stack",
+		},
+		{
+			name: "stack underflow",
+			module: moduleHeader + "\x03\x02\x01\x00" +
+				"\x0a\x05\x01\x03\x00\x1a\x0b",
+			err: "operand stack underflow",
+		},
+		{
+			name: "unreachable code",
+			module: moduleHeader + "\x03\x02\x01\x00" +
+				"\x0a\x06\x01\x04\x00\x00\x1a\x0b",
+		},
+	}
+	for _, test := range tests {
+		_, err := NewWasmInterpreter([]byte(test.module))
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.err != "" && err == nil:
+			t.Errorf("%s: expected an error", test.name)
+		case test.err != "" && !strings.Contains(err.Error(), test.err):
+			t.Errorf("%s: expected an error with %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestMatchConst(t *testing.T) {
+	f32, f64 := valueTypeCodes["f32"], valueTypeCodes["f64"]
+	tests := []struct {
+		expected string
+		code     byte
+		v        uint64
+		match    bool
+	}{
+		{"(f32.const nan:canonical)", f32, 0x7fc00000, true},
+		{"(f32.const nan:canonical)", f32, 0xffc00000, true},
+		{"(f32.const nan:canonical)", f32, 0x7fc00001, false},
+		{"(f32.const nan:canonical)", f64, 0x7ff8000000000000, false},
+		{"(f32.const nan:arithmetic)", f32, 0x7fc00001, true},
+		{"(f32.const nan:arithmetic)", f32, 0x7f800001, false},
+		{"(f64.const nan:canonical)", f64, 0xfff8000000000000, true},
+		{"(f64.const nan:arithmetic)", f64, 0x7ff8000000000001, true},
+		{"(f64.const nan:arithmetic)", f64, 0x7ff0000000000000, false},
+		{"(f64.const nan)", f64, 0x7ff8000000000000, true},
+		{"(f64.const -nan:0x4)", f64, 0xfff0000000000004, true},
+		{"(f32.const nan:0x200000)", f32, 0x7fa00000, true},
+		{"(f32.const 1.5)", f32, 0x3fc00000, true},
+	}
+	for _, test := range tests {
+		exprs, err := parseSexprs(test.expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		match, err := matchConst(exprs[0], test.code, test.v)
+		if err != nil {
+			t.Errorf("%s: %v", test.expected, err)
+		} else if match != test.match {
+			t.Errorf("%s: expected %v for 0x%x, got %v", test.expected, test.match, test.v, match)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Packages whose functions are implemented by the host. Calls to them become
+// WASM imports, so they are not compiled.
+var hostPackages = map[string]bool{
+	"v8":   true,
+	"wasm": true,
+}
+
+// The packages in the runtime directory. The compiler generates calls to their
+// functions, e.g., to allocate memory or to concatenate strings.
+var runtimePackages = []string{"closure", "gc", "hashmap", "iface", "panics", "sched", "slice", "str"}
+
+// runtimePath returns the import path of a runtime package.
+func runtimePath(pkg string) string {
+	return runtimeRoot + "/" + pkg
+}
+
+// listedPackage is the part of the output of 'go list -json' needed to compile
+// a package.
+type listedPackage struct {
+	ImportPath string
+	Name       string
+	Dir        string
+	GoFiles    []string
+	Standard   bool
+	Error      *struct {
+		Err string
+	}
+}
+
+// link compiles the packages named on the command line into a module. A module
+// compiled from packages gets the runtime packages whose functions the
+// generated code calls, and the ones they import: it is compiled again with the
+// ones that are missing, until none are. Source files are compiled as they
+// are.
+func link(args []string) (WasmModuleLinker, error) {
+	rt := map[string]bool{"gc": true}
+	for {
+		var pkgs []string
+		for _, pkg := range runtimePackages {
+			if rt[pkg] {
+				pkgs = append(pkgs, pkg)
+			}
+		}
+		m := NewWasmModuleLinker()
+		if err := addSources(m, token.NewFileSet(), args, pkgs); err != nil {
+			return nil, err
+		}
+		err := m.finalize()
+		if err == nil || runtimeRoot == "" || strings.HasSuffix(args[0], ".go") {
+			return m, err
+		}
+		added := false
+		for _, pkg := range m.missingRuntime() {
+			added = added || !rt[pkg]
+			rt[pkg] = true
+		}
+		if !added {
+			return m, err
+		}
+	}
+}
+
+// addSources adds the packages named on the command line to the module. The
+// arguments are either Go source files, where the files in each directory form
+// a package, or package patterns, e.g., ./mypkg or example.com/mod/pkg. The
+// runtime packages rt are added to the packages.
+func addSources(m WasmModuleLinker, fset *token.FileSet, args []string, rt []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no packages or source files to compile")
+	}
+	if strings.HasSuffix(args[0], ".go") {
+		return addSourceFiles(m, fset, args)
+	}
+	patterns := args
+	if runtimeRoot != "" {
+		for _, pkg := range rt {
+			patterns = append(patterns, runtimePath(pkg))
+		}
+	}
+	pkgs, err := listPackages(patterns)
+	if err != nil {
+		return err
+	}
+	var errs GoWasmErrorList
+	for _, p := range pkgs {
+		if p.Standard || hostPackages[p.Name] {
+			continue
+		}
+		files := make([]*ast.File, 0, len(p.GoFiles))
+		for _, name := range p.GoFiles {
+			f, err := Compile(filepath.Join(p.Dir, name), fset)
+			errs.add(err)
+			files = append(files, f)
+		}
+		if len(errs) == 0 {
+			errs.add(m.addPackage(p.ImportPath, files, fset))
+		}
+	}
+	return errs.err()
+}
+
+func addSourceFiles(m WasmModuleLinker, fset *token.FileSet, fileNames []string) error {
+	var dirs []string
+	var errs GoWasmErrorList
+	packages := make(map[string][]*ast.File)
+	for _, fileName := range fileNames {
+		if !strings.HasSuffix(fileName, ".go") {
+			return fmt.Errorf("can't mix source files and packages: %s", fileName)
+		}
+		dir := filepath.Dir(fileName)
+		if _, ok := packages[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		f, err := Compile(fileName, fset)
+		errs.add(err)
+		packages[dir] = append(packages[dir], f)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	for _, dir := range dirs {
+		errs.add(m.addPackage(packagePath(dir), packages[dir], fset))
+	}
+	return errs.err()
+}
+
+// packagePath derives the import path of a package from its directory in a
+// GOPATH workspace.
+func packagePath(dir string) string {
+	path := filepath.ToSlash(dir)
+	// TODO: support other path patterns.
+	if strings.HasPrefix(path, "src/") {
+		path = path[4:]
+	}
+	return path
+}
+
+// listPackages returns the packages matching patterns and all their
+// dependencies, which come before the packages that import them. The go
+// command resolves the patterns the same way as 'go build' does, so it works
+// both in module mode and in a GOPATH workspace.
+func listPackages(patterns []string) ([]*listedPackage, error) {
+	args := append([]string{"list", "-e", "-json", "-deps", "--"}, patterns...)
+	cmd := exec.Command("go", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list failed: %v\n%s", err, stderr.String())
+	}
+	var pkgs []*listedPackage
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		p := &listedPackage{}
+		if err := dec.Decode(p); err != nil {
+			return nil, fmt.Errorf("couldn't decode the output of go list: %w", err)
+		}
+		if p.Error != nil {
+			return nil, fmt.Errorf("error loading package %s: %s", p.ImportPath, p.Error.Err)
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+)
+
+// The operations on maps are implemented by the runtime package rt/hashmap.
+// Keys are passed to it as i32 values, or as i64 values to the functions for
+// 8-byte keys, see runtimeFunc. It returns the addresses of the values,
+// which are loaded and stored by the generated code, so the values can have
+// any type that can be loaded.
+
+func isMap(t types.Type) bool {
+	if t == nil {
+		return false
+	}
+	_, ok := t.Underlying().(*types.Map)
+	return ok
+}
+
+// mapType returns the type of a map-valued expression. It reports an error if
+// the runtime doesn't support its keys or values.
+func (s *WasmScope) mapType(expr ast.Expr) (*WasmTypeMap, error) {
+	ty, err := s.f.file.convertType(s.f.file.info.TypeOf(expr))
+	if err != nil {
+		return nil, s.f.file.ErrorNode(expr, "%v", err)
+	}
+	m, ok := ty.(*WasmTypeMap)
+	if !ok {
+		return nil, s.f.file.ErrorNode(expr, "not a map: %s", ty.getName())
+	}
+	if _, ok := m.valueType.(*WasmTypeStruct); ok {
+		return nil, s.f.file.ErrorNode(expr, "unimplemented map value type: %s", m.valueType.getName())
+	}
+	if m.keyType.isFloat() || m.keyType.getSize() > 8 {
+		return nil, s.f.file.ErrorNode(expr, "unimplemented map key type: %s", m.keyType.getName())
+	}
+	switch m.keyType.(type) {
+	case *WasmTypeScalar, *WasmTypePointer:
+		return m, nil
+	}
+	return nil, s.f.file.ErrorNode(expr, "unimplemented map key type: %s", m.keyType.getName())
+}
+
+// runtimeFunc returns the name of the variant of the runtime function name for
+// the keys of the map, e.g., MapAccessWide for 8-byte keys.
+func (t *WasmTypeMap) runtimeFunc(name string) string {
+	if t.keyType.getSize() == 8 {
+		return name + "Wide"
+	}
+	return name
+}
+
+// createMapMake returns a call that creates a map of the type of expr.
+func (s *WasmScope) createMapMake(expr ast.Expr, hint WasmExpression, indent int) (WasmExpression, error) {
+	key := s.f.file.info.TypeOf(expr).Underlying().(*types.Map).Key()
+	strings := "0"
+	if isString(key) {
+		strings = "1"
+	}
+	boolType, err := s.f.module.scalarType("bool")
+	if err != nil {
+		return nil, err
+	}
+	kind, err := s.createLiteral(strings, boolType, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	kind.setComment("string keys")
+	return s.createTypedRuntimeCall("hashmap", "MapMake", []WasmExpression{kind, hint}, expr, indent)
+}
+
+func (s *WasmScope) parseMapMake(call *ast.CallExpr, indent int) (WasmExpression, error) {
+	if _, err := s.mapType(call); err != nil {
+		return nil, err
+	}
+	var hint WasmExpression
+	var err error
+	if len(call.Args) > 1 {
+		hint, err = s.parseExpr(call.Args[1], indent+1)
+	} else {
+		hint, err = s.createLiteralInt32(0, indent+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.createMapMake(call, hint, indent)
+}
+
+// parseMapLit handles a map literal as a chain of calls that add one entry
+// each, i.e., MapPut(MapPut(MapMake(), k1, v1), k2, v2).
+func (s *WasmScope) parseMapLit(expr *ast.CompositeLit, indent int) (WasmExpression, error) {
+	ty, err := s.mapType(expr)
+	if err != nil {
+		return nil, err
+	}
+	if ty.valueType.isFloat() || ty.valueType.getSize() > 4 {
+		return nil, s.f.file.ErrorNode(expr, "unimplemented map literal with values of type %s", ty.valueType.getName())
+	}
+	n := len(expr.Elts)
+	hint, err := s.createLiteralInt32(int32(n), indent+n+1)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.createMapMake(expr, hint, indent+n)
+	if err != nil {
+		return nil, err
+	}
+	for i, elt := range expr.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return nil, s.f.file.ErrorNode(elt, "missing key in map literal")
+		}
+		key, err := s.parseExpr(kv.Key, indent+n-i)
+		if err != nil {
+			return nil, err
+		}
+		value, err := s.parseExprAs(kv.Value, s.f.file.info.TypeOf(expr).Underlying().(*types.Map).Elem(), indent+n-i)
+		if err != nil {
+			return nil, err
+		}
+		args := []WasmExpression{key, value}
+		result, err = s.createTypedRuntimeCall("hashmap", ty.runtimeFunc("MapPut"), append([]WasmExpression{result}, args...), expr, indent+n-i-1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (s *WasmScope) parseMapLen(call *ast.CallExpr, indent int) (WasmExpression, error) {
+	args, err := s.parseArgs(call.Args, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createRuntimeCall("hashmap", "MapLen", args, call, indent)
+}
+
+func (s *WasmScope) parseMapDelete(call *ast.CallExpr, indent int) (WasmExpression, error) {
+	ty, err := s.mapType(call.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	args, err := s.parseArgs(call.Args, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createRuntimeCall("hashmap", ty.runtimeFunc("MapDelete"), args, call, indent)
+}
+
+// parseMapIndex handles m[k] other than on the left side of an assignment.
+func (s *WasmScope) parseMapIndex(expr *ast.IndexExpr, indent int) (WasmExpression, error) {
+	ty, err := s.mapType(expr.X)
+	if err != nil {
+		return nil, err
+	}
+	args, err := s.parseArgs([]ast.Expr{expr.X, expr.Index}, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := s.createRuntimeCall("hashmap", ty.runtimeFunc("MapAccess"), args, nil, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	l, err := s.createLoad(addr, ty.valueType, indent)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(expr, "%v", err)
+	}
+	l.setScope(s)
+	l.setNode(expr)
+	return l, nil
+}
+
+// parseMapIndexOk handles v, ok := m[k]. The returned call produces the
+// address of the value and ok. The value has to be loaded from the address.
+func (s *WasmScope) parseMapIndexOk(expr *ast.IndexExpr, indent int) (WasmExpression, WasmType, error) {
+	ty, err := s.mapType(expr.X)
+	if err != nil {
+		return nil, nil, err
+	}
+	args, err := s.parseArgs([]ast.Expr{expr.X, expr.Index}, indent+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	call, err := s.createRuntimeCall("hashmap", ty.runtimeFunc("MapAccess2"), args, expr, indent)
+	if err != nil {
+		return nil, nil, err
+	}
+	return call, ty.valueType, nil
+}
+
+// createMapAssignLValue returns the location of m[k] for an assignment. The
+// key is added to the map if it isn't there.
+func (s *WasmScope) createMapAssignLValue(index, x WasmExpression, ty *WasmTypeMap, node ast.Node, indent int) (*LValue, error) {
+	addr, err := s.createRuntimeCall("hashmap", ty.runtimeFunc("MapAssign"), []WasmExpression{x, index}, node, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	l := &LValue{
+		addr: addr,
+		t:    ty.valueType,
+	}
+	return l, nil
+}
+
+// parseMapRangeStmt lowers a range loop over a map onto a loop over the
+// entries, which the runtime returns in insertion order.
+func (s *WasmScope) parseMapRangeStmt(stmt *ast.RangeStmt, indent int) (WasmExpression, error) {
+	ty, err := s.mapType(stmt.X)
+	if err != nil {
+		return nil, err
+	}
+	outerScope := s.f.createScope("loop_block")
+	scope := s.f.createScope("loop")
+
+	x, err := outerScope.parseExpr(stmt.X, indent+3)
+	if err != nil {
+		return nil, fmt.Errorf("error in the range expression of a loop: %w", err)
+	}
+	first, err := outerScope.createRuntimeCall("hashmap", "MapIter", []WasmExpression{x}, stmt.X, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	ptrType, err := s.f.module.scalarType("uintptr")
+	if err != nil {
+		return nil, err
+	}
+	iter, err := outerScope.createTempVar("range_iter", ptrType)
+	if err != nil {
+		return nil, err
+	}
+	set, err := outerScope.createSetVar(iter, first, stmt, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	outerScope.expressions = append(outerScope.expressions, set)
+
+	exitCond, err := scope.createNegation(scope.createGetLocal(iter, stmt, indent+4), indent+3)
+	if err != nil {
+		return nil, err
+	}
+	scope.appendLoopExit(exitCond, indent+2)
+
+	if key := stmt.Key; key != nil && !isBlankIdent(key) {
+		k, err := scope.createRuntimeCall("hashmap", ty.runtimeFunc("MapIterKey"), []WasmExpression{scope.createGetLocal(iter, stmt, indent+4)}, nil, indent+3)
+		if err != nil {
+			return nil, err
+		}
+		k.setFullType(ty.keyType)
+		set, err := scope.createRangeAssign(key, k, stmt, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		scope.expressions = append(scope.expressions, set)
+	}
+	if value := stmt.Value; value != nil && !isBlankIdent(value) {
+		addr, err := scope.createRuntimeCall("hashmap", "MapIterValue", []WasmExpression{scope.createGetLocal(iter, stmt, indent+5)}, nil, indent+4)
+		if err != nil {
+			return nil, err
+		}
+		v, err := scope.createLoad(addr, ty.valueType, indent+3)
+		if err != nil {
+			return nil, s.f.file.ErrorNode(stmt.Value, "%v", err)
+		}
+		v.setComment("range value")
+		set, err := scope.createRangeAssign(value, v, stmt, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		scope.expressions = append(scope.expressions, set)
+	}
+
+	err = scope.parseLoopBody(stmt.Body, indent+2)
+	if err != nil {
+		return nil, fmt.Errorf("error in the body of a loop: %w", err)
+	}
+
+	next, err := scope.createRuntimeCall("hashmap", "MapIterNext", []WasmExpression{scope.createGetLocal(iter, stmt, indent+4)}, nil, indent+3)
+	if err != nil {
+		return nil, err
+	}
+	post, err := scope.createSetVar(iter, next, stmt, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	scope.expressions = append(scope.expressions, post)
+
+	return s.createLoop(outerScope, scope, stmt, indent), nil
+}
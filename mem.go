@@ -4,31 +4,59 @@ import (
 	"fmt"
 )
 
+// The linear memory is exported so that the embedder can access it, e.g., to
+// implement imported functions that take pointers.
+const memoryExportName = "memory"
+
+const wasmPageSize = 64 * 1024
+
+// The static memory is at the start of the linear memory and the heap follows
+// it, see rt/gc. The memory is at least initialPages large and can grow up to
+// maxPages, if it isn't 0.
 type WasmMemory struct {
-	size           int
+	initialPages   int
+	maxPages       int
 	nextStaticAddr int
 	content        []byte
 }
 
-func createMemory(size int) *WasmMemory {
+func createMemory(initialPages, maxPages int) *WasmMemory {
 	memory := &WasmMemory{
-		size:           size,
+		initialPages:   initialPages,
+		maxPages:       maxPages,
 		nextStaticAddr: 4,
-		content:        make([]byte, 0, size),
+		// The word at address 0, to which nil points, is an invalid function
+		// table index, see createFuncIndex.
+		content: []byte{0xff, 0xff, 0xff, 0xff},
 	}
 	return memory
 }
 
+// setPages sets the sizes of the memory from a pragma, e.g.,
+// //wasm:memory 4 16. The memory is large enough for all packages, and the
+// command line takes precedence over the maximum.
+func (memory *WasmMemory) setPages(args string) error {
+	var initial, max int
+	n, _ := fmt.Sscan(args, &initial, &max)
+	if n == 0 || initial <= 0 || max < 0 || (max != 0 && max < initial) {
+		return fmt.Errorf("invalid memory pragma, want initial and optional maximum pages: %s", args)
+	}
+	if initial > memory.initialPages {
+		memory.initialPages = initial
+	}
+	if max > 0 && maxMemoryPages == 0 && (memory.maxPages == 0 || max > memory.maxPages) {
+		memory.maxPages = max
+	}
+	return nil
+}
+
 func (memory *WasmMemory) allocGlobal(size, align int) int {
 	addr := memory.nextStaticAddr + (align - 1)
 	mask := ^(align - 1)
 	addr = addr & mask
 	nextAddr := addr + size
-	if nextAddr > cap(memory.content) {
-		panic(fmt.Sprintf("Out of static memory: %d", nextAddr))
-	}
 	if nextAddr > len(memory.content) {
-		memory.content = memory.content[:nextAddr]
+		memory.content = append(memory.content, make([]byte, nextAddr-len(memory.content))...)
 	}
 	memory.nextStaticAddr = nextAddr
 	return addr
@@ -42,19 +70,53 @@ func (memory *WasmMemory) writeInt32(addr int, val int32) {
 	}
 }
 
+func (memory *WasmMemory) readInt32(addr int) int32 {
+	var val int32
+	for i := 3; i >= 0; i-- {
+		val = val<<8 | int32(memory.content[addr+i])
+	}
+	return val
+}
+
+// writeUint writes the size low-order bytes of val in little-endian order.
+func (memory *WasmMemory) writeUint(addr, size int, val uint64) {
+	for i := 0; i < size; i++ {
+		memory.content[addr+i] = byte(val)
+		val >>= 8
+	}
+}
+
 func (memory *WasmMemory) writeBytes(addr int, bytes []byte) {
 	for i, b := range bytes {
 		memory.content[addr+i] = b
 	}
 }
 
-func (memory *WasmMemory) print(writer FormattingWriter) {
-	indent := 1
-	writer.Printf("\n")
-	writer.PrintfIndent(indent, "(memory %d\n", memory.size)
+// heapPages is the size of the heap in the smallest memory, after the static
+// memory. The heap grows when it is full.
+const heapPages = 1
 
-	// Static memory segment
-	writer.PrintfIndent(indent+1, "(segment 0 \"")
+// pages returns the initial size of the memory.
+func (memory *WasmMemory) pages() int {
+	pages := (len(memory.content)+wasmPageSize-1)/wasmPageSize + heapPages
+	if pages < memory.initialPages {
+		pages = memory.initialPages
+	}
+	return pages
+}
+
+// checkPages returns an error if the initial memory exceeds the maximum.
+func (memory *WasmMemory) checkPages() error {
+	if memory.maxPages == 0 || memory.pages() <= memory.maxPages {
+		return nil
+	}
+	if memory.pages() > memory.initialPages {
+		return fmt.Errorf("the memory needs %d pages for %d bytes of static data, more than the maximum of %d", memory.pages(), len(memory.content), memory.maxPages)
+	}
+	return fmt.Errorf("the initial memory of %d pages is larger than the maximum of %d", memory.initialPages, memory.maxPages)
+}
+
+func (memory *WasmMemory) printContent(writer FormattingWriter) {
 	for _, b := range memory.content {
 		switch {
 		default:
@@ -65,6 +127,34 @@ func (memory *WasmMemory) print(writer FormattingWriter) {
 			writer.Printf("%c", b)
 		}
 	}
+}
+
+// In the standard syntax:
+// memory:  ( memory <pages> )
+// data:    ( data ( i32.const <offset> ) "<bytes>" )
+func (memory *WasmMemory) print(writer FormattingWriter) {
+	indent := 1
+	writer.Printf("\n")
+	if !legacySyntax {
+		if memory.maxPages != 0 {
+			writer.PrintfIndent(indent, "(memory %d %d)\n", memory.pages(), memory.maxPages)
+		} else {
+			writer.PrintfIndent(indent, "(memory %d)\n", memory.pages())
+		}
+		writer.PrintfIndent(indent, "(data (i32.const 0) \"")
+		memory.printContent(writer)
+		writer.Printf("\") ;; static memory\n")
+		return
+	}
+	if memory.maxPages != 0 {
+		writer.PrintfIndent(indent, "(memory %d %d\n", memory.pages()*wasmPageSize, memory.maxPages*wasmPageSize)
+	} else {
+		writer.PrintfIndent(indent, "(memory %d\n", memory.pages()*wasmPageSize)
+	}
+
+	// Static memory segment
+	writer.PrintfIndent(indent+1, "(segment 0 \"")
+	memory.printContent(writer)
 	writer.Printf("\") ;; static memory\n")
 
 	// Heap segment
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+)
+
+// A method is compiled as a function that takes the receiver as its first
+// parameter. Its name includes the name of the receiver type, e.g.,
+// $pkg/Point.Sum. A struct receiver is passed by address and the method makes
+// its own copy on entry.
+
+// receiverTypeName returns the name of the named type a method is declared on.
+func receiverTypeName(recv *types.Var) string {
+	t := recv.Type()
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	if n, ok := t.(*types.Named); ok {
+		return n.Obj().Name()
+	}
+	return t.String()
+}
+
+// parseReceiver adds the receiver of a method as the first parameter.
+func (f *WasmFunc) parseReceiver(field *ast.Field) error {
+	recvType, err := f.file.parseAstType(field.Type)
+	if err != nil {
+		return fmt.Errorf("error in a receiver type: %w", err)
+	}
+	if _, ok := recvType.(*WasmTypeStruct); ok {
+		f.copyRecv = true
+		recvType, err = f.file.createPointerType(recvType)
+		if err != nil {
+			return err
+		}
+	}
+	p := &WasmParam{
+		astType:  field.Type,
+		t:        recvType,
+		fullType: recvType,
+	}
+	if len(field.Names) == 1 && field.Names[0].Name != "_" {
+		name := field.Names[0]
+		p.astIdent = name
+		p.name = astNameToWASM(name.Name, nil)
+		f.module.variables[f.file.objectOf(name)] = p
+	}
+	f.params = append(f.params, p)
+	return nil
+}
+
+// methodFunc returns the function of the method selected by se, which may be
+// promoted from an embedded field.
+func (s *WasmScope) methodFunc(se *ast.SelectorExpr, sel *types.Selection) (*WasmFunc, error) {
+	fn, ok := s.f.module.functionMap2[sel.Obj()]
+	if !ok {
+		return nil, s.f.file.ErrorNode(se, "unimplemented method: %s", se.Sel.Name)
+	}
+	return fn, nil
+}
+
+// parseReceiverArg returns the receiver argument of a call of the method fn.
+// The address of x is taken or x is dereferenced if its type doesn't match
+// what the method takes.
+func (s *WasmScope) parseReceiverArg(x ast.Expr, fn *WasmFunc, indent int) (WasmExpression, error) {
+	sig := fn.file.objectOf(fn.funcDecl.Name).Type().(*types.Signature)
+	_, ptrRecv := sig.Recv().Type().(*types.Pointer)
+	_, ptrX := s.f.file.info.TypeOf(x).Underlying().(*types.Pointer)
+	byAddr := ptrRecv || fn.copyRecv
+	switch {
+	case byAddr == ptrX:
+		return s.parseExpr(x, indent)
+	case byAddr:
+		return s.parseValueAddress(x, indent)
+	}
+	p, err := s.parseExpr(x, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	l, err := s.createLoad(p, fn.params[0].t, indent)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(x, "%v", err)
+	}
+	l.setScope(s)
+	l.setNode(x)
+	return l, nil
+}
+
+// parsePromotedReceiverArg returns the receiver argument of a call of the
+// method fn of the embedded field of x at path.
+func (s *WasmScope) parsePromotedReceiverArg(x ast.Expr, path []int, fn *WasmFunc, indent int) (WasmExpression, error) {
+	v, err := s.parseExpr(x, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	recv, err := s.createPromotedReceiver(v, path, fn, indent)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(x, "%v", err)
+	}
+	return recv, nil
+}
+
+// createPromotedReceiver returns the receiver argument of the method fn of
+// the embedded field at path of the struct v.
+func (s *WasmScope) createPromotedReceiver(v WasmExpression, path []int, fn *WasmFunc, indent int) (WasmExpression, error) {
+	lvalue, err := s.parseFieldPath(v, path, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	sig := fn.file.objectOf(fn.funcDecl.Name).Type().(*types.Signature)
+	_, ptrRecv := sig.Recv().Type().(*types.Pointer)
+	byAddr := ptrRecv || fn.copyRecv
+	ptr, ptrField := lvalue.t.(*WasmTypePointer)
+	if byAddr && !ptrField {
+		return lvalue.addr, nil
+	}
+	field, err := s.createLoad(lvalue.addr, lvalue.t, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	if byAddr || !ptrField {
+		return field, nil
+	}
+	return s.createLoad(field, ptr.base, indent)
+}
+
+// parseMethodCall handles x.M(args) and the method expression T.M(x, args).
+func (s *WasmScope) parseMethodCall(call *ast.CallExpr, se *ast.SelectorExpr, sel *types.Selection, indent int) (WasmExpression, error) {
+	if types.IsInterface(sel.Recv()) {
+		return s.parseInterfaceMethodCall(call, se, sel, indent)
+	}
+	fn, err := s.methodFunc(se, sel)
+	if err != nil {
+		return nil, err
+	}
+	x, args := se.X, call.Args
+	if sel.Kind() == types.MethodExpr {
+		x, args = call.Args[0], call.Args[1:]
+	}
+	var recv WasmExpression
+	if path := sel.Index(); len(path) > 1 {
+		recv, err = s.parsePromotedReceiverArg(x, path[:len(path)-1], fn, indent+1)
+	} else {
+		recv, err = s.parseReceiverArg(x, fn, indent+1)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error in the receiver of method %s: %w", fn.origName, err)
+	}
+	rest, err := s.parseCallArgs(args, sel.Obj().Type().(*types.Signature), indent+1)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing args to method %s: %w", fn.origName, err)
+	}
+	return s.createCallExprWithArgs(call, fn.name, fn, append([]WasmExpression{recv}, rest...), indent)
+}
+
+// parseMethodExpr handles a method expression, e.g., (*T).M, which is a
+// function that takes the receiver as its first argument.
+func (s *WasmScope) parseMethodExpr(expr *ast.SelectorExpr, sel *types.Selection, indent int) (WasmExpression, error) {
+	if len(sel.Index()) > 1 {
+		return nil, s.f.file.ErrorNode(expr, "unimplemented promoted method: %s", expr.Sel.Name)
+	}
+	if sel.Kind() == types.MethodVal {
+		return s.parseMethodValue(expr, sel, indent)
+	}
+	fn, err := s.methodFunc(expr, sel)
+	if err != nil {
+		return nil, err
+	}
+	return s.parseFuncIdent(expr.Sel, fn, indent)
+}
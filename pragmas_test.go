@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPragmas compiles each package in tests and rt and runs its
+// assert_return, assert_trap and invoke pragmas in the interpreter.
+func TestPragmas(t *testing.T) {
+	// The default of -rt, the flags aren't parsed.
+	runtimeRoot = "gowasm/rt"
+	var dirs []string
+	for _, pattern := range []string{"tests/*/*.go", "rt/*/*.go"} {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files {
+			if dir := filepath.Dir(f); len(dirs) == 0 || dirs[len(dirs)-1] != dir {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	for _, dir := range dirs {
+		t.Run(dir, func(t *testing.T) {
+			m, err := link([]string{"./" + dir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = m.runPragmas(func(kind, pragma string, err error) {
+				if err != nil {
+					t.Errorf("%s %s: %v", kind, pragma, err)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestMVPTarget checks that the conversions behave the same with only the
+// instructions of the MVP, as in the legacy syntax.
+func TestMVPTarget(t *testing.T) {
+	runtimeRoot = "gowasm/rt"
+	mvpTarget = true
+	defer func() { mvpTarget = false }()
+	m, err := link([]string{"./tests/conv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &FormattingWriterImpl{}
+	m.print(w)
+	for _, op := range []string{"trunc_sat", "extend8_s", "extend16_s"} {
+		if strings.Contains(w.b.String(), op) {
+			t.Errorf("unexpected %s instruction", op)
+		}
+	}
+	err = m.runPragmas(func(kind, pragma string, err error) {
+		if err != nil {
+			t.Errorf("%s %s: %v", kind, pragma, err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
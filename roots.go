@@ -0,0 +1,551 @@
+package main
+
+// Roots and layouts for the garbage collector in rt/gc.
+//
+// The collector finds the objects in use from the global variables, whose
+// words that may hold pointers are listed in a table in the static memory,
+// and from a shadow stack in the linear memory, where functions keep copies
+// of their locals that may hold pointers. Objects allocated for values of a
+// known type have a layout with the offsets of the pointers in them. Integers
+// aren't pointers, small integers would often look like addresses in the heap,
+// so the runtime keeps addresses in uintptr values.
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The layouts of objects without a descriptor, see rt/gc.
+const (
+	layoutScanAll    = 0
+	layoutNoPointers = 1
+)
+
+// mayHoldPointer returns whether a value of type t is a word that may hold
+// the address of an object in the heap.
+func mayHoldPointer(t WasmType) bool {
+	if t == nil || wasmTypeName(t) != "i32" {
+		return false
+	}
+	if t, ok := t.(*WasmTypeScalar); ok {
+		switch t.dbgName {
+		case "uintptr", "unsafe.Pointer", "string":
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// holdsPointer returns whether a value of type t may hold a pointer. The full
+// type is used if known, because it is more precise: e.g., gc.Alloc returns an
+// int32 that the caller converts to a pointer.
+func holdsPointer(t, fullType WasmType) bool {
+	if fullType != nil {
+		return mayHoldPointer(fullType)
+	}
+	return mayHoldPointer(t)
+}
+
+// pointerOffsets appends the offsets of the words that may hold pointers in a
+// value of type t at offset.
+func pointerOffsets(t WasmType, offset int, offsets []int) []int {
+	if t, ok := t.(*WasmTypeStruct); ok {
+		for _, field := range t.fields {
+			offsets = pointerOffsets(field.t, offset+field.offset, offsets)
+		}
+		return offsets
+	}
+	if t, ok := t.(*WasmTypeArray); ok && t.inline {
+		size := t.elementType.getSize()
+		for i := 0; i < int(t.length); i++ {
+			offsets = pointerOffsets(t.elementType, offset+i*size, offsets)
+		}
+		return offsets
+	}
+	if !mayHoldPointer(t) {
+		return offsets
+	}
+	return append(offsets, offset)
+}
+
+// layout returns the layout of an object of size bytes allocated for a value
+// of type ty, an array or a pointer. The descriptors are shared by the types
+// with the same layout.
+func (m *WasmModule) layout(ty WasmType, size int32) int32 {
+	var elem WasmType
+	switch ty := ty.(type) {
+	default:
+		return layoutScanAll
+	case *WasmTypeArray:
+		elem = ty.elementType
+	case *WasmTypePointer:
+		elem = ty.base
+	}
+	elemSize := int32(elem.getSize())
+	if elemSize == 0 || size%elemSize != 0 {
+		// E.g., the cell of a captured struct variable holds its address.
+		return layoutScanAll
+	}
+	return m.elemLayout(elem)
+}
+
+// elemLayout returns the layout of an array of elements of type elem, e.g.,
+// of the elements of a slice.
+func (m *WasmModule) elemLayout(elem WasmType) int32 {
+	elemSize := int32(elem.getSize())
+	if elemSize == 0 {
+		return layoutScanAll
+	}
+	offsets := pointerOffsets(elem, 0, nil)
+	if len(offsets) == 0 {
+		return layoutNoPointers
+	}
+	key := fmt.Sprint(elemSize, offsets)
+	if addr, ok := m.layouts[key]; ok {
+		return addr
+	}
+	addr := m.memory.allocGlobal(4*(2+len(offsets)), 4)
+	m.memory.writeInt32(addr, elemSize)
+	m.memory.writeInt32(addr+4, int32(len(offsets)))
+	for i, offset := range offsets {
+		m.memory.writeInt32(addr+8+4*i, int32(offset))
+	}
+	m.layouts[key] = int32(addr)
+	return int32(addr)
+}
+
+// globalRoots writes the table of the words of global variables that may hold
+// pointers into the static memory and returns its address. The variables of
+// rt/gc itself aren't roots.
+func (m *WasmModule) globalRoots() int32 {
+	var globals []*WasmGlobalVar
+	for obj, v := range m.variables {
+		g, ok := v.(*WasmGlobalVar)
+		if !ok || (obj.Pkg() != nil && obj.Pkg().Path() == runtimePath("gc")) {
+			continue
+		}
+		globals = append(globals, g)
+	}
+	sort.Slice(globals, func(i, j int) bool {
+		return globals[i].addr < globals[j].addr
+	})
+	var roots []int
+	for _, g := range globals {
+		roots = m.appendRoots(roots, int(g.addr), g.t)
+	}
+	addr := m.memory.allocGlobal(4*(1+len(roots)), 4)
+	m.memory.writeInt32(addr, int32(len(roots)))
+	for i, root := range roots {
+		m.memory.writeInt32(addr+4+4*i, int32(root))
+	}
+	return int32(addr)
+}
+
+// appendRoots appends the addresses of the words that may hold pointers in a
+// global value of type t at addr. The elements of global arrays are in the
+// static memory, unless the array is assigned by the start function.
+func (m *WasmModule) appendRoots(roots []int, addr int, t WasmType) []int {
+	switch t := t.(type) {
+	case *WasmTypeStruct:
+		for _, field := range t.fields {
+			roots = m.appendRoots(roots, addr+field.offset, field.t)
+		}
+		return roots
+	case *WasmTypeArray:
+		size := t.elementType.getSize()
+		if t.inline {
+			for i := 0; i < int(t.length); i++ {
+				roots = m.appendRoots(roots, addr+i*size, t.elementType)
+			}
+			return roots
+		}
+		elems := int(m.memory.readInt32(addr))
+		if elems != 0 && elems+int(t.length)*size <= len(m.memory.content) {
+			for i := 0; i < int(t.length); i++ {
+				roots = m.appendRoots(roots, elems+i*size, t.elementType)
+			}
+		}
+	}
+	if !mayHoldPointer(t) {
+		return roots
+	}
+	return append(roots, addr)
+}
+
+// shadowFrame keeps copies of the locals of a function that may hold pointers
+// in a frame on the shadow stack:
+//
+//	(local.set $frame (call Enter (i32.const <slots>)))
+//	<store the parameters in their slots>
+//	<body>
+//	(call Leave (local.get $frame))
+//
+// Each local.set and local.tee of such a local in the body also stores it in
+// its slot, and each return calls Leave before it returns. Values on the
+// operand stack while a call may collect garbage are spilled to locals with
+// slots. Functions without such locals don't need a frame.
+type shadowFrame struct {
+	f       *WasmFunc
+	frame   WasmVariable
+	slots   map[WasmVariable]int
+	returns []*WasmReturn
+	ptrType WasmType
+}
+
+// addShadowFrames adds shadow frames to all functions except those of rt/gc,
+// which don't hold pointers to objects while they allocate. It runs after the
+// bodies are complete, e.g., after makeResumable.
+func (m *WasmModule) addShadowFrames() error {
+	ptrType, err := m.scalarType("uintptr")
+	if err != nil {
+		return err
+	}
+	for _, f := range m.functions {
+		if f != m.start && f.file.pkgName == runtimePath("gc") {
+			continue
+		}
+		sf := &shadowFrame{
+			f:       f,
+			slots:   make(map[WasmVariable]int),
+			ptrType: ptrType,
+		}
+		if err := sf.instrument(); err != nil {
+			return fmt.Errorf("%s: %w", f.origName, err)
+		}
+	}
+	return nil
+}
+
+// addHostEntries adds a function for each exported function, which the host
+// calls instead. It resets the shadow stack before it calls the exported
+// function, since a call that trapped left its frames on it. The module is
+// entered only by the host, whose calls don't nest.
+func (m *WasmModule) addHostEntries() error {
+	reset, ok := m.funcSymTab[mangleFunctionName(runtimePath("gc"), "Reset")]
+	if !ok {
+		// Without rt/gc, there is no shadow stack.
+		return nil
+	}
+	// The deferred calls and the panic of a call that trapped are dropped too.
+	resetPanics := m.funcSymTab[mangleFunctionName(runtimePath("panics"), "DeferReset")]
+	for _, fn := range m.functions {
+		if !fn.isExported() {
+			continue
+		}
+		f := &WasmFunc{
+			fset:       fn.fset,
+			module:     m,
+			file:       fn.file,
+			indent:     fn.indent,
+			name:       fn.name + "$entry",
+			origName:   fn.origName + "$entry",
+			namePos:    fn.namePos,
+			signature:  fn.signature,
+			results:    fn.results,
+			gotoLabels: make(map[string]string),
+		}
+		for i, p := range fn.params {
+			f.params = append(f.params, &WasmParam{
+				name:     fmt.Sprintf("$p%d", i),
+				t:        p.t,
+				fullType: p.fullType,
+			})
+		}
+		f.scope = f.createScope(fmt.Sprintf("function_%s", f.origName))
+		s := f.scope
+		call, err := s.createCallExprWithArgs(nil, reset.name, reset, nil, f.indent+1)
+		if err != nil {
+			return err
+		}
+		call.setNode(nil)
+		s.expressions = append(s.expressions, call)
+		if resetPanics != nil {
+			call, err := s.createCallExprWithArgs(nil, resetPanics.name, resetPanics, nil, f.indent+1)
+			if err != nil {
+				return err
+			}
+			call.setNode(nil)
+			s.expressions = append(s.expressions, call)
+		}
+		var args []WasmExpression
+		for _, p := range f.params {
+			args = append(args, s.createGetLocal(p, nil, f.indent+3))
+		}
+		call, err = s.createCallExprWithArgs(nil, fn.name, fn, args, f.indent+2)
+		if err != nil {
+			return err
+		}
+		call.setNode(nil)
+		if len(fn.results) == 0 {
+			call.setIndent(f.indent + 1)
+			s.expressions = append(s.expressions, call)
+		} else {
+			r := &WasmReturn{
+				values: []WasmExpression{call},
+			}
+			r.setIndent(f.indent + 1)
+			r.setScope(s)
+			s.expressions = append(s.expressions, r)
+		}
+		fn.entry = f
+		m.functions = append(m.functions, f)
+		m.funcSymTab[f.name] = f
+	}
+	return nil
+}
+
+func (sf *shadowFrame) instrument() error {
+	f := sf.f
+	s := f.scope
+	indent := f.indent + 1
+	var params []WasmExpression
+	for _, p := range f.params {
+		if p.name == "" || !holdsPointer(p.t, p.fullType) {
+			continue
+		}
+		store, err := sf.rootStore(p, indent)
+		if err != nil {
+			return err
+		}
+		params = append(params, store)
+	}
+	for _, e := range s.expressions {
+		if err := sf.visit(e); err != nil {
+			return err
+		}
+	}
+	if sf.frame == nil {
+		return nil
+	}
+	n, err := s.createLiteralInt32(int32(len(sf.slots)), indent+2)
+	if err != nil {
+		return err
+	}
+	enter, err := s.createRuntimeCall("gc", "Enter", []WasmExpression{n}, nil, indent+1)
+	if err != nil {
+		return err
+	}
+	set, err := s.createSetVar(sf.frame, enter, nil, indent)
+	if err != nil {
+		return err
+	}
+	s.expressions = append(append([]WasmExpression{set}, params...), s.expressions...)
+	for _, r := range sf.returns {
+		r.leave, err = sf.leave(r.getIndent() + 1)
+		if err != nil {
+			return err
+		}
+	}
+	if len(f.results) == 0 {
+		leave, err := sf.leave(indent)
+		if err != nil {
+			return err
+		}
+		s.expressions = append(s.expressions, leave)
+	}
+	return nil
+}
+
+func (sf *shadowFrame) leave(indent int) (WasmExpression, error) {
+	s := sf.f.scope
+	frame := s.createGetLocal(sf.frame, nil, indent+1)
+	return s.createRuntimeCall("gc", "Leave", []WasmExpression{frame}, nil, indent)
+}
+
+// rootStore returns the store of v in its slot. The frame is created for the
+// first slot.
+func (sf *shadowFrame) rootStore(v WasmVariable, indent int) (WasmExpression, error) {
+	s := sf.f.scope
+	if sf.frame == nil {
+		frame, err := s.createTempVar("shadow", sf.ptrType)
+		if err != nil {
+			return nil, err
+		}
+		sf.frame = frame
+	}
+	slot, ok := sf.slots[v]
+	if !ok {
+		slot = len(sf.slots)
+		sf.slots[v] = slot
+	}
+	offset, err := s.createLiteral(fmt.Sprintf("%d", 4*slot), sf.ptrType, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := s.createBinaryExpr(s.createGetLocal(sf.frame, nil, indent+2), offset, binOpAdd, sf.ptrType, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	store, err := s.createStore(addr, s.createGetLocal(v, nil, indent+1), sf.ptrType, nil, indent)
+	if err != nil {
+		return nil, err
+	}
+	store.setComment(fmt.Sprintf("root %s", v.getName()))
+	return store, nil
+}
+
+// visit instruments e and the expressions in it.
+func (sf *shadowFrame) visit(e WasmExpression) error {
+	ops := operands(e)
+	for _, op := range ops {
+		if *op == nil {
+			continue
+		}
+		if err := sf.visit(*op); err != nil {
+			return err
+		}
+	}
+	var err error
+	switch e := e.(type) {
+	case *WasmCall, *WasmCallIndirect, *WasmCallImport, *WasmBinOp, *WasmSelect, *WasmStore:
+		err = sf.spill(ops)
+	case *WasmReturn:
+		err = sf.spill(ops)
+		sf.returns = append(sf.returns, e)
+	case *WasmSetLocal:
+		if holdsPointer(e.lhs.getType(), e.lhs.getFullType()) {
+			e.root, err = sf.rootStore(e.lhs, e.getIndent())
+		}
+	case *WasmTeeLocal:
+		if holdsPointer(e.lhs.getType(), e.lhs.getFullType()) {
+			e.root, err = sf.rootStore(e.lhs, e.getIndent())
+		}
+	case *WasmTupleSet:
+		for _, v := range e.vars {
+			if !holdsPointer(v.getType(), v.getFullType()) {
+				continue
+			}
+			root, err := sf.rootStore(v, e.getIndent())
+			if err != nil {
+				return err
+			}
+			e.roots = append(e.roots, root)
+		}
+	}
+	return err
+}
+
+// spill saves the operands that may hold pointers in locals with slots if a
+// later operand calls a function, which may collect garbage.
+func (sf *shadowFrame) spill(ops []*WasmExpression) error {
+	s := sf.f.scope
+	for i, op := range ops {
+		e := *op
+		if e == nil || !needsSpill(e) {
+			continue
+		}
+		for _, later := range ops[i+1:] {
+			if *later == nil || !hasCall(*later) {
+				continue
+			}
+			t := e.getType()
+			if _, ok := t.(*WasmTypeStruct); ok {
+				t = sf.ptrType
+			}
+			tmp, err := s.createTempVar("spill", t)
+			if err != nil {
+				return err
+			}
+			tee := s.createTeeLocal(tmp, e, e.getIndent())
+			tee.root, err = sf.rootStore(tmp, e.getIndent())
+			if err != nil {
+				return err
+			}
+			*op = tee
+			break
+		}
+	}
+	return nil
+}
+
+// needsSpill returns whether e computes a single value that may hold a
+// pointer. Constants and locals don't need to be spilled, and a store to a
+// local, e.g., before a call_indirect, computes no value.
+func needsSpill(e WasmExpression) bool {
+	switch e.(type) {
+	case *WasmValue, *WasmGetLocal, *WasmSetLocal:
+		return false
+	}
+	return numValues(e) <= 1 && holdsPointer(e.getType(), e.getFullType())
+}
+
+// hasCall returns whether e calls a function of the module.
+func hasCall(e WasmExpression) bool {
+	switch e.(type) {
+	case *WasmCall, *WasmCallIndirect:
+		return true
+	}
+	for _, op := range operands(e) {
+		if *op != nil && hasCall(*op) {
+			return true
+		}
+	}
+	return false
+}
+
+// operands returns the expressions in e in the order in which they are
+// evaluated. For calls, binary operations, stores and returns, these are the
+// operands, which are on the operand stack together.
+func operands(e WasmExpression) []*WasmExpression {
+	var ops []*WasmExpression
+	list := func(exprs []WasmExpression) {
+		for i := range exprs {
+			ops = append(ops, &exprs[i])
+		}
+	}
+	switch e := e.(type) {
+	case *WasmCall:
+		list(e.args)
+	case *WasmCallIndirect:
+		ops = append(ops, &e.before)
+		list(e.args)
+		ops = append(ops, &e.index)
+	case *WasmCallImport:
+		list(e.args)
+	case *WasmIntrinsic:
+		list(e.args)
+	case *WasmSequence:
+		list(e.stmts)
+		ops = append(ops, &e.value)
+	case *WasmUnwindCheck:
+		var check WasmExpression = e.check
+		ops = append(ops, &e.call, &check)
+	case *WasmSuspendPoint:
+		var check WasmExpression = e.check
+		ops = append(ops, &e.stmt, &check, &e.done)
+	case *WasmBinOp:
+		ops = append(ops, &e.x, &e.y)
+	case *WasmSelect:
+		ops = append(ops, &e.x, &e.y, &e.cond)
+	case *WasmConvert:
+		ops = append(ops, &e.x)
+	case *WasmLoad:
+		ops = append(ops, &e.addr)
+	case *WasmStore:
+		ops = append(ops, &e.addr, &e.val)
+	case *WasmBlock:
+		list(e.scope.expressions)
+	case *WasmLoop:
+		list(e.scope.expressions)
+	case *WasmIf:
+		ops = append(ops, &e.cond, &e.body, &e.bodyElse)
+	case *WasmBreak:
+		ops = append(ops, &e.cond)
+	case *WasmBrTable:
+		ops = append(ops, &e.index)
+	case *WasmSetLocal:
+		ops = append(ops, &e.rhs)
+	case *WasmTeeLocal:
+		ops = append(ops, &e.rhs)
+	case *WasmTupleSet:
+		ops = append(ops, &e.value)
+	case *WasmReturn:
+		list(e.values)
+	case *WasmGetGlobal:
+		ops = append(ops, &e.load)
+	case *WasmSetGlobal:
+		ops = append(ops, &e.store)
+	}
+	return ops
+}
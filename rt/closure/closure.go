@@ -0,0 +1,44 @@
+// Package closure implements function values.
+//
+// A function value is the address of a closure object, or 0 for a nil
+// function. The first word of the object is the function table index of the
+// function. It is followed by the environment: the addresses of the variables
+// that a function literal captures, or the receiver of a method value. The
+// compiler passes the address of the object to the function as its last
+// argument.
+package closure
+
+import (
+	"gowasm/rt/gc"
+	"unsafe"
+)
+
+// ClosureMake returns a closure object for the function with table index fn
+// and an environment of n words.
+func ClosureMake(fn, n int32) uintptr {
+	c := uintptr(gc.Alloc((n+1)*4, 4))
+	p := (*int32)(unsafe.Pointer(c))
+	*p = fn
+	return c
+}
+
+// ClosureSet sets the i-th word of the environment and returns the closure.
+func ClosureSet(c uintptr, i int32, v uintptr) uintptr {
+	p := (*uintptr)(unsafe.Pointer(c + uintptr((i+1)*4)))
+	*p = v
+	return c
+}
+
+// ClosureEnv returns the i-th word of the environment.
+func ClosureEnv(c uintptr, i int32) uintptr {
+	p := (*uintptr)(unsafe.Pointer(c + uintptr((i+1)*4)))
+	return *p
+}
+
+// ClosureCopy returns a copy of the struct at src, which is the receiver of a
+// method value.
+func ClosureCopy(src uintptr, size int32) uintptr {
+	dst := uintptr(gc.Alloc(size, 8))
+	gc.Memcpy(dst, src, int(size))
+	return dst
+}
@@ -1,13 +1,532 @@
+// Package gc implements the heap: allocation and a mark-and-sweep garbage
+// collector.
+//
+// Each block of the heap starts with a header of two words: the size of the
+// block, including the header, with the flags below in its low bits, and the
+// layout of the object that follows. A layout is scanAll, noPointers or the
+// address of a descriptor in the static memory that the compiler generates
+// for the type of the object: the size of an element, the number of words in
+// it that may hold pointers and their offsets. An array repeats the layout of
+// its element. A bitmap records the start of each block, so that a pointer into
+// an object, e.g., to an element of an array, can be mapped to its block.
+//
+// The roots are the global variables listed in the table at globalRoots and
+// the shadow stack, where the compiled functions keep copies of their locals
+// that may hold pointers, see Enter. The shadow stack continues in segments
+// allocated from the heap when it overflows. Free blocks are kept in lists by
+// size. Adjacent free blocks are coalesced when the heap is swept.
+//
+// The bitmap is at the end of the linear memory, after the heap. If there is
+// no free block for an allocation after a collection, the memory grows and
+// the bitmap moves to its new end.
 package gc
 
+import "gowasm/rt/wasm"
 import "unsafe"
 
+// freePointer is the end of the used part of the heap, which grows towards
+// the bitmap. The compiler initializes it to the end of the static memory.
 var freePointer int32
 
+// heapEnd is the end of the linear memory, which the compiler initializes to
+// its initial size. It is 0 when the package runs natively, e.g., in
+// tests/main.go.
+var heapEnd int32
+
+// globalRoots is the address of a table in the static memory, set by the
+// compiler: the number of words of global variables that may hold pointers,
+// followed by their addresses.
+var globalRoots int32
+
+const headerSize = 8
+
+// The flags in the header of a block.
+const (
+	allocated = 1
+	marked    = 2
+	flags     = 7
+)
+
+// Layouts of objects that aren't described by a descriptor.
+const (
+	scanAll    = 0 // any word may be a pointer
+	noPointers = 1
+)
+
+// scanStep is the distance between the words that are scanned in objects
+// without a descriptor. Pointers are aligned to words, also in structs.
+const scanStep = 4
+
+// Free blocks of up to maxSmall bytes are kept in a list for each size, the
+// larger ones in a single list. A free block holds the address of the next
+// block in its list after the header.
+const maxSmall = 256
+const numClasses = maxSmall/8 + 1
+
+const stackSize = 4096     // bytes of a segment of the shadow stack
+const markStackSize = 1024 // bytes of the stack of blocks to scan
+const pageSize = 64 * 1024
+
+// A segment of the shadow stack starts with the base, top and end of the
+// previous one, or zeros for the first one.
+const segmentHeader = 12
+
+var heapStart int32
+var bitmap int32      // one bit for each 8 bytes of the heap, which ends here
+var classes int32     // the heads of the free lists by size, then of the large blocks
+var stackBottom int32 // the base of the first segment of the shadow stack
+var stackBase int32   // the base of the current segment
+var stackTop int32
+var stackEnd int32
+var spareSegment int32 // the last segment that was popped, to be pushed again
+var markBase int32
+var markTop int32
+var overflow bool // the mark stack overflowed
+
+// initHeap reserves the shadow stack, the mark stack and the heads of the free
+// lists at the start of the heap, and the bitmap at its end.
+func initHeap() {
+	p := Align(freePointer, 8)
+	stackBottom = p + segmentHeader
+	stackBase = stackBottom
+	stackTop = stackBottom
+	p = p + stackSize
+	stackEnd = p
+	markBase = p
+	markTop = p
+	p = p + markStackSize
+	classes = p
+	p = p + (numClasses+1)*4
+	heapStart = Align(p, 8)
+	freePointer = heapStart
+	bitmap = heapEnd - bitmapSize(heapEnd)
+}
+
+// bitmapSize returns the size of the bitmap for a heap that ends before the
+// bitmap at end.
+func bitmapSize(end int32) int32 {
+	return (end-heapStart)/64 + 1
+}
+
+// Alloc returns the address of size zeroed bytes, which the collector scans
+// conservatively. Blocks are aligned to 8 bytes, which is the largest
+// alignment.
 func Alloc(size, align int32) int32 {
-	mem := Align(freePointer, align)
-	freePointer = mem + size
-	return mem
+	return AllocTyped(size, align, scanAll)
+}
+
+// AllocTyped returns the address of size zeroed bytes for an object with the
+// given layout. It collects garbage if there is no free block large enough,
+// then grows the memory, and traps if the memory is exhausted.
+func AllocTyped(size, align, layout int32) int32 {
+	if heapEnd == 0 {
+		mem := Align(freePointer, align)
+		freePointer = mem + size
+		return mem
+	}
+	if heapStart == 0 {
+		initHeap()
+	}
+	n := Align(size, 8) + headerSize
+	if n < headerSize+8 {
+		// A free block holds a link.
+		n = headerSize + 8
+	}
+	b := allocBlock(n)
+	if b == 0 {
+		Collect()
+		b = allocBlock(n)
+		if b == 0 {
+			if !grow(n) {
+				panic("out of memory")
+			}
+			b = allocBlock(n)
+		}
+	}
+	store(b+4, layout)
+	end := b + blockSize(b)
+	for a := b + headerSize; a < end; a = a + 4 {
+		store(a, 0)
+	}
+	return b + headerSize
+}
+
+// allocBlock returns a block of at least n bytes, or 0.
+func allocBlock(n int32) int32 {
+	b := takeFree(n)
+	if b == 0 {
+		if n > bitmap-freePointer {
+			return 0
+		}
+		b = freePointer
+		freePointer = b + n
+		setStart(b)
+		store(b, n)
+	}
+	store(b, load(b)|allocated)
+	return b
+}
+
+// takeFree removes a free block of at least n bytes from the free lists and
+// returns it, or 0. The rest of a larger block is freed.
+func takeFree(n int32) int32 {
+	for c := n / 8; c < numClasses; c++ {
+		head := classes + c*4
+		b := load(head)
+		if b != 0 {
+			store(head, load(b+headerSize))
+			split(b, n)
+			return b
+		}
+	}
+	prev := classes + numClasses*4
+	for b := load(prev); b != 0; b = load(b + headerSize) {
+		if load(b) >= n {
+			store(prev, load(b+headerSize))
+			split(b, n)
+			return b
+		}
+		prev = b + headerSize
+	}
+	return 0
+}
+
+// grow adds pages to the memory for a block of n bytes and moves the bitmap to
+// its new end. The memory doubles if it can, so that a heap where most objects
+// are live isn't collected for every allocation. It returns false if the
+// memory can't grow.
+func grow(n int32) bool {
+	// The bitmap takes 1/64 of the new pages.
+	need := (n+n/32)/pageSize + 1
+	pages := heapEnd / pageSize
+	if pages < need {
+		pages = need
+	}
+	old := wasm.MemoryGrow(pages)
+	if old < 0 {
+		pages = need
+		old = wasm.MemoryGrow(pages)
+		if old < 0 {
+			return false
+		}
+	}
+	end := (old + pages) * pageSize
+	to := end - bitmapSize(end)
+	// The old bitmap is copied from its end, because the new one may overlap
+	// it. The rest of the new bitmap is in the new pages, which are zero.
+	for i := heapEnd - bitmap - 1; i >= 0; i-- {
+		store8(to+i, load8(bitmap+i))
+	}
+	bitmap = to
+	heapEnd = end
+	return true
+}
+
+// split shrinks the free block b to n bytes if the rest can be a block.
+func split(b, n int32) {
+	size := load(b)
+	if size-n < headerSize+8 {
+		return
+	}
+	store(b, n)
+	setStart(b + n)
+	free(b+n, size-n)
+}
+
+// free adds b to the free list for size bytes.
+func free(b, size int32) {
+	store(b, size)
+	head := classes + numClasses*4
+	if size <= maxSmall {
+		head = classes + size/8*4
+	}
+	store(b+headerSize, load(head))
+	store(head, b)
+}
+
+func blockSize(b int32) int32 {
+	return load(b) & ^flags
+}
+
+// Enter pushes a frame of n words on the shadow stack and returns its
+// address. A function keeps copies of its locals that may hold pointers in
+// its frame while it runs.
+func Enter(n int32) int32 {
+	if heapStart == 0 {
+		initHeap()
+	}
+	frame := stackTop
+	top := frame + n*4
+	if top > stackEnd {
+		pushSegment(n * 4)
+		frame = stackTop
+		top = frame + n*4
+	}
+	for a := frame; a < top; a = a + 4 {
+		store(a, 0)
+	}
+	stackTop = top
+	return frame
+}
+
+// Leave pops the frame returned by Enter, and any frames above it that were
+// left by functions that didn't return.
+func Leave(frame int32) {
+	for !inSegment(frame) {
+		popSegment()
+	}
+	stackTop = frame
+}
+
+// inSegment returns whether the frame at a is in the current segment of the
+// shadow stack.
+func inSegment(a int32) bool {
+	if a < stackBase {
+		return false
+	}
+	return a <= stackEnd
+}
+
+// Reset pops all frames of the shadow stack. It is called when the host calls
+// the module, since the frames of a call that trapped are never left.
+func Reset() {
+	if heapStart == 0 {
+		return
+	}
+	for stackBase != stackBottom {
+		popSegment()
+	}
+	stackTop = stackBase
+}
+
+// pushSegment continues the shadow stack in a segment for a frame of size
+// bytes. The segments are allocated from the heap, so that the frames don't
+// move: the functions keep their addresses.
+func pushSegment(size int32) {
+	n := Align(size+segmentHeader, 8)
+	if n < stackSize {
+		n = stackSize
+	}
+	seg := spareSegment
+	spareSegment = 0
+	if seg != 0 {
+		if blockSize(seg-headerSize)-headerSize < n {
+			seg = 0
+		}
+	}
+	if seg == 0 {
+		seg = AllocTyped(n, 8, noPointers)
+	}
+	store(seg, stackBase)
+	store(seg+4, stackTop)
+	store(seg+8, stackEnd)
+	stackBase = seg + segmentHeader
+	stackTop = stackBase
+	stackEnd = seg - headerSize + blockSize(seg-headerSize)
+}
+
+// popSegment returns to the previous segment of the shadow stack.
+func popSegment() {
+	seg := stackBase - segmentHeader
+	stackBase = load(seg)
+	stackTop = load(seg + 4)
+	stackEnd = load(seg + 8)
+	spareSegment = seg
+}
+
+// Collect frees the objects that aren't reachable from the roots.
+func Collect() {
+	if heapStart == 0 {
+		return
+	}
+	n := load(globalRoots)
+	for i := int32(1); i <= n; i++ {
+		mark(load(load(globalRoots + i*4)))
+	}
+	base := stackBase
+	top := stackTop
+	for base != 0 {
+		for a := base; a < top; a = a + 4 {
+			mark(load(a))
+		}
+		seg := base - segmentHeader
+		mark(seg)
+		base = load(seg)
+		top = load(seg + 4)
+	}
+	mark(spareSegment)
+	drain()
+	for overflow {
+		// Scan the marked blocks again for the objects that didn't fit on
+		// the mark stack.
+		overflow = false
+		for b := heapStart; b < freePointer; b = b + blockSize(b) {
+			if load(b)&marked != 0 {
+				scan(b)
+				drain()
+			}
+		}
+	}
+	sweep()
+}
+
+// Used returns the number of bytes in allocated blocks, including their
+// headers.
+func Used() int32 {
+	used := int32(0)
+	if heapStart == 0 {
+		return used
+	}
+	for b := heapStart; b < freePointer; b = b + blockSize(b) {
+		if load(b)&allocated != 0 {
+			used = used + blockSize(b)
+		}
+	}
+	return used
+}
+
+// mark marks the block that p points into, if any, and pushes it on the mark
+// stack.
+func mark(p int32) {
+	b := findBlock(p)
+	if b == 0 {
+		return
+	}
+	h := load(b)
+	if h&marked != 0 {
+		return
+	}
+	store(b, h|marked)
+	if load(b+4) == noPointers {
+		return
+	}
+	if markTop == markBase+markStackSize {
+		overflow = true
+		return
+	}
+	store(markTop, b)
+	markTop = markTop + 4
+}
+
+// drain scans the blocks on the mark stack.
+func drain() {
+	for markTop > markBase {
+		markTop = markTop - 4
+		scan(load(markTop))
+	}
+}
+
+// scan marks the blocks that the object in block b points to.
+func scan(b int32) {
+	layout := load(b + 4)
+	end := b + blockSize(b)
+	if layout == noPointers {
+		return
+	}
+	if layout == scanAll {
+		for a := b + headerSize; a+4 <= end; a = a + scanStep {
+			mark(load(a))
+		}
+		return
+	}
+	elemSize := load(layout)
+	n := load(layout + 4)
+	for e := b + headerSize; e+elemSize <= end; e = e + elemSize {
+		for i := int32(0); i < n; i++ {
+			mark(load(e + load(layout+8+i*4)))
+		}
+	}
+}
+
+// findBlock returns the allocated block that contains the object p points
+// into, or 0.
+func findBlock(p int32) int32 {
+	if p < heapStart+headerSize {
+		return 0
+	}
+	if p >= freePointer {
+		return 0
+	}
+	i := (p - heapStart) / 8
+	a := bitmap + i/8
+	bits := load8(a) & (int32(2)<<(i&7) - 1)
+	for bits == 0 {
+		// The first block of the heap starts at heapStart.
+		a = a - 1
+		bits = load8(a)
+	}
+	k := int32(7)
+	for bits&(int32(1)<<k) == 0 {
+		k = k - 1
+	}
+	b := heapStart + ((a-bitmap)*8+k)*8
+	if load(b)&allocated == 0 {
+		return 0
+	}
+	if p < b+headerSize {
+		return 0
+	}
+	return b
+}
+
+// sweep frees the blocks that aren't marked, coalescing adjacent free blocks,
+// and rebuilds the free lists. Free blocks at the end of the heap are
+// returned to the unused part.
+func sweep() {
+	for c := int32(0); c <= numClasses; c++ {
+		store(classes+c*4, 0)
+	}
+	run := int32(0) // the first block of a run of free blocks
+	for b := heapStart; b < freePointer; b = b + blockSize(b) {
+		h := load(b)
+		if h&marked != 0 {
+			store(b, h & ^marked)
+			if run != 0 {
+				free(run, b-run)
+				run = 0
+			}
+		} else if run == 0 {
+			run = b
+		} else {
+			clearStart(b)
+		}
+	}
+	if run != 0 {
+		clearStart(run)
+		freePointer = run
+	}
+}
+
+func setStart(b int32) {
+	i := (b - heapStart) / 8
+	a := bitmap + i/8
+	store8(a, load8(a)|int32(1)<<(i&7))
+}
+
+func clearStart(b int32) {
+	i := (b - heapStart) / 8
+	a := bitmap + i/8
+	store8(a, load8(a) & ^(int32(1)<<(i&7)))
+}
+
+func load(addr int32) int32 {
+	p := (*int32)(unsafe.Pointer(uintptr(addr)))
+	return *p
+}
+
+func store(addr, val int32) {
+	p := (*int32)(unsafe.Pointer(uintptr(addr)))
+	*p = val
+}
+
+func load8(addr int32) int32 {
+	p := (*uint8)(unsafe.Pointer(uintptr(addr)))
+	return int32(*p)
+}
+
+func store8(addr, val int32) {
+	p := (*uint8)(unsafe.Pointer(uintptr(addr)))
+	*p = uint8(val)
 }
 
 //wasm:assert_return (invoke "Align" (i32.const 9) (i32.const 4)) (i32.const 12)
@@ -39,3 +558,10 @@ func Memcpy(dst, src uintptr, n int) {
 		Poke8(uintptr(dst)+i, Peek8(uintptr(src)+i))
 	}
 }
+
+// Copy copies n bytes from src to dst and returns dst, e.g., the address of a
+// new struct that becomes a copy of the struct at src.
+func Copy(dst, src uintptr, n int) uintptr {
+	Memcpy(dst, src, n)
+	return dst
+}
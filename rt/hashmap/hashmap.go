@@ -0,0 +1,323 @@
+// Package hashmap implements the built-in map type.
+//
+// A map value is the address of its table, or 0 for a nil map. Keys are
+// passed as words: integers, pointers and strings, which are compared by their
+// contents. The functions with the suffix Wide take 8-byte integer keys. The compiler loads and stores the values through the
+// addresses returned by MapAccess and MapAssign, so values of any size up to 8
+// bytes can be stored.
+//
+// The entries are chained in buckets by the hash of the key, and in a list in
+// insertion order, which is the iteration order. Deleted entries stay in the
+// list until the table is rehashed, so that iterators can move past them.
+package hashmap
+
+import (
+	"gowasm/rt/gc"
+	"unsafe"
+)
+
+type entry struct {
+	value   uintptr
+	value2  int32   // the upper half of 8-byte values
+	key     uintptr // the key, or the address of the header of a string key
+	key2    int32   // the upper half of 8-byte keys
+	next    *entry  // the next entry in the same bucket
+	link    *entry  // the next entry in insertion order
+	deleted bool
+}
+
+type table struct {
+	count    int32   // the number of live entries
+	total    int32   // the number of entries in the list, including deleted ones
+	buckets  uintptr // an array of nbuckets pointers to entries
+	nbuckets int32   // a power of 2
+	first    *entry
+	last     *entry
+	strings  bool // the keys are strings
+}
+
+// stringHeader is the representation of a string, see rt/str.
+type stringHeader struct {
+	data uintptr
+	len  int32
+}
+
+// zeroValue is the address of the value of missing keys.
+var zeroValue uintptr
+
+func zero() uintptr {
+	if zeroValue == 0 {
+		zeroValue = uintptr(gc.Alloc(8, 8))
+	}
+	return zeroValue
+}
+
+func hash(t *table, key uintptr, key2 int32) int32 {
+	if t.strings {
+		return hashString(key)
+	}
+	h := (int32(key)*-1640531527 ^ key2) * -1640531527
+	return h ^ (h >> 15)
+}
+
+// hashString computes the FNV-1a hash of the bytes of a string.
+func hashString(key uintptr) int32 {
+	h := int32(-2128831035)
+	if key == 0 {
+		return h
+	}
+	s := (*stringHeader)(unsafe.Pointer(key))
+	for i := int32(0); i < s.len; i++ {
+		h = (h ^ int32(gc.Peek8(s.data+uintptr(i)))) * 16777619
+	}
+	return h
+}
+
+func equal(t *table, e *entry, key uintptr, key2 int32) bool {
+	if e.key == key {
+		return e.key2 == key2
+	}
+	if t.strings {
+		return stringsEqual(e.key, key)
+	}
+	return false
+}
+
+func stringsEqual(a, b uintptr) bool {
+	if a == 0 {
+		return false
+	}
+	if b == 0 {
+		return false
+	}
+	x := (*stringHeader)(unsafe.Pointer(a))
+	y := (*stringHeader)(unsafe.Pointer(b))
+	if x.len != y.len {
+		return false
+	}
+	for i := int32(0); i < x.len; i++ {
+		if gc.Peek8(x.data+uintptr(i)) != gc.Peek8(y.data+uintptr(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+// bucket returns the address of the head of the bucket for a hash.
+func bucket(t *table, h int32) **entry {
+	i := h & (t.nbuckets - 1)
+	return (**entry)(unsafe.Pointer(t.buckets + uintptr(i*4)))
+}
+
+func find(t *table, key uintptr, key2 int32) *entry {
+	if t == nil {
+		return nil
+	}
+	b := bucket(t, hash(t, key, key2))
+	e := *b
+	for e != nil {
+		if equal(t, e, key, key2) {
+			return e
+		}
+		e = e.next
+	}
+	return nil
+}
+
+// rehash rebuilds the buckets with n buckets and drops the deleted entries
+// from the list.
+func rehash(t *table, n int32) {
+	t.buckets = uintptr(gc.Alloc(n*4, 4))
+	t.nbuckets = n
+	var last *entry
+	for e := t.first; e != nil; e = e.link {
+		if e.deleted {
+			continue
+		}
+		b := bucket(t, hash(t, e.key, e.key2))
+		e.next = *b
+		*b = e
+		if last == nil {
+			t.first = e
+		} else {
+			last.link = e
+		}
+		last = e
+	}
+	if last == nil {
+		t.first = nil
+	} else {
+		last.link = nil
+	}
+	t.last = last
+	t.total = t.count
+}
+
+func insert(t *table, key uintptr, key2 int32) *entry {
+	if t.total >= t.nbuckets {
+		n := t.nbuckets
+		if t.count*2 >= n {
+			n = n * 2
+		}
+		rehash(t, n)
+	}
+	e := &entry{}
+	e.key = key
+	e.key2 = key2
+	b := bucket(t, hash(t, key, key2))
+	e.next = *b
+	*b = e
+	if t.last == nil {
+		t.first = e
+	} else {
+		t.last.link = e
+	}
+	t.last = e
+	t.count = t.count + 1
+	t.total = t.total + 1
+	return e
+}
+
+// live returns the first entry in the list starting at e that isn't deleted.
+func live(e *entry) *entry {
+	for e != nil {
+		if !e.deleted {
+			return e
+		}
+		e = e.link
+	}
+	return nil
+}
+
+// MapMake returns a new map with room for at least hint entries.
+func MapMake(strings bool, hint int32) *table {
+	n := int32(8)
+	for n < hint {
+		n = n * 2
+	}
+	t := &table{}
+	t.buckets = uintptr(gc.Alloc(n*4, 4))
+	t.nbuckets = n
+	t.strings = strings
+	return t
+}
+
+//wasm:assert_return (invoke "MapLen" (i32.const 0)) (i32.const 0)
+func MapLen(t *table) int32 {
+	if t == nil {
+		return 0
+	}
+	return t.count
+}
+
+// MapAccess returns the address of the value for a key, or of a zero value if
+// the map doesn't contain the key.
+func MapAccess(t *table, key uintptr) uintptr {
+	return access(find(t, key, 0))
+}
+
+func MapAccessWide(t *table, key int64) uintptr {
+	return access(find(t, uintptr(key), int32(key>>32)))
+}
+
+func access(e *entry) uintptr {
+	if e == nil {
+		return zero()
+	}
+	return uintptr(unsafe.Pointer(&e.value))
+}
+
+// MapAccess2 is MapAccess that also reports whether the map contains the key.
+func MapAccess2(t *table, key uintptr) (uintptr, bool) {
+	e := find(t, key, 0)
+	return access(e), e != nil
+}
+
+func MapAccess2Wide(t *table, key int64) (uintptr, bool) {
+	e := find(t, uintptr(key), int32(key>>32))
+	return access(e), e != nil
+}
+
+// MapAssign returns the address of the value for a key, which is added to the
+// map if it isn't there.
+func MapAssign(t *table, key uintptr) uintptr {
+	return assign(t, key, 0)
+}
+
+func MapAssignWide(t *table, key int64) uintptr {
+	return assign(t, uintptr(key), int32(key>>32))
+}
+
+func assign(t *table, key uintptr, key2 int32) uintptr {
+	if t == nil {
+		panic("assignment to entry in nil map")
+	}
+	e := find(t, key, key2)
+	if e == nil {
+		e = insert(t, key, key2)
+	}
+	return uintptr(unsafe.Pointer(&e.value))
+}
+
+// MapPut sets the value for a key and returns the map. It is used for map
+// literals with values of up to 4 bytes.
+func MapPut(t *table, key, value uintptr) *table {
+	p := (*uintptr)(unsafe.Pointer(assign(t, key, 0)))
+	*p = value
+	return t
+}
+
+func MapPutWide(t *table, key int64, value uintptr) *table {
+	p := (*uintptr)(unsafe.Pointer(assign(t, uintptr(key), int32(key>>32))))
+	*p = value
+	return t
+}
+
+func MapDelete(t *table, key uintptr) {
+	remove(t, key, 0)
+}
+
+func MapDeleteWide(t *table, key int64) {
+	remove(t, uintptr(key), int32(key>>32))
+}
+
+func remove(t *table, key uintptr, key2 int32) {
+	if t == nil {
+		return
+	}
+	p := bucket(t, hash(t, key, key2))
+	for *p != nil {
+		e := *p
+		if equal(t, e, key, key2) {
+			*p = e.next
+			e.deleted = true
+			t.count = t.count - 1
+			return
+		}
+		p = &e.next
+	}
+}
+
+// MapIter returns the first entry of a range loop, or nil if the map is empty.
+func MapIter(t *table) *entry {
+	if t == nil {
+		return nil
+	}
+	return live(t.first)
+}
+
+func MapIterNext(e *entry) *entry {
+	return live(e.link)
+}
+
+func MapIterKey(e *entry) uintptr {
+	return e.key
+}
+
+func MapIterKeyWide(e *entry) int64 {
+	return int64(e.key) | int64(e.key2)<<32
+}
+
+func MapIterValue(e *entry) uintptr {
+	return uintptr(unsafe.Pointer(&e.value))
+}
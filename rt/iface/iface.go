@@ -0,0 +1,249 @@
+// Package iface implements interface values.
+//
+// An interface value is the address of its header, or 0 for a nil interface.
+// The header holds the type descriptor of the dynamic type, the method table
+// for the static interface type and the data word. The data word is the value
+// itself if it fits in 4 bytes, otherwise the address of a copy. Floats and
+// 8-byte integers are boxed this way too, whatever their size. The compiler
+// generates the type descriptors and the method tables in static memory.
+//
+// A method table is an array with the function table index of each method of
+// the interface, in the order of the methods in go/types. The descriptor of an
+// interface lists the method tables of all dynamic types that implement it,
+// which are used for conversions to the interface at run time.
+package iface
+
+import (
+	"gowasm/rt/gc"
+	"unsafe"
+)
+
+// Kinds of dynamic types, which determine how values are compared.
+const (
+	kindWord         = 0
+	kindString       = 1
+	kindMemory       = 2
+	kindIncomparable = 3
+	kindBox          = 4 // an 8-byte integer
+	kindFloat32      = 5
+	kindFloat64      = 6
+)
+
+type typeDesc struct {
+	kind int32
+	size int32
+}
+
+// ifaceDesc is the descriptor of an interface type. Its entries are n pairs
+// of the address of a type descriptor and the address of a method table.
+type ifaceDesc struct {
+	n       int32
+	entries uintptr
+}
+
+type header struct {
+	typ     *typeDesc
+	methods uintptr
+	data    uintptr
+}
+
+// stringHeader is the representation of a string, see rt/str.
+type stringHeader struct {
+	data uintptr
+	len  int32
+}
+
+func IfaceMake(t *typeDesc, methods, data uintptr) *header {
+	x := &header{}
+	x.typ = t
+	x.methods = methods
+	x.data = data
+	return x
+}
+
+// IfaceMakeCopy returns an interface holding a copy of the value at src.
+func IfaceMakeCopy(t *typeDesc, methods, src uintptr) *header {
+	return IfaceMake(t, methods, clone(t, src))
+}
+
+// IfaceMakeBox returns an interface holding a float or an 8-byte integer,
+// whose bits are stored in a box of 8 bytes.
+func IfaceMakeBox(t *typeDesc, methods uintptr, bits int64) *header {
+	data := uintptr(gc.Alloc(8, 8))
+	p := (*int64)(unsafe.Pointer(data))
+	*p = bits
+	return IfaceMake(t, methods, data)
+}
+
+func clone(t *typeDesc, src uintptr) uintptr {
+	data := uintptr(gc.Alloc(t.size, 8))
+	gc.Memcpy(data, src, int(t.size))
+	return data
+}
+
+// IfaceData returns the data word of the receiver of a method call.
+func IfaceData(x *header) uintptr {
+	if x == nil {
+		panic("invalid memory address or nil pointer dereference")
+	}
+	return x.data
+}
+
+// IfaceMethod returns the function table index of the i-th method.
+func IfaceMethod(x *header, i int32) int32 {
+	if x == nil {
+		panic("invalid memory address or nil pointer dereference")
+	}
+	p := (*int32)(unsafe.Pointer(x.methods + uintptr(i*4)))
+	return *p
+}
+
+// find returns the method table of type t for interface j, or 0 if t doesn't
+// implement j.
+func find(j *ifaceDesc, t *typeDesc) uintptr {
+	for i := int32(0); i < j.n; i++ {
+		e := (*header)(unsafe.Pointer(j.entries + uintptr(i*8)))
+		if e.typ == t {
+			return e.methods
+		}
+	}
+	return 0
+}
+
+// IfaceImplements returns whether x is not nil and its dynamic type
+// implements j. A nil j is the empty interface.
+func IfaceImplements(x *header, j *ifaceDesc) bool {
+	if x == nil {
+		return false
+	}
+	if j == nil {
+		return true
+	}
+	return find(j, x.typ) != 0
+}
+
+// IfaceConvert converts x to interface j, whose methods the static type of x
+// has.
+func IfaceConvert(x *header, j *ifaceDesc) *header {
+	if x == nil {
+		return nil
+	}
+	return IfaceAssert(x, j)
+}
+
+// IfaceAssert returns x.(J) for an interface type J.
+func IfaceAssert(x *header, j *ifaceDesc) *header {
+	if !IfaceImplements(x, j) {
+		panic("interface conversion: missing method")
+	}
+	if j == nil {
+		return x
+	}
+	return IfaceMake(x.typ, find(j, x.typ), x.data)
+}
+
+func IfaceAssert2(x *header, j *ifaceDesc) (*header, bool) {
+	if !IfaceImplements(x, j) {
+		return nil, false
+	}
+	return IfaceAssert(x, j), true
+}
+
+//wasm:assert_return (invoke "IfaceIs" (i32.const 0) (i32.const 0)) (i32.const 0)
+func IfaceIs(x *header, t *typeDesc) bool {
+	if x == nil {
+		return false
+	}
+	return x.typ == t
+}
+
+// IfaceAssertType returns the value of x.(T) for a type T that isn't an
+// interface. A value that doesn't fit in the data word is copied, but a box
+// isn't, since the compiled code loads its value.
+func IfaceAssertType(x *header, t *typeDesc) uintptr {
+	if !IfaceIs(x, t) {
+		panic("interface conversion: wrong type")
+	}
+	if t.kind == kindMemory {
+		return clone(t, x.data)
+	}
+	return x.data
+}
+
+// IfaceAssertType2 returns the value of x.(T) and ok. For a boxed type, the
+// value is the address of a zero box if the assertion fails.
+func IfaceAssertType2(x *header, t *typeDesc) (uintptr, bool) {
+	if !IfaceIs(x, t) {
+		if t.kind >= kindBox {
+			return zero(), false
+		}
+		return 0, false
+	}
+	return IfaceAssertType(x, t), true
+}
+
+// zeroBox is the address of a box with the zero value.
+var zeroBox uintptr
+
+func zero() uintptr {
+	if zeroBox == 0 {
+		zeroBox = uintptr(gc.Alloc(8, 8))
+	}
+	return zeroBox
+}
+
+// IfaceEqual compares interface values, which are equal if they have the same
+// dynamic type and equal values.
+func IfaceEqual(x, y *header) bool {
+	if x == nil {
+		return y == nil
+	}
+	if y == nil {
+		return false
+	}
+	if x.typ != y.typ {
+		return false
+	}
+	switch x.typ.kind {
+	case kindString:
+		return stringsEqual(x.data, y.data)
+	case kindMemory:
+		return bytesEqual(x.data, y.data, x.typ.size)
+	case kindBox:
+		return bytesEqual(x.data, y.data, 8)
+	case kindFloat32:
+		return *(*float32)(unsafe.Pointer(x.data)) == *(*float32)(unsafe.Pointer(y.data))
+	case kindFloat64:
+		return *(*float64)(unsafe.Pointer(x.data)) == *(*float64)(unsafe.Pointer(y.data))
+	case kindIncomparable:
+		panic("comparing uncomparable type")
+	}
+	return x.data == y.data
+}
+
+func stringsEqual(a, b uintptr) bool {
+	if a == b {
+		return true
+	}
+	if a == 0 {
+		return false
+	}
+	if b == 0 {
+		return false
+	}
+	x := (*stringHeader)(unsafe.Pointer(a))
+	y := (*stringHeader)(unsafe.Pointer(b))
+	if x.len != y.len {
+		return false
+	}
+	return bytesEqual(x.data, y.data, x.len)
+}
+
+func bytesEqual(a, b uintptr, n int32) bool {
+	for i := int32(0); i < n; i++ {
+		if gc.Peek8(a+uintptr(i)) != gc.Peek8(b+uintptr(i)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+// Package panics implements defer, panic and recover.
+//
+// Deferred calls are function values without parameters or results, see
+// rt/closure, on a stack shared by all functions. A function with deferred
+// calls remembers the top of the stack on entry and runs the calls pushed
+// since then when it returns.
+//
+// panic doesn't unwind the stack by itself. It sets a flag, which the compiler
+// checks after each call: a function with deferred calls then runs them and
+// returns, others return right away. recover clears the panic, so that the
+// function whose deferred call recovered returns normally. A panic traps when
+// no function with deferred calls is left to recover it.
+package panics
+
+import "unsafe"
+
+type record struct {
+	fn   func()
+	next *record
+}
+
+var top *record
+
+// frames is the number of active functions with deferred calls.
+var frames int32
+
+// unwinding is set while the stack is unwound. It is cleared while deferred
+// calls run, so that they can make calls.
+var unwinding bool
+
+var panicking bool
+var value uintptr
+
+// Panic starts a panic with the interface value v.
+func Panic(v uintptr) {
+	if frames == 0 {
+		panic("panic")
+	}
+	value = v
+	panicking = true
+	unwinding = true
+}
+
+// Unwinding returns whether the caller has to return because of a panic.
+func Unwinding() bool {
+	return unwinding
+}
+
+// Recover stops the panic, if any, and returns its value.
+func Recover() uintptr {
+	if !panicking {
+		return 0
+	}
+	v := value
+	panicking = false
+	value = 0
+	return v
+}
+
+// DeferReset drops the deferred calls and the panic of a call that trapped,
+// whose functions never returned. It is called when the host calls the
+// module, like gc.Reset.
+func DeferReset() {
+	top = nil
+	frames = 0
+	unwinding = false
+	panicking = false
+	value = 0
+}
+
+// DeferEnter is called on entry of a function with deferred calls. It returns
+// the top of the stack of deferred calls, which is passed to DeferReturn.
+func DeferEnter() *record {
+	frames = frames + 1
+	return top
+}
+
+// DeferPush adds a deferred call.
+func DeferPush(fn func()) {
+	r := &record{}
+	r.fn = fn
+	r.next = top
+	top = r
+}
+
+// DeferSwitch replaces the deferred calls of the running goroutine by those of
+// the next one, see rt/sched, and returns them.
+func DeferSwitch(next uintptr, depth int32) (uintptr, int32) {
+	prev := uintptr(unsafe.Pointer(top))
+	prevDepth := frames
+	top = (*record)(unsafe.Pointer(next))
+	frames = depth
+	return prev, prevDepth
+}
+
+// DeferReturn runs the deferred calls of a function in reverse order. If a
+// panic is still in progress afterwards, the caller continues unwinding.
+func DeferReturn(frame *record) {
+	for top != frame {
+		r := top
+		top = r.next
+		unwinding = false
+		fn := r.fn
+		fn()
+	}
+	frames = frames - 1
+	if panicking {
+		if frames == 0 {
+			panicking = false
+			unwinding = false
+			value = 0
+			panic("panic")
+		}
+		unwinding = true
+	}
+}
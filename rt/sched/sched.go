@@ -0,0 +1,474 @@
+// Package sched implements goroutines and channels on a single thread.
+//
+// Goroutines are scheduled cooperatively: a goroutine runs until it blocks in
+// a channel operation or returns. The main goroutine, which entered the module
+// from the host, runs the others while it is blocked. Any other goroutine
+// blocks by unwinding its stack back to the scheduler: after each call that
+// may block, the compiler checks whether the goroutine is suspending, in which
+// case the function saves its locals in a frame and returns. To resume the
+// goroutine, the scheduler calls its function again while rewinding: each
+// function restores its locals from its frame and continues with the call
+// that blocked, down to the channel operation, which returns its result.
+//
+// A channel value is the address of a channel, or 0 for a nil channel. The
+// elements of channels are single words.
+package sched
+
+import (
+	"gowasm/rt/gc"
+	"gowasm/rt/panics"
+	"unsafe"
+)
+
+type g struct {
+	fn     func()
+	frames *frame // the frames of a suspended goroutine, outermost first
+	next   *g     // the next goroutine in the run queue
+	// ticket is incremented when the goroutine is woken up, which makes the
+	// other waiters of a select statement stale.
+	ticket int32
+	// The result of the channel operation the goroutine waited for.
+	value uintptr
+	index int32
+	ok    bool
+	// The deferred calls of the goroutine while others run, see rt/panics.
+	defers     uintptr
+	deferDepth int32
+	waiting    bool
+}
+
+// frame is followed by the locals of a function.
+type frame struct {
+	next *frame
+	size int32
+}
+
+// mainG is the main goroutine, current the running one.
+var mainG *g
+var current *g
+
+// The run queue holds the goroutines that are ready to run.
+var head *g
+var tail *g
+
+var suspending bool
+var rewinding bool
+
+type waiter struct {
+	gp     *g
+	ticket int32
+	value  uintptr // the value to send
+	index  int32   // the case of a select statement
+	next   *waiter
+}
+
+type queue struct {
+	first *waiter
+	last  *waiter
+}
+
+type channel struct {
+	buf    uintptr // a ring buffer of size words
+	size   int32
+	count  int32
+	first  int32 // the index of the first buffered element
+	closed bool
+	recvq  *queue
+	sendq  *queue
+}
+
+// selectCase is a case of a select statement. Its size is caseSize.
+type selectCase struct {
+	c     *channel
+	value uintptr
+	send  bool
+}
+
+const caseSize = 12
+
+func cur() *g {
+	if current == nil {
+		mainG = &g{}
+		current = mainG
+	}
+	return current
+}
+
+// Go starts a goroutine that calls fn.
+func Go(fn func()) {
+	gp := &g{}
+	gp.fn = fn
+	ready(gp)
+}
+
+// Suspending returns whether the caller has to save its locals and return
+// because the goroutine blocked.
+func Suspending() bool {
+	return suspending
+}
+
+// Rewinding returns whether the caller has to restore its locals because the
+// goroutine is resumed.
+func Rewinding() bool {
+	return rewinding
+}
+
+// FramePush returns a frame of size bytes for the locals of a function that
+// returns because the goroutine blocked.
+func FramePush(size int32) uintptr {
+	f := (*frame)(unsafe.Pointer(uintptr(gc.Alloc(size+8, 4))))
+	f.size = size
+	gp := cur()
+	f.next = gp.frames
+	gp.frames = f
+	return uintptr(unsafe.Pointer(f)) + 8
+}
+
+// FramePop returns the frame of the outermost function that has to restore
+// its locals. The frame is garbage after the function has restored them.
+func FramePop() uintptr {
+	gp := cur()
+	f := gp.frames
+	gp.frames = f.next
+	return uintptr(unsafe.Pointer(f)) + 8
+}
+
+// ready wakes up a goroutine. Others than the main goroutine are added to the
+// run queue.
+func ready(gp *g) {
+	gp.waiting = false
+	gp.ticket = gp.ticket + 1
+	if gp == mainG {
+		return
+	}
+	gp.next = nil
+	if tail == nil {
+		head = gp
+	} else {
+		tail.next = gp
+	}
+	tail = gp
+}
+
+// park blocks the running goroutine until it is woken up. The main goroutine
+// runs the others meanwhile, the others start suspending.
+func park() {
+	gp := cur()
+	gp.waiting = true
+	if gp != mainG {
+		suspending = true
+		return
+	}
+	for mainG.waiting {
+		next := head
+		if next == nil {
+			panic("all goroutines are asleep - deadlock!")
+		}
+		head = next.next
+		if head == nil {
+			tail = nil
+		}
+		run(next)
+	}
+}
+
+// run runs a goroutine until it returns or blocks.
+func run(gp *g) {
+	mainG.defers, mainG.deferDepth = panics.DeferSwitch(gp.defers, gp.deferDepth)
+	current = gp
+	rewinding = gp.frames != nil
+	fn := gp.fn
+	fn()
+	suspending = false
+	current = mainG
+	gp.defers, gp.deferDepth = panics.DeferSwitch(mainG.defers, mainG.deferDepth)
+}
+
+// resumed returns whether a channel operation is called again by a resumed
+// goroutine, whose operation has completed.
+func resumed() bool {
+	if !rewinding {
+		return false
+	}
+	rewinding = false
+	return true
+}
+
+func enqueue(q *queue, gp *g, value uintptr, index int32) {
+	w := &waiter{}
+	w.gp = gp
+	w.ticket = gp.ticket
+	w.value = value
+	w.index = index
+	if q.last == nil {
+		q.first = w
+	} else {
+		last := q.last
+		last.next = w
+	}
+	q.last = w
+}
+
+// dequeue removes the first waiter whose goroutine still waits for it from a
+// queue and returns it, or nil.
+func dequeue(q *queue) *waiter {
+	for q.first != nil {
+		w := q.first
+		q.first = w.next
+		if q.first == nil {
+			q.last = nil
+		}
+		gp := w.gp
+		if gp.waiting {
+			if w.ticket == gp.ticket {
+				return w
+			}
+		}
+	}
+	return nil
+}
+
+// wake completes the operation of a waiter with a received value, or with !ok
+// if the channel was closed.
+func wake(w *waiter, value uintptr, ok bool) {
+	gp := w.gp
+	gp.value = value
+	gp.ok = ok
+	gp.index = w.index
+	ready(gp)
+}
+
+func slot(c *channel, i int32) *uintptr {
+	if i >= c.size {
+		i = i - c.size
+	}
+	return (*uintptr)(unsafe.Pointer(c.buf + uintptr(i*4)))
+}
+
+// ChanMake returns a channel with a buffer of size elements.
+func ChanMake(size int32) *channel {
+	if size < 0 {
+		panic("makechan: size out of range")
+	}
+	c := &channel{}
+	c.buf = uintptr(gc.Alloc(size*4, 4))
+	c.size = size
+	c.recvq = &queue{}
+	c.sendq = &queue{}
+	return c
+}
+
+// trySend sends v if a receiver waits or the buffer has room.
+func trySend(c *channel, v uintptr) bool {
+	if c.closed {
+		panic("send on closed channel")
+	}
+	w := dequeue(c.recvq)
+	if w != nil {
+		wake(w, v, true)
+		return true
+	}
+	if c.count < c.size {
+		p := slot(c, c.first+c.count)
+		*p = v
+		c.count = c.count + 1
+		return true
+	}
+	return false
+}
+
+// tryRecv receives into gp.value if the buffer isn't empty, a sender waits or
+// the channel is closed.
+func tryRecv(c *channel, gp *g) bool {
+	if c.count > 0 {
+		p := slot(c, c.first)
+		gp.value = *p
+		gp.ok = true
+		c.first = c.first + 1
+		if c.first == c.size {
+			c.first = 0
+		}
+		c.count = c.count - 1
+		w := dequeue(c.sendq)
+		if w != nil {
+			// The value of the sender takes the free place in the buffer.
+			p = slot(c, c.first+c.count)
+			*p = w.value
+			c.count = c.count + 1
+			wake(w, 0, true)
+		}
+		return true
+	}
+	w := dequeue(c.sendq)
+	if w != nil {
+		gp.value = w.value
+		gp.ok = true
+		wake(w, 0, true)
+		return true
+	}
+	if c.closed {
+		gp.value = 0
+		gp.ok = false
+		return true
+	}
+	return false
+}
+
+// ChanSend sends v on c. A send on a nil channel blocks forever.
+func ChanSend(c *channel, v uintptr) {
+	gp := cur()
+	if !resumed() {
+		if c != nil {
+			if trySend(c, v) {
+				return
+			}
+			enqueue(c.sendq, gp, v, 0)
+		}
+		park()
+		if suspending {
+			return
+		}
+	}
+	if !gp.ok {
+		panic("send on closed channel")
+	}
+}
+
+// ChanRecv receives from c. The result is false if c is closed and empty. A
+// receive from a nil channel blocks forever.
+func ChanRecv(c *channel) (uintptr, bool) {
+	gp := cur()
+	if resumed() {
+		return gp.value, gp.ok
+	}
+	if c != nil {
+		if tryRecv(c, gp) {
+			return gp.value, gp.ok
+		}
+		enqueue(c.recvq, gp, 0, 0)
+	}
+	park()
+	return gp.value, gp.ok
+}
+
+// ChanClose closes c. Receivers that wait get zero values, senders panic.
+func ChanClose(c *channel) {
+	if c == nil {
+		panic("close of nil channel")
+	}
+	if c.closed {
+		panic("close of closed channel")
+	}
+	c.closed = true
+	for {
+		w := dequeue(c.recvq)
+		if w == nil {
+			break
+		}
+		wake(w, 0, false)
+	}
+	for {
+		w := dequeue(c.sendq)
+		if w == nil {
+			break
+		}
+		wake(w, 0, false)
+	}
+}
+
+// ChanLen returns the number of buffered elements.
+func ChanLen(c *channel) int32 {
+	if c == nil {
+		return 0
+	}
+	return c.count
+}
+
+// ChanCap returns the size of the buffer.
+func ChanCap(c *channel) int32 {
+	if c == nil {
+		return 0
+	}
+	return c.size
+}
+
+// SelectMake returns the cases of a select statement with n cases, which are
+// set by SelectSend and SelectRecv.
+func SelectMake(n int32) uintptr {
+	return uintptr(gc.Alloc(n*caseSize, 4))
+}
+
+func caseAt(cases uintptr, i int32) *selectCase {
+	return (*selectCase)(unsafe.Pointer(cases + uintptr(i*caseSize)))
+}
+
+// SelectSend makes the i-th case a send of v on c.
+func SelectSend(cases uintptr, i int32, c *channel, v uintptr) {
+	sc := caseAt(cases, i)
+	sc.c = c
+	sc.value = v
+	sc.send = true
+}
+
+// SelectRecv makes the i-th case a receive from c.
+func SelectRecv(cases uintptr, i int32, c *channel) {
+	sc := caseAt(cases, i)
+	sc.c = c
+	sc.send = false
+}
+
+// Select performs the first of n cases that can proceed and returns its index
+// and, for a receive, the value and whether it was received. If none can, a
+// select statement with a default case returns -1 without blocking. Cases
+// with nil channels never proceed.
+func Select(cases uintptr, n int32, block bool) (int32, uintptr, bool) {
+	gp := cur()
+	if resumed() {
+		return selected(cases, gp)
+	}
+	for i := int32(0); i < n; i++ {
+		sc := caseAt(cases, i)
+		if sc.c == nil {
+			continue
+		}
+		if sc.send {
+			if trySend(sc.c, sc.value) {
+				return i, 0, true
+			}
+		} else if tryRecv(sc.c, gp) {
+			return i, gp.value, gp.ok
+		}
+	}
+	if !block {
+		return -1, 0, false
+	}
+	for i := int32(0); i < n; i++ {
+		sc := caseAt(cases, i)
+		c := sc.c
+		if c == nil {
+			continue
+		}
+		if sc.send {
+			enqueue(c.sendq, gp, sc.value, i)
+		} else {
+			enqueue(c.recvq, gp, 0, i)
+		}
+	}
+	park()
+	if suspending {
+		return 0, 0, false
+	}
+	return selected(cases, gp)
+}
+
+// selected returns the result of a select statement whose goroutine was woken
+// up.
+func selected(cases uintptr, gp *g) (int32, uintptr, bool) {
+	sc := caseAt(cases, gp.index)
+	if sc.send {
+		if !gp.ok {
+			panic("send on closed channel")
+		}
+	}
+	return gp.index, gp.value, gp.ok
+}
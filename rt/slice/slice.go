@@ -0,0 +1,215 @@
+// Package slice implements the operations on slices that the compiler doesn't
+// generate inline.
+//
+// A slice value is the address of its header, or 0 for a nil slice. Slicing
+// and append return a new header, which may share the elements with the
+// operand. The compiler passes the size, the alignment and the layout of the
+// elements, which tells the collector where they may hold pointers.
+package slice
+
+import (
+	"gowasm/rt/gc"
+	"unsafe"
+)
+
+// The layouts of gc.AllocTyped for elements that the compiler doesn't
+// describe.
+const (
+	scanAll    = 0
+	noPointers = 1
+)
+
+type header struct {
+	data uintptr
+	len  int32
+	cap  int32
+}
+
+//wasm:assert_return (invoke "SliceLen" (i32.const 0)) (i32.const 0)
+func SliceLen(s *header) int32 {
+	if s == nil {
+		return 0
+	}
+	return s.len
+}
+
+func SliceCap(s *header) int32 {
+	if s == nil {
+		return 0
+	}
+	return s.cap
+}
+
+// SliceCheckIndex returns i if it is a valid index for a length of n.
+//
+//wasm:assert_return (invoke "SliceCheckIndex" (i32.const 2) (i32.const 3)) (i32.const 2)
+func SliceCheckIndex(i, n int32) int32 {
+	if i < 0 {
+		panic("index out of range")
+	}
+	if i >= n {
+		panic("index out of range")
+	}
+	return i
+}
+
+// SliceElem returns the address of s[i].
+func SliceElem(s *header, i, size int32) uintptr {
+	SliceCheckIndex(i, SliceLen(s))
+	return s.data + uintptr(i*size)
+}
+
+func SliceMake(n, c, size, align, layout int32) *header {
+	if n < 0 {
+		panic("makeslice: len out of range")
+	}
+	if c < n {
+		panic("makeslice: cap out of range")
+	}
+	r := &header{}
+	r.data = uintptr(gc.AllocTyped(c*size, align, layout))
+	r.len = n
+	r.cap = c
+	return r
+}
+
+// SliceMakeLen returns make([]T, n).
+func SliceMakeLen(n, size, align, layout int32) *header {
+	return SliceMake(n, n, size, align, layout)
+}
+
+// SliceOfArray returns a slice of all n elements of an array.
+func SliceOfArray(data uintptr, n int32) *header {
+	r := &header{}
+	r.data = data
+	r.len = n
+	r.cap = n
+	return r
+}
+
+// SliceExpr3 returns s[i:j:k].
+func SliceExpr3(s *header, i, j, k, size int32) *header {
+	if i < 0 {
+		panic("slice bounds out of range")
+	}
+	if j < i {
+		panic("slice bounds out of range")
+	}
+	if k < j {
+		panic("slice bounds out of range")
+	}
+	if k > SliceCap(s) {
+		panic("slice bounds out of range")
+	}
+	if s == nil {
+		return nil
+	}
+	r := &header{}
+	r.data = s.data + uintptr(i*size)
+	r.len = j - i
+	r.cap = k - i
+	return r
+}
+
+// SliceExpr2 returns s[i:j].
+func SliceExpr2(s *header, i, j, size int32) *header {
+	return SliceExpr3(s, i, j, SliceCap(s), size)
+}
+
+// SliceExprFrom returns s[i:].
+func SliceExprFrom(s *header, i, size int32) *header {
+	return SliceExpr3(s, i, SliceLen(s), SliceCap(s), size)
+}
+
+// grow returns s extended by n elements. If s doesn't have enough capacity,
+// the elements are copied to a new array, which is at least twice as large.
+func grow(s *header, n, size, align, layout int32) *header {
+	l := SliceLen(s)
+	c := SliceCap(s)
+	r := &header{}
+	r.len = l + n
+	if l+n <= c {
+		r.data = s.data
+		r.cap = c
+		return r
+	}
+	c = 2 * c
+	if c < l+n {
+		c = l + n
+	}
+	r.data = uintptr(gc.AllocTyped(c*size, align, layout))
+	r.cap = c
+	if l > 0 {
+		gc.Memcpy(r.data, s.data, int(l*size))
+	}
+	return r
+}
+
+func SliceAppend1(s *header, v uint8) *header {
+	r := grow(s, 1, 1, 1, noPointers)
+	p := (*uint8)(unsafe.Pointer(r.data + uintptr(r.len-1)))
+	*p = v
+	return r
+}
+
+func SliceAppend2(s *header, v uint16) *header {
+	r := grow(s, 1, 2, 2, noPointers)
+	p := (*uint16)(unsafe.Pointer(r.data + uintptr((r.len-1)*2)))
+	*p = v
+	return r
+}
+
+func SliceAppend4(s *header, v int32) *header {
+	r := grow(s, 1, 4, 4, scanAll)
+	p := (*int32)(unsafe.Pointer(r.data + uintptr((r.len-1)*4)))
+	*p = v
+	return r
+}
+
+func SliceAppend8(s *header, v int64) *header {
+	r := grow(s, 1, 8, 8, noPointers)
+	p := (*int64)(unsafe.Pointer(r.data + uintptr((r.len-1)*8)))
+	*p = v
+	return r
+}
+
+// SliceAppendValue returns append(s, v) for an element that is a value in
+// memory, e.g., a struct, which is copied from src.
+func SliceAppendValue(s *header, src uintptr, size, align, layout int32) *header {
+	r := grow(s, 1, size, align, layout)
+	gc.Memcpy(r.data+uintptr((r.len-1)*size), src, int(size))
+	return r
+}
+
+// SliceAppendSlice returns append(s, t...).
+func SliceAppendSlice(s, t *header, size, align, layout int32) *header {
+	n := SliceLen(t)
+	if n == 0 {
+		return s
+	}
+	l := SliceLen(s)
+	r := grow(s, n, size, align, layout)
+	gc.Memcpy(r.data+uintptr(l*size), t.data, int(n*size))
+	return r
+}
+
+// SliceCopy copies the elements of src to dst and returns the number of
+// elements copied. The slices may overlap.
+func SliceCopy(dst, src *header, size int32) int32 {
+	n := SliceLen(dst)
+	if SliceLen(src) < n {
+		n = SliceLen(src)
+	}
+	if n == 0 {
+		return 0
+	}
+	bytes := uintptr(n * size)
+	if dst.data <= src.data {
+		gc.Memcpy(dst.data, src.data, int(bytes))
+		return n
+	}
+	for i := bytes; i > 0; i-- {
+		gc.Poke8(dst.data+i-1, gc.Peek8(src.data+i-1))
+	}
+	return n
+}
@@ -0,0 +1,271 @@
+// Package str implements the operations on strings that the compiler doesn't
+// generate inline.
+//
+// A string value is the address of its header, or 0 for the empty string.
+// String literals and their headers are in static memory, other strings are
+// allocated on the heap. Strings are immutable, so they can share bytes.
+package str
+
+import (
+	"gowasm/rt/gc"
+	"unsafe"
+)
+
+type header struct {
+	data uintptr
+	len  int32
+}
+
+//wasm:assert_return (invoke "StringLen" (i32.const 0)) (i32.const 0)
+func StringLen(s *header) int32 {
+	if s == nil {
+		return 0
+	}
+	return s.len
+}
+
+func StringIndex(s *header, i int32) uint8 {
+	if i < 0 {
+		panic("string index out of range")
+	}
+	if i >= StringLen(s) {
+		panic("string index out of range")
+	}
+	p := (*uint8)(unsafe.Pointer(s.data + uintptr(i)))
+	return *p
+}
+
+// StringSlice returns s[i:j].
+func StringSlice(s *header, i, j int32) *header {
+	if i < 0 {
+		panic("slice bounds out of range")
+	}
+	if j < i {
+		panic("slice bounds out of range")
+	}
+	if j > StringLen(s) {
+		panic("slice bounds out of range")
+	}
+	if i == j {
+		return nil
+	}
+	r := &header{}
+	r.data = s.data + uintptr(i)
+	r.len = j - i
+	return r
+}
+
+// StringSliceFrom returns s[i:].
+func StringSliceFrom(s *header, i int32) *header {
+	return StringSlice(s, i, StringLen(s))
+}
+
+func StringConcat(a, b *header) *header {
+	n := StringLen(a)
+	if n == 0 {
+		return b
+	}
+	m := StringLen(b)
+	if m == 0 {
+		return a
+	}
+	data := uintptr(gc.Alloc(n+m, 1))
+	gc.Memcpy(data, a.data, int(n))
+	gc.Memcpy(data+uintptr(n), b.data, int(m))
+	r := &header{}
+	r.data = data
+	r.len = n + m
+	return r
+}
+
+// sliceHeader is the representation of a []byte, see rt/slice.
+type sliceHeader struct {
+	data uintptr
+	len  int32
+	cap  int32
+}
+
+// StringFromRune returns string(r), the UTF-8 encoding of r, which is that of
+// U+FFFD if r isn't a valid code point.
+func StringFromRune(r int32) *header {
+	if r < 0 {
+		r = 0xfffd
+	}
+	if r > 0x10ffff {
+		r = 0xfffd
+	}
+	if r >= 0xd800 {
+		if r <= 0xdfff {
+			r = 0xfffd
+		}
+	}
+	n := int32(4)
+	lead := int32(0xf0)
+	if r < 0x80 {
+		n = 1
+		lead = 0
+	} else if r < 0x800 {
+		n = 2
+		lead = 0xc0
+	} else if r < 0x10000 {
+		n = 3
+		lead = 0xe0
+	}
+	data := uintptr(gc.Alloc(n, 1))
+	shift := (n - 1) * 6
+	store8(data, lead|r>>shift)
+	for i := int32(1); i < n; i++ {
+		shift = shift - 6
+		store8(data+uintptr(i), 0x80|r>>shift&0x3f)
+	}
+	s := &header{}
+	s.data = data
+	s.len = n
+	return s
+}
+
+func store8(addr uintptr, v int32) {
+	p := (*uint8)(unsafe.Pointer(addr))
+	*p = uint8(v)
+}
+
+// StringFromBytes returns string(b), which has a copy of the bytes of b.
+func StringFromBytes(b *sliceHeader) *header {
+	if b == nil {
+		return nil
+	}
+	if b.len == 0 {
+		return nil
+	}
+	data := uintptr(gc.Alloc(b.len, 1))
+	gc.Memcpy(data, b.data, int(b.len))
+	s := &header{}
+	s.data = data
+	s.len = b.len
+	return s
+}
+
+// StringToBytes returns []byte(s), which has a copy of the bytes of s.
+func StringToBytes(s *header) *sliceHeader {
+	n := StringLen(s)
+	b := &sliceHeader{}
+	if n > 0 {
+		b.data = uintptr(gc.Alloc(n, 1))
+		gc.Memcpy(b.data, s.data, int(n))
+	}
+	b.len = n
+	b.cap = n
+	return b
+}
+
+// StringCompare returns -1, 0 or 1 if a is less than, equal to or greater than
+// b, respectively.
+func StringCompare(a, b *header) int32 {
+	n := StringLen(a)
+	m := StringLen(b)
+	for i := int32(0); i < n; i++ {
+		if i >= m {
+			return 1
+		}
+		x := StringIndex(a, i)
+		y := StringIndex(b, i)
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	if n < m {
+		return -1
+	}
+	return 0
+}
+
+// runeWidth returns the length of the UTF-8 encoding at s[i], or 0 if the
+// bytes there aren't a valid encoding, e.g., a continuation byte, an overlong
+// encoding, a surrogate half or a truncated sequence.
+func runeWidth(s *header, i int32) int32 {
+	n := StringLen(s) - i
+	b := StringIndex(s, i)
+	if b < 0x80 {
+		return 1
+	}
+	if b < 0xc2 {
+		return 0
+	}
+	w := int32(4)
+	if b < 0xf0 {
+		w = 3
+	}
+	if b < 0xe0 {
+		w = 2
+	}
+	if b > 0xf4 {
+		return 0
+	}
+	if n < w {
+		return 0
+	}
+	// The range of the second byte excludes overlong encodings, surrogate
+	// halves and values above U+10FFFF.
+	lo := uint8(0x80)
+	hi := uint8(0xbf)
+	if b == 0xe0 {
+		lo = 0xa0
+	}
+	if b == 0xed {
+		hi = 0x9f
+	}
+	if b == 0xf0 {
+		lo = 0x90
+	}
+	if b == 0xf4 {
+		hi = 0x8f
+	}
+	c := StringIndex(s, i+1)
+	if c < lo {
+		return 0
+	}
+	if c > hi {
+		return 0
+	}
+	for j := int32(2); j < w; j++ {
+		c = StringIndex(s, i+j)
+		if c < 0x80 {
+			return 0
+		}
+		if c > 0xbf {
+			return 0
+		}
+	}
+	return w
+}
+
+// StringRune decodes the rune at s[i] for a range loop, or returns U+FFFD if
+// the bytes there aren't valid UTF-8.
+func StringRune(s *header, i int32) int32 {
+	w := runeWidth(s, i)
+	b := int32(StringIndex(s, i))
+	if w == 0 {
+		return 0xfffd
+	}
+	if w == 1 {
+		return b
+	}
+	r := b & (0x7f >> w)
+	for j := int32(1); j < w; j++ {
+		r = r<<6 | int32(StringIndex(s, i+j))&0x3f
+	}
+	return r
+}
+
+// StringNext returns the index of the rune after the one at s[i]. An invalid
+// byte is a rune of its own.
+func StringNext(s *header, i int32) int32 {
+	w := runeWidth(s, i)
+	if w == 0 {
+		return i + 1
+	}
+	return i + w
+}
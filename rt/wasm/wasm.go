@@ -11,3 +11,20 @@ func Print_int32(n int32) {
 func Print_int64(n int64) {
 	fmt.Printf("%d : i64\n", n)
 }
+
+// pages is the size of the emulated memory.
+var pages int32
+
+// MemorySize returns the size of the memory in 64 KiB pages. Calls are
+// compiled to the memory.size instruction.
+func MemorySize() int32 {
+	return pages
+}
+
+// MemoryGrow adds delta pages to the memory and returns its previous size, or
+// -1 if it can't grow. Calls are compiled to the memory.grow instruction.
+func MemoryGrow(delta int32) int32 {
+	old := pages
+	pages = pages + delta
+	return old
+}
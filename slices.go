@@ -0,0 +1,406 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+)
+
+// The operations on slices are implemented by the runtime package rt/slice.
+// The functions that need to know the element type take its size and
+// alignment as arguments.
+
+func isSlice(t types.Type) bool {
+	if t == nil {
+		return false
+	}
+	_, ok := t.Underlying().(*types.Slice)
+	return ok
+}
+
+// sliceType returns the type of a slice-valued expression.
+func (s *WasmScope) sliceType(expr ast.Expr) (*WasmTypeSlice, error) {
+	ty, err := s.f.file.convertType(s.f.file.info.TypeOf(expr))
+	if err != nil {
+		return nil, s.f.file.ErrorNode(expr, "%v", err)
+	}
+	slice, ok := ty.(*WasmTypeSlice)
+	if !ok {
+		return nil, s.f.file.ErrorNode(expr, "not a slice: %s", ty.getName())
+	}
+	return slice, nil
+}
+
+// createElementLayout returns literals with the size, the alignment and the
+// layout of the elements of a slice, see WasmModule.layout.
+func (s *WasmScope) createElementLayout(elementType WasmType, indent int) ([]WasmExpression, error) {
+	size, err := s.createLiteralInt32(int32(elementType.getSize()), indent)
+	if err != nil {
+		return nil, err
+	}
+	size.setComment("element size")
+	align, err := s.createLiteralInt32(int32(elementType.getAlign()), indent)
+	if err != nil {
+		return nil, err
+	}
+	align.setComment("element alignment")
+	layout, err := s.createLiteralInt32(s.f.module.elemLayout(elementType), indent)
+	if err != nil {
+		return nil, err
+	}
+	layout.setComment("element layout")
+	return []WasmExpression{size, align, layout}, nil
+}
+
+// parseSliceLen handles len and cap of a slice.
+func (s *WasmScope) parseSliceLen(call *ast.CallExpr, name string, indent int) (WasmExpression, error) {
+	args, err := s.parseArgs(call.Args, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	if name == "cap" {
+		return s.createRuntimeCall("slice", "SliceCap", args, call, indent)
+	}
+	return s.createRuntimeCall("slice", "SliceLen", args, call, indent)
+}
+
+func (s *WasmScope) parseMakeCall(call *ast.CallExpr, indent int) (WasmExpression, error) {
+	if !isSlice(s.f.file.info.TypeOf(call)) {
+		return nil, s.f.file.ErrorNode(call, "unimplemented make of %v", s.f.file.info.TypeOf(call))
+	}
+	ty, err := s.sliceType(call)
+	if err != nil {
+		return nil, err
+	}
+	args, err := s.parseArgs(call.Args[1:], indent+1)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := s.createElementLayout(ty.elementType, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, layout...)
+	if len(call.Args) == 2 {
+		return s.createTypedRuntimeCall("slice", "SliceMakeLen", args, call, indent)
+	}
+	return s.createTypedRuntimeCall("slice", "SliceMake", args, call, indent)
+}
+
+// literalIndices returns the index of each element of an array or slice
+// literal, whose keys are constant indices, and the length they need.
+func (s *WasmScope) literalIndices(lit *ast.CompositeLit) ([]int, int, error) {
+	indices := make([]int, len(lit.Elts))
+	length := 0
+	next := 0
+	for i, elt := range lit.Elts {
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			index, err := s.f.file.evaluateIntConstant(kv.Key)
+			if err != nil {
+				return nil, 0, s.f.file.ErrorNode(kv.Key, "%v", err)
+			}
+			next = index
+		}
+		indices[i] = next
+		next++
+		if next > length {
+			length = next
+		}
+	}
+	return indices, length, nil
+}
+
+// createArrayLit returns a new array of length elements of type elem with the
+// elements of a composite literal, which may have constant indices as keys.
+// The other elements are zero. An array of integer constants is initialized
+// with a copy from static memory.
+func (s *WasmScope) createArrayLit(lit *ast.CompositeLit, elem types.Type, length int, indent int) (*WasmSequence, error) {
+	indices, _, err := s.literalIndices(lit)
+	if err != nil {
+		return nil, err
+	}
+	ty, err := s.f.file.convertType(types.NewArray(elem, int64(length)))
+	if err != nil {
+		return nil, s.f.file.ErrorNode(lit, "%v", err)
+	}
+	arrayType := ty.(*WasmTypeArray)
+	size := int32(length * arrayType.elementType.getSize())
+	alloc, err := s.generateAlloc(size, int32(arrayType.elementType.getAlign()), lit, arrayType, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := s.createTempVar("lit", arrayType)
+	if err != nil {
+		return nil, err
+	}
+	tmp.setFullType(arrayType)
+	set, err := s.createSetVar(tmp, alloc, nil, indent)
+	if err != nil {
+		return nil, err
+	}
+	q := &WasmSequence{
+		stmts: []WasmExpression{set},
+	}
+	if bytes := s.constantArrayBytes(lit, arrayType.elementType); bytes != nil {
+		init, err := s.initFromStaticMemory(bytes, s.createGetLocal(tmp, nil, indent+1), arrayType.getAlign(), lit, indent)
+		if err != nil {
+			return nil, err
+		}
+		q.stmts = append(q.stmts, init)
+	} else {
+		for i, elt := range lit.Elts {
+			if kv, ok := elt.(*ast.KeyValueExpr); ok {
+				elt = kv.Value
+			}
+			val, err := s.parseExprAs(elt, elem, indent+1)
+			if err != nil {
+				return nil, err
+			}
+			index, err := s.createLiteralInt32(int32(indices[i]), indent+3)
+			if err != nil {
+				return nil, err
+			}
+			index.setComment("element index")
+			lvalue, err := s.createIndexExprLValue(index, s.createGetLocal(tmp, nil, indent+2), elt, indent)
+			if err != nil {
+				return nil, err
+			}
+			store, err := s.createStore(lvalue.addr, val, arrayType.elementType, nil, indent)
+			if err != nil {
+				return nil, s.f.file.ErrorNode(elt, "%v", err)
+			}
+			store.setComment(fmt.Sprintf("element #%d", indices[i]))
+			q.stmts = append(q.stmts, store)
+		}
+	}
+	q.value = s.createGetLocal(tmp, nil, indent)
+	q.setIndent(indent)
+	q.setScope(s)
+	q.setNode(lit)
+	q.setFullType(arrayType)
+	return q, nil
+}
+
+// constantArrayBytes returns the bytes of the elements of an array literal
+// without keys whose elements are integer constants, or nil.
+func (s *WasmScope) constantArrayBytes(lit *ast.CompositeLit, elem WasmType) []byte {
+	if _, ok := elem.(*WasmTypeScalar); !ok || elem.isFloat() || len(lit.Elts) == 0 {
+		return nil
+	}
+	var bytes []byte
+	for _, elt := range lit.Elts {
+		if _, ok := elt.(*ast.KeyValueExpr); ok {
+			return nil
+		}
+		v, err := s.f.file.evaluateIntConstant(elt)
+		if err != nil {
+			return nil
+		}
+		for j := 0; j < elem.getSize(); j++ {
+			bytes = append(bytes, byte(v))
+			v = v >> 8
+		}
+	}
+	return bytes
+}
+
+// parseSliceLit returns a slice of a new array with the elements of a
+// composite literal.
+func (s *WasmScope) parseSliceLit(lit *ast.CompositeLit, indent int) (WasmExpression, error) {
+	elem := s.f.file.info.TypeOf(lit).Underlying().(*types.Slice).Elem()
+	_, length, err := s.literalIndices(lit)
+	if err != nil {
+		return nil, err
+	}
+	q, err := s.createArrayLit(lit, elem, length, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	n32, err := s.createLiteralInt32(int32(length), indent+1)
+	if err != nil {
+		return nil, err
+	}
+	n32.setComment("length")
+	return s.createTypedRuntimeCall("slice", "SliceOfArray", []WasmExpression{q, n32}, lit, indent)
+}
+
+// appendFuncName returns the runtime function that appends an element of type
+// t to a slice.
+func appendFuncName(t WasmType) (string, error) {
+	if _, ok := t.(*WasmTypeStruct); ok {
+		return "SliceAppendValue", nil
+	}
+	switch t.getSize() {
+	case 1:
+		return "SliceAppend1", nil
+	case 2:
+		return "SliceAppend2", nil
+	case 4:
+		return "SliceAppend4", nil
+	case 8:
+		return "SliceAppend8", nil
+	}
+	return "", fmt.Errorf("unimplemented append of %s", t.getName())
+}
+
+// parseAppendCall handles append(s, x, y) as a chain of calls that append one
+// element each, i.e., SliceAppend(SliceAppend(s, x), y).
+func (s *WasmScope) parseAppendCall(call *ast.CallExpr, indent int) (WasmExpression, error) {
+	ty, err := s.sliceType(call)
+	if err != nil {
+		return nil, err
+	}
+	if call.Ellipsis.IsValid() {
+		if isString(s.f.file.info.TypeOf(call.Args[1])) {
+			return nil, s.f.file.ErrorNode(call, "unimplemented append of a string to a byte slice")
+		}
+		args, err := s.parseArgs(call.Args, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		layout, err := s.createElementLayout(ty.elementType, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		return s.createTypedRuntimeCall("slice", "SliceAppendSlice", append(args, layout...), call, indent)
+	}
+	name, err := appendFuncName(ty.elementType)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(call, "%v", err)
+	}
+	n := len(call.Args) - 1
+	result, err := s.parseExpr(call.Args[0], indent+n)
+	if err != nil {
+		return nil, err
+	}
+	elem := s.f.file.info.TypeOf(call).Underlying().(*types.Slice).Elem()
+	for i, arg := range call.Args[1:] {
+		v, err := s.parseExprAs(arg, elem, indent+n-i)
+		if err != nil {
+			return nil, err
+		}
+		v, err = s.createFloatBits(v, indent+n-i)
+		if err != nil {
+			return nil, err
+		}
+		args := []WasmExpression{result, v}
+		if name == "SliceAppendValue" {
+			layout, err := s.createElementLayout(ty.elementType, indent+n-i)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, layout...)
+		}
+		result, err = s.createTypedRuntimeCall("slice", name, args, call, indent+n-i-1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// createFloatBits reinterprets a float as the integer of the same size with
+// the same bits, which the runtime stores like any other integer. Other values
+// are returned as they are.
+func (s *WasmScope) createFloatBits(v WasmExpression, indent int) (WasmExpression, error) {
+	t := v.getType()
+	if !t.isFloat() {
+		return v, nil
+	}
+	name := "int32"
+	if t.getSize() == 8 {
+		name = "int64"
+	}
+	intType, err := s.f.module.scalarType(name)
+	if err != nil {
+		return nil, err
+	}
+	return s.createConvert(wasmTypeName(intType)+".reinterpret_"+wasmTypeName(t), v, intType, indent), nil
+}
+
+func (s *WasmScope) parseCopyCall(call *ast.CallExpr, indent int) (WasmExpression, error) {
+	if isString(s.f.file.info.TypeOf(call.Args[1])) {
+		return nil, s.f.file.ErrorNode(call, "unimplemented copy from a string")
+	}
+	ty, err := s.sliceType(call.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	args, err := s.parseArgs(call.Args, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := s.createElementLayout(ty.elementType, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createRuntimeCall("slice", "SliceCopy", append(args, layout[0]), call, indent)
+}
+
+// parseSliceExpr handles slice expressions. Slicing an array creates a slice
+// of the whole array first.
+func (s *WasmScope) parseSliceExpr(expr *ast.SliceExpr, indent int) (WasmExpression, error) {
+	if isString(s.f.file.info.TypeOf(expr.X)) {
+		return s.parseStringSliceExpr(expr, indent)
+	}
+	ty, err := s.sliceType(expr)
+	if err != nil {
+		return nil, err
+	}
+	var x WasmExpression
+	switch xt := s.f.file.info.TypeOf(expr.X).Underlying().(type) {
+	default:
+		return nil, s.f.file.ErrorNode(expr, "unimplemented slice expression of %v", xt)
+	case *types.Slice:
+		x, err = s.parseExpr(expr.X, indent+1)
+		if err != nil {
+			return nil, err
+		}
+	case *types.Array:
+		array, err := s.parseExpr(expr.X, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		n, err := s.createLiteralInt32(int32(xt.Len()), indent+2)
+		if err != nil {
+			return nil, err
+		}
+		n.setComment("array length")
+		x, err = s.createRuntimeCall("slice", "SliceOfArray", []WasmExpression{array, n}, expr.X, indent+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var low WasmExpression
+	if expr.Low == nil {
+		low, err = s.createLiteralInt32(0, indent+1)
+	} else {
+		low, err = s.parseExpr(expr.Low, indent+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	args := []WasmExpression{x, low}
+	name := "SliceExprFrom"
+	if expr.High != nil {
+		bounds, err := s.parseArgs([]ast.Expr{expr.High}, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, bounds...)
+		name = "SliceExpr2"
+	}
+	if expr.Slice3 {
+		bounds, err := s.parseArgs([]ast.Expr{expr.Max}, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, bounds...)
+		name = "SliceExpr3"
+	}
+	layout, err := s.createElementLayout(ty.elementType, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createTypedRuntimeCall("slice", name, append(args, layout[0]), expr, indent)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"go/ast"
 	"go/token"
+	"go/types"
 )
 
 // Expression list that may introduce new locals, e.g. block or loop.
@@ -19,19 +20,26 @@ type WasmNop struct {
 	WasmExprBase
 }
 
+// ( unreachable )
+type WasmUnreachable struct {
+	WasmExprBase
+}
+
 // ( block <expr>+ )
 // ( block <var> <expr>+ ) ;; = (label <var> (block <expr>+))
 type WasmBlock struct {
 	WasmExprBase
 	scope *WasmScope
+	label string
 	stmt  ast.Stmt
 }
 
-// ( return <expr>? )
+// ( return <expr>* )
 type WasmReturn struct {
 	WasmExprBase
-	value WasmExpression
-	stmt  *ast.ReturnStmt
+	values []WasmExpression
+	stmt   *ast.ReturnStmt
+	leave  WasmExpression // pops the shadow frame, see roots.go
 }
 
 // ( if <expr> <expr> <expr> )
@@ -53,15 +61,50 @@ type WasmLoop struct {
 	labelContinue string
 	cond          WasmExpression
 	body          *WasmBlock
-	stmt          *ast.ForStmt
+	stmt          ast.Stmt
 }
 
-// ( break <var> <expr>? )
+// ( br <var> <expr>? )
+// ( br_if <var> <expr>? <expr> )
 type WasmBreak struct {
 	WasmExprBase
 	scope *WasmScope
 	label string
 	v     int
+	cond  WasmExpression
+}
+
+// ( br_table <var>+ <expr> )
+type WasmBrTable struct {
+	WasmExprBase
+	labels       []string
+	labelDefault string
+	index        WasmExpression
+}
+
+// ( local.tee <var> <expr> )
+type WasmTeeLocal struct {
+	WasmExprBase
+	lhs  WasmVariable
+	rhs  WasmExpression
+	root WasmExpression // stores the local in its shadow slot, see roots.go
+}
+
+// Assigns the values left on the stack by value, e.g., a call returning
+// multiple results, to vars.
+type WasmTupleSet struct {
+	WasmExprBase
+	value WasmExpression
+	vars  []WasmVariable
+	stmt  ast.Stmt
+	roots []WasmExpression // store the vars in their shadow slots
+}
+
+// Target of break and continue statements, i.e., an enclosing loop or switch.
+type WasmBranchTarget struct {
+	name          string // label of the Go statement, if any
+	labelBreak    string
+	labelContinue string // empty for a switch
 }
 
 // ( set_local <var> <expr> )
@@ -70,6 +113,7 @@ type WasmSetLocal struct {
 	lhs  WasmVariable
 	rhs  WasmExpression
 	stmt ast.Stmt
+	root WasmExpression // stores the local in its shadow slot, see roots.go
 }
 
 func (f *WasmFunc) createScope(prefix string) *WasmScope {
@@ -86,17 +130,126 @@ func (f *WasmFunc) createScope(prefix string) *WasmScope {
 	return s
 }
 
+// gotoTarget is a labeled statement in a statement list that is the target
+// of a forward goto.
+type gotoTarget struct {
+	index int
+	name  string
+}
+
+// parseStatementList parses a list of statements. Forward gotos to a label in
+// the list branch out of a block that ends right before the labeled statement:
+//
+//	(block $label_L2
+//	  (block $label_L1
+//	    <statements before L1>
+//	  )
+//	  <statements from L1 to L2>
+//	)
+//	<statements from L2>
 func (s *WasmScope) parseStatementList(stmts []ast.Stmt, indent int) error {
+	targets := findGotoTargets(stmts)
+	if len(targets) == 0 {
+		return s.appendStatements(stmts, indent)
+	}
+	for _, t := range targets {
+		s.f.gotoLabels[t.name] = "label_" + t.name
+	}
+	var inner WasmExpression
+	start := 0
+	for k, t := range targets {
+		scope := s.f.createScope("goto_block")
+		scopeIndent := indent + len(targets) - k
+		if inner != nil {
+			scope.expressions = append(scope.expressions, inner)
+		}
+		err := scope.appendStatements(stmts[start:t.index], scopeIndent)
+		if err != nil {
+			return err
+		}
+		// Only forward jumps are supported.
+		delete(s.f.gotoLabels, t.name)
+		block := s.createBlock(scope, nil, scopeIndent-1)
+		block.label = "label_" + t.name
+		inner = block
+		start = t.index
+	}
+	s.expressions = append(s.expressions, inner)
+	return s.appendStatements(stmts[start:], indent)
+}
+
+func (s *WasmScope) appendStatements(stmts []ast.Stmt, indent int) error {
 	for _, stmt := range stmts {
 		expr, err := s.parseStmt(stmt, indent)
 		if err != nil {
-			return err
+			return s.f.file.errorAt(stmt, err)
 		}
 		s.expressions = append(s.expressions, expr...)
 	}
 	return nil
 }
 
+// findGotoTargets returns the labeled statements in stmts which are the
+// target of a goto in a preceding statement.
+func findGotoTargets(stmts []ast.Stmt) []gotoTarget {
+	var targets []gotoTarget
+	for j, stmt := range stmts {
+		l, ok := stmt.(*ast.LabeledStmt)
+		if !ok {
+			continue
+		}
+		found := false
+		for _, prev := range stmts[:j] {
+			ast.Inspect(prev, func(n ast.Node) bool {
+				if br, ok := n.(*ast.BranchStmt); ok && br.Tok == token.GOTO && br.Label.Name == l.Label.Name {
+					found = true
+				}
+				return !found
+			})
+		}
+		if found {
+			targets = append(targets, gotoTarget{index: j, name: l.Label.Name})
+		}
+	}
+	return targets
+}
+
+// pushBranchTarget makes a loop or a switch the target of break and continue
+// statements in its body. A pending label is attached to the target.
+func (f *WasmFunc) pushBranchTarget(labelBreak, labelContinue string) {
+	t := &WasmBranchTarget{
+		name:          f.pendingLabel,
+		labelBreak:    labelBreak,
+		labelContinue: labelContinue,
+	}
+	f.pendingLabel = ""
+	f.branchTargets = append(f.branchTargets, t)
+}
+
+func (f *WasmFunc) popBranchTarget() {
+	f.branchTargets = f.branchTargets[:len(f.branchTargets)-1]
+}
+
+// findBranchTarget returns the target of a break or continue statement, i.e.,
+// the innermost enclosing loop (or switch for break), or the one with the
+// given label.
+func (f *WasmFunc) findBranchTarget(label *ast.Ident, isContinue bool) *WasmBranchTarget {
+	for i := len(f.branchTargets) - 1; i >= 0; i-- {
+		t := f.branchTargets[i]
+		if label != nil && t.name != label.Name {
+			continue
+		}
+		if isContinue && t.labelContinue == "" {
+			if label != nil {
+				return nil
+			}
+			continue
+		}
+		return t
+	}
+	return nil
+}
+
 func (s *WasmScope) parseStmt(stmt ast.Stmt, indent int) ([]WasmExpression, error) {
 	var expr WasmExpression
 	var err error
@@ -104,21 +257,52 @@ func (s *WasmScope) parseStmt(stmt ast.Stmt, indent int) ([]WasmExpression, erro
 	default:
 		return nil, s.f.file.ErrorNode(stmt, "unimplemented statement")
 	case *ast.AssignStmt:
+		if len(stmt.Rhs) == 1 && s.isBlocking(stmt.Rhs[0]) {
+			return s.parseBlockingStmt(stmt, indent)
+		}
 		return s.parseAssignStmt(stmt, indent)
 	case *ast.BlockStmt:
 		expr, err = s.parseBlockStmt(stmt, indent)
+	case *ast.BranchStmt:
+		expr, err = s.parseBranchStmt(stmt, indent)
 	case *ast.DeclStmt:
 		expr, err = s.parseDeclStmt(stmt, indent)
+	case *ast.DeferStmt:
+		expr, err = s.parseDeferStmt(stmt, indent)
 	case *ast.ExprStmt:
+		if s.isBlocking(stmt.X) {
+			return s.parseBlockingStmt(stmt, indent)
+		}
 		expr, err = s.parseExprStmt(stmt, indent)
 	case *ast.ForStmt:
 		expr, err = s.parseForStmt(stmt, indent)
+	case *ast.GoStmt:
+		expr, err = s.parseGoStmt(stmt, indent)
+	case *ast.RangeStmt:
+		if isChan(s.f.file.info.TypeOf(stmt.X)) {
+			expr, err = s.parseChanRangeStmt(stmt, indent)
+			break
+		}
+		expr, err = s.parseRangeStmt(stmt, indent)
 	case *ast.IfStmt:
 		expr, err = s.parseIfStmt(stmt, indent)
 	case *ast.IncDecStmt:
-		expr, err = s.parseIncDecStmt(stmt, indent)
+		return s.parseIncDecStmt(stmt, indent)
+	case *ast.LabeledStmt:
+		return s.parseLabeledStmt(stmt, indent)
 	case *ast.ReturnStmt:
+		if len(stmt.Results) == 1 && s.isBlocking(stmt.Results[0]) {
+			return s.parseBlockingReturn(stmt, indent)
+		}
 		expr, err = s.parseReturnStmt(stmt, indent)
+	case *ast.SelectStmt:
+		expr, err = s.parseSelectStmt(stmt, indent)
+	case *ast.SendStmt:
+		return s.parseSendStmt(stmt, indent)
+	case *ast.SwitchStmt:
+		expr, err = s.parseSwitchStmt(stmt, indent)
+	case *ast.TypeSwitchStmt:
+		expr, err = s.parseTypeSwitchStmt(stmt, indent)
 	}
 	if err != nil {
 		return nil, err
@@ -132,13 +316,21 @@ func (s *WasmScope) createNop(indent int) *WasmNop {
 	return n
 }
 
+func (s *WasmScope) createUnreachable(node ast.Node, indent int) *WasmUnreachable {
+	u := &WasmUnreachable{}
+	u.setIndent(indent)
+	u.setNode(node)
+	u.setScope(s)
+	return u
+}
+
 func (s *WasmScope) parseDefineAssignLHS(lhs []ast.Expr, ty WasmType, indent int) (WasmVariable, error) {
 	if len(lhs) != 1 {
 		return nil, fmt.Errorf("unimplemented multi-value LHS in AssignStmt")
 	}
 	switch lhs := lhs[0].(type) {
 	default:
-		return nil, fmt.Errorf("unimplemented LHS in define-assignment: %v at %s", lhs, positionString(lhs.Pos(), s.f.fset))
+		return nil, s.f.file.ErrorNode(lhs, "unimplemented LHS in define-assignment")
 	case *ast.Ident:
 		return s.createLocalVar(lhs, ty)
 	}
@@ -150,29 +342,35 @@ func (s *WasmScope) parseAssignLHS(lhs []ast.Expr, ty WasmType, indent int) (Was
 	}
 	var lvalue *LValue
 	var err error
-	switch lhs := lhs[0].(type) {
+	switch lhs := s.f.file.unqualified(lhs[0]).(type) {
 	default:
-		return nil, nil, fmt.Errorf("unimplemented LHS in assignment: %v at %s", lhs, positionString(lhs.Pos(), s.f.fset))
+		return nil, nil, s.f.file.ErrorNode(lhs, "unimplemented LHS in assignment")
 	case *ast.Ident:
-		v, ok := s.f.module.variables[lhs.Obj]
+		v, ok := s.f.module.variables[s.f.file.objectOf(lhs)]
 		if !ok {
 			return nil, nil, fmt.Errorf("couldn't find variable '%s' on the LHS of an assignment", lhs.Name)
 		}
 		return v, nil, nil
 	case *ast.IndexExpr:
-		lvalue, err = s.parseIndexExprLValue(lhs, nil, indent)
+		lvalue, err = s.parseIndexExprLValue(lhs, indent)
 	case *ast.SelectorExpr:
-		lvalue, err = s.parseSelectorExprLValue(lhs, nil, indent)
+		lvalue, err = s.parseSelectorExprLValue(lhs, indent)
 	case *ast.StarExpr:
-		lvalue, err = s.parseStarExprLValue(lhs, nil, indent)
+		lvalue, err = s.parseStarExprLValue(lhs, indent)
 	}
 	if err != nil {
-		return nil, nil, fmt.Errorf("error in LValue computation for LHS %v: %v", lhs[0], err)
+		return nil, nil, fmt.Errorf("error in LValue computation for LHS %v: %w", lhs[0], err)
 	}
 	return nil, lvalue, nil
 }
 
 func (s *WasmScope) parseAssignToLvalue(lvalue *LValue, rhs WasmExpression, stmt *ast.AssignStmt, indent int) (WasmExpression, error) {
+	if st, ok := lvalue.t.(*WasmTypeStruct); ok {
+		return s.createStructStore(lvalue.addr, rhs, st, stmt, indent)
+	}
+	if isInlineArray(lvalue.t) {
+		return s.createStore(lvalue.addr, rhs, lvalue.t, stmt, indent)
+	}
 	return s.createStore(lvalue.addr, rhs, rhs.getType(), stmt, indent)
 }
 
@@ -192,92 +390,29 @@ func (s *WasmScope) initFromStaticMemory(bytes []byte, dst WasmExpression, align
 	return s.generateMemcpy(dst, src, n, node, indent)
 }
 
-func (s *WasmScope) initValuesIfNeeded(v WasmVariable, expr WasmExpression, rhs ast.Expr, stmt *ast.AssignStmt, indent int) ([]WasmExpression, error) {
-	exprList := []WasmExpression{expr}
-	staticMemInitPossible := true
-	var arrayElementSize int
-	var arrayAlign int
-	bytes := []byte{}
-	if v != nil {
-		if v.getFullType() != nil {
-			switch ty := v.getFullType().(type) {
-			default:
-				staticMemInitPossible = false
-			case *WasmTypeArray:
-				arrayAlign = ty.getAlign()
-				switch elemType := ty.elementType.(type) {
-				default:
-					staticMemInitPossible = false
-				case *WasmTypeScalar:
-					arrayElementSize = elemType.getSize()
-				}
-			}
-		} else {
-			staticMemInitPossible = false
-		}
-	} else {
-		staticMemInitPossible = false
+func (s *WasmScope) parseAssignStmt(stmt *ast.AssignStmt, indent int) ([]WasmExpression, error) {
+	if len(stmt.Lhs) > 1 {
+		return s.parseTupleAssignStmt(stmt, indent)
 	}
-	switch rhs := rhs.(type) {
-	default:
-	case *ast.CompositeLit:
-		for i, astExpr := range rhs.Elts {
-			val, err := s.parseExpr(astExpr, nil, indent+1)
-			if err != nil {
-				return nil, err
-			}
-			if staticMemInitPossible {
-				v, err := s.f.file.evaluateIntConstant(astExpr)
-				if err != nil {
-					// Not a compile-time, constant
-					staticMemInitPossible = false
-				} else {
-					for j := 0; j < arrayElementSize; j++ {
-						b := byte(v & 0xff)
-						bytes = append(bytes, b)
-						v = v >> 8
-					}
-
-				}
-			}
-			x := s.createGetLocal(v, rhs, indent+2)
-			index, err := s.createLiteralInt32(int32(i), indent+3)
-			if err != nil {
-				return nil, err
-			}
-			index.setComment("element index")
-			lvalue, err := s.createIndexExprLValue(index, x, rhs, nil, indent)
-			if err != nil {
-				return nil, err
-			}
-			initExpr, err := s.parseAssignToLvalue(lvalue, val, stmt, indent)
-			if err != nil {
-				return nil, err
-			}
-			initExpr.setComment(fmt.Sprintf("initialize array element #%d", i))
-			exprList = append(exprList, initExpr)
-		}
+	if len(stmt.Rhs) != 1 {
+		return nil, s.f.file.ErrorNode(stmt, "assignment mismatch: 1 variable but %d values", len(stmt.Rhs))
 	}
-	if staticMemInitPossible {
-		dst := s.createGetLocal(v, rhs, indent+1)
-		staticInit, err := s.initFromStaticMemory(bytes, dst, arrayAlign, stmt, indent)
+	if isBlankIdent(stmt.Lhs[0]) {
+		// The value is evaluated and dropped.
+		expr, err := s.parseExprStmt(&ast.ExprStmt{X: stmt.Rhs[0]}, indent)
 		if err != nil {
 			return nil, err
 		}
-		exprList = []WasmExpression{expr, staticInit}
+		return []WasmExpression{expr}, nil
 	}
-	return exprList, nil
-}
-
-func (s *WasmScope) parseAssignStmt(stmt *ast.AssignStmt, indent int) ([]WasmExpression, error) {
-	if len(stmt.Lhs) != 1 || len(stmt.Rhs) != 1 {
-		return nil, fmt.Errorf("unimplemented multi-value AssignStmt")
+	if isStruct(s.f.file.info.TypeOf(stmt.Lhs[0])) {
+		return s.parseStructAssignStmt(stmt, indent)
 	}
 
 	var err error
-	rhs, err := s.parseExpr(stmt.Rhs[0], nil, indent+1)
+	rhs, err := s.parseExprAs(stmt.Rhs[0], s.f.file.info.TypeOf(stmt.Lhs[0]), indent+1)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing RHS of an assignment: %v", err)
+		return nil, fmt.Errorf("error parsing RHS of an assignment: %w", err)
 	}
 	ty := rhs.getType()
 	if ty == nil {
@@ -306,13 +441,260 @@ func (s *WasmScope) parseAssignStmt(stmt *ast.AssignStmt, indent int) ([]WasmExp
 	if err != nil {
 		return nil, err
 	}
-	return s.initValuesIfNeeded(v, expr, stmt.Rhs[0], stmt, indent)
+	return []WasmExpression{expr}, nil
+}
+
+// parseTupleAssignStmt parses an assignment with multiple values on the left,
+// e.g., a, b = b, a or v, ok := f(). All values on the right are evaluated
+// into temporary locals before any of them is assigned.
+func (s *WasmScope) parseTupleAssignStmt(stmt *ast.AssignStmt, indent int) ([]WasmExpression, error) {
+	if stmt.Tok != token.ASSIGN && stmt.Tok != token.DEFINE {
+		return nil, s.f.file.ErrorNode(stmt, "assignment operation %v requires single-valued expressions", stmt.Tok)
+	}
+	exprs := make([]WasmExpression, 0, 2*len(stmt.Lhs))
+	temps := make([]WasmVariable, 0, len(stmt.Lhs))
+	// The operands of index expressions and pointer indirections on the left
+	// are evaluated before the values, e.g., i, a[i] = 1, 2 assigns a[0].
+	lvalues := make([]*LValue, len(stmt.Lhs))
+	for i, lhs := range stmt.Lhs {
+		if _, ok := s.f.file.unqualified(lhs).(*ast.Ident); ok {
+			continue
+		}
+		lvalue, sets, err := s.evalLValueOperands(lhs, indent)
+		if err != nil {
+			return nil, err
+		}
+		lvalues[i] = lvalue
+		exprs = append(exprs, sets...)
+	}
+	// The type of v in v, ok := m[k] and in v, ok := x.(T) for a boxed T,
+	// which is loaded from an address.
+	var loadType WasmType
+	// The type of v in v, ok := x.(T), which the runtime returns as a uintptr.
+	var assertType WasmType
+	if len(stmt.Rhs) == 1 {
+		var rhs WasmExpression
+		var err error
+		if index, ok := stmt.Rhs[0].(*ast.IndexExpr); ok && isMap(s.f.file.info.TypeOf(index.X)) {
+			rhs, loadType, err = s.parseMapIndexOk(index, indent+1)
+		} else if assert, ok := stmt.Rhs[0].(*ast.TypeAssertExpr); ok {
+			rhs, err = s.createTypeAssert(assert, true, indent+1)
+			if err == nil {
+				assertType = rhs.getFullType()
+			}
+			if isBoxed(assertType) {
+				loadType, assertType = assertType, nil
+			}
+		} else {
+			rhs, err = s.parseExpr(stmt.Rhs[0], indent+1)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing RHS of an assignment: %w", err)
+		}
+		types := resultTypes(rhs)
+		if len(types) != len(stmt.Lhs) {
+			return nil, s.f.file.ErrorNode(stmt, "assignment mismatch: %d variables but %d values", len(stmt.Lhs), len(types))
+		}
+		for _, ty := range types {
+			v, err := s.createTempVar("tuple", ty)
+			if err != nil {
+				return nil, err
+			}
+			v.setFullType(ty)
+			temps = append(temps, v)
+		}
+		exprs = append(exprs, s.createTupleSet(rhs, temps, stmt, indent))
+	} else {
+		if len(stmt.Rhs) != len(stmt.Lhs) {
+			return nil, s.f.file.ErrorNode(stmt, "assignment mismatch: %d variables but %d values", len(stmt.Lhs), len(stmt.Rhs))
+		}
+		for i, r := range stmt.Rhs {
+			rhs, err := s.parseExprAs(r, s.f.file.info.TypeOf(stmt.Lhs[i]), indent+2)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing RHS of an assignment: %w", err)
+			}
+			if st, ok := rhs.getFullType().(*WasmTypeStruct); ok {
+				// The values are copied before the variables change.
+				rhs, err = s.createStructCopy(rhs, st, r, indent+2)
+				if err != nil {
+					return nil, err
+				}
+			}
+			v, err := s.createTempVar("tuple", rhs.getType())
+			if err != nil {
+				return nil, err
+			}
+			set, err := s.createSetVar(v, rhs, stmt, indent)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, set)
+			temps = append(temps, v)
+		}
+	}
+
+	for i, lhs := range stmt.Lhs {
+		if isBlankIdent(lhs) {
+			continue
+		}
+		var value WasmExpression = s.createGetLocal(temps[i], nil, indent+1)
+		ty := temps[i].getType()
+		if i == 0 && loadType != nil {
+			load, err := s.createLoad(s.createGetLocal(temps[i], nil, indent+2), loadType, indent+1)
+			if err != nil {
+				return nil, s.f.file.ErrorNode(stmt, "%v", err)
+			}
+			value = load
+			ty = loadType
+		}
+		if i == 0 && assertType != nil {
+			value.setFullType(assertType)
+			ty = assertType
+		}
+		set, err := s.assignValue(lhs, lvalues[i], value, ty, stmt, indent)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, set)
+	}
+	return exprs, nil
+}
+
+// evalLValueOperands returns the location of lhs, whose operands are saved in
+// temporary variables by the returned expressions. An element of a map is
+// added by the assignment, i.e., the location is computed from the saved map
+// and key when it is used.
+func (s *WasmScope) evalLValueOperands(lhs ast.Expr, indent int) (*LValue, []WasmExpression, error) {
+	ptrType, err := s.f.module.scalarType("uintptr")
+	if err != nil {
+		return nil, nil, err
+	}
+	save := func(x WasmExpression, t WasmType) (WasmVariable, WasmExpression, error) {
+		v, err := s.createTempVar("lhs", t)
+		if err != nil {
+			return nil, nil, err
+		}
+		set, err := s.createSetVar(v, x, nil, indent)
+		return v, set, err
+	}
+	if index, ok := lhs.(*ast.IndexExpr); ok && isMap(s.f.file.info.TypeOf(index.X)) {
+		ty, err := s.mapType(index.X)
+		if err != nil {
+			return nil, nil, err
+		}
+		x, err := s.parseExpr(index.X, indent+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := s.parseExprAs(index.Index, s.f.file.info.TypeOf(index.X).Underlying().(*types.Map).Key(), indent+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		m, setMap, err := save(x, ptrType)
+		if err != nil {
+			return nil, nil, err
+		}
+		m.setFullType(ty)
+		k, setKey, err := save(key, key.getType())
+		if err != nil {
+			return nil, nil, err
+		}
+		lvalue, err := s.createMapAssignLValue(s.createGetLocal(k, nil, indent+2), s.createGetLocal(m, nil, indent+2), ty, lhs, indent+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		return lvalue, []WasmExpression{setMap, setKey}, nil
+	}
+	_, lvalue, err := s.parseAssignLHS([]ast.Expr{lhs}, nil, indent+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	addr, set, err := save(lvalue.addr, ptrType)
+	if err != nil {
+		return nil, nil, err
+	}
+	lvalue.addr = s.createGetLocal(addr, nil, indent+1)
+	return lvalue, []WasmExpression{set}, nil
+}
+
+// assignValue assigns one of the values of a tuple assignment to lhs, which
+// := may declare, or to lvalue, the location of lhs if it was computed before
+// the values.
+func (s *WasmScope) assignValue(lhs ast.Expr, lvalue *LValue, value WasmExpression, ty WasmType, stmt *ast.AssignStmt, indent int) (WasmExpression, error) {
+	if lvalue != nil {
+		if st, ok := lvalue.t.(*WasmTypeStruct); ok {
+			return s.createStructStore(lvalue.addr, value, st, stmt, indent)
+		}
+		return s.createStore(lvalue.addr, value, ty, stmt, indent)
+	}
+	if st, ok := value.getFullType().(*WasmTypeStruct); ok {
+		// The values of several expressions have been copied already.
+		return s.assignStruct(lhs, value, len(stmt.Rhs) > 1, st, stmt, indent)
+	}
+	if ident, ok := lhs.(*ast.Ident); ok && stmt.Tok == token.DEFINE && s.f.file.info.Defs[ident] != nil {
+		v, err := s.createLocalVar(ident, ty)
+		if err != nil {
+			return nil, err
+		}
+		return s.createSetVar(v, value, stmt, indent)
+	}
+	v, lvalue, err := s.parseAssignLHS([]ast.Expr{lhs}, ty, indent)
+	if err != nil {
+		return nil, err
+	}
+	if lvalue != nil {
+		return s.createStore(lvalue.addr, value, ty, stmt, indent)
+	}
+	return s.createSetVar(v, value, stmt, indent)
+}
+
+// createTeeLocal returns an expression that assigns rhs to v and produces
+// its value.
+func (s *WasmScope) createTeeLocal(v WasmVariable, rhs WasmExpression, indent int) *WasmTeeLocal {
+	t := &WasmTeeLocal{
+		lhs: v,
+		rhs: rhs,
+	}
+	t.setIndent(indent)
+	t.setScope(s)
+	t.setFullType(rhs.getFullType())
+	v.setFullType(rhs.getFullType())
+	return t
+}
+
+func (s *WasmScope) createTupleSet(value WasmExpression, vars []WasmVariable, stmt ast.Stmt, indent int) *WasmTupleSet {
+	t := &WasmTupleSet{
+		value: value,
+		vars:  vars,
+		stmt:  stmt,
+	}
+	value.setIndent(indent)
+	t.setIndent(indent)
+	t.setScope(s)
+	t.setNode(stmt)
+	return t
 }
 
 func (s *WasmScope) createStore(addr, val WasmExpression, t WasmType, stmt ast.Stmt, indent int) (WasmExpression, error) {
+	if st, ok := t.(*WasmTypeStruct); ok {
+		return s.createStructStore(addr, val, st, stmt, indent)
+	}
+	if isInlineArray(t) {
+		n, err := s.createLiteralInt32(int32(t.getSize()), indent+1)
+		if err != nil {
+			return nil, err
+		}
+		n.setComment(fmt.Sprintf("size of %s", t.getName()))
+		return s.generateMemcpy(addr, val, n, stmt, indent)
+	}
+	if _, err := storeOpName(t); err != nil {
+		return nil, err
+	}
+	addr, offset := splitOffset(addr)
 	store := &WasmStore{
-		addr: addr,
-		val:  val,
+		addr:   addr,
+		val:    val,
+		offset: offset,
 	}
 	store.setType(t)
 	store.setIndent(indent)
@@ -330,7 +712,7 @@ func (s *WasmScope) createSetVar(v WasmVariable, rhs WasmExpression, stmt ast.St
 		if err != nil {
 			return nil, fmt.Errorf("couldn't create address for global %s", v.getName())
 		}
-		store, err := s.createStore(addr, rhs, addr.getType(), stmt, indent)
+		store, err := s.createStore(addr, rhs, v.getType(), stmt, indent)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't generate a store for global %s", v.getName())
 		}
@@ -343,6 +725,8 @@ func (s *WasmScope) createSetVar(v WasmVariable, rhs WasmExpression, stmt ast.St
 		}
 		sg.setIndent(indent)
 		return sg, nil
+	case *WasmCellVar:
+		return s.createCellSet(v, rhs, stmt, indent)
 	case *WasmLocal:
 	case *WasmParam:
 	}
@@ -359,12 +743,25 @@ func (s *WasmScope) createSetVar(v WasmVariable, rhs WasmExpression, stmt ast.St
 	return sl, nil
 }
 
+// varSpec returns the spec of a declaration of a single variable.
+func varSpec(node ast.Node) *ast.ValueSpec {
+	if decl, ok := node.(*ast.GenDecl); ok && len(decl.Specs) == 1 {
+		spec, _ := decl.Specs[0].(*ast.ValueSpec)
+		return spec
+	}
+	return nil
+}
+
 func (s *WasmScope) genVarInit(v WasmVariable, stmt *ast.DeclStmt, node ast.Node, indent int) (WasmExpression, error) {
 	var initValue WasmExpression
 	var err error
 	switch ty := v.getType().(type) {
 	default:
-		initValue, err = s.createNilLiteral(v.getType(), indent+2)
+		if spec := varSpec(node); spec != nil && len(spec.Values) == 1 {
+			initValue, err = s.parseExprAs(spec.Values[0], s.f.file.info.TypeOf(spec.Names[0]), indent+1)
+		} else {
+			initValue, err = s.createNilLiteral(v.getType(), indent+2)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -373,7 +770,16 @@ func (s *WasmScope) genVarInit(v WasmVariable, stmt *ast.DeclStmt, node ast.Node
 		align := ty.elementType.getAlign()
 		initValue, err = s.generateAlloc(size, int32(align), node, ty, indent+1)
 		if err != nil {
-			return nil, fmt.Errorf("couldn't generate array alloc: %v", err)
+			return nil, fmt.Errorf("couldn't generate array alloc: %w", err)
+		}
+	case *WasmTypeStruct:
+		if spec := varSpec(node); spec != nil && len(spec.Values) == 1 {
+			initValue, err = s.parseStructValue(spec.Values[0], ty, indent+1)
+		} else {
+			initValue, err = s.createStructAlloc(ty, node, indent+1)
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
 	expr, err := s.createSetVar(v, initValue, stmt, indent)
@@ -393,6 +799,9 @@ func (s *WasmScope) parseDeclStmt(stmt *ast.DeclStmt, indent int) (WasmExpressio
 		switch decl.Tok {
 		default:
 			return nil, s.f.file.ErrorNode(decl, "unimplemented decl token '%v'", decl.Tok)
+		case token.CONST:
+			// Uses of constants are replaced by their values.
+			return s.createNop(indent), nil
 		case token.VAR:
 			v, err := s.parseAstVarDeclLocal(decl)
 			if err != nil {
@@ -404,9 +813,9 @@ func (s *WasmScope) parseDeclStmt(stmt *ast.DeclStmt, indent int) (WasmExpressio
 }
 
 func (s *WasmScope) parseExprStmt(stmt *ast.ExprStmt, indent int) (WasmExpression, error) {
-	expr, err := s.parseExpr(stmt.X, nil, indent)
+	expr, err := s.parseExpr(stmt.X, indent)
 	if err != nil {
-		return nil, fmt.Errorf("error in ExprStmt: %v", err)
+		return nil, fmt.Errorf("error in ExprStmt: %w", err)
 	}
 	return expr, nil
 }
@@ -418,41 +827,78 @@ func (s *WasmScope) parseForStmt(stmt *ast.ForStmt, indent int) (WasmExpression,
 		init := []ast.Stmt{stmt.Init}
 		err = outerScope.parseStatementList(init, indent+1)
 		if err != nil {
-			return nil, fmt.Errorf("error in the init part of a loop: %v", err)
+			return nil, fmt.Errorf("error in the init part of a loop: %w", err)
 		}
 	}
 
-	cond, err := s.parseExpr(stmt.Cond, nil, indent+3)
-	if err != nil {
-		return nil, fmt.Errorf("error in the condition of a loop: %v", err)
-	}
 	scope := s.f.createScope("loop")
-	labelBreak := scope.name + "_break"
-	labelContinue := scope.name + "_continue"
-	b := &WasmBreak{
-		scope: scope,
-		label: labelBreak,
-	}
-	b.setIndent(indent + 3)
-	ifStmt, err := s.createIf(cond, s.createNop(indent+3), b, indent+2)
-	if err != nil {
-		return nil, fmt.Errorf("error in the condition stmt of a loop: %v", err)
+	if stmt.Cond != nil {
+		cond, err := s.parseExpr(stmt.Cond, indent+4)
+		if err != nil {
+			return nil, fmt.Errorf("error in the condition of a loop: %w", err)
+		}
+		exitCond, err := s.createNegation(cond, indent+3)
+		if err != nil {
+			return nil, fmt.Errorf("error in the condition of a loop: %w", err)
+		}
+		scope.appendLoopExit(exitCond, indent+2)
 	}
-	scope.expressions = append(scope.expressions, ifStmt)
 
-	err = scope.parseStatementList(stmt.Body.List, indent+2)
+	err = scope.parseLoopBody(stmt.Body, indent+2)
 	if err != nil {
-		return nil, fmt.Errorf("error in the body of a loop: %v", err)
+		return nil, fmt.Errorf("error in the body of a loop: %w", err)
 	}
 
+	if stmt.Init != nil {
+		err = scope.appendIterationCells(stmt.Init, indent+2)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if stmt.Post != nil {
 		post := []ast.Stmt{stmt.Post}
 		err = scope.parseStatementList(post, indent+2)
 		if err != nil {
-			return nil, fmt.Errorf("error in the post part of a loop: %v", err)
+			return nil, fmt.Errorf("error in the post part of a loop: %w", err)
 		}
 	}
 
+	return s.createLoop(outerScope, scope, stmt, indent), nil
+}
+
+// parseLoopBody parses the body of the loop s into a block. A continue
+// statement branches to the end of the block, so that the post statement of
+// the loop is executed.
+func (s *WasmScope) parseLoopBody(body *ast.BlockStmt, indent int) error {
+	s.f.pushBranchTarget(s.name+"_break", s.name+"_next")
+	defer s.f.popBranchTarget()
+	scope := s.f.createScope("loop_body")
+	err := scope.parseStatementList(body.List, indent+1)
+	if err != nil {
+		return err
+	}
+	block := s.createBlock(scope, body, indent)
+	block.label = s.name + "_next"
+	s.expressions = append(s.expressions, block)
+	return nil
+}
+
+// appendLoopExit adds a branch out of the loop s if exitCond is true.
+func (s *WasmScope) appendLoopExit(exitCond WasmExpression, indent int) {
+	b := &WasmBreak{
+		scope: s,
+		label: s.name + "_break",
+		cond:  exitCond,
+	}
+	b.setIndent(indent)
+	s.expressions = append(s.expressions, b)
+}
+
+// createLoop wraps the body of a loop in scope, including the exit check and
+// the post statement, in a loop preceded by the init statements in outerScope.
+func (s *WasmScope) createLoop(outerScope, scope *WasmScope, stmt ast.Stmt, indent int) *WasmBlock {
+	labelBreak := scope.name + "_break"
+	labelContinue := scope.name + "_continue"
 	cont := &WasmBreak{
 		scope: scope,
 		label: labelContinue,
@@ -467,35 +913,259 @@ func (s *WasmScope) parseForStmt(stmt *ast.ForStmt, indent int) (WasmExpression,
 		labelContinue: labelContinue,
 	}
 	l.setIndent(indent + 1)
-
-	if outerScope == nil {
-		return nil, fmt.Errorf("loops with no init are not implemented")
-	}
 	outerScope.expressions = append(outerScope.expressions, l)
-	outerBlock := s.createBlock(outerScope, stmt, indent)
-
-	return outerBlock, nil
+	return s.createBlock(outerScope, stmt, indent)
 }
 
-func (s *WasmScope) createBlock(scope *WasmScope, stmt ast.Stmt, indent int) *WasmBlock {
-	b := &WasmBlock{
-		scope: scope,
-		stmt:  stmt,
+// parseRangeStmt lowers a range loop over an array, a slice or an integer count
+// onto a counting loop. The range expression is evaluated once, before the
+// loop.
+func (s *WasmScope) parseRangeStmt(stmt *ast.RangeStmt, indent int) (WasmExpression, error) {
+	xt := s.f.file.info.TypeOf(stmt.X)
+	if isMap(xt) {
+		return s.parseMapRangeStmt(stmt, indent)
 	}
-	b.setIndent(indent)
-	return b
-}
+	if isString(xt) {
+		return s.parseStringRangeStmt(stmt, indent)
+	}
+	outerScope := s.f.createScope("loop_block")
+	scope := s.f.createScope("loop")
 
-func (s *WasmScope) parseBlockStmt(stmt *ast.BlockStmt, indent int) (*WasmBlock, error) {
-	scope := s.f.createScope("block")
-	err := scope.parseStatementList(stmt.List, indent+1)
+	x, err := outerScope.parseExpr(stmt.X, indent+2)
+	if err != nil {
+		return nil, fmt.Errorf("error in the range expression of a loop: %w", err)
+	}
+	xType := x.getFullType()
+	if xType == nil {
+		xType = x.getType()
+	}
+	intType, err := s.f.module.convertAstTypeNameToWasmType("int")
 	if err != nil {
 		return nil, err
 	}
-	return s.createBlock(scope, stmt, indent), nil
-}
 
-func (s *WasmScope) createIf(cond, body, bodyElse WasmExpression, indent int) (*WasmIf, error) {
+	var length WasmExpression
+	var elementType WasmType
+	var rangeVar WasmVariable
+	switch ty := xType.(type) {
+	default:
+		return nil, s.f.file.ErrorNode(stmt.X, "unsupported type in a range loop: %s", ty.getName())
+	case *WasmTypeArray:
+		elementType = ty.elementType
+		length, err = outerScope.createLiteral(fmt.Sprintf("%d", ty.length), intType, indent+4)
+		if err != nil {
+			return nil, err
+		}
+		length.setComment("array length")
+		if value := stmt.Value; value != nil && !isBlankIdent(value) {
+			// The values are those of the array when the loop starts.
+			x, err = outerScope.createArrayCopy(x, ty, stmt.X, indent+2)
+			if err != nil {
+				return nil, err
+			}
+		}
+		rangeVar, err = outerScope.createTempVar("range", x.getType())
+		if err != nil {
+			return nil, err
+		}
+		set, err := outerScope.createSetVar(rangeVar, x, stmt, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		outerScope.expressions = append(outerScope.expressions, set)
+	case *WasmTypeSlice:
+		elementType = ty.elementType
+		rangeVar, err = outerScope.createTempVar("range", x.getType())
+		if err != nil {
+			return nil, err
+		}
+		set, err := outerScope.createSetVar(rangeVar, x, stmt, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		outerScope.expressions = append(outerScope.expressions, set)
+		rangeVar.setFullType(ty)
+		n, err := outerScope.createTempVar("range_count", intType)
+		if err != nil {
+			return nil, err
+		}
+		sliceLen, err := outerScope.createRuntimeCall("slice", "SliceLen", []WasmExpression{outerScope.createGetLocal(rangeVar, stmt.X, indent+3)}, stmt.X, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		set, err = outerScope.createSetVar(n, sliceLen, stmt, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		outerScope.expressions = append(outerScope.expressions, set)
+		length = outerScope.createGetLocal(n, stmt.X, indent+4)
+	case *WasmTypeScalar:
+		if b, ok := xt.Underlying().(*types.Basic); !ok || b.Info()&types.IsInteger == 0 {
+			return nil, s.f.file.ErrorNode(stmt.X, "cannot range over a value of type %s", xt)
+		}
+		intType = ty
+		n, err := outerScope.createTempVar("range_count", ty)
+		if err != nil {
+			return nil, err
+		}
+		set, err := outerScope.createSetVar(n, x, stmt, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		outerScope.expressions = append(outerScope.expressions, set)
+		length = outerScope.createGetLocal(n, stmt.X, indent+4)
+		if stmt.Value != nil {
+			return nil, s.f.file.ErrorNode(stmt.Value, "range over %s permits only one iteration variable", ty.getName())
+		}
+	}
+
+	index, err := outerScope.createTempVar("range_index", intType)
+	if err != nil {
+		return nil, err
+	}
+	zero, err := outerScope.createLiteral("0", intType, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	init, err := outerScope.createSetVar(index, zero, stmt, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	outerScope.expressions = append(outerScope.expressions, init)
+
+	exitCond, err := scope.createBinaryExpr(scope.createGetLocal(index, stmt, indent+4), length, binOpGe, intType, indent+3)
+	if err != nil {
+		return nil, err
+	}
+	scope.appendLoopExit(exitCond, indent+2)
+
+	if key := stmt.Key; key != nil && !isBlankIdent(key) {
+		set, err := scope.createRangeAssign(key, scope.createGetLocal(index, stmt, indent+3), stmt, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		scope.expressions = append(scope.expressions, set)
+	}
+	if value := stmt.Value; value != nil && !isBlankIdent(value) {
+		x := scope.createGetLocal(rangeVar, stmt.X, indent+5)
+		lvalue, err := scope.createIndexExprLValue(scope.createGetLocal(index, stmt, indent+6), x, stmt, indent+3)
+		if err != nil {
+			return nil, err
+		}
+		elem, err := scope.createLoad(lvalue.addr, elementType, indent+3)
+		if err != nil {
+			return nil, err
+		}
+		elem.setComment("range value")
+		set, err := scope.createRangeAssign(value, elem, stmt, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		scope.expressions = append(scope.expressions, set)
+	}
+
+	err = scope.parseLoopBody(stmt.Body, indent+2)
+	if err != nil {
+		return nil, fmt.Errorf("error in the body of a loop: %w", err)
+	}
+
+	one, err := scope.createLiteral("1", intType, indent+4)
+	if err != nil {
+		return nil, err
+	}
+	inc, err := scope.createBinaryExpr(scope.createGetLocal(index, stmt, indent+4), one, binOpAdd, intType, indent+3)
+	if err != nil {
+		return nil, err
+	}
+	post, err := scope.createSetVar(index, inc, stmt, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	scope.expressions = append(scope.expressions, post)
+
+	return s.createLoop(outerScope, scope, stmt, indent), nil
+}
+
+// createArrayCopy returns a new array that is a copy of the array src.
+func (s *WasmScope) createArrayCopy(src WasmExpression, t *WasmTypeArray, node ast.Node, indent int) (WasmExpression, error) {
+	size := int32(t.length) * int32(t.elementType.getSize())
+	dst, err := s.generateAlloc(size, int32(t.elementType.getAlign()), node, t, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	n, err := s.createLiteralInt32(size, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	n.setComment("array size in bytes")
+	cp, err := s.createRuntimeCall("gc", "Copy", []WasmExpression{dst, src, n}, node, indent)
+	if err != nil {
+		return nil, err
+	}
+	cp.setFullType(t)
+	return cp, nil
+}
+
+// createRangeAssign assigns an iteration value to the key or value variable
+// of a range loop.
+func (s *WasmScope) createRangeAssign(lhs ast.Expr, rhs WasmExpression, stmt *ast.RangeStmt, indent int) (WasmExpression, error) {
+	if st, ok := rhs.getFullType().(*WasmTypeStruct); ok {
+		return s.assignStruct(lhs, rhs, false, st, stmt, indent)
+	}
+	if stmt.Tok == token.DEFINE {
+		ident, ok := lhs.(*ast.Ident)
+		if !ok {
+			return nil, s.f.file.ErrorNode(lhs, "non-name on the left side of :=")
+		}
+		v, err := s.createLocalVar(ident, rhs.getType())
+		if err != nil {
+			return nil, err
+		}
+		return s.createSetVar(v, rhs, stmt, indent)
+	}
+	v, lvalue, err := s.parseAssignLHS([]ast.Expr{lhs}, rhs.getType(), indent)
+	if err != nil {
+		return nil, err
+	}
+	if lvalue != nil {
+		return s.createStore(lvalue.addr, rhs, rhs.getType(), stmt, indent)
+	}
+	return s.createSetVar(v, rhs, stmt, indent)
+}
+
+func isBlankIdent(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == "_"
+}
+
+func (s *WasmScope) createBlock(scope *WasmScope, stmt ast.Stmt, indent int) *WasmBlock {
+	b := &WasmBlock{
+		scope: scope,
+		stmt:  stmt,
+	}
+	b.setIndent(indent)
+	return b
+}
+
+func (s *WasmScope) parseBlockStmt(stmt *ast.BlockStmt, indent int) (*WasmBlock, error) {
+	scope := s.f.createScope("block")
+	err := scope.parseStatementList(stmt.List, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createBlock(scope, stmt, indent), nil
+}
+
+// createNegation returns an expression that is true iff cond is false.
+func (s *WasmScope) createNegation(cond WasmExpression, indent int) (WasmExpression, error) {
+	zero, err := s.createLiteralInt32(0, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createBinaryExpr(cond, zero, binOpEq, zero.getType(), indent)
+}
+
+func (s *WasmScope) createIf(cond, body, bodyElse WasmExpression, indent int) (*WasmIf, error) {
 	i := &WasmIf{
 		cond:     cond,
 		body:     body,
@@ -505,83 +1175,491 @@ func (s *WasmScope) createIf(cond, body, bodyElse WasmExpression, indent int) (*
 	return i, nil
 }
 
+// parseIfStmt lowers an if statement onto an if instruction. An init
+// statement precedes it in a block.
 func (s *WasmScope) parseIfStmt(stmt *ast.IfStmt, indent int) (WasmExpression, error) {
 	if stmt.Init != nil {
-		return nil, fmt.Errorf("unimplemented IfStmt with an init")
+		scope := s.f.createScope("if")
+		err := scope.parseStatementList([]ast.Stmt{stmt.Init}, indent+1)
+		if err != nil {
+			return nil, fmt.Errorf("error in the init part of an IfStmt: %w", err)
+		}
+		i, err := scope.parseIfStmt(&ast.IfStmt{If: stmt.If, Cond: stmt.Cond, Body: stmt.Body, Else: stmt.Else}, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		scope.expressions = append(scope.expressions, i)
+		return s.createBlock(scope, stmt, indent), nil
 	}
 	var elseStmt WasmExpression
 	if stmt.Else != nil {
 		elseStmtList, err := s.parseStmt(stmt.Else, indent+1)
 		if err != nil || len(elseStmtList) != 1 {
-			return nil, fmt.Errorf("error in the else statement: %v", err)
+			return nil, fmt.Errorf("error in the else statement: %w", err)
 		}
 		elseStmt = elseStmtList[0]
 	}
-	cond, err := s.parseExpr(stmt.Cond, nil, indent+1)
+	cond, err := s.parseExpr(stmt.Cond, indent+1)
 	if err != nil {
-		return nil, fmt.Errorf("error in condition of an IfStmt: %v", err)
+		return nil, fmt.Errorf("error in condition of an IfStmt: %w", err)
 	}
 	body, err := s.parseBlockStmt(stmt.Body, indent+1)
 	if err != nil {
-		return nil, fmt.Errorf("error in the block of an IfStmt: %v", err)
+		return nil, fmt.Errorf("error in the block of an IfStmt: %w", err)
 	}
 	i, err := s.createIf(cond, body, elseStmt, indent)
 	if err != nil {
-		return nil, fmt.Errorf("error creating an IfStmt: %v", err)
+		return nil, fmt.Errorf("error creating an IfStmt: %w", err)
 	}
 	i.stmt = stmt
 	return i, nil
 }
 
-func (s *WasmScope) parseIncDecStmt(stmt *ast.IncDecStmt, indent int) (WasmExpression, error) {
-	switch x := stmt.X.(type) {
+// Switch statements with at least this many constant cases are dispatched
+// with a br_table if the case values are dense.
+const minJumpTableCases = 3
+
+// parseSwitchStmt lowers a switch statement onto nested blocks, one per case
+// clause. The innermost block holds the dispatch code that branches out of
+// the block which is followed by the body of the selected clause. Each body
+// ends with a branch to the end of the switch unless it falls through:
+//
+//	(block $switch_break
+//	  (block $case2
+//	    (block $case1
+//	      (block $case0
+//	        <dispatch>
+//	      )
+//	      <body0> (br $switch_break)
+//	    )
+//	    <body1> (br $switch_break)
+//	  )
+//	  <body2>
+//	)
+func (s *WasmScope) parseSwitchStmt(stmt *ast.SwitchStmt, indent int) (WasmExpression, error) {
+	outerScope := s.f.createScope("switch")
+	labelBreak := outerScope.name + "_break"
+	if stmt.Init != nil {
+		err := outerScope.parseStatementList([]ast.Stmt{stmt.Init}, indent+1)
+		if err != nil {
+			return nil, fmt.Errorf("error in the init part of a switch: %w", err)
+		}
+	}
+
+	clauses := make([]*ast.CaseClause, len(stmt.Body.List))
+	for i, c := range stmt.Body.List {
+		clauses[i] = c.(*ast.CaseClause)
+	}
+	n := len(clauses)
+	labels := make([]string, n)
+	for i := range clauses {
+		labels[i] = fmt.Sprintf("%s_case%d", outerScope.name, i)
+	}
+	labelDefault := labelBreak
+
+	// The tag is evaluated once and kept in a local.
+	var tag WasmVariable
+	if stmt.Tag != nil {
+		tagExpr, err := outerScope.parseExpr(stmt.Tag, indent+2)
+		if err != nil {
+			return nil, fmt.Errorf("error in the tag of a switch: %w", err)
+		}
+		tag, err = outerScope.createTempVar("tag", tagExpr.getType())
+		if err != nil {
+			return nil, err
+		}
+		setTag, err := outerScope.createSetVar(tag, tagExpr, stmt, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		outerScope.expressions = append(outerScope.expressions, setTag)
+	}
+
+	s.f.pushBranchTarget(labelBreak, "")
+	defer s.f.popBranchTarget()
+
+	dispatchScope := s.f.createScope("switch_dispatch")
+	dispatchIndent := indent + n + 1
+	for i, c := range clauses {
+		if c.List == nil {
+			labelDefault = labels[i]
+		}
+	}
+	table, err := s.createJumpTable(clauses, tag, labels, labelDefault, dispatchIndent)
+	if err != nil {
+		return nil, err
+	}
+	if table != nil {
+		dispatchScope.expressions = append(dispatchScope.expressions, table)
+	} else {
+		for i, c := range clauses {
+			for _, value := range c.List {
+				cond, err := dispatchScope.createCaseCond(tag, value, dispatchIndent+1)
+				if err != nil {
+					return nil, err
+				}
+				b := &WasmBreak{
+					scope: dispatchScope,
+					label: labels[i],
+					cond:  cond,
+				}
+				b.setIndent(dispatchIndent)
+				dispatchScope.expressions = append(dispatchScope.expressions, b)
+			}
+		}
+		b := &WasmBreak{
+			scope: dispatchScope,
+			label: labelDefault,
+		}
+		b.setIndent(dispatchIndent)
+		dispatchScope.expressions = append(dispatchScope.expressions, b)
+	}
+
+	var inner WasmExpression
+	if n > 0 {
+		block := s.createBlock(dispatchScope, stmt, indent+n)
+		block.label = labels[0]
+		inner = block
+	} else {
+		inner = s.createBlock(dispatchScope, stmt, indent+1)
+	}
+	for i, c := range clauses {
+		scope := outerScope
+		if i < n-1 {
+			scope = s.f.createScope("case")
+		}
+		bodyIndent := indent + n - i
+		scope.expressions = append(scope.expressions, inner)
+		body := c.Body
+		fallsThrough := false
+		if len(body) > 0 {
+			if br, ok := body[len(body)-1].(*ast.BranchStmt); ok && br.Tok == token.FALLTHROUGH {
+				body = body[:len(body)-1]
+				fallsThrough = true
+			}
+		}
+		err := scope.parseStatementList(body, bodyIndent)
+		if err != nil {
+			return nil, fmt.Errorf("error in a case clause of a switch: %w", err)
+		}
+		if i < n-1 {
+			if !fallsThrough {
+				b := &WasmBreak{
+					scope: scope,
+					label: labelBreak,
+				}
+				b.setIndent(bodyIndent)
+				scope.expressions = append(scope.expressions, b)
+			}
+			block := s.createBlock(scope, stmt, bodyIndent-1)
+			block.label = labels[i+1]
+			inner = block
+		}
+	}
+	if n == 0 {
+		outerScope.expressions = append(outerScope.expressions, inner)
+	}
+
+	outerBlock := s.createBlock(outerScope, stmt, indent)
+	outerBlock.label = labelBreak
+	return outerBlock, nil
+}
+
+// createCaseCond returns the condition under which a case value matches. In
+// a switch without a tag, the value is the condition.
+func (s *WasmScope) createCaseCond(tag WasmVariable, value ast.Expr, indent int) (WasmExpression, error) {
+	if tag == nil {
+		cond, err := s.parseExpr(value, indent)
+		if err != nil {
+			return nil, fmt.Errorf("error in a case condition: %w", err)
+		}
+		return cond, nil
+	}
+	if isString(s.f.file.info.TypeOf(value)) {
+		// Strings are compared by their bytes.
+		v, err := s.parseExpr(value, indent+2)
+		if err != nil {
+			return nil, fmt.Errorf("error in a case value: %w", err)
+		}
+		x := s.createGetLocal(tag, value, indent+2)
+		cmp, err := s.createRuntimeCall("str", "StringCompare", []WasmExpression{x, v}, nil, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		zero, err := s.createLiteralInt32(0, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		return s.createBinaryExpr(cmp, zero, binOpEq, cmp.getType(), indent)
+	}
+	v, err := s.parseExpr(value, indent+1)
+	if err != nil {
+		return nil, fmt.Errorf("error in a case value: %w", err)
+	}
+	x := s.createGetLocal(tag, value, indent+1)
+	return s.createBinaryExpr(x, v, binOpEq, tag.getType(), indent)
+}
+
+// createJumpTable returns a br_table dispatching on the tag of a switch if
+// all case values are dense integer constants. Otherwise, it returns nil.
+func (s *WasmScope) createJumpTable(clauses []*ast.CaseClause, tag WasmVariable, labels []string, labelDefault string, indent int) (WasmExpression, error) {
+	if tag == nil || wasmTypeName(tag.getType()) != "i32" {
+		return nil, nil
+	}
+	targets := make(map[int]string)
+	min, max := 0, 0
+	for i, c := range clauses {
+		for _, value := range c.List {
+			v, err := s.f.file.evaluateIntConstant(value)
+			if err != nil {
+				return nil, nil
+			}
+			if len(targets) == 0 || v < min {
+				min = v
+			}
+			if len(targets) == 0 || v > max {
+				max = v
+			}
+			targets[v] = labels[i]
+		}
+	}
+	if len(targets) < minJumpTableCases || max-min+1 > 2*len(targets) {
+		return nil, nil
+	}
+	t := &WasmBrTable{
+		labels:       make([]string, max-min+1),
+		labelDefault: labelDefault,
+	}
+	for i := range t.labels {
+		label, ok := targets[min+i]
+		if !ok {
+			label = labelDefault
+		}
+		t.labels[i] = label
+	}
+	var index WasmExpression = s.createGetLocal(tag, nil, indent+2)
+	if min != 0 {
+		offset, err := s.createLiteral(fmt.Sprintf("%d", min), tag.getType(), indent+2)
+		if err != nil {
+			return nil, err
+		}
+		index, err = s.createBinaryExpr(index, offset, binOpSub, tag.getType(), indent+1)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		index.setIndent(indent + 1)
+	}
+	t.index = index
+	t.setIndent(indent)
+	return t, nil
+}
+
+func (s *WasmScope) parseBranchStmt(stmt *ast.BranchStmt, indent int) (WasmExpression, error) {
+	var label string
+	switch stmt.Tok {
 	default:
-		return nil, fmt.Errorf("unimplemented expr in IncDecStmt: %v at %s", x, positionString(x.Pos(), s.f.fset))
+		return nil, s.f.file.ErrorNode(stmt, "unimplemented branch statement '%v'", stmt.Tok)
+	case token.BREAK, token.CONTINUE:
+		isContinue := stmt.Tok == token.CONTINUE
+		t := s.f.findBranchTarget(stmt.Label, isContinue)
+		if t == nil {
+			if stmt.Label != nil {
+				return nil, s.f.file.ErrorNode(stmt, "invalid %v label %s", stmt.Tok, stmt.Label.Name)
+			}
+			return nil, s.f.file.ErrorNode(stmt, "%v is not in a loop or switch", stmt.Tok)
+		}
+		if isContinue {
+			label = t.labelContinue
+		} else {
+			label = t.labelBreak
+		}
+	case token.GOTO:
+		var ok bool
+		label, ok = s.f.gotoLabels[stmt.Label.Name]
+		if !ok {
+			return nil, s.f.file.ErrorNode(stmt, "unsupported goto %s: only forward jumps to a label in an enclosing block are implemented", stmt.Label.Name)
+		}
+	case token.FALLTHROUGH:
+		return nil, s.f.file.ErrorNode(stmt, "fallthrough statement out of place")
+	}
+	b := &WasmBreak{
+		scope: s,
+		label: label,
+	}
+	b.setIndent(indent)
+	b.setScope(s)
+	b.setNode(stmt)
+	return b, nil
+}
+
+// parseLabeledStmt parses a labeled statement. The label of a loop or a switch
+// can be used by break and continue statements.
+func (s *WasmScope) parseLabeledStmt(stmt *ast.LabeledStmt, indent int) ([]WasmExpression, error) {
+	switch stmt.Stmt.(type) {
+	case *ast.ForStmt, *ast.RangeStmt, *ast.SelectStmt, *ast.SwitchStmt:
+		s.f.pendingLabel = stmt.Label.Name
+	}
+	return s.parseStmt(stmt.Stmt, indent)
+}
+
+func (s *WasmScope) parseIncDecStmt(stmt *ast.IncDecStmt, indent int) ([]WasmExpression, error) {
+	switch x := s.f.file.unqualified(stmt.X).(type) {
+	default:
+		return nil, s.f.file.ErrorNode(x, "unimplemented expr in IncDecStmt")
 	case *ast.Ident:
-		v, ok := s.f.module.variables[x.Obj]
+		v, ok := s.f.module.variables[s.f.file.objectOf(x)]
 		if !ok {
-			return nil, fmt.Errorf("undefined variable '%s' in IncDecStmt at %s", x.Obj.Name, positionString(x.Pos(), s.f.fset))
+			return nil, s.f.file.ErrorNode(x, "undefined variable '%s' in IncDecStmt", x.Name)
 		}
 		vRHS, err := s.parseIdent(x, indent+2)
 		if err != nil {
-			return nil, fmt.Errorf("error in IncDecStmt: %v", err)
+			return nil, fmt.Errorf("error in IncDecStmt: %w", err)
 		}
 
 		inc, err := s.createLiteral("1", v.getType(), indent+2)
 		if err != nil {
-			return nil, fmt.Errorf("error in IncDecStmt: %v", err)
+			return nil, fmt.Errorf("error in IncDecStmt: %w", err)
 		}
 
-		rhs, err := s.createBinaryExpr(vRHS, inc, binOpMapping[stmt.Tok], v.getType(), indent+1)
+		sum, err := s.createBinaryExpr(vRHS, inc, binOpMapping[stmt.Tok], v.getType(), indent+1)
+		if err != nil {
+			return nil, fmt.Errorf("error in IncDecStmt: %w", err)
+		}
+		rhs, err := s.wrapSmallInt(sum, sum.op, indent+1)
 		if err != nil {
-			return nil, fmt.Errorf("error in IncDecStmt: %v", err)
+			return nil, err
 		}
 
-		return s.createSetVar(v, rhs, stmt, indent)
+		set, err := s.createSetVar(v, rhs, stmt, indent)
+		if err != nil {
+			return nil, err
+		}
+		return []WasmExpression{set}, nil
+	case *ast.IndexExpr, *ast.SelectorExpr, *ast.StarExpr:
+		return s.parseIncDecLValue(stmt, indent)
 	}
-	return nil, fmt.Errorf("not implemented: IncDecStmt")
+}
+
+// parseIncDecLValue handles x++ and x-- where x is an element, a field or a
+// pointer indirection, e.g., m[k]++. The address of x is computed once, into
+// a temporary local.
+func (s *WasmScope) parseIncDecLValue(stmt *ast.IncDecStmt, indent int) ([]WasmExpression, error) {
+	_, lvalue, err := s.parseAssignLHS([]ast.Expr{stmt.X}, nil, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	ptrType, err := s.f.module.scalarType("uintptr")
+	if err != nil {
+		return nil, err
+	}
+	addr, err := s.createTempVar("incdec_addr", ptrType)
+	if err != nil {
+		return nil, err
+	}
+	set, err := s.createSetVar(addr, lvalue.addr, stmt, indent)
+	if err != nil {
+		return nil, err
+	}
+	x, err := s.createLoad(s.createGetLocal(addr, stmt.X, indent+3), lvalue.t, indent+2)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(stmt, "%v", err)
+	}
+	inc, err := s.createLiteral("1", lvalue.t, indent+2)
+	if err != nil {
+		return nil, fmt.Errorf("error in IncDecStmt: %w", err)
+	}
+	rhs, err := s.createBinaryExpr(x, inc, binOpMapping[stmt.Tok], lvalue.t, indent+1)
+	if err != nil {
+		return nil, fmt.Errorf("error in IncDecStmt: %w", err)
+	}
+	store, err := s.createStore(s.createGetLocal(addr, stmt.X, indent+1), rhs, lvalue.t, stmt, indent)
+	if err != nil {
+		return nil, s.f.file.ErrorNode(stmt, "%v", err)
+	}
+	return []WasmExpression{set, store}, nil
 }
 
 func (s *WasmScope) parseReturnStmt(stmt *ast.ReturnStmt, indent int) (WasmExpression, error) {
+	if s.f.deferFrame != nil {
+		return s.parseDeferredReturn(stmt, indent)
+	}
+	return s.createReturn(stmt, indent)
+}
+
+func (s *WasmScope) createReturn(stmt *ast.ReturnStmt, indent int) (*WasmReturn, error) {
 	r := &WasmReturn{
 		stmt: stmt,
 	}
 	r.setIndent(indent)
 	r.setNode(stmt)
 	r.setScope(s)
-	if stmt.Results != nil {
-		if len(stmt.Results) != 1 {
-			return nil, fmt.Errorf("unimplemented multi-value return statement")
+	results := s.f.results
+	if len(stmt.Results) == 0 {
+		// A bare return returns the current values of named results.
+		for _, result := range results {
+			if result.v == nil {
+				zero, err := s.createNilLiteral(result.t, indent+1)
+				if err != nil {
+					return nil, err
+				}
+				r.values = append(r.values, zero)
+				continue
+			}
+			value, err := s.parseIdent(result.astIdent, indent+1)
+			if err != nil {
+				return nil, err
+			}
+			r.values = append(r.values, value)
+		}
+		return r, nil
+	}
+	if len(stmt.Results) == 1 && len(results) > 1 {
+		// return f(), where f returns multiple values.
+		value, err := s.parseExpr(stmt.Results[0], indent+1)
+		if err != nil {
+			return nil, err
 		}
-		value, err := s.parseExpr(stmt.Results[0], s.f.result.t, indent+1)
+		if numValues(value) != len(results) {
+			return nil, s.f.file.ErrorNode(stmt, "wrong number of return values (have %d, want %d)", numValues(value), len(results))
+		}
+		r.values = []WasmExpression{value}
+		return r, nil
+	}
+	if len(stmt.Results) != len(results) {
+		return nil, s.f.file.ErrorNode(stmt, "wrong number of return values (have %d, want %d)", len(stmt.Results), len(results))
+	}
+	sig := s.f.goSignature()
+	for i, result := range stmt.Results {
+		value, err := s.parseExprAs(result, sig.Results().At(i).Type(), indent+1)
 		if err != nil {
 			return nil, err
 		}
-		r.value = value
+		r.values = append(r.values, value)
 	}
 	return r, nil
 }
 
+// printStmt prints an expression in a statement position. In the standard
+// syntax, values it produces are dropped.
+func printStmt(writer FormattingWriter, e WasmExpression) {
+	n := numValues(e)
+	if legacySyntax || n == 0 {
+		e.print(writer)
+		return
+	}
+	if n > 1 {
+		e.print(writer)
+		for i := 0; i < n; i++ {
+			writer.PrintfIndent(e.getIndent(), "(drop)\n")
+		}
+		return
+	}
+	writer.PrintfIndent(e.getIndent(), "(drop\n")
+	e.print(writer)
+	writer.PrintfIndent(e.getIndent(), ") ;; drop\n")
+}
+
 func (n *WasmNop) getType() WasmType {
 	return nil
 }
@@ -590,22 +1668,53 @@ func (n *WasmNop) print(writer FormattingWriter) {
 	writer.PrintfIndent(n.getIndent(), "(nop)\n")
 }
 
+func (n *WasmNop) encode(writer *WasmBinaryWriter) {
+	writer.writeOpcode("nop")
+}
+
 func (n *WasmNop) getNode() ast.Node {
 	return nil
 }
 
+func (u *WasmUnreachable) getType() WasmType {
+	return nil
+}
+
+func (u *WasmUnreachable) print(writer FormattingWriter) {
+	writer.PrintfIndent(u.getIndent(), "(unreachable)%s\n", u.getComment())
+}
+
+func (u *WasmUnreachable) encode(writer *WasmBinaryWriter) {
+	writer.writeOpcode("unreachable")
+}
+
 func (b *WasmBlock) getType() WasmType {
 	return nil
 }
 
 func (b *WasmBlock) print(writer FormattingWriter) {
-	writer.PrintfIndent(b.getIndent(), "(block\n")
+	if b.label != "" {
+		writer.PrintfIndent(b.getIndent(), "(block $%s\n", b.label)
+	} else {
+		writer.PrintfIndent(b.getIndent(), "(block\n")
+	}
 	for _, expr := range b.scope.expressions {
-		expr.print(writer)
+		printStmt(writer, expr)
 	}
 	writer.PrintfIndent(b.getIndent(), ") ;; block\n")
 }
 
+func (b *WasmBlock) encode(writer *WasmBinaryWriter) {
+	writer.writeOpcode("block")
+	writer.writeByte(blockTypeEmpty)
+	writer.pushLabel(b.label)
+	for _, expr := range b.scope.expressions {
+		writer.encodeStmt(expr)
+	}
+	writer.popLabel()
+	writer.writeOpcode("end")
+}
+
 func (b *WasmBlock) getNode() ast.Node {
 	if b.stmt == nil {
 		return nil
@@ -615,21 +1724,34 @@ func (b *WasmBlock) getNode() ast.Node {
 }
 
 func (r *WasmReturn) getType() WasmType {
-	if r.value == nil {
+	if len(r.values) == 0 {
 		return nil
 	} else {
-		return r.value.getType()
+		return r.values[0].getType()
 	}
 }
 
 func (r *WasmReturn) print(writer FormattingWriter) {
 	writer.PrintfIndent(r.getIndent(), "(return%s\n", r.getComment())
-	if r.value != nil {
-		r.value.print(writer)
+	for _, v := range r.values {
+		v.print(writer)
+	}
+	if r.leave != nil {
+		r.leave.print(writer)
 	}
 	writer.PrintfIndent(r.getIndent(), ") ;; return\n")
 }
 
+func (r *WasmReturn) encode(writer *WasmBinaryWriter) {
+	for _, v := range r.values {
+		v.encode(writer)
+	}
+	if r.leave != nil {
+		r.leave.encode(writer)
+	}
+	writer.writeOpcode("return")
+}
+
 func (r *WasmReturn) getNode() ast.Node {
 	if r.stmt == nil {
 		return nil
@@ -643,19 +1765,47 @@ func (i *WasmIf) getType() WasmType {
 }
 
 func (i *WasmIf) print(writer FormattingWriter) {
-	if i.bodyElse != nil {
-		writer.PrintfIndent(i.getIndent(), "(if_else\n")
-	} else {
-		writer.PrintfIndent(i.getIndent(), "(if\n")
+	if legacySyntax {
+		if i.bodyElse != nil {
+			writer.PrintfIndent(i.getIndent(), "(if_else\n")
+		} else {
+			writer.PrintfIndent(i.getIndent(), "(if\n")
+		}
+		i.cond.print(writer)
+		i.body.print(writer)
+		if i.bodyElse != nil {
+			i.bodyElse.print(writer)
+		}
+		writer.PrintfIndent(i.getIndent(), ") ;; if\n")
+		return
 	}
+	writer.PrintfIndent(i.getIndent(), "(if\n")
 	i.cond.print(writer)
-	i.body.print(writer)
+	writer.PrintfIndent(i.getIndent(), "(then\n")
+	printStmt(writer, i.body)
+	writer.PrintfIndent(i.getIndent(), ")\n")
 	if i.bodyElse != nil {
-		i.bodyElse.print(writer)
+		writer.PrintfIndent(i.getIndent(), "(else\n")
+		printStmt(writer, i.bodyElse)
+		writer.PrintfIndent(i.getIndent(), ")\n")
 	}
 	writer.PrintfIndent(i.getIndent(), ") ;; if\n")
 }
 
+func (i *WasmIf) encode(writer *WasmBinaryWriter) {
+	i.cond.encode(writer)
+	writer.writeOpcode("if")
+	writer.writeByte(blockTypeEmpty)
+	writer.pushLabel("")
+	writer.encodeStmt(i.body)
+	if i.bodyElse != nil {
+		writer.writeOpcode("else")
+		writer.encodeStmt(i.bodyElse)
+	}
+	writer.popLabel()
+	writer.writeOpcode("end")
+}
+
 func (i *WasmIf) getNode() ast.Node {
 	if i.stmt == nil {
 		return nil
@@ -669,13 +1819,39 @@ func (l *WasmLoop) getType() WasmType {
 }
 
 func (l *WasmLoop) print(writer FormattingWriter) {
-	writer.PrintfIndent(l.getIndent(), "(loop $%s $%s\n", l.labelBreak, l.labelContinue)
+	if legacySyntax {
+		writer.PrintfIndent(l.getIndent(), "(loop $%s $%s\n", l.labelBreak, l.labelContinue)
+	} else {
+		writer.PrintfIndent(l.getIndent(), "(block $%s\n", l.labelBreak)
+		writer.PrintfIndent(l.getIndent(), "(loop $%s\n", l.labelContinue)
+	}
 	for _, e := range l.scope.expressions {
-		e.print(writer)
+		printStmt(writer, e)
+	}
+	if !legacySyntax {
+		writer.PrintfIndent(l.getIndent(), ")\n")
 	}
 	writer.PrintfIndent(l.getIndent(), ") ;; loop\n")
 }
 
+// encode emits the loop as a block (the break target) around a loop (the
+// continue target).
+func (l *WasmLoop) encode(writer *WasmBinaryWriter) {
+	writer.writeOpcode("block")
+	writer.writeByte(blockTypeEmpty)
+	writer.pushLabel(l.labelBreak)
+	writer.writeOpcode("loop")
+	writer.writeByte(blockTypeEmpty)
+	writer.pushLabel(l.labelContinue)
+	for _, e := range l.scope.expressions {
+		writer.encodeStmt(e)
+	}
+	writer.popLabel()
+	writer.writeOpcode("end")
+	writer.popLabel()
+	writer.writeOpcode("end")
+}
+
 func (l *WasmLoop) getNode() ast.Node {
 	if l.stmt == nil {
 		return nil
@@ -689,17 +1865,77 @@ func (b *WasmBreak) getType() WasmType {
 }
 
 func (b *WasmBreak) print(writer FormattingWriter) {
-	writer.PrintfIndent(b.getIndent(), "(br $%s)\n", b.label)
+	if b.cond == nil {
+		writer.PrintfIndent(b.getIndent(), "(br $%s)%s\n", b.label, b.getComment())
+		return
+	}
+	writer.PrintfIndent(b.getIndent(), "(br_if $%s\n", b.label)
+	b.cond.print(writer)
+	writer.PrintfIndent(b.getIndent(), ") ;; br_if $%s\n", b.label)
+}
+
+func (b *WasmBreak) encode(writer *WasmBinaryWriter) {
+	if b.cond == nil {
+		writer.writeOpcode("br")
+	} else {
+		b.cond.encode(writer)
+		writer.writeOpcode("br_if")
+	}
+	writer.writeU32(writer.labelDepth(b.label))
 }
 
 func (b *WasmBreak) getNode() ast.Node {
 	return nil
 }
 
+func (t *WasmBrTable) getType() WasmType {
+	return nil
+}
+
+func (t *WasmBrTable) print(writer FormattingWriter) {
+	writer.PrintfIndent(t.getIndent(), "(br_table")
+	for _, l := range t.labels {
+		writer.Printf(" $%s", l)
+	}
+	writer.Printf(" $%s\n", t.labelDefault)
+	t.index.print(writer)
+	writer.PrintfIndent(t.getIndent(), ") ;; br_table\n")
+}
+
+func (t *WasmBrTable) encode(writer *WasmBinaryWriter) {
+	t.index.encode(writer)
+	writer.writeOpcode("br_table")
+	writer.writeU32(uint32(len(t.labels)))
+	for _, l := range t.labels {
+		writer.writeU32(writer.labelDepth(l))
+	}
+	writer.writeU32(writer.labelDepth(t.labelDefault))
+}
+
+func (t *WasmBrTable) getNode() ast.Node {
+	return nil
+}
+
 func (s *WasmSetLocal) print(writer FormattingWriter) {
-	writer.PrintfIndent(s.getIndent(), "(set_local %s%s\n", s.lhs.getName(), s.getComment())
+	op := "local.set"
+	if legacySyntax {
+		op = "set_local"
+	}
+	writer.PrintfIndent(s.getIndent(), "(%s %s%s\n", op, s.lhs.getName(), s.getComment())
 	s.rhs.print(writer)
-	writer.PrintfIndent(s.getIndent(), ") ;; set_local %s\n", s.lhs.getName())
+	writer.PrintfIndent(s.getIndent(), ") ;; %s %s\n", op, s.lhs.getName())
+	if s.root != nil {
+		s.root.print(writer)
+	}
+}
+
+func (s *WasmSetLocal) encode(writer *WasmBinaryWriter) {
+	s.rhs.encode(writer)
+	writer.writeOpcode("local.set")
+	writer.writeU32(writer.getLocalIndex(s.lhs.getName()))
+	if s.root != nil {
+		s.root.encode(writer)
+	}
 }
 
 func (s *WasmSetLocal) getType() WasmType {
@@ -713,3 +1949,65 @@ func (s *WasmSetLocal) getNode() ast.Node {
 		return s.stmt
 	}
 }
+
+func (t *WasmTeeLocal) print(writer FormattingWriter) {
+	op := "local.tee"
+	if legacySyntax {
+		op = "tee_local"
+	}
+	writer.PrintfIndent(t.getIndent(), "(%s %s%s\n", op, t.lhs.getName(), t.getComment())
+	t.rhs.print(writer)
+	writer.PrintfIndent(t.getIndent(), ") ;; %s %s\n", op, t.lhs.getName())
+	if t.root != nil {
+		// The store leaves the value of the tee on the stack.
+		t.root.print(writer)
+	}
+}
+
+func (t *WasmTeeLocal) encode(writer *WasmBinaryWriter) {
+	t.rhs.encode(writer)
+	writer.writeOpcode("local.tee")
+	writer.writeU32(writer.getLocalIndex(t.lhs.getName()))
+	if t.root != nil {
+		t.root.encode(writer)
+	}
+}
+
+func (t *WasmTeeLocal) getType() WasmType {
+	return t.lhs.getType()
+}
+
+func (t *WasmTupleSet) getType() WasmType {
+	return nil
+}
+
+// print emits the value followed by a local.set for each of its results, last
+// result first.
+func (t *WasmTupleSet) print(writer FormattingWriter) {
+	op := "local.set"
+	if legacySyntax {
+		op = "set_local"
+	}
+	t.value.print(writer)
+	for i := len(t.vars) - 1; i >= 0; i-- {
+		writer.PrintfIndent(t.getIndent(), "(%s %s)\n", op, t.vars[i].getName())
+	}
+	for _, root := range t.roots {
+		root.print(writer)
+	}
+}
+
+func (t *WasmTupleSet) encode(writer *WasmBinaryWriter) {
+	t.value.encode(writer)
+	for i := len(t.vars) - 1; i >= 0; i-- {
+		writer.writeOpcode("local.set")
+		writer.writeU32(writer.getLocalIndex(t.vars[i].getName()))
+	}
+	for _, root := range t.roots {
+		root.encode(writer)
+	}
+}
+
+func (t *WasmTupleSet) getNode() ast.Node {
+	return t.stmt
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// A string value is the address of a header with a pointer to the bytes and
+// the length, or 0 for the empty string. The operations on strings are
+// implemented by the runtime package rt/str.
+const stringHeaderSize = 8
+
+func isString(t types.Type) bool {
+	if t == nil {
+		return false
+	}
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Info()&types.IsString != 0
+}
+
+func isByteSlice(t types.Type) bool {
+	sl, ok := t.Underlying().(*types.Slice)
+	if !ok {
+		return false
+	}
+	b, ok := sl.Elem().Underlying().(*types.Basic)
+	return ok && b.Kind() == types.Uint8
+}
+
+// parseStringConversion converts an integer, which is a rune, or a []byte to
+// a string, or a string to a []byte. The bytes are copied by rt/str, since
+// strings are immutable.
+func (s *WasmScope) parseStringConversion(call *ast.CallExpr, from, to types.Type, indent int) (WasmExpression, error) {
+	var name string
+	switch {
+	case isString(to) && isInteger(from):
+		if b := from.Underlying().(*types.Basic); b.Kind() == types.Int64 || b.Kind() == types.Uint64 {
+			return nil, s.f.file.ErrorNode(call, "unimplemented conversion from %v to %v", from, to)
+		}
+		name = "StringFromRune"
+	case isString(to) && isByteSlice(from):
+		name = "StringFromBytes"
+	case isString(from) && isByteSlice(to):
+		name = "StringToBytes"
+	default:
+		return nil, s.f.file.ErrorNode(call, "unimplemented conversion from %v to %v", from, to)
+	}
+	x, err := s.parseExpr(call.Args[0], indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createTypedRuntimeCall("str", name, []WasmExpression{x}, call, indent)
+}
+
+// stringLiteral places a string literal in static memory and returns the
+// address of its header. Equal literals share the header.
+func (m *WasmModule) stringLiteral(s string) int32 {
+	if s == "" {
+		return 0
+	}
+	if addr, ok := m.strings[s]; ok {
+		return addr
+	}
+	data := m.memory.allocGlobal(len(s), 1)
+	m.memory.writeBytes(data, []byte(s))
+	addr := m.memory.allocGlobal(stringHeaderSize, 4)
+	m.memory.writeInt32(addr, int32(data))
+	m.memory.writeInt32(addr+4, int32(len(s)))
+	m.strings[s] = int32(addr)
+	return int32(addr)
+}
+
+func (s *WasmScope) parseStringLen(call *ast.CallExpr, indent int) (WasmExpression, error) {
+	args, err := s.parseArgs(call.Args, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createRuntimeCall("str", "StringLen", args, call, indent)
+}
+
+func (s *WasmScope) parseStringIndex(expr *ast.IndexExpr, indent int) (WasmExpression, error) {
+	args, err := s.parseArgs([]ast.Expr{expr.X, expr.Index}, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createRuntimeCall("str", "StringIndex", args, expr, indent)
+}
+
+func (s *WasmScope) parseStringSliceExpr(expr *ast.SliceExpr, indent int) (WasmExpression, error) {
+	x, err := s.parseExpr(expr.X, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	var low WasmExpression
+	if expr.Low == nil {
+		low, err = s.createLiteralInt32(0, indent+1)
+	} else {
+		low, err = s.parseExpr(expr.Low, indent+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expr.High == nil {
+		return s.createTypedRuntimeCall("str", "StringSliceFrom", []WasmExpression{x, low}, expr, indent)
+	}
+	high, err := s.parseExpr(expr.High, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createTypedRuntimeCall("str", "StringSlice", []WasmExpression{x, low, high}, expr, indent)
+}
+
+// parseStringBinaryExpr handles concatenation and comparison of strings. A
+// comparison compares the result of StringCompare to 0.
+func (s *WasmScope) parseStringBinaryExpr(expr *ast.BinaryExpr, indent int) (WasmExpression, error) {
+	switch expr.Op {
+	case token.ADD:
+		args, err := s.parseArgs([]ast.Expr{expr.X, expr.Y}, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		return s.createTypedRuntimeCall("str", "StringConcat", args, expr, indent)
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		args, err := s.parseArgs([]ast.Expr{expr.X, expr.Y}, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		cmp, err := s.createRuntimeCall("str", "StringCompare", args, nil, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		zero, err := s.createLiteralInt32(0, indent+1)
+		if err != nil {
+			return nil, err
+		}
+		result, err := s.createBinaryExpr(cmp, zero, binOpMapping[expr.Op], cmp.getType(), indent)
+		if err != nil {
+			return nil, err
+		}
+		result.setNode(expr)
+		return result, nil
+	}
+	return nil, s.f.file.ErrorNode(expr, "unsupported string operator: %v", expr.Op)
+}
+
+// parseStringRangeStmt lowers a range loop over a string onto a loop over the
+// byte indices of its runes. The value is decoded by StringRune, and invalid
+// UTF-8 yields U+FFFD for each invalid byte like in Go.
+func (s *WasmScope) parseStringRangeStmt(stmt *ast.RangeStmt, indent int) (WasmExpression, error) {
+	outerScope := s.f.createScope("loop_block")
+	scope := s.f.createScope("loop")
+
+	x, err := outerScope.parseExpr(stmt.X, indent+2)
+	if err != nil {
+		return nil, fmt.Errorf("error in the range expression of a loop: %w", err)
+	}
+	intType, err := s.f.module.scalarType("int")
+	if err != nil {
+		return nil, err
+	}
+	str, err := outerScope.createTempVar("range", x.getType())
+	if err != nil {
+		return nil, err
+	}
+	set, err := outerScope.createSetVar(str, x, stmt, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	outerScope.expressions = append(outerScope.expressions, set)
+	n, err := outerScope.createTempVar("range_count", intType)
+	if err != nil {
+		return nil, err
+	}
+	strLen, err := outerScope.createRuntimeCall("str", "StringLen", []WasmExpression{outerScope.createGetLocal(str, stmt.X, indent+3)}, stmt.X, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	set, err = outerScope.createSetVar(n, strLen, stmt, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	outerScope.expressions = append(outerScope.expressions, set)
+	index, err := outerScope.createTempVar("range_index", intType)
+	if err != nil {
+		return nil, err
+	}
+	zero, err := outerScope.createLiteral("0", intType, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	set, err = outerScope.createSetVar(index, zero, stmt, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	outerScope.expressions = append(outerScope.expressions, set)
+
+	exitCond, err := scope.createBinaryExpr(scope.createGetLocal(index, stmt, indent+4), scope.createGetLocal(n, stmt, indent+4), binOpGe, intType, indent+3)
+	if err != nil {
+		return nil, err
+	}
+	scope.appendLoopExit(exitCond, indent+2)
+
+	if key := stmt.Key; key != nil && !isBlankIdent(key) {
+		set, err := scope.createRangeAssign(key, scope.createGetLocal(index, stmt, indent+3), stmt, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		scope.expressions = append(scope.expressions, set)
+	}
+	if value := stmt.Value; value != nil && !isBlankIdent(value) {
+		args := []WasmExpression{scope.createGetLocal(str, stmt.X, indent+4), scope.createGetLocal(index, stmt, indent+4)}
+		r, err := scope.createRuntimeCall("str", "StringRune", args, nil, indent+3)
+		if err != nil {
+			return nil, err
+		}
+		r.setComment("range value")
+		set, err := scope.createRangeAssign(value, r, stmt, indent+2)
+		if err != nil {
+			return nil, err
+		}
+		scope.expressions = append(scope.expressions, set)
+	}
+
+	err = scope.parseLoopBody(stmt.Body, indent+2)
+	if err != nil {
+		return nil, fmt.Errorf("error in the body of a loop: %w", err)
+	}
+
+	args := []WasmExpression{scope.createGetLocal(str, stmt.X, indent+4), scope.createGetLocal(index, stmt, indent+4)}
+	next, err := scope.createRuntimeCall("str", "StringNext", args, nil, indent+3)
+	if err != nil {
+		return nil, err
+	}
+	post, err := scope.createSetVar(index, next, stmt, indent+2)
+	if err != nil {
+		return nil, err
+	}
+	scope.expressions = append(scope.expressions, post)
+
+	return s.createLoop(outerScope, scope, stmt, indent), nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+)
+
+// A struct value is the address of its memory, like an array. The fields are
+// laid out as in Go, see convertStructType. A struct variable holds the address
+// of its own memory, which is allocated when it is declared, and assignments
+// copy the value into it. Struct parameters are passed by address and the
+// function makes its own copies on entry, results are returned by address and
+// the caller copies them if it keeps them.
+
+// WasmSequence evaluates statements before a value, e.g., the stores of the
+// fields of a composite literal before its address.
+type WasmSequence struct {
+	WasmExprBase
+	stmts []WasmExpression
+	value WasmExpression
+}
+
+func (q *WasmSequence) getType() WasmType {
+	if q.ty != nil {
+		return q.ty
+	}
+	return q.value.getType()
+}
+
+func (q *WasmSequence) print(writer FormattingWriter) {
+	for _, stmt := range q.stmts {
+		printStmt(writer, stmt)
+	}
+	q.value.print(writer)
+}
+
+func (q *WasmSequence) encode(writer *WasmBinaryWriter) {
+	for _, stmt := range q.stmts {
+		writer.encodeStmt(stmt)
+	}
+	q.value.encode(writer)
+}
+
+// isInlineArray returns whether t is the type of an array laid out in a struct.
+func isInlineArray(t WasmType) bool {
+	a, ok := t.(*WasmTypeArray)
+	return ok && a.inline
+}
+
+func isStruct(t types.Type) bool {
+	_, ok := t.Underlying().(*types.Struct)
+	return ok
+}
+
+// structType returns the type of a struct-valued expression.
+func (s *WasmScope) structType(expr ast.Expr) (*WasmTypeStruct, error) {
+	ty, err := s.f.file.convertType(s.f.file.info.TypeOf(expr))
+	if err != nil {
+		return nil, s.f.file.ErrorNode(expr, "%v", err)
+	}
+	st, ok := ty.(*WasmTypeStruct)
+	if !ok {
+		return nil, s.f.file.ErrorNode(expr, "not a struct: %s", ty.getName())
+	}
+	return st, nil
+}
+
+// structOf returns the struct type of x, which is a struct value or a pointer
+// to a struct.
+func structOf(x WasmExpression) (*WasmTypeStruct, error) {
+	switch ty := x.getFullType().(type) {
+	case *WasmTypeStruct:
+		return ty, nil
+	case *WasmTypePointer:
+		if st, ok := ty.base.(*WasmTypeStruct); ok {
+			return st, nil
+		}
+	case nil:
+		return nil, fmt.Errorf("full type of a struct is nil")
+	}
+	return nil, fmt.Errorf("unsupported type in SelectorExpr: %v", x.getFullType())
+}
+
+// createStructAlloc returns a new zeroed struct of type t.
+func (s *WasmScope) createStructAlloc(t *WasmTypeStruct, node ast.Node, indent int) (WasmExpression, error) {
+	ptr, err := s.f.file.createPointerType(t)
+	if err != nil {
+		return nil, err
+	}
+	alloc, err := s.generateAlloc(int32(t.getSize()), int32(t.getAlign()), node, ptr, indent)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't generate struct alloc: %w", err)
+	}
+	alloc.setFullType(t)
+	return alloc, nil
+}
+
+func (s *WasmScope) createStructSize(t *WasmTypeStruct, indent int) (WasmExpression, error) {
+	n, err := s.createLiteralInt32(int32(t.getSize()), indent)
+	if err != nil {
+		return nil, err
+	}
+	n.setComment(fmt.Sprintf("size of %s", t.getName()))
+	return n, nil
+}
+
+// createStructCopy returns a new struct that is a copy of src.
+func (s *WasmScope) createStructCopy(src WasmExpression, t *WasmTypeStruct, node ast.Node, indent int) (WasmExpression, error) {
+	dst, err := s.createStructAlloc(t, node, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	n, err := s.createStructSize(t, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	cp, err := s.createRuntimeCall("gc", "Copy", []WasmExpression{dst, src, n}, node, indent)
+	if err != nil {
+		return nil, err
+	}
+	cp.setFullType(t)
+	return cp, nil
+}
+
+// createStructStore copies the struct src into the memory at dst.
+func (s *WasmScope) createStructStore(dst, src WasmExpression, t *WasmTypeStruct, node ast.Node, indent int) (WasmExpression, error) {
+	n, err := s.createStructSize(t, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.generateMemcpy(dst, src, n, node, indent)
+}
+
+// parseStructValue returns the value of expr for a new variable, i.e., a copy,
+// unless it is a composite literal, which is a new struct already.
+func (s *WasmScope) parseStructValue(expr ast.Expr, t *WasmTypeStruct, indent int) (WasmExpression, error) {
+	if _, ok := ast.Unparen(expr).(*ast.CompositeLit); ok {
+		return s.parseExpr(expr, indent)
+	}
+	x, err := s.parseExpr(expr, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createStructCopy(x, t, expr, indent)
+}
+
+// parseStructLit returns a new struct with the fields of a composite literal,
+// which may be keyed or positional. The other fields are zero.
+func (s *WasmScope) parseStructLit(lit *ast.CompositeLit, indent int) (WasmExpression, error) {
+	t, err := s.structType(lit)
+	if err != nil {
+		return nil, err
+	}
+	alloc, err := s.createStructAlloc(t, lit, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(lit.Elts) == 0 {
+		return alloc, nil
+	}
+	tmp, err := s.createTempVar("lit", t)
+	if err != nil {
+		return nil, err
+	}
+	set, err := s.createSetVar(tmp, alloc, nil, indent)
+	if err != nil {
+		return nil, err
+	}
+	q := &WasmSequence{
+		stmts: []WasmExpression{set},
+		value: s.createGetLocal(tmp, lit, indent),
+	}
+	st := s.f.file.info.TypeOf(lit).Underlying().(*types.Struct)
+	for i, elt := range lit.Elts {
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			for j := 0; j < st.NumFields(); j++ {
+				if st.Field(j).Name() == kv.Key.(*ast.Ident).Name {
+					i = j
+				}
+			}
+			elt = kv.Value
+		}
+		field := t.fields[i]
+		val, err := s.parseExprAs(elt, st.Field(i).Type(), indent+1)
+		if err != nil {
+			return nil, err
+		}
+		lvalue, err := s.createFieldAccessExpr(s.createGetLocal(tmp, nil, indent+2), field, indent)
+		if err != nil {
+			return nil, err
+		}
+		store, err := s.createStore(lvalue.addr, val, field.t, nil, indent)
+		if err != nil {
+			return nil, s.f.file.ErrorNode(elt, "%v", err)
+		}
+		store.setComment(fmt.Sprintf("field %s", field.name))
+		q.stmts = append(q.stmts, store)
+	}
+	q.setIndent(indent)
+	q.setScope(s)
+	q.setNode(lit)
+	q.setFullType(t)
+	return q, nil
+}
+
+// parseStructAssignStmt parses the assignment of a struct value to a single
+// variable or memory location.
+func (s *WasmScope) parseStructAssignStmt(stmt *ast.AssignStmt, indent int) ([]WasmExpression, error) {
+	t, err := s.structType(stmt.Lhs[0])
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := s.parseExpr(stmt.Rhs[0], indent+1)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing RHS of an assignment: %w", err)
+	}
+	_, isNew := ast.Unparen(stmt.Rhs[0]).(*ast.CompositeLit)
+	expr, err := s.assignStruct(stmt.Lhs[0], rhs, isNew, t, stmt, indent)
+	if err != nil {
+		return nil, err
+	}
+	return []WasmExpression{expr}, nil
+}
+
+// assignStruct assigns the struct value to lhs. A variable that lhs declares
+// gets a copy, unless the value is new, otherwise the value is copied into
+// the memory of lhs.
+func (s *WasmScope) assignStruct(lhs ast.Expr, value WasmExpression, isNew bool, t *WasmTypeStruct, stmt ast.Stmt, indent int) (WasmExpression, error) {
+	if ident, ok := lhs.(*ast.Ident); ok && s.f.file.info.Defs[ident] != nil {
+		v, err := s.createLocalVar(ident, t)
+		if err != nil {
+			return nil, err
+		}
+		if !isNew {
+			value, err = s.createStructCopy(value, t, stmt, indent+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return s.createSetVar(v, value, stmt, indent)
+	}
+	dst, err := s.parseExpr(lhs, indent+1)
+	if err != nil {
+		return nil, err
+	}
+	return s.createStructStore(dst, value, t, stmt, indent)
+}
+
+// initStructVars replaces the addresses of the struct parameters, including a
+// struct receiver, with the addresses of copies, so that the function can't
+// modify the caller's structs. Named struct results get new zeroed structs.
+func (f *WasmFunc) initStructVars(indent int) error {
+	s := f.scope
+	for i, p := range f.params {
+		st, ok := p.t.(*WasmTypeStruct)
+		if i == 0 && f.copyRecv {
+			st, ok = p.t.(*WasmTypePointer).base.(*WasmTypeStruct)
+		}
+		if !ok || p.name == "" || st.getSize() == 0 {
+			continue
+		}
+		cp, err := s.createStructCopy(s.createGetLocal(p, nil, indent+2), st, nil, indent+1)
+		if err != nil {
+			return err
+		}
+		cp.setFullType(p.fullType)
+		set, err := s.createSetVar(p, cp, nil, indent)
+		if err != nil {
+			return err
+		}
+		s.expressions = append(s.expressions, set)
+	}
+	for _, r := range f.results {
+		st, ok := r.t.(*WasmTypeStruct)
+		if !ok || r.v == nil {
+			continue
+		}
+		alloc, err := s.createStructAlloc(st, r.astIdent, indent+1)
+		if err != nil {
+			return err
+		}
+		set, err := s.createSetVar(r.v, alloc, nil, indent)
+		if err != nil {
+			return err
+		}
+		s.expressions = append(s.expressions, set)
+	}
+	return nil
+}
+
+// parseFieldPath returns the lvalue of the field of x selected by the indices
+// of a path of embedded fields, see types.Selection. x is a struct value or a
+// pointer to a struct, as are the embedded fields on the way.
+func (s *WasmScope) parseFieldPath(x WasmExpression, path []int, indent int) (*LValue, error) {
+	var lvalue *LValue
+	for i, index := range path {
+		if i > 0 {
+			var err error
+			x, err = s.createLoad(lvalue.addr, lvalue.t, indent+2)
+			if err != nil {
+				return nil, err
+			}
+		}
+		st, err := structOf(x)
+		if err != nil {
+			return nil, err
+		}
+		lvalue, err = s.createFieldAccessExpr(x, st.fields[index], indent)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lvalue, nil
+}
@@ -0,0 +1,181 @@
+package chans
+
+func generate(n int32, out chan int32) {
+	defer close(out)
+	for i := int32(1); i <= n; i++ {
+		out <- i
+	}
+}
+
+func square(in chan int32, out chan int32) {
+	for v := range in {
+		out <- v * v
+	}
+	close(out)
+}
+
+// A pipeline of goroutines connected by unbuffered channels.
+//
+//wasm:assert_return (invoke "Pipeline" (i32.const 4)) (i32.const 30)
+//wasm:assert_return (invoke "Pipeline" (i32.const 0)) (i32.const 0)
+func Pipeline(n int32) int32 {
+	nums := make(chan int32)
+	squares := make(chan int32)
+	go generate(n, nums)
+	go square(nums, squares)
+	sum := int32(0)
+	for v := range squares {
+		sum = sum + v
+	}
+	return sum
+}
+
+//wasm:assert_return (invoke "Buffered") (i32.const 2312)
+func Buffered() int32 {
+	c := make(chan int32, 3)
+	c <- 1
+	c <- 2
+	n := int32(len(c))*1000 + int32(cap(c))*100
+	a := <-c
+	b := <-c
+	return n + a*10 + b
+}
+
+// A receive from a closed channel returns the buffered values, then zero
+// values.
+//
+//wasm:assert_return (invoke "Closed") (i32.const 7)
+func Closed() int32 {
+	c := make(chan int32, 2)
+	c <- 7
+	close(c)
+	a, ok := <-c
+	b, more := <-c
+	if !ok {
+		return -1
+	}
+	if more {
+		return -2
+	}
+	return a + b
+}
+
+//wasm:assert_return (invoke "TrySend" (i32.const 1)) (i32.const 1)
+//wasm:assert_return (invoke "TrySend" (i32.const 5)) (i32.const 2)
+func TrySend(n int32) int32 {
+	c := make(chan int32, 2)
+	sent := int32(0)
+	for i := int32(0); i < n; i++ {
+		select {
+		case c <- i:
+			sent++
+		default:
+		}
+	}
+	return sent
+}
+
+func produce(c chan int32, v int32, n int32, done chan bool) {
+	for i := int32(0); i < n; i++ {
+		c <- v
+	}
+	done <- true
+}
+
+// select waits for the first of several channels.
+//
+//wasm:assert_return (invoke "FanIn") (i32.const 2005)
+func FanIn() int32 {
+	a := make(chan int32)
+	b := make(chan int32)
+	done := make(chan bool)
+	go produce(a, 1, 5, done)
+	go produce(b, 1000, 2, done)
+	sum := int32(0)
+	running := 2
+	for running > 0 {
+		select {
+		case v := <-a:
+			sum = sum + v
+		case v, ok := <-b:
+			if ok {
+				sum = sum + v
+			}
+		case <-done:
+			running--
+		}
+	}
+	return sum
+}
+
+// receive returns the sum of n values received from c.
+func receive(c chan int32, n int32) int32 {
+	total := int32(0)
+	for i := int32(0); i < n; i++ {
+		v := <-c
+		total = total + v
+	}
+	return total
+}
+
+func receiveTwice(c chan int32, n int32) (int32, int32) {
+	a := receive(c, n)
+	b := receive(c, n)
+	return a, b
+}
+
+// A goroutine blocks in functions that it calls.
+//
+//wasm:assert_return (invoke "Workers" (i32.const 3)) (i32.const 615)
+func Workers(n int32) int32 {
+	c := make(chan int32)
+	results := make(chan int32, 1)
+	go func() {
+		a, b := receiveTwice(c, n)
+		results <- a*100 + b
+	}()
+	for i := int32(1); i <= 2*n; i++ {
+		c <- i
+	}
+	return <-results
+}
+
+type acc struct {
+	sum int32
+}
+
+func (a *acc) run(in chan int32, done chan int32) {
+	for {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				done <- a.sum
+				return
+			}
+			a.sum = a.sum + v
+		}
+	}
+}
+
+// A method runs as a goroutine, which returns from a select statement.
+//
+//wasm:assert_return (invoke "Method" (i32.const 10)) (i32.const 55)
+func Method(n int32) int32 {
+	a := &acc{}
+	in := make(chan int32, 2)
+	done := make(chan int32)
+	go a.run(in, done)
+	for i := int32(1); i <= n; i++ {
+		in <- i
+	}
+	close(in)
+	return <-done
+}
+
+// Blocking when no other goroutine can run traps.
+//
+//wasm:assert_trap (invoke "Deadlock") "unreachable"
+func Deadlock() int32 {
+	c := make(chan int32)
+	return <-c
+}
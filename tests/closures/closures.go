@@ -0,0 +1,187 @@
+package closures
+
+type counter struct {
+	n int32
+}
+
+func (c *counter) add(k int32) int32 {
+	c.n = c.n + k
+	return c.n
+}
+
+type pair struct {
+	a int32
+	b int32
+}
+
+func (p pair) sum() int32 {
+	return p.a + p.b
+}
+
+type handler struct {
+	f func(int32) int32
+}
+
+func makeAdder(n int32) func(int32) int32 {
+	return func(x int32) int32 {
+		return x + n
+	}
+}
+
+func apply(f func(int32) int32, x int32) int32 {
+	return f(x)
+}
+
+func double(x int32) int32 {
+	return x * 2
+}
+
+//wasm:assert_return (invoke "Counter" (i32.const 5)) (i32.const 15)
+func Counter(n int32) int32 {
+	count := int32(0)
+	inc := func(k int32) {
+		count = count + k
+	}
+	for i := int32(1); i <= n; i++ {
+		inc(i)
+	}
+	return count
+}
+
+//wasm:assert_return (invoke "Adder" (i32.const 3)) (i32.const 13)
+func Adder(n int32) int32 {
+	add10 := makeAdder(10)
+	return add10(n)
+}
+
+// Each closure created by the factory has its own copy of the parameter.
+//
+//wasm:assert_return (invoke "Factory") (i32.const 521)
+func Factory() int32 {
+	f := makeAdder(1)
+	g := makeAdder(20)
+	h := makeAdder(500)
+	return f(0) + g(0) + h(0)
+}
+
+//wasm:assert_return (invoke "Nested" (i32.const 2)) (i32.const 123)
+func Nested(n int32) int32 {
+	a := int32(100)
+	f := func() func() int32 {
+		b := int32(20)
+		return func() int32 {
+			a = a + b + n
+			return a
+		}
+	}
+	g := f()
+	g()
+	return a - n - 19 + g() - 120 - n
+}
+
+// The variables declared in the body of a loop are new in each iteration.
+//
+//wasm:assert_return (invoke "Loop") (i32.const 123)
+func Loop() int32 {
+	var fs []func() int32
+	for i := int32(1); i <= 3; i++ {
+		k := i
+		fs = append(fs, func() int32 {
+			return k
+		})
+	}
+	result := int32(0)
+	for _, f := range fs {
+		result = result*10 + f()
+	}
+	return result
+}
+
+// The variables declared by the init statement of a loop are new in each
+// iteration, too. An increment in the body carries over to the next one.
+//
+//wasm:assert_return (invoke "LoopVar") (i32.const 246)
+func LoopVar() int32 {
+	var fs []func() int32
+	for i := int32(1); i <= 6; i++ {
+		fs = append(fs, func() int32 {
+			return i
+		})
+		i = i + 1
+	}
+	result := int32(0)
+	for _, f := range fs {
+		result = result*10 + f()
+	}
+	return result
+}
+
+//wasm:assert_return (invoke "Callback" (i32.const 7)) (i32.const 35)
+func Callback(n int32) int32 {
+	sq := apply(func(x int32) int32 {
+		return x * x
+	}, n)
+	return sq - apply(double, n)
+}
+
+//wasm:assert_return (invoke "MethodValue" (i32.const 4)) (i32.const 10)
+func MethodValue(n int32) int32 {
+	c := &counter{}
+	add := c.add
+	add(n)
+	add(n)
+	r := apply(c.add, 1)
+	return r + c.n - n*2
+}
+
+// A method value with a value receiver binds a copy of the receiver.
+//
+//wasm:assert_return (invoke "BoundCopy") (i32.const 3)
+func BoundCopy() int32 {
+	p := &pair{}
+	p.a = 1
+	p.b = 2
+	sum := p.sum
+	p.a = 10
+	return sum()
+}
+
+//wasm:assert_return (invoke "FieldFunc" (i32.const 6)) (i32.const 12)
+func FieldFunc(n int32) int32 {
+	h := &handler{}
+	if h.f != nil {
+		return -1
+	}
+	h.f = double
+	return h.f(n)
+}
+
+//wasm:assert_return (invoke "CapturedPointer" (i32.const 8)) (i32.const 16)
+func CapturedPointer(n int32) int32 {
+	c := &counter{}
+	add := func() {
+		c.add(n)
+	}
+	add()
+	add()
+	return c.n
+}
+
+// The cell of a captured pointer holds the address of the literal.
+//
+//wasm:assert_return (invoke "CapturedLiteral" (i32.const 5)) (i32.const 6)
+func CapturedLiteral(n int32) int32 {
+	p := &pair{a: 1, b: n}
+	f := func() int32 {
+		return p.sum()
+	}
+	return f()
+}
+
+// Calling a nil function value traps.
+//
+//wasm:assert_trap (invoke "NilCall") "undefined element"
+func NilCall() int32 {
+	var f func() int32
+	return f()
+}
@@ -0,0 +1,121 @@
+package collect
+
+import "gowasm/rt/gc"
+
+type node struct {
+	value int32
+	next  *node
+}
+
+func list(n int32) *node {
+	var head *node
+	for i := int32(1); i <= n; i++ {
+		p := &node{}
+		p.value = i
+		p.next = head
+		head = p
+	}
+	return head
+}
+
+func sum(head *node) int32 {
+	total := int32(0)
+	for p := head; p != nil; p = p.next {
+		total = total + p.value
+	}
+	return total
+}
+
+// The garbage of the loop is larger than the heap, which is collected.
+//
+//wasm:assert_return (invoke "Garbage" (i32.const 100)) (i32.const 505000)
+func Garbage(n int32) int32 {
+	total := int32(0)
+	for i := int32(0); i < n; i++ {
+		total = total + sum(list(100))
+	}
+	return total
+}
+
+// Objects that are reachable from locals survive a collection.
+//
+//wasm:assert_return (invoke "Survive" (i32.const 500)) (i32.const 125250)
+func Survive(n int32) int32 {
+	head := list(n)
+	gc.Collect()
+	for i := int32(0); i < 100; i++ {
+		list(50)
+	}
+	return sum(head)
+}
+
+// A collection frees the blocks that are no longer referenced.
+//
+//wasm:assert_return (invoke "Reclaim") (i32.const 0)
+func Reclaim() int32 {
+	gc.Collect()
+	before := gc.Used()
+	head := list(100)
+	if sum(head) != 5050 {
+		return -1
+	}
+	head = nil
+	gc.Collect()
+	return gc.Used() - before
+}
+
+type pair struct {
+	a int32
+	b int32
+}
+
+// A pointer into the middle of an object keeps it alive.
+//
+//wasm:assert_return (invoke "Interior" (i32.const 7)) (i32.const 7)
+func Interior(v int32) int32 {
+	p := &pair{}
+	p.b = v
+	q := &p.b
+	p = nil
+	gc.Collect()
+	for i := int32(0); i < 100; i++ {
+		list(10)
+	}
+	return *q
+}
+
+// descend allocates a node in each of n nested calls and collects garbage in
+// the innermost one, while the nodes are referenced only by the frames.
+func descend(n int32) int32 {
+	if n == 0 {
+		gc.Collect()
+		for i := int32(0); i < 100; i++ {
+			list(10)
+		}
+		return 0
+	}
+	p := &node{}
+	p.value = n
+	return descend(n-1) + p.value
+}
+
+// The shadow stack of a deep recursion continues in segments on the heap,
+// whose frames are roots too.
+//
+//wasm:assert_return (invoke "Deep" (i32.const 3000)) (i32.const 4501500)
+func Deep(n int32) int32 {
+	return descend(n)
+}
+
+// A call that traps leaves its frames on the shadow stack, which is reset
+// when the host calls the module again.
+//
+//wasm:assert_trap (invoke "Abort" (i32.const 2000)) "unreachable"
+//wasm:invoke (invoke "Deep" (i32.const 3000))
+func Abort(n int32) int32 {
+	p := &node{}
+	if n == 0 {
+		panic("abort")
+	}
+	return Abort(n-1) + p.value
+}
@@ -0,0 +1,105 @@
+package consts
+
+import "unsafe"
+
+type Weekday int32
+
+const (
+	Sunday Weekday = iota
+	Monday
+	Tuesday
+	Wednesday
+	Thursday
+	Friday
+	Saturday
+)
+
+func (d Weekday) next() Weekday {
+	if d == Saturday {
+		return Sunday
+	}
+	return d + 1
+}
+
+const (
+	_  = iota
+	KB = 1 << (10 * iota)
+	MB
+	GB
+)
+
+const mask = 1<<10 - 1
+
+const (
+	flagRead = 1 << iota
+	flagWrite
+	flagExec
+)
+
+var table [mask >> 7]int32
+
+//wasm:assert_return (invoke "Enum" (i32.const 6)) (i32.const 0)
+//wasm:assert_return (invoke "Enum" (i32.const 2)) (i32.const 3)
+func Enum(d int32) int32 {
+	return int32(Weekday(d).next())
+}
+
+//wasm:assert_return (invoke "Sizes") (i32.const 1031)
+func Sizes() int32 {
+	return int32(MB/KB) + int32(len(table))
+}
+
+//wasm:assert_return (invoke "Local" (i32.const 1)) (i32.const 26502)
+func Local(n int32) int32 {
+	const local = 0x10 + 0o7 + 0b11
+	const (
+		x = iota * 2
+		y
+		z
+	)
+	var arr [z + 1]int32
+	return local*1000 + int32(len(arr))*100 + y*n
+}
+
+//wasm:assert_return (invoke "Flags" (i32.const 5)) (i32.const 2)
+//wasm:assert_return (invoke "Flags" (i32.const 3)) (i32.const 1)
+func Flags(f int32) int32 {
+	switch f {
+	case flagRead | flagExec:
+		return 2
+	case flagRead | flagWrite:
+		return 1
+	}
+	return 0
+}
+
+//wasm:assert_return (invoke "Huge") (i64.const 1073741824)
+func Huge() int64 {
+	const big = GB * 1024
+	return big / KB
+}
+
+//wasm:assert_return (invoke "Area") (f64.const 12.56636)
+func Area() float64 {
+	const pi = 3.14159
+	const r = 2
+	return pi * r * r
+}
+
+type header struct {
+	kind  uint8
+	flags uint16
+	size  int32
+}
+
+// unsafe.Sizeof and unsafe.Alignof use the sizes of the linear memory, where
+// int and pointers are 32 bits wide.
+//
+//wasm:assert_return (invoke "Layout") (i32.const 4848)
+func Layout() int32 {
+	var p *int32
+	n := int32(unsafe.Sizeof(p)) * 1000
+	n = n + int32(unsafe.Sizeof(header{}))*100
+	n = n + int32(unsafe.Alignof(header{}))*10
+	return n + int32(unsafe.Sizeof(n)) + int32(unsafe.Sizeof(len(table)))
+}
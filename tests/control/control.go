@@ -0,0 +1,260 @@
+package control
+
+//wasm:assert_return (invoke "Classify" (i32.const 0)) (i32.const 100)
+//wasm:assert_return (invoke "Classify" (i32.const 1)) (i32.const 101)
+//wasm:assert_return (invoke "Classify" (i32.const 2)) (i32.const 101)
+//wasm:assert_return (invoke "Classify" (i32.const 3)) (i32.const 103)
+//wasm:assert_return (invoke "Classify" (i32.const 5)) (i32.const 105)
+//wasm:assert_return (invoke "Classify" (i32.const 4)) (i32.const 999)
+//wasm:assert_return (invoke "Classify" (i32.const -1)) (i32.const 999)
+func Classify(x int32) int32 {
+	var r int32
+	r = 0
+	switch x {
+	case 0:
+		r = 100
+	case 1, 2:
+		r = 101
+	case 3:
+		r = 103
+	default:
+		r = 999
+	case 5:
+		r = 105
+	}
+	return r
+}
+
+//wasm:assert_return (invoke "Fallthrough" (i32.const 1)) (i32.const 3)
+//wasm:assert_return (invoke "Fallthrough" (i32.const 2)) (i32.const 2)
+//wasm:assert_return (invoke "Fallthrough" (i32.const 7)) (i32.const 0)
+func Fallthrough(x int32) int32 {
+	var r int32
+	r = 0
+	switch x {
+	case 1:
+		r = r + 1
+		fallthrough
+	case 2:
+		r = r + 2
+	}
+	return r
+}
+
+//wasm:assert_return (invoke "Sign" (i64.const -5)) (i32.const -1)
+//wasm:assert_return (invoke "Sign" (i64.const 0)) (i32.const 0)
+//wasm:assert_return (invoke "Sign" (i64.const 8)) (i32.const 1)
+func Sign(x int64) int32 {
+	switch {
+	case x < 0:
+		return -1
+	case x > 0:
+		return 1
+	}
+	return 0
+}
+
+//wasm:assert_return (invoke "SwitchInit" (i32.const 4)) (i32.const 1)
+//wasm:assert_return (invoke "SwitchInit" (i32.const 6)) (i32.const 2)
+func SwitchInit(x int32) int32 {
+	switch y := x + 1; y {
+	case 5:
+		return 1
+	}
+	return 2
+}
+
+//wasm:assert_return (invoke "CountDown" (i32.const 5)) (i32.const 15)
+func CountDown(n int32) int32 {
+	var sum int32
+	sum = 0
+	for n > 0 {
+		sum = sum + n
+		n--
+	}
+	return sum
+}
+
+//wasm:assert_return (invoke "ForNoInit" (i32.const 3)) (i32.const 8)
+func ForNoInit(n int32) int32 {
+	var r int32
+	r = 1
+	for ; n > 0; n-- {
+		r = r * 2
+	}
+	return r
+}
+
+//wasm:assert_return (invoke "Forever" (i32.const 7)) (i32.const 7)
+func Forever(n int32) int32 {
+	var i int32
+	i = 0
+	for {
+		if i == n {
+			return i
+		}
+		i++
+	}
+}
+
+//wasm:assert_return (invoke "RangeArray") (i32.const 70)
+func RangeArray() int32 {
+	a := [...]int32{3, 5, 7, 9}
+	var sum int32
+	sum = 0
+	for i, v := range a {
+		sum = sum + v*int32(i+1)
+	}
+	return sum
+}
+
+//wasm:assert_return (invoke "RangeKeys") (i32.const 6)
+func RangeKeys() int32 {
+	var a [4]int32
+	var sum int
+	sum = 0
+	for i := range a {
+		sum = sum + i
+	}
+	return int32(sum)
+}
+
+//wasm:assert_return (invoke "RangeAssign") (i32.const 9)
+func RangeAssign() int32 {
+	a := [...]int32{3, 9, 4}
+	var v int32
+	v = 0
+	for _, v = range a {
+		if v == 9 {
+			return v
+		}
+	}
+	return 0
+}
+
+//wasm:assert_return (invoke "RangeCount" (i32.const 5)) (i32.const 10)
+func RangeCount(n int32) int32 {
+	var sum int32
+	sum = 0
+	for i := range n {
+		sum = sum + i
+	}
+	return sum
+}
+
+//wasm:assert_return (invoke "FindFirst" (i32.const 7)) (i32.const 2)
+//wasm:assert_return (invoke "FindFirst" (i32.const 8)) (i32.const -1)
+func FindFirst(x int32) int32 {
+	a := [...]int32{3, 5, 7, 9, 7}
+	var found int32
+	found = -1
+	for i, v := range a {
+		if v == x {
+			found = int32(i)
+			break
+		}
+	}
+	return found
+}
+
+//wasm:assert_return (invoke "SumOdd" (i32.const 10)) (i32.const 25)
+func SumOdd(n int32) int32 {
+	var sum int32
+	sum = 0
+	for i := int32(0); i < n; i++ {
+		if i&1 == 0 {
+			continue
+		}
+		sum = sum + i
+	}
+	return sum
+}
+
+//wasm:assert_return (invoke "Labeled" (i32.const 4)) (i32.const 9)
+func Labeled(n int32) int32 {
+	var count int32
+	count = 0
+outer:
+	for i := int32(0); i < n; i++ {
+		for j := int32(0); j < n; j++ {
+			if j > i {
+				continue outer
+			}
+			if i == 3 {
+				break outer
+			}
+			count++
+		}
+	}
+	return count + 3
+}
+
+//wasm:assert_return (invoke "BreakSwitch" (i32.const 1)) (i32.const 10)
+//wasm:assert_return (invoke "BreakSwitch" (i32.const 2)) (i32.const 3)
+func BreakSwitch(x int32) int32 {
+	var r int32
+	r = 0
+loop:
+	for i := int32(0); i < 3; i++ {
+		switch x {
+		case 1:
+			r = 10
+			break loop
+		case 2:
+			r++
+			break
+		}
+	}
+	return r
+}
+
+//wasm:assert_return (invoke "Goto" (i32.const 0)) (i32.const 1)
+//wasm:assert_return (invoke "Goto" (i32.const 5)) (i32.const 2)
+func Goto(x int32) int32 {
+	var r int32
+	r = 0
+	for x > 0 {
+		goto done
+	}
+	r = 1
+	return r
+done:
+	r = 2
+	return r
+}
+
+// RangeCopy ranges over a copy of the array, so that the assignment in the
+// loop doesn't change the later values.
+//
+//wasm:assert_return (invoke "RangeCopy") (i32.const 6)
+func RangeCopy() int32 {
+	a := [...]int32{1, 2, 3}
+	var sum int32
+	sum = 0
+	for i, v := range a {
+		if i == 0 {
+			a[2] = 100
+		}
+		sum = sum + v
+	}
+	return sum
+}
+
+func sum4(a [4]int32) int32 {
+	return a[0] + a[1] + a[2] + a[3]
+}
+
+// ShortArray has array literals with fewer elements than their length, whose
+// other elements are zero.
+//
+//wasm:assert_return (invoke "ShortArray" (i32.const 7)) (i32.const 5928)
+func ShortArray(x int32) int32 {
+	a := [4]int32{x}
+	b := [5]int32{1, 2}
+	n := int32(0)
+	for range b {
+		n++
+	}
+	c := [4]int32{3: x, 1: 2}
+	return a[3] + b[4] + n*1000 + sum4([4]int32{x, x, x}) + sum4(c)*100 + a[0]*2 - 7
+}
@@ -0,0 +1,86 @@
+// Package conv tests conversions between integers of different sizes and
+// floats, and the wrapping of arithmetic on small integers.
+package conv
+
+//wasm:assert_return (invoke "Extend" (i32.const -5) (i32.const -1)) (i64.const 4294967290)
+func Extend(x int32, y uint32) int64 {
+	return int64(x) + int64(y)
+}
+
+//wasm:assert_return (invoke "Wrap" (i64.const 4294967301)) (i32.const 5)
+func Wrap(x int64) int32 {
+	return int32(x)
+}
+
+//wasm:assert_return (invoke "Halve" (i32.const -3)) (f64.const -1.5)
+func Halve(x int32) float64 {
+	return float64(x) / 2
+}
+
+// Conversions of floats to integers truncate toward zero and saturate.
+//
+//wasm:assert_return (invoke "Truncate" (f64.const -2.75)) (i32.const -2)
+//wasm:assert_return (invoke "Truncate" (f64.const 1e20)) (i32.const 2147483647)
+//wasm:assert_return (invoke "Truncate" (f64.const -1e20)) (i32.const -2147483648)
+//wasm:assert_return (invoke "Truncate" (f64.const nan)) (i32.const 0)
+func Truncate(f float64) int32 {
+	return int32(f)
+}
+
+//wasm:assert_return (invoke "TruncateUnsigned" (f64.const 7.9)) (i32.const 7)
+//wasm:assert_return (invoke "TruncateUnsigned" (f64.const -0.5)) (i32.const 0)
+//wasm:assert_return (invoke "TruncateUnsigned" (f64.const -5)) (i32.const 0)
+//wasm:assert_return (invoke "TruncateUnsigned" (f64.const 5e9)) (i32.const -1)
+func TruncateUnsigned(f float64) uint32 {
+	return uint32(f)
+}
+
+//wasm:assert_return (invoke "TruncateWide" (f32.const -3.5)) (i64.const -3)
+//wasm:assert_return (invoke "TruncateWide" (f32.const 1e30)) (i64.const 9223372036854775807)
+//wasm:assert_return (invoke "TruncateWide" (f32.const -1e30)) (i64.const -9223372036854775808)
+//wasm:assert_return (invoke "TruncateWide" (f32.const -nan)) (i64.const 0)
+func TruncateWide(f float32) int64 {
+	return int64(f)
+}
+
+//wasm:assert_return (invoke "TruncateWideUnsigned" (f64.const 1e19)) (i64.const -8446744073709551616)
+//wasm:assert_return (invoke "TruncateWideUnsigned" (f64.const 1e30)) (i64.const -1)
+//wasm:assert_return (invoke "TruncateWideUnsigned" (f64.const -1)) (i64.const 0)
+func TruncateWideUnsigned(f float64) uint64 {
+	return uint64(f)
+}
+
+//wasm:assert_return (invoke "Single" (f64.const 0.1)) (f64.const 0.10000000149011612)
+func Single(f float64) float64 {
+	return float64(float32(f))
+}
+
+//wasm:assert_return (invoke "Bytes" (i32.const 200)) (i32.const 43944)
+func Bytes(x int32) int32 {
+	return int32(uint8(x+100))*1000 + int32(int8(x))
+}
+
+// Arithmetic on 8- and 16-bit integers wraps around.
+//
+//wasm:assert_return (invoke "Wraparound" (i32.const 127)) (i32.const -128128)
+func Wraparound(x int32) int32 {
+	a := int8(x)
+	a = a + 1
+	b := uint8(x)
+	b = ^b * 3
+	return int32(a)*1000 - int32(b)
+}
+
+//wasm:assert_return (invoke "Square" (i32.const 300)) (i32.const 24464)
+func Square(x int32) int32 {
+	s := int16(x)
+	return int32(s * s)
+}
+
+var levels [2]int8
+
+//wasm:assert_return (invoke "Stored" (i32.const -3)) (i64.const -3)
+func Stored(x int32) int64 {
+	levels[1] = int8(x)
+	return int64(levels[1])
+}
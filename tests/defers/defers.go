@@ -0,0 +1,183 @@
+package defers
+
+type recorder struct {
+	sum int32
+}
+
+func (r *recorder) add(k int32) {
+	r.sum = r.sum*10 + k
+}
+
+func record(r *recorder, k int32) {
+	r.add(k)
+}
+
+// Deferred calls run in reverse order after the result has been set.
+//
+//wasm:assert_return (invoke "DeferOrder") (i32.const 321)
+func DeferOrder() (result int32) {
+	for i := int32(1); i <= 3; i++ {
+		k := i
+		defer func() {
+			result = result*10 + k
+		}()
+	}
+	return 0
+}
+
+func run(r *recorder, n int32) {
+	defer r.add(n)
+	n = n + 1
+	defer record(r, n)
+	n = n + 1
+	r.add(n)
+}
+
+// The arguments of a deferred call are evaluated by the defer statement.
+//
+//wasm:assert_return (invoke "DeferArgs" (i32.const 1)) (i32.const 321)
+func DeferArgs(n int32) int32 {
+	r := &recorder{}
+	run(r, n)
+	return r.sum
+}
+
+func classify(r *recorder, n int32) int32 {
+	defer r.add(9)
+	if n < 0 {
+		return -1
+	}
+	for i := int32(0); i < 10; i++ {
+		if i == n {
+			return i * 2
+		}
+	}
+	return 100
+}
+
+//wasm:assert_return (invoke "MultiReturn" (i32.const 3)) (i32.const 1104)
+func MultiReturn(n int32) int32 {
+	r := &recorder{}
+	a := classify(r, -n)
+	b := classify(r, n)
+	c := classify(r, n+20)
+	return a + b + c + r.sum
+}
+
+func safeDiv(a, b int32) (q int32, ok bool) {
+	defer func() {
+		if recover() != nil {
+			q = -1
+			ok = false
+		}
+	}()
+	if b == 0 {
+		panic("division by zero")
+	}
+	return a / b, true
+}
+
+//wasm:assert_return (invoke "RecoverDiv" (i32.const 5)) (i32.const 20)
+//wasm:assert_return (invoke "RecoverDiv" (i32.const 0)) (i32.const -1000)
+func RecoverDiv(n int32) int32 {
+	q, ok := safeDiv(100, n)
+	if !ok {
+		return q * 1000
+	}
+	return q
+}
+
+func catch(f func()) (v interface{}) {
+	defer func() {
+		v = recover()
+	}()
+	f()
+	return nil
+}
+
+//wasm:assert_return (invoke "PanicValue" (i32.const 5)) (i32.const 47)
+func PanicValue(n int32) int32 {
+	v := catch(func() {
+		panic(n * 9)
+	})
+	s := catch(func() {
+		panic("ab")
+	})
+	e := catch(func() {})
+	if e != nil {
+		return -1
+	}
+	return v.(int32) + int32(len(s.(string)))
+}
+
+func deep(r *recorder, n int32) int32 {
+	if n == 0 {
+		panic("bottom")
+	}
+	defer r.add(n)
+	return deep(r, n-1) + 1
+}
+
+func middle(r *recorder, n int32) int32 {
+	return deep(r, n) + 5
+}
+
+func protect(r *recorder, n int32) (v int32) {
+	defer func() {
+		if recover() != nil {
+			v = -1
+		}
+	}()
+	return middle(r, n)
+}
+
+// A panic runs the deferred calls of all functions it unwinds, up to the one
+// that recovers.
+//
+//wasm:assert_return (invoke "Unwind" (i32.const 3)) (i32.const -877)
+func Unwind(n int32) int32 {
+	r := &recorder{}
+	v := protect(r, n)
+	return v*1000 + r.sum
+}
+
+// A panic that isn't recovered traps.
+//
+//wasm:assert_return (invoke "Crash" (i32.const 0)) (i32.const 0)
+//wasm:assert_trap (invoke "Crash" (i32.const 1)) "unreachable"
+//wasm:assert_trap (invoke "CrashNow") "unreachable"
+func Crash(n int32) int32 {
+	r := &recorder{}
+	defer r.add(n)
+	if n > 0 {
+		panic("crash")
+	}
+	return r.sum
+}
+
+func CrashNow() {
+	panic(1)
+}
+
+// A trap in a deferred call during a panic doesn't leave the panic behind for
+// the next call.
+//
+//wasm:assert_trap (invoke "TrapInDefer") "unreachable"
+//wasm:assert_trap (invoke "PanicAgain") "unreachable"
+//wasm:assert_return (invoke "RecoverAgain") (i32.const 3)
+func TrapInDefer() {
+	defer func() {
+		var a []int32
+		a[1] = 1
+	}()
+	panic("first")
+}
+
+func PanicAgain() int32 {
+	panic("again")
+}
+
+func RecoverAgain() int32 {
+	v, _ := catch(func() { panic(3) }).(int)
+	return int32(v)
+}
@@ -0,0 +1,107 @@
+package globals
+
+var primes = [8]int32{2, 3, 5, 7, 11, 13, 17, 19}
+
+var squares = [...]int32{1: 1, 2: 4, 3: 9}
+
+var grid [2][3]int32
+
+var (
+	count    int32  = 3
+	small    int8   = -3
+	half     uint16 = 40000
+	greeting        = "hello"
+	enabled         = true
+	big      int64  = 1 << 40
+	ratio           = 0.25
+)
+
+type point struct {
+	x int32
+	y int32
+}
+
+var origin = point{y: 4, x: 3}
+
+// The initializers that aren't constant run in dependency order: a is
+// initialized after b, which is declared after it.
+var a = b * 10
+var b = sumPrimes()
+var q, r = divmod(b, 10)
+var cursor point
+var pos = &cursor
+
+var initialized int32
+
+func sumPrimes() int32 {
+	total := int32(0)
+	for _, p := range primes {
+		total = total + p
+	}
+	return total
+}
+
+func divmod(x int32, y int32) (int32, int32) {
+	return x / y, x - x/y*y
+}
+
+func init() {
+	initialized = initialized + a
+}
+
+func init() {
+	pos.x = 5
+	initialized = initialized*10 + count
+}
+
+//wasm:assert_return (invoke "Prime" (i32.const 0)) (i32.const 2)
+//wasm:assert_return (invoke "Prime" (i32.const 7)) (i32.const 19)
+func Prime(i int32) int32 {
+	return primes[i]
+}
+
+//wasm:assert_return (invoke "Sparse") (i32.const 4014)
+func Sparse() int32 {
+	return int32(len(squares))*1000 + squares[0] + squares[1] + squares[2] + squares[3]
+}
+
+// The elements of global arrays are allocated statically.
+//
+//wasm:assert_return (invoke "Grid" (i32.const 7)) (i32.const 7)
+func Grid(v int32) int32 {
+	grid[1][2] = v
+	return grid[0][2] + grid[1][2]
+}
+
+//wasm:assert_return (invoke "Scalars") (i32.const 40002)
+func Scalars() int32 {
+	n := int32(half) + int32(small)*2 + int32(len(greeting))
+	if enabled {
+		n = n + count
+	}
+	return n
+}
+
+//wasm:assert_return (invoke "Origin") (i32.const 34)
+func Origin() int32 {
+	p := &origin
+	return p.x*10 + p.y
+}
+
+//wasm:assert_return (invoke "Dynamic") (i32.const 7777)
+func Dynamic() int32 {
+	return a*10 + b
+}
+
+//wasm:assert_return (invoke "Divmod") (i32.const 77)
+func Divmod() int32 {
+	return q*10 + r
+}
+
+// init functions run in the order of their declarations, after all variables
+// are initialized.
+//
+//wasm:assert_return (invoke "Initialized") (i32.const 7708)
+func Initialized() int32 {
+	return initialized + pos.x
+}
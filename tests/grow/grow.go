@@ -0,0 +1,67 @@
+// Package grow tests a heap that is larger than the initial memory.
+//
+//wasm:memory 2 32
+package grow
+
+import "gowasm/rt/wasm"
+
+// A table that is larger than the initial static memory.
+var table [4096]int32
+
+type chunk struct {
+	data []int32
+	next *chunk
+}
+
+func newChunk(n int32, next *chunk) *chunk {
+	c := &chunk{}
+	c.data = make([]int32, n)
+	c.next = next
+	return c
+}
+
+// The live objects take several times the initial memory, which grows.
+//
+//wasm:assert_return (invoke "Live" (i32.const 100)) (i32.const 5050)
+func Live(n int32) int32 {
+	var head *chunk
+	for i := int32(1); i <= n; i++ {
+		head = newChunk(1000, head)
+		head.data[999] = i
+	}
+	total := int32(0)
+	for c := head; c != nil; c = c.next {
+		total = total + c.data[999]
+	}
+	return total
+}
+
+// An object that is larger than the memory doesn't fit in the heap.
+//
+//wasm:assert_return (invoke "Size") (i32.const 1)
+func Size() int32 {
+	before := wasm.MemorySize()
+	n := before * 16384
+	s := make([]int32, n)
+	s[n-1] = 1
+	if wasm.MemorySize() <= before {
+		return 0
+	}
+	return s[n-1]
+}
+
+//wasm:assert_return (invoke "Table" (i32.const 4095)) (i32.const 4095)
+func Table(i int32) int32 {
+	table[i] = i
+	return table[i] + table[0]
+}
+
+// Allocating more than the maximum memory traps.
+//
+//wasm:assert_trap (invoke "Exhaust") "unreachable"
+func Exhaust() int32 {
+	var head *chunk
+	for {
+		head = newChunk(65536, head)
+	}
+}
@@ -0,0 +1,355 @@
+package ifaces
+
+type shape interface {
+	area() int32
+	scaled(k int32) int32
+}
+
+type sized interface {
+	area() int32
+}
+
+type rect struct {
+	w int32
+	h int32
+}
+
+type square struct {
+	side int32
+}
+
+type Length int32
+
+func (r *rect) area() int32 {
+	return r.w * r.h
+}
+
+func (r *rect) scaled(k int32) int32 {
+	return r.area() * k * k
+}
+
+func (s square) area() int32 {
+	return s.side * s.side
+}
+
+func (s square) scaled(k int32) int32 {
+	return s.area() * k * k
+}
+
+func (l Length) area() int32 {
+	return 0
+}
+
+func (l Length) scaled(k int32) int32 {
+	return int32(l) * k
+}
+
+func newRect(w, h int32) *rect {
+	r := &rect{}
+	r.w = w
+	r.h = h
+	return r
+}
+
+func newSquare(side int32) *square {
+	s := &square{}
+	s.side = side
+	return s
+}
+
+func total(shapes []shape) int32 {
+	sum := int32(0)
+	for _, s := range shapes {
+		sum = sum + s.area()
+	}
+	return sum
+}
+
+//wasm:assert_return (invoke "Dispatch" (i32.const 3)) (i32.const 22)
+func Dispatch(n int32) int32 {
+	var s shape = newRect(n, 2)
+	a := s.area()
+	s = *newSquare(n + 1)
+	return a + s.area()
+}
+
+//wasm:assert_return (invoke "Shapes") (i32.const 32)
+func Shapes() int32 {
+	var shapes []shape
+	shapes = append(shapes, newRect(2, 3), *newSquare(4), Length(5))
+	return total(shapes) + shapes[2].scaled(2) + shapes[0].scaled(1) - 6
+}
+
+// The copy made by the conversion doesn't change with the original value.
+//
+//wasm:assert_return (invoke "CopyOnConvert") (i32.const 9)
+func CopyOnConvert() int32 {
+	sq := newSquare(3)
+	var s shape = *sq
+	sq.side = 10
+	return s.area()
+}
+
+func kind(x interface{}) int32 {
+	switch v := x.(type) {
+	case nil:
+		return -1
+	case int32:
+		return v
+	case string:
+		return int32(len(v)) * 100
+	case *rect, square:
+		return 1000
+	case sized:
+		return v.area() + 2000
+	default:
+		return -2
+	}
+}
+
+//wasm:assert_return (invoke "TypeSwitch" (i32.const 7)) (i32.const 7)
+//wasm:assert_return (invoke "TypeSwitch" (i32.const 1)) (i32.const 300)
+//wasm:assert_return (invoke "TypeSwitch" (i32.const 2)) (i32.const 1000)
+//wasm:assert_return (invoke "TypeSwitch" (i32.const 3)) (i32.const 1000)
+//wasm:assert_return (invoke "TypeSwitch" (i32.const 4)) (i32.const 2000)
+//wasm:assert_return (invoke "TypeSwitch" (i32.const 5)) (i32.const -1)
+//wasm:assert_return (invoke "TypeSwitch" (i32.const 6)) (i32.const -2)
+func TypeSwitch(n int32) int32 {
+	var x interface{}
+	switch n {
+	case 1:
+		x = "abc"
+	case 2:
+		x = newRect(1, 1)
+	case 3:
+		x = *newSquare(1)
+	case 4:
+		x = Length(3)
+	case 5:
+		x = nil
+	case 6:
+		x = true
+	default:
+		x = n
+	}
+	return kind(x)
+}
+
+//wasm:assert_return (invoke "Assert" (i32.const 6)) (i32.const 42)
+func Assert(n int32) int32 {
+	var x interface{} = n
+	v := x.(int32)
+	var s shape = newRect(n, 1)
+	r := s.(*rect)
+	z, ok := s.(sized)
+	if !ok {
+		return -1
+	}
+	return v*n + r.w + z.area() - n
+}
+
+//wasm:assert_return (invoke "AssertOk" (i32.const 5)) (i32.const 11)
+func AssertOk(n int32) int32 {
+	var x interface{} = Length(n)
+	result := int32(0)
+	_, ok := x.(int32)
+	if ok {
+		result = result + 100
+	}
+	l, ok := x.(Length)
+	if ok {
+		result = result + int32(l)
+	}
+	s, ok := x.(shape)
+	if ok {
+		result = result + s.scaled(1)
+	}
+	sq, ok := x.(square)
+	if ok {
+		result = result + sq.side
+	}
+	var y interface{}
+	_, ok = y.(sized)
+	if !ok {
+		result++
+	}
+	return result
+}
+
+//wasm:assert_return (invoke "Equal") (i32.const 63)
+func Equal() int32 {
+	var a interface{}
+	var b interface{}
+	result := int32(0)
+	if a == b {
+		result = result | 1
+	}
+	a = int32(3)
+	b = int32(3)
+	if a == b {
+		result = result | 2
+	}
+	if a == int32(3) {
+		result = result | 4
+	}
+	h := "hello"
+	a = h
+	b = h[0:3] + "lo"
+	if a == b {
+		result = result | 8
+	}
+	var s sized = *newSquare(2)
+	var t sized = *newSquare(2)
+	if s == t {
+		result = result | 16
+	}
+	if a != nil {
+		if s != sized(Length(2)) {
+			result = result | 32
+		}
+	}
+	return result
+}
+
+func describe(s sized) interface{} {
+	return s
+}
+
+//wasm:assert_return (invoke "Convert" (i32.const 4)) (i32.const 16)
+func Convert(n int32) int32 {
+	var s shape = *newSquare(n)
+	var z sized = s
+	x := describe(z)
+	return x.(sized).area()
+}
+
+type base struct {
+	side int32
+}
+
+func (b base) area() int32 {
+	return b.side * b.side
+}
+
+type framed struct {
+	border int32
+	base
+	color int32
+}
+
+type linked struct {
+	border int32
+	*base
+}
+
+type Celsius float64
+
+func (c Celsius) area() int32 {
+	return int32(c) * 2
+}
+
+type Wide int64
+
+func (w Wide) area() int32 {
+	return int32(w >> 32)
+}
+
+func areaOf(s sized) int32 {
+	return s.area()
+}
+
+//wasm:assert_return (invoke "Promoted" (i32.const 4)) (i32.const 61)
+func Promoted(n int32) int32 {
+	var s sized = framed{7, base{n}, 5}
+	f := &framed{1, base{3}, 2}
+	b := &base{6}
+	return s.area() + areaOf(f) + areaOf(linked{2, b})
+}
+
+//wasm:assert_return (invoke "BoxedMethods" (i32.const 3)) (i32.const 39)
+func BoxedMethods(n int32) int32 {
+	lengths := []Length{Length(n)}
+	var s shape = &lengths[0]
+	return areaOf(Celsius(3.5)) + areaOf(Wide(n)<<32) + s.scaled(10)
+}
+
+//wasm:assert_return (invoke "BoxedAssert" (i64.const 5000000000)) (f64.const 5000000003.75)
+func BoxedAssert(v int64) float64 {
+	var x interface{} = v
+	var y interface{} = 1.25
+	var z interface{} = float32(2.5)
+	return float64(x.(int64)) + y.(float64) + float64(z.(float32))
+}
+
+//wasm:assert_return (invoke "BoxedSwitch" (i32.const 0)) (i32.const 1)
+//wasm:assert_return (invoke "BoxedSwitch" (i32.const 1)) (i32.const 2)
+//wasm:assert_return (invoke "BoxedSwitch" (i32.const 2)) (i32.const 3)
+//wasm:assert_return (invoke "BoxedSwitch" (i32.const 3)) (i32.const 4)
+func BoxedSwitch(n int32) int32 {
+	var x interface{}
+	switch n {
+	case 0:
+		x = int64(1)
+	case 1:
+		x = 2.0
+	case 2:
+		x = float32(3)
+	default:
+		x = uint64(4)
+	}
+	switch v := x.(type) {
+	case int64:
+		return int32(v)
+	case float64:
+		return int32(v)
+	case float32:
+		return int32(v)
+	case uint64:
+		return int32(v)
+	}
+	return 0
+}
+
+//wasm:assert_return (invoke "BoxedOk") (i32.const 11)
+func BoxedOk() int32 {
+	var x interface{} = 2.5
+	result := int32(0)
+	f, ok := x.(float64)
+	if ok {
+		result = result + int32(f*4)
+	}
+	n, ok := x.(int64)
+	if !ok {
+		result = result + 1 + int32(n)
+	}
+	return result
+}
+
+//wasm:assert_return (invoke "BoxedEqual") (i32.const 31)
+func BoxedEqual() int32 {
+	var a interface{} = int64(1) << 40
+	var b interface{} = int64(1) << 40
+	var one interface{} = int64(1)
+	var zero interface{} = 0.0
+	var negZero interface{} = -zero.(float64)
+	nan := 0.0
+	nan = nan / nan
+	var x interface{} = nan
+	result := int32(0)
+	if a == b {
+		result = result | 1
+	}
+	if a != one {
+		result = result | 2
+	}
+	if zero == negZero {
+		result = result | 4
+	}
+	if x != x {
+		result = result | 8
+	}
+	if zero == 0.0 {
+		result = result | 16
+	}
+	return result
+}
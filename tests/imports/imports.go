@@ -0,0 +1,52 @@
+package imports
+
+import "gowasm/tests/imports/sub"
+
+//wasm:assert_return (invoke "CrossCall" (i32.const 3)) (i32.const 180)
+func CrossCall(k int32) int32 {
+	v := sub.Vec{X: 1, Y: 2}
+	p := &sub.Vec{X: 3, Y: 4}
+	v.Scale(k)
+	p.Scale(2)
+	return v.Dot(*p) + p.Dot(v) + int32(sub.Level(k).Next())*12
+}
+
+//wasm:assert_return (invoke "CrossValue" (i32.const 5)) (i32.const 60)
+func CrossValue(k int32) int32 {
+	v := &sub.Vec{X: k, Y: 1}
+	scale := v.Scale
+	scale(2)
+	dot := v.Dot
+	next := sub.Level(k).Next
+	return dot(sub.Vec{X: 5, Y: 2}) + int32(next())
+}
+
+// The variables of another package can be read and set.
+//
+//wasm:assert_return (invoke "CrossVar" (i32.const 4)) (i32.const 4916)
+func CrossVar(k int32) int32 {
+	sub.Counter = k
+	sub.Counter++
+	sub.Counter = sub.Counter * 10
+	sub.Counter--
+	p := sub.Start()
+	p.X = p.X + 1
+	return sub.Counter*100 + sub.Start().X + sub.Start().Y
+}
+
+// The variables of another package can be assigned in tuples, copied as
+// structs and have their addresses taken.
+//
+//wasm:assert_return (invoke "CrossVarAddr") (i32.const 24)
+func CrossVarAddr() int32 {
+	var a int32
+	var b int32
+	sub.Counter, a = 3, 4
+	b, sub.Counter = sub.Counter, 6
+	p := &sub.Counter
+	*p = *p + b
+	sub.Home = sub.Vec{X: 5, Y: 6}
+	v := sub.Home
+	sub.Home.X = 0
+	return sub.Counter + a + v.X + v.Y + sub.Home.X
+}
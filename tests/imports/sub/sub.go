@@ -0,0 +1,35 @@
+// Package sub is imported by the imports test package.
+package sub
+
+type Vec struct {
+	X int32
+	Y int32
+}
+
+func (v Vec) Dot(w Vec) int32 {
+	return v.X*w.X + v.Y*w.Y
+}
+
+func (v *Vec) Scale(k int32) {
+	v.X = v.X * k
+	v.Y = v.Y * k
+}
+
+type Level int32
+
+func (l Level) Next() Level {
+	return l + 1
+}
+
+// Counter is set by the imports test package.
+var Counter int32
+
+// Home is set by the imports test package.
+var Home Vec
+
+var origin = Vec{X: 7, Y: 8}
+
+// Start returns the address of a variable of the package.
+func Start() *Vec {
+	return &origin
+}
@@ -3,10 +3,29 @@ package main
 import (
 	"fmt"
 	"gowasm/rt/gc"
+	"gowasm/tests/chans"
+	"gowasm/tests/closures"
+	"gowasm/tests/collect"
+	"gowasm/tests/consts"
+	"gowasm/tests/control"
+	"gowasm/tests/conv"
+	"gowasm/tests/defers"
 	"gowasm/tests/fac"
+	"gowasm/tests/globals"
+	"gowasm/tests/grow"
 	"gowasm/tests/i32"
+	"gowasm/tests/ifaces"
+	"gowasm/tests/imports"
+	"gowasm/tests/maps"
 	"gowasm/tests/mem"
+	"gowasm/tests/methods"
 	"gowasm/tests/newstuff"
+	"gowasm/tests/results"
+	"gowasm/tests/slices"
+	"gowasm/tests/structs"
+	"gowasm/tests/text"
+	"gowasm/tests/untyped"
+	"gowasm/tests/wide"
 )
 
 func main() {
@@ -50,5 +69,101 @@ func main() {
 	fmt.Printf("-- Asserting return... gc.Alloc(64, 32) --> %d\n", v32)
 	i := newstuff.TestPuts()
 	fmt.Printf("-- Invoking... newstuff.TestPuts() --> %d\n", i)
+	v32 = control.Classify(3)
+	fmt.Printf("-- Asserting return... control.Classify(3) --> %d\n", v32)
+	v32 = control.Fallthrough(1)
+	fmt.Printf("-- Asserting return... control.Fallthrough(1) --> %d\n", v32)
+	v32 = control.RangeArray()
+	fmt.Printf("-- Asserting return... control.RangeArray() --> %d\n", v32)
+	v32 = control.RangeCopy()
+	fmt.Printf("-- Asserting return... control.RangeCopy() --> %d\n", v32)
+	v32 = control.Labeled(4)
+	fmt.Printf("-- Asserting return... control.Labeled(4) --> %d\n", v32)
+	v32 = control.ShortArray(7)
+	fmt.Printf("-- Asserting return... control.ShortArray(7) --> %d\n", v32)
+	v32 = results.DivMod(17, 5)
+	fmt.Printf("-- Asserting return... results.DivMod(17, 5) --> %d\n", v32)
+	v32 = results.Swap(3, 4)
+	fmt.Printf("-- Asserting return... results.Swap(3, 4) --> %d\n", v32)
+	v32 = results.LeftFirst()
+	fmt.Printf("-- Asserting return... results.LeftFirst() --> %d\n", v32)
+	v32 = results.LeftFirstMap()
+	fmt.Printf("-- Asserting return... results.LeftFirstMap() --> %d\n", v32)
+	v64 = untyped.Big(3)
+	fmt.Printf("-- Asserting return... untyped.Big(3) --> %d\n", v64)
+	fmt.Printf("-- Asserting return... untyped.Half(9) --> %v\n", untyped.Half(9))
+	fmt.Printf("-- Asserting return... untyped.SignedDiv(-7, 2) --> %d\n", untyped.SignedDiv(-7, 2))
+	fmt.Printf("-- Asserting return... text.Length() --> %d\n", text.Length())
+	fmt.Printf("-- Asserting return... text.Joined() --> %d\n", text.Joined())
+	fmt.Printf("-- Asserting return... text.IsHello(5) --> %v\n", text.IsHello(5))
+	fmt.Printf("-- Asserting return... text.Runes() --> %d\n", text.Runes())
+	fmt.Printf("-- Asserting return... text.RuneSum() --> %d\n", text.RuneSum())
+	fmt.Printf("-- Asserting return... text.Encode(8364) --> %d\n", text.Encode(8364))
+	fmt.Printf("-- Asserting return... text.ByteString(255) --> %d\n", text.ByteString(255))
+	fmt.Printf("-- Asserting return... text.CopyBytes() --> %d\n", text.CopyBytes())
+	fmt.Printf("-- Asserting return... slices.SumSquares(4) --> %d\n", slices.SumSquares(4))
+	fmt.Printf("-- Asserting return... slices.Grow() --> %d\n", slices.Grow())
+	fmt.Printf("-- Asserting return... slices.AppendWide(10) --> %d\n", slices.AppendWide(10))
+	fmt.Printf("-- Asserting return... slices.Keyed() --> %d\n", slices.Keyed())
+	fmt.Printf("-- Asserting return... slices.CopyOverlap() --> %d\n", slices.CopyOverlap())
+	fmt.Printf("-- Asserting return... maps.Squares(10) --> %d\n", maps.Squares(10))
+	fmt.Printf("-- Asserting return... maps.Words() --> %d\n", maps.Words())
+	fmt.Printf("-- Asserting return... maps.SumRange(10) --> %d\n", maps.SumRange(10))
+	fmt.Printf("-- Asserting return... maps.WideKeys() --> %d\n", maps.WideKeys())
+	fmt.Printf("-- Asserting return... maps.IfInit(-3) --> %d\n", maps.IfInit(-3))
+	fmt.Printf("-- Asserting return... methods.Count(3) --> %d\n", methods.Count(3))
+	fmt.Printf("-- Asserting return... methods.ValueReceiver() --> %d\n", methods.ValueReceiver())
+	fmt.Printf("-- Asserting return... methods.MethodExpr(7) --> %d\n", methods.MethodExpr(7))
+	fmt.Printf("-- Asserting return... ifaces.Dispatch(3) --> %d\n", ifaces.Dispatch(3))
+	fmt.Printf("-- Asserting return... ifaces.TypeSwitch(4) --> %d\n", ifaces.TypeSwitch(4))
+	fmt.Printf("-- Asserting return... ifaces.Equal() --> %d\n", ifaces.Equal())
+	fmt.Printf("-- Asserting return... ifaces.Promoted(4) --> %d\n", ifaces.Promoted(4))
+	fmt.Printf("-- Asserting return... ifaces.BoxedMethods(3) --> %d\n", ifaces.BoxedMethods(3))
+	fmt.Printf("-- Asserting return... ifaces.BoxedAssert(5000000000) --> %v\n", ifaces.BoxedAssert(5000000000))
+	fmt.Printf("-- Asserting return... ifaces.BoxedEqual() --> %d\n", ifaces.BoxedEqual())
+	fmt.Printf("-- Asserting return... imports.CrossCall(3) --> %d\n", imports.CrossCall(3))
+	fmt.Printf("-- Asserting return... imports.CrossValue(5) --> %d\n", imports.CrossValue(5))
+	fmt.Printf("-- Asserting return... imports.CrossVar(4) --> %d\n", imports.CrossVar(4))
+	fmt.Printf("-- Asserting return... imports.CrossVarAddr() --> %d\n", imports.CrossVarAddr())
+	fmt.Printf("-- Asserting return... closures.Counter(5) --> %d\n", closures.Counter(5))
+	fmt.Printf("-- Asserting return... closures.Loop() --> %d\n", closures.Loop())
+	fmt.Printf("-- Asserting return... closures.LoopVar() --> %d\n", closures.LoopVar())
+	fmt.Printf("-- Asserting return... closures.MethodValue(4) --> %d\n", closures.MethodValue(4))
+	fmt.Printf("-- Asserting return... defers.DeferOrder() --> %d\n", defers.DeferOrder())
+	fmt.Printf("-- Asserting return... defers.RecoverDiv(0) --> %d\n", defers.RecoverDiv(0))
+	fmt.Printf("-- Asserting return... defers.Unwind(3) --> %d\n", defers.Unwind(3))
+	fmt.Printf("-- Asserting return... defers.RecoverAgain() --> %d\n", defers.RecoverAgain())
+	fmt.Printf("-- Asserting return... chans.Pipeline(4) --> %d\n", chans.Pipeline(4))
+	fmt.Printf("-- Asserting return... chans.FanIn() --> %d\n", chans.FanIn())
+	fmt.Printf("-- Asserting return... chans.Workers(3) --> %d\n", chans.Workers(3))
+	fmt.Printf("-- Asserting return... consts.Enum(6) --> %d\n", consts.Enum(6))
+	fmt.Printf("-- Asserting return... consts.Sizes() --> %d\n", consts.Sizes())
+	fmt.Printf("-- Asserting return... consts.Local(1) --> %d\n", consts.Local(1))
+	fmt.Printf("-- Asserting return... consts.Huge() --> %d\n", consts.Huge())
+	fmt.Printf("-- Asserting return... globals.Dynamic() --> %d\n", globals.Dynamic())
+	fmt.Printf("-- Asserting return... globals.Initialized() --> %d\n", globals.Initialized())
+	fmt.Printf("-- Asserting return... globals.Scalars() --> %d\n", globals.Scalars())
+	fmt.Printf("-- Asserting return... collect.Garbage(100) --> %d\n", collect.Garbage(100))
+	fmt.Printf("-- Asserting return... collect.Survive(500) --> %d\n", collect.Survive(500))
+	fmt.Printf("-- Asserting return... collect.Interior(7) --> %d\n", collect.Interior(7))
+	fmt.Printf("-- Asserting return... collect.Deep(3000) --> %d\n", collect.Deep(3000))
+	fmt.Printf("-- Asserting return... grow.Live(100) --> %d\n", grow.Live(100))
+	fmt.Printf("-- Asserting return... grow.Table(4095) --> %d\n", grow.Table(4095))
+	fmt.Printf("-- Asserting return... structs.Offsets() --> %d\n", structs.Offsets())
+	fmt.Printf("-- Asserting return... structs.Params() --> %d\n", structs.Params())
+	fmt.Printf("-- Asserting return... structs.Embedded() --> %d\n", structs.Embedded())
+	fmt.Printf("-- Asserting return... structs.Boxed() --> %d\n", structs.Boxed())
+	fmt.Printf("-- Asserting return... structs.ArrayFields() --> %d\n", structs.ArrayFields())
+	fmt.Printf("-- Asserting return... structs.ArrayCopy() --> %d\n", structs.ArrayCopy())
+	fmt.Printf("-- Asserting return... structs.ArrayGlobal() --> %d\n", structs.ArrayGlobal())
+	fmt.Printf("-- Asserting return... structs.Discard() --> %d\n", structs.Discard())
+	fmt.Printf("-- Asserting return... wide.Accumulate(7) --> %d\n", wide.Accumulate(7))
+	fmt.Printf("-- Asserting return... wide.Average() --> %g\n", wide.Average())
+	fmt.Printf("-- Asserting return... wide.Gain() --> %g\n", wide.Gain())
+	fmt.Printf("-- Asserting return... wide.Samples(3) --> %d\n", wide.Samples(3))
+	fmt.Printf("-- Asserting return... conv.Extend(-5, 4294967295) --> %d\n", conv.Extend(-5, 4294967295))
+	fmt.Printf("-- Asserting return... conv.Truncate(-2.75) --> %d\n", conv.Truncate(-2.75))
+	fmt.Printf("-- Asserting return... conv.Bytes(200) --> %d\n", conv.Bytes(200))
+	fmt.Printf("-- Asserting return... conv.Wraparound(127) --> %d\n", conv.Wraparound(127))
 	fmt.Printf("Tests complete\n")
 }
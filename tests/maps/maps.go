@@ -0,0 +1,143 @@
+package maps
+
+type point struct {
+	x, y int32
+}
+
+//wasm:assert_return (invoke "Squares" (i32.const 100)) (i32.const 9801)
+func Squares(n int32) int32 {
+	m := make(map[int32]int32)
+	for i := int32(0); i < n; i++ {
+		m[i] = i * i
+	}
+	return m[n-1]
+}
+
+//wasm:assert_return (invoke "Missing" (i32.const 3)) (i32.const 0)
+func Missing(k int) int {
+	m := map[int]int{1: 10, 2: 20}
+	return m[k]
+}
+
+//wasm:assert_return (invoke "MapCommaOk" (i32.const 2)) (i32.const 21)
+//wasm:assert_return (invoke "MapCommaOk" (i32.const 5)) (i32.const 0)
+func MapCommaOk(k int) int {
+	m := map[int]int{1: 10, 2: 20}
+	v, ok := m[k]
+	if ok {
+		return v + 1
+	}
+	return v
+}
+
+//wasm:assert_return (invoke "Words") (i32.const 3)
+func Words() int {
+	counts := make(map[string]int)
+	counts["to"]++
+	counts["be"]++
+	counts["or"]++
+	counts["not"]++
+	counts["to"]++
+	counts["be"]++
+	return counts["to"] + len(counts) - counts["be"] - counts["or"]
+}
+
+//wasm:assert_return (invoke "StringKeys" (i32.const 3)) (i32.const 7)
+func StringKeys(n int) int {
+	s := "abcdef"
+	m := map[string]int{"abc": 7, "abd": 8}
+	return m[s[:n]]
+}
+
+//wasm:assert_return (invoke "Delete") (i32.const 2)
+func Delete() int {
+	m := map[int8]bool{1: true, 2: true, 3: true}
+	delete(m, 2)
+	delete(m, 4)
+	if m[2] {
+		return -1
+	}
+	return len(m)
+}
+
+//wasm:assert_return (invoke "SumRange" (i32.const 10)) (i32.const 4000)
+func SumRange(n int) int {
+	m := make(map[int]int, n)
+	for i := 0; i < n; i++ {
+		m[i] = i * 100
+	}
+	delete(m, 5)
+	sum := 0
+	for k, v := range m {
+		sum = sum + v - k*100 + v
+	}
+	return sum
+}
+
+//wasm:assert_return (invoke "PointerKeys") (i32.const 12)
+func PointerKeys() int32 {
+	p := &point{}
+	q := &point{}
+	m := make(map[*point]int32)
+	m[p] = 5
+	m[q] = 7
+	total := int32(0)
+	for k := range m {
+		total = total + m[k]
+	}
+	return total
+}
+
+//wasm:assert_return (invoke "NilMap" (i32.const 1)) (i32.const 0)
+func NilMap(k int) int {
+	var m map[int]int
+	_, ok := m[k]
+	if ok {
+		return 1
+	}
+	return len(m)
+}
+
+//wasm:assert_return (invoke "Rehash" (i32.const 1000)) (i32.const 1000)
+func Rehash(n int) int {
+	m := make(map[int]int)
+	for i := 0; i < n; i++ {
+		m[i] = i
+		if i&1 == 1 {
+			delete(m, i-1)
+		}
+	}
+	for i := 0; i < n; i++ {
+		m[i] = i
+	}
+	return len(m)
+}
+
+// WideKeys uses keys that differ only in their upper halves.
+//
+//wasm:assert_return (invoke "WideKeys") (i64.const 47244640257)
+func WideKeys() int64 {
+	m := map[int64]int32{1: 1, 1 << 32: 2, -1: 3}
+	m[1<<33+1] = 4
+	m[1<<32]++
+	delete(m, 1)
+	var sum int64
+	sum = 0
+	for k, v := range m {
+		sum = sum + k*int64(v)
+	}
+	return sum
+}
+
+//wasm:assert_return (invoke "IfInit" (i64.const 1099511627776)) (i32.const 5)
+//wasm:assert_return (invoke "IfInit" (i64.const -3)) (i32.const -1)
+//wasm:assert_return (invoke "IfInit" (i64.const 1)) (i32.const 0)
+func IfInit(k int64) int32 {
+	m := map[uint64]int32{1 << 40: 5}
+	if v, ok := m[uint64(k)]; ok {
+		return v
+	} else if n := k; n < 0 {
+		return -1
+	}
+	return 0
+}
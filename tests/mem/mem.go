@@ -9,11 +9,19 @@ type Point struct {
 	y int32
 }
 
-//wasm:assert_return (invoke "R" (i32.const 16) (i32.const 8)) (i32.const 16)
+// Allocations are distinct and aligned to 8 bytes, the largest alignment.
+//
+//wasm:assert_return (invoke "R" (i32.const 16) (i32.const 8)) (i32.const 1)
 func R(size, align int32) int32 {
-	p1 := gc.Alloc(size, align)
-	p2 := gc.Alloc(size, align)
-	return p2 - p1
+	p1 := uintptr(gc.Alloc(size, align))
+	p2 := uintptr(gc.Alloc(size, align))
+	if p1 == p2 {
+		return 0
+	}
+	if (p1|p2)&7 != 0 {
+		return 0
+	}
+	return 1
 }
 
 //wasm:assert_return (invoke "F" (i32.const 6)) (i32.const 6)
@@ -0,0 +1,112 @@
+package methods
+
+type Temp int32
+
+//wasm:assert_return (invoke "Temp.Double" (i32.const 21)) (i32.const 42)
+func (t Temp) Double() Temp {
+	return t * 2
+}
+
+type counter struct {
+	n    int32
+	step int32
+}
+
+func (c *counter) add(d int32) {
+	c.n = c.n + d*c.step
+}
+
+func (c counter) value() int32 {
+	return c.n
+}
+
+// bumped modifies its copy of the receiver.
+func (c counter) bumped() int32 {
+	c.n = c.n + 1
+	return c.n
+}
+
+type rect struct {
+	w int32
+	h int32
+}
+
+type square struct {
+	side int32
+}
+
+func (r *rect) area() int32 {
+	return r.w * r.h
+}
+
+func (s *square) area() int32 {
+	return s.side * s.side
+}
+
+type box struct {
+	label int32
+	n     Total
+	t     Temp
+}
+
+type Total int32
+
+func (t *Total) Add(n int32) {
+	*t = *t + Total(n)
+}
+
+var total Total
+
+//wasm:assert_return (invoke "Count" (i32.const 3)) (i32.const 6)
+func Count(n int32) int32 {
+	c := &counter{}
+	c.step = 2
+	for i := int32(0); i < n; i++ {
+		c.add(1)
+	}
+	return c.value()
+}
+
+//wasm:assert_return (invoke "ValueReceiver") (i32.const 10)
+func ValueReceiver() int32 {
+	c := &counter{}
+	c.n = 5
+	b := c.bumped()
+	return b + c.n - 1
+}
+
+//wasm:assert_return (invoke "Areas" (i32.const 3)) (i32.const 15)
+func Areas(n int32) int32 {
+	r := &rect{}
+	r.w = n
+	r.h = 2
+	s := &square{}
+	s.side = n
+	return r.area() + s.area()
+}
+
+//wasm:assert_return (invoke "FieldReceiver") (i32.const 21)
+func FieldReceiver() int32 {
+	b := &box{}
+	b.n.Add(20)
+	b.t = 1
+	p := &b.t
+	return int32(b.n) + int32(p.Double()) - 1
+}
+
+//wasm:assert_return (invoke "GlobalReceiver" (i32.const 4)) (i32.const 7)
+func GlobalReceiver(n int32) int32 {
+	total.Add(3)
+	total.Add(n)
+	return int32(total)
+}
+
+//wasm:assert_return (invoke "MethodExpr" (i32.const 7)) (i32.const 70)
+func MethodExpr(n int32) int32 {
+	add := (*counter).add
+	c := &counter{}
+	c.step = 5
+	add(c, n)
+	double := Temp.Double
+	return int32(double(Temp(counter.value(*c))))
+}
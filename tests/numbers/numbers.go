@@ -51,3 +51,39 @@ func TestBitwise3() int64 {
 	a := int64(5)
 	return ^a
 }
+
+//wasm:assert_return (invoke "ShiftLeft" (i32.const 1) (i32.const 31)) (i32.const -2147483648)
+//wasm:assert_return (invoke "ShiftLeft" (i32.const 1) (i32.const 40)) (i32.const 0)
+func ShiftLeft(x int32, n uint32) int32 {
+	return x << n
+}
+
+//wasm:assert_return (invoke "ShiftRight" (i32.const -1) (i32.const 33)) (i32.const 0)
+func ShiftRight(x uint32, n uint32) uint32 {
+	return x >> n
+}
+
+//wasm:assert_return (invoke "SignFill" (i32.const -8) (i64.const 40)) (i32.const -1)
+//wasm:assert_return (invoke "SignFill" (i32.const 8) (i64.const 4294967297)) (i32.const 0)
+//wasm:assert_return (invoke "SignFill" (i32.const 8) (i64.const 1)) (i32.const 4)
+func SignFill(x int32, n uint64) int32 {
+	return x >> n
+}
+
+//wasm:assert_return (invoke "MixedShift" (i64.const 3) (i32.const 33)) (i64.const 25769803776)
+//wasm:assert_return (invoke "MixedShift" (i64.const 3) (i32.const 64)) (i64.const 0)
+func MixedShift(x int64, n uint32) int64 {
+	return x << n
+}
+
+//wasm:assert_return (invoke "SmallShift" (i32.const 1) (i32.const 7)) (i32.const -128)
+//wasm:assert_return (invoke "SmallShift" (i32.const 1) (i32.const 8)) (i32.const 0)
+func SmallShift(x int8, n uint8) int8 {
+	return x << n
+}
+
+//wasm:assert_return (invoke "Indeterminate" (f64.const inf)) (f64.const nan:canonical)
+//wasm:assert_return (invoke "Indeterminate" (f64.const nan:0x4)) (f64.const nan:arithmetic)
+func Indeterminate(x float64) float64 {
+	return x - x
+}
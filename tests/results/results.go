@@ -0,0 +1,97 @@
+package results
+
+func divMod(a, b int32) (int32, int32) {
+	return a / b, a - (a/b)*b
+}
+
+//wasm:assert_return (invoke "DivMod" (i32.const 17) (i32.const 5)) (i32.const 32)
+func DivMod(a, b int32) int32 {
+	q, r := divMod(a, b)
+	return q*10 + r
+}
+
+//wasm:assert_return (invoke "Swap" (i32.const 3) (i32.const 4)) (i32.const 43)
+func Swap(a, b int32) int32 {
+	a, b = b, a
+	return a*10 + b
+}
+
+func minMax(a, b int64) (min, max int64) {
+	min = a
+	max = b
+	if a > b {
+		min, max = b, a
+	}
+	return
+}
+
+//wasm:assert_return (invoke "MinMax" (i64.const 9) (i64.const 2)) (i64.const 7)
+func MinMax(a, b int64) int64 {
+	lo, hi := minMax(a, b)
+	return hi - lo
+}
+
+func lookup(x int32) (int32, bool) {
+	if x > 0 {
+		return x * 2, true
+	}
+	return 0, false
+}
+
+//wasm:assert_return (invoke "CommaOk" (i32.const 4)) (i32.const 8)
+//wasm:assert_return (invoke "CommaOk" (i32.const -4)) (i32.const -1)
+func CommaOk(x int32) int32 {
+	v, ok := lookup(x)
+	if ok {
+		return v
+	}
+	return -1
+}
+
+func pair() (int32, int32) {
+	return divMod(23, 10)
+}
+
+//wasm:assert_return (invoke "Forward") (i32.const 7)
+func Forward() int32 {
+	a, b := pair()
+	var c int32
+	c, _ = pair()
+	_, b = pair()
+	return a + b + c
+}
+
+//wasm:assert_return (invoke "Ignore") (i32.const 0)
+func Ignore() int32 {
+	pair()
+	return 0
+}
+
+//wasm:assert_return (invoke "Pair") (i32.const 2) (i32.const 3)
+func Pair() (int32, int32) {
+	return pair()
+}
+
+// LeftFirst evaluates the index and pointer operands on the left before the
+// assignments.
+//
+//wasm:assert_return (invoke "LeftFirst") (i32.const 2056)
+func LeftFirst() int32 {
+	var a [3]int32
+	i := 0
+	i, a[i] = 1, 2
+	b := []int32{0, 0}
+	p := &b[0]
+	p, *p = &b[1], 5
+	s := []int32{3, 6}
+	s[0], s[1] = s[1], s[0]
+	return a[0]*1000 + a[1]*100 + b[0]*10 + b[1] + s[0]
+}
+
+//wasm:assert_return (invoke "LeftFirstMap") (i32.const 201)
+func LeftFirstMap() int32 {
+	m := map[int32]int32{}
+	k := int32(1)
+	k, m[k] = 2, int32(len(m))
+	return m[1]*10 + int32(len(m)) + k*100
+}
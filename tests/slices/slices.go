@@ -0,0 +1,126 @@
+package slices
+
+//wasm:assert_return (invoke "MakeLen" (i32.const 5)) (i32.const 5)
+func MakeLen(n int) int {
+	s := make([]int32, n)
+	return len(s)
+}
+
+//wasm:assert_return (invoke "MakeCap" (i32.const 3) (i32.const 10)) (i32.const 10)
+func MakeCap(n, c int) int {
+	s := make([]int32, n, c)
+	return cap(s)
+}
+
+//wasm:assert_return (invoke "SumSquares" (i32.const 4)) (i32.const 14)
+func SumSquares(n int) int32 {
+	s := make([]int32, n)
+	for i := 0; i < n; i++ {
+		s[i] = int32(i * i)
+	}
+	var sum int32
+	for _, v := range s {
+		sum = sum + v
+	}
+	return sum
+}
+
+//wasm:assert_return (invoke "AppendBytes" (i32.const 100)) (i32.const 199)
+func AppendBytes(n int) int {
+	var b []byte
+	for i := 0; i < n; i++ {
+		b = append(b, byte(i))
+	}
+	return len(b) + int(b[n-1])
+}
+
+//wasm:assert_return (invoke "AppendMany") (i32.const 123)
+func AppendMany() int {
+	var s []int16
+	s = append(s, 1, 2, 3)
+	return int(s[0])*100 + int(s[1])*10 + int(s[2])
+}
+
+//wasm:assert_return (invoke "ArraySlice" (i32.const 1) (i32.const 3)) (i32.const 4210)
+func ArraySlice(i, j int) int32 {
+	a := [...]int32{1, 2, 3, 4, 5}
+	s := a[i:j]
+	s[0] = 10
+	return a[i] + int32(len(s))*100 + int32(cap(s))*1000
+}
+
+//wasm:assert_return (invoke "FullSlice" (i32.const 2)) (i32.const 24)
+func FullSlice(i int) int {
+	s := make([]int32, 10)
+	t := s[i : i+2 : i+4]
+	return len(t)*10 + cap(t)
+}
+
+//wasm:assert_return (invoke "Grow") (i32.const 17)
+func Grow() int32 {
+	s := make([]int32, 0, 2)
+	t := append(s, 1, 2)
+	u := append(t, 3)
+	u[0] = 7
+	return t[0]*10 + u[0]
+}
+
+//wasm:assert_return (invoke "AppendSlice") (i32.const 7)
+func AppendSlice() int {
+	a := make([]int32, 3)
+	b := make([]int32, 4)
+	c := append(a, b...)
+	return len(c)
+}
+
+//wasm:assert_return (invoke "CopyOverlap") (i32.const 403)
+func CopyOverlap() int32 {
+	s := make([]int32, 5)
+	for i := range s {
+		s[i] = int32(i)
+	}
+	n := copy(s[1:], s)
+	return int32(n)*100 + s[4]
+}
+
+//wasm:assert_return (invoke "NilSlice") (i32.const 1)
+func NilSlice() int {
+	var s []int32
+	if s == nil {
+		return len(s) + 1
+	}
+	return 0
+}
+
+//wasm:assert_return (invoke "AppendWide" (i32.const 10)) (i64.const 4294967301)
+func AppendWide(n int) int64 {
+	var s []int64
+	for i := 0; i < n; i++ {
+		s = append(s, int64(i)<<32)
+	}
+	return s[1] + int64(len(s)/2)
+}
+
+//wasm:assert_return (invoke "AppendFloats") (f64.const 7.75)
+func AppendFloats() float64 {
+	var s []float64
+	s = append(s, 1.5, 2.25)
+	var t []float32
+	t = append(t, 4)
+	return s[0] + s[1] + float64(t[0])
+}
+
+//wasm:assert_return (invoke "Literal" (i32.const 2)) (i32.const 3)
+func Literal(i int) int32 {
+	s := []int32{1, 2, 3}
+	return s[i]
+}
+
+// Keyed has elements with indices and nested literals with elided types.
+//
+//wasm:assert_return (invoke "Keyed") (i32.const 4465)
+func Keyed() int {
+	s := []int{3: 4, 1: 2, 6}
+	t := [][]int{{1, 2}, nil, {3}}
+	return len(s)*1000 + s[3]*100 + s[2]*10 + len(t[0]) + len(t[1]) + t[2][0]
+}
@@ -0,0 +1,216 @@
+// Package structs tests struct values, which are copied when they are
+// assigned, passed or returned.
+package structs
+
+import "unsafe"
+
+type header struct {
+	kind  uint8
+	size  int32
+	flags uint16
+}
+
+type point struct {
+	x, y int32
+}
+
+type rect struct {
+	min, max point
+	tag      uint8
+}
+
+func (r rect) area() int32 {
+	return (r.max.x - r.min.x) * (r.max.y - r.min.y)
+}
+
+func (p *point) scale(k int32) {
+	p.x = p.x * k
+	p.y = p.y * k
+}
+
+func grow(r rect, d int32) rect {
+	r.max.x = r.max.x + d
+	r.max.y = r.max.y + d
+	return r
+}
+
+// The fields are laid out as in Go, with the sizes of the linear memory.
+//
+//wasm:assert_return (invoke "Offsets") (i32.const 1244)
+func Offsets() int32 {
+	n := int32(unsafe.Sizeof(header{})) * 100
+	n = n + int32(unsafe.Alignof(header{}))*10
+	return n + int32(unsafe.Offsetof(header{}.size))
+}
+
+// A variable gets a copy of the struct it is assigned.
+//
+//wasm:assert_return (invoke "Values") (i32.const 35)
+func Values() int32 {
+	var p point
+	p.x = 3
+	q := p
+	q.x = 5
+	return p.x*10 + q.x
+}
+
+// Parameters are copies, and so are results.
+//
+//wasm:assert_return (invoke "Params") (i32.const 9004)
+func Params() int32 {
+	r := rect{point{0, 0}, point{2, 2}, 1}
+	g := grow(r, 1)
+	return g.area()*1000 + r.area()
+}
+
+//wasm:assert_return (invoke "Literals") (i32.const 1234)
+func Literals() int32 {
+	p := point{y: 34, x: 12}
+	q := &point{1, 2}
+	return p.x*100 + p.y - q.x + q.y - 1
+}
+
+type node struct {
+	point
+	next *node
+}
+
+// The fields and methods of embedded structs are promoted.
+//
+//wasm:assert_return (invoke "Embedded") (i32.const 40)
+func Embedded() int32 {
+	var n node
+	n.x = 2
+	n.y = 3
+	n.scale(2)
+	n.next = &node{}
+	n.next.x = 30
+	return n.x + n.pt().y + n.next.x
+}
+
+func (n *node) pt() point {
+	return n.point
+}
+
+// Arrays of structs hold the structs, not their addresses.
+//
+//wasm:assert_return (invoke "Elements") (i32.const 321)
+func Elements() int32 {
+	var a [3]point
+	a[0].x = 1
+	a[1] = point{2, 0}
+	b := a[1]
+	b.x = 9
+	a[2] = a[1]
+	a[2].x = 3
+	return a[2].x*100 + a[1].x*10 + a[0].x
+}
+
+//wasm:assert_return (invoke "Exchange") (i32.const 21)
+func Exchange() int32 {
+	a := point{1, 0}
+	b := point{2, 0}
+	a, b = b, a
+	return a.x*10 + b.x
+}
+
+//wasm:assert_return (invoke "Appended") (i32.const 37)
+func Appended() int32 {
+	var s []point
+	p := point{1, 2}
+	s = append(s, p, point{3, 4})
+	p.x = 100
+	s = append(s, s[0])
+	s[2].y = 20
+	return s[0].x + s[0].y + s[1].x + s[1].y + s[2].x + s[2].y + int32(len(s))*2
+}
+
+type shape interface {
+	area() int32
+}
+
+// An interface holds a copy of the struct.
+//
+//wasm:assert_return (invoke "Boxed") (i32.const 8)
+func Boxed() int32 {
+	r := rect{}
+	r.max = point{2, 4}
+	var s shape = r
+	r.max.x = 0
+	return s.area()
+}
+
+type packet struct {
+	magic [4]byte
+	n     uint16
+	body  [10]int32
+	tail  uint8
+}
+
+type grid struct {
+	n     int32
+	cells [2][3]int32
+	next  [2]*grid
+}
+
+var origin = grid{n: 1, cells: [2][3]int32{{1, 2, 3}, {4, 5, 6}}}
+
+// Arrays are laid out inline in a struct.
+//
+//wasm:assert_return (invoke "ArrayFields") (i32.const 5248)
+func ArrayFields() int32 {
+	n := int32(unsafe.Sizeof(packet{})) * 100
+	return n + int32(unsafe.Offsetof(packet{}.tail))
+}
+
+// The arrays in a zeroed struct are zeroed, and they are copied with it.
+//
+//wasm:assert_return (invoke "ArrayCopy") (i32.const 7709)
+func ArrayCopy() int32 {
+	var p packet
+	p.body[3] = 77
+	q := p
+	p.body[3] = 1
+	n := q.body[3] * 100
+	var a [10]int32
+	a[9] = 9
+	q.body = a
+	a[9] = 1
+	return n + q.body[9]
+}
+
+// Arrays of arrays in a global struct are initialized inline, and the pointers
+// in them are roots.
+//
+//wasm:assert_return (invoke "ArrayGlobal") (i32.const 2121)
+func ArrayGlobal() int32 {
+	s := int32(0)
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 3; j++ {
+			s = s + origin.cells[i][j]
+		}
+	}
+	origin.next[1] = &grid{n: 21}
+	garbage := make([]int32, 10000)
+	garbage[0] = 1
+	return origin.next[1].n*100 + s + origin.n - garbage[0]
+}
+
+var made int32
+
+func makePoint() point {
+	made = made + 1
+	return point{made, 0}
+}
+
+// A value assigned to the blank identifier is evaluated and dropped.
+//
+//wasm:assert_return (invoke "Discard") (i32.const 2)
+func Discard() int32 {
+	p := point{1, 2}
+	_ = p
+	_ = makePoint()
+	_ = p.x
+	_ = makePoint()
+	return made
+}
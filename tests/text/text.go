@@ -0,0 +1,143 @@
+package text
+
+const hello = "hello"
+
+var greeting = "hi there"
+
+//wasm:assert_return (invoke "Length") (i32.const 8)
+func Length() int {
+	return len(greeting)
+}
+
+//wasm:assert_return (invoke "ByteAt" (i32.const 1)) (i32.const 101)
+func ByteAt(i int) byte {
+	return hello[i]
+}
+
+//wasm:assert_return (invoke "SubstrLen" (i32.const 1) (i32.const 4)) (i32.const 3)
+func SubstrLen(i, j int) int {
+	return len(hello[i:j])
+}
+
+//wasm:assert_return (invoke "Suffix" (i32.const 3)) (i32.const 108)
+func Suffix(i int) byte {
+	s := hello[i:]
+	return s[0]
+}
+
+//wasm:assert_return (invoke "Joined") (i32.const 12)
+func Joined() int {
+	s := hello + ", " + "world"
+	return len(s)
+}
+
+//wasm:assert_return (invoke "JoinedByte" (i32.const 7)) (i32.const 119)
+func JoinedByte(i int) byte {
+	s := hello + ", "
+	s = s + "world"
+	return s[i]
+}
+
+//wasm:assert_return (invoke "IsHello" (i32.const 5)) (i32.const 1)
+//wasm:assert_return (invoke "IsHello" (i32.const 4)) (i32.const 0)
+func IsHello(n int) bool {
+	s := "hello, world"
+	return s[:n] == hello
+}
+
+//wasm:assert_return (invoke "Before" (i32.const 4)) (i32.const 1)
+//wasm:assert_return (invoke "Before" (i32.const 5)) (i32.const 0)
+func Before(n int) bool {
+	return hello[:n] < hello
+}
+
+//wasm:assert_return (invoke "Runes") (i32.const 3)
+func Runes() int {
+	n := 0
+	for range "héé" {
+		n++
+	}
+	return n
+}
+
+// RuneSum adds the runes of a string with a 2-, 3- and 4-byte encoding and
+// their byte indices.
+//
+//wasm:assert_return (invoke "RuneSum") (i32.const 147206)
+func RuneSum() int {
+	sum := 0
+	for i, r := range "aé€😀" {
+		sum = sum + i*1000 + int(r)
+	}
+	return sum
+}
+
+// Invalid has a stray continuation byte and a truncated encoding, whose bytes
+// are each U+FFFD.
+//
+//wasm:assert_return (invoke "Invalid") (i32.const 3)
+func Invalid() int {
+	n := 0
+	for _, r := range "a\x80b\xe2\x82" {
+		if r == 0xfffd {
+			n++
+		}
+	}
+	return n
+}
+
+// Encode converts a rune to a string and checks it against a literal. Invalid
+// runes are U+FFFD.
+//
+//wasm:assert_return (invoke "Encode" (i32.const 97)) (i32.const 1)
+//wasm:assert_return (invoke "Encode" (i32.const 233)) (i32.const 2)
+//wasm:assert_return (invoke "Encode" (i32.const 8364)) (i32.const 3)
+//wasm:assert_return (invoke "Encode" (i32.const 128512)) (i32.const 4)
+//wasm:assert_return (invoke "Encode" (i32.const 55296)) (i32.const 13)
+//wasm:assert_return (invoke "Encode" (i32.const -1)) (i32.const 13)
+//wasm:assert_return (invoke "Encode" (i32.const 1114112)) (i32.const 13)
+func Encode(r rune) int {
+	s := string(r)
+	switch s {
+	case "a", "é", "€", "😀":
+		return len(s)
+	case "�":
+		return 10 + len(s)
+	}
+	return -1
+}
+
+// ByteString converts a byte, which is a rune, to a string.
+//
+//wasm:assert_return (invoke "ByteString" (i32.const 255)) (i32.const 295)
+func ByteString(n int) int {
+	b := byte(n)
+	s := string(b)
+	return len(s)*100 + int(s[0]) - 100
+}
+
+// CopyBytes checks that string(b) and []byte(s) copy the bytes.
+//
+//wasm:assert_return (invoke "CopyBytes") (i32.const 4)
+func CopyBytes() int {
+	b := []byte("hello")
+	s := string(b)
+	b[0] = 'j'
+	t := string(b)
+	c := []byte(t)
+	c[4] = 'y'
+	n := 0
+	if s == "hello" {
+		n++
+	}
+	if t == "jello" {
+		n++
+	}
+	if string(c) == "jelly" {
+		n++
+	}
+	if len(string(b[:0])) == 0 {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,53 @@
+package untyped
+
+const big = 1 << 40
+const half = 0.5
+
+type celsius float64
+
+//wasm:assert_return (invoke "Big" (i64.const 3)) (i64.const 3298534883331)
+func Big(x int64) int64 {
+	return x*big + x
+}
+
+//wasm:assert_return (invoke "Half" (f64.const 9)) (f64.const 4.5)
+func Half(x float64) float64 {
+	return x * half
+}
+
+//wasm:assert_return (invoke "Scale" (f32.const 3)) (f32.const 7.5)
+func Scale(x float32) float32 {
+	return x * 2.5
+}
+
+//wasm:assert_return (invoke "Boil" (f64.const 1)) (f64.const 101)
+func Boil(x celsius) celsius {
+	return x + 100
+}
+
+//wasm:assert_return (invoke "SignedDiv" (i32.const -7) (i32.const 2)) (i32.const -3)
+func SignedDiv(a, b int) int {
+	return a / b
+}
+
+//wasm:assert_return (invoke "Less" (i32.const -1)) (i32.const 1)
+func Less(a int) int {
+	if a < 0 {
+		return 1
+	}
+	return 0
+}
+
+//wasm:assert_return (invoke "Shift" (i64.const 5)) (i64.const 40)
+func Shift(x int64) int64 {
+	return x << uint8(3)
+}
+
+//wasm:assert_return (invoke "Later" (i32.const 4)) (i32.const 16)
+func Later(x int32) int32 {
+	return square(x)
+}
+
+func square(x int32) int32 {
+	return x * x
+}
@@ -0,0 +1,67 @@
+// Package wide tests 64-bit integers and floats in memory: in globals, arrays,
+// slices and struct fields.
+package wide
+
+var counter int64
+var scale = 2.5
+var base int64 = 1 << 40
+
+type sample struct {
+	tag  uint8
+	gain float32
+	at   int64
+	v    float64
+}
+
+//wasm:assert_return (invoke "Accumulate" (i64.const 7)) (i64.const 1099511627790)
+func Accumulate(n int64) int64 {
+	counter = n
+	counter = counter + n
+	return counter + base
+}
+
+//wasm:assert_return (invoke "Average") (f64.const 6.25)
+func Average() float64 {
+	var a [4]float64
+	for i := 0; i < 4; i++ {
+		a[i] = scale * 2
+	}
+	a[2] = a[1] + a[3]
+	s := 0.0
+	for _, v := range a {
+		s = s + v
+	}
+	return s / 4
+}
+
+//wasm:assert_return (invoke "Gain") (f32.const 1.5)
+func Gain() float32 {
+	x := sample{tag: 1, gain: 0.5, at: 1 << 33, v: 2}
+	p := &x
+	p.gain = p.gain * 3
+	return p.gain
+}
+
+//wasm:assert_return (invoke "Fields") (i64.const 8589934594)
+func Fields() int64 {
+	x := sample{at: 1 << 33}
+	y := x
+	y.at = y.at + 2
+	x.v = 3
+	return y.at
+}
+
+//wasm:assert_return (invoke "Samples" (i32.const 3)) (i64.const 60)
+func Samples(n int32) int64 {
+	s := make([]int64, n)
+	for i := int32(0); i < n; i++ {
+		s[i] = int64(10)
+	}
+	s[1] = s[1] * 2
+	s[2] = s[0] + s[1]
+	var t int64
+	for _, v := range s {
+		t = t + v
+	}
+	return t
+}
@@ -3,9 +3,8 @@ package main
 import (
 	"fmt"
 	"go/ast"
-	"go/token"
-	"strconv"
-	"strings"
+	"go/constant"
+	"go/types"
 )
 
 type WasmType interface {
@@ -52,16 +51,46 @@ type WasmTypeFunc struct {
 	WasmTypeBase
 	wasmName string
 	params   []WasmType
-	result   WasmType
+	results  []WasmType
 	indent   int
 }
 
-const ellipsisLength = -1
-
+// An array value is the address of its elements. An array in a struct is laid
+// out inline, like in Go, see inlineArrayType, elsewhere it is a word with the
+// address of elements allocated separately.
 type WasmTypeArray struct {
 	WasmTypeBase
 	length      uint32
 	elementType WasmType
+	inline      bool
+}
+
+// A map is the address of a hash table, or 0 for a nil map, see rt/hashmap.
+type WasmTypeMap struct {
+	WasmTypeBase
+	keyType   WasmType
+	valueType WasmType
+}
+
+// A channel is the address of a channel object, or 0 for a nil channel, see
+// rt/sched.
+type WasmTypeChan struct {
+	WasmTypeBase
+	elementType WasmType
+}
+
+// An interface value is the address of a header with the dynamic type, the
+// method table and the data word, or 0 for a nil interface, see rt/iface.
+type WasmTypeInterface struct {
+	WasmTypeBase
+	iface *types.Interface
+}
+
+// A slice is the address of a header with a pointer to the elements, the
+// length and the capacity, or 0 for a nil slice, see rt/slice.
+type WasmTypeSlice struct {
+	WasmTypeBase
+	elementType WasmType
 }
 
 func (t *WasmTypeBase) getName() string {
@@ -115,9 +144,12 @@ func (t *WasmTypeFunc) printType(writer FormattingWriter) {
 		param.print(writer)
 	}
 	writer.Printf(")")
-	if t.result != nil {
-		writer.Printf(" (result ")
-		t.result.print(writer)
+	if len(t.results) > 0 {
+		writer.Printf(" (result")
+		for _, r := range t.results {
+			writer.Printf(" ")
+			r.print(writer)
+		}
 		writer.Printf(")")
 	}
 	writer.Printf("))")
@@ -152,7 +184,7 @@ func (t *WasmTypeStruct) isFloat() bool {
 }
 
 func (t *WasmTypeStruct) print(writer FormattingWriter) {
-	writer.Printf("%s", t.name)
+	writer.Printf("i32")
 }
 
 func (a *WasmTypeArray) isSigned() bool {
@@ -167,6 +199,54 @@ func (a *WasmTypeArray) print(writer FormattingWriter) {
 	writer.Printf("i32")
 }
 
+func (t *WasmTypeMap) isSigned() bool {
+	return false
+}
+
+func (t *WasmTypeMap) isFloat() bool {
+	return false
+}
+
+func (t *WasmTypeMap) print(writer FormattingWriter) {
+	writer.Printf("i32")
+}
+
+func (t *WasmTypeChan) isSigned() bool {
+	return false
+}
+
+func (t *WasmTypeChan) isFloat() bool {
+	return false
+}
+
+func (t *WasmTypeChan) print(writer FormattingWriter) {
+	writer.Printf("i32")
+}
+
+func (t *WasmTypeInterface) isSigned() bool {
+	return false
+}
+
+func (t *WasmTypeInterface) isFloat() bool {
+	return false
+}
+
+func (t *WasmTypeInterface) print(writer FormattingWriter) {
+	writer.Printf("i32")
+}
+
+func (t *WasmTypeSlice) isSigned() bool {
+	return false
+}
+
+func (t *WasmTypeSlice) isFloat() bool {
+	return false
+}
+
+func (t *WasmTypeSlice) print(writer FormattingWriter) {
+	writer.Printf("i32")
+}
+
 func (m *WasmModule) convertAstTypeNameToWasmType(name string) (*WasmTypeScalar, error) {
 	t := &WasmTypeScalar{
 		dbgName: name,
@@ -179,6 +259,8 @@ func (m *WasmModule) convertAstTypeNameToWasmType(name string) (*WasmTypeScalar,
 		t.setSize(1)
 		t.setAlign(1)
 		t.signed = true
+	case "bool":
+		fallthrough
 	case "byte":
 		fallthrough
 	case "uint8":
@@ -196,11 +278,6 @@ func (m *WasmModule) convertAstTypeNameToWasmType(name string) (*WasmTypeScalar,
 		t.setSize(2)
 		t.setAlign(2)
 		t.signed = false
-	case "int32":
-		t.setName("i32")
-		t.setSize(4)
-		t.setAlign(4)
-		t.signed = true
 	case "int64":
 		t.setName("i64")
 		t.setSize(8)
@@ -213,8 +290,18 @@ func (m *WasmModule) convertAstTypeNameToWasmType(name string) (*WasmTypeScalar,
 		t.signed = false
 	case "int":
 		fallthrough
+	case "int32":
+		t.setName("i32")
+		t.setSize(4)
+		t.setAlign(4)
+		t.signed = true
+	case "uint":
+		fallthrough
 	case "uint32":
 		fallthrough
+	case "string":
+		// A string is the address of its header, see rt/str.
+		fallthrough
 	case "unsafe.Pointer":
 		fallthrough
 	case "uintptr":
@@ -238,90 +325,28 @@ func (m *WasmModule) convertAstTypeNameToWasmType(name string) (*WasmTypeScalar,
 	return t, nil
 }
 
-func (file *WasmGoSourceFile) convertAstTypeToWasmType(astType *ast.Ident) (*WasmTypeScalar, error) {
-	return file.module.convertAstTypeNameToWasmType(astType.Name)
-}
-
-func (file *WasmGoSourceFile) evaluateIntConstantBasicLit(expr *ast.BasicLit) (int, error) {
-	switch expr.Kind {
-	default:
-		return 0, fmt.Errorf("int constant expression with literal of kind: %v", expr.Kind)
-	case token.CHAR:
-		value := expr.Value
-		if strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") && len(value) == 3 {
-			// Special-case characters. Note that this doesn't work for runes which are not ASCII.
-			i := int(strings.Trim(value, "'")[0])
-			return i, nil
-		}
-		return 0, fmt.Errorf("can't interpret a char constant expression as an int: %v", value)
-	case token.INT:
-		i, err := strconv.Atoi(expr.Value)
-		if err != nil {
-			return 0, fmt.Errorf("error parsing an integer constant:'%s' %v", expr.Value, err)
-		}
-		return i, nil
-	}
-}
-
+// evaluateIntConstant returns the value of an integer constant expression.
 func (file *WasmGoSourceFile) evaluateIntConstant(expr ast.Expr) (int, error) {
-	switch expr := expr.(type) {
-	default:
-		return 0, fmt.Errorf("unsupported constant expression: %v", expr)
-	case *ast.BasicLit:
-		return file.evaluateIntConstantBasicLit(expr)
-	case *ast.Ellipsis:
-		return ellipsisLength, nil
-	}
-}
-
-func (file *WasmGoSourceFile) parseArrayType(astType *ast.ArrayType) (WasmType, error) {
-	element, err := file.parseAstType(astType.Elt)
-	if err != nil {
-		return nil, fmt.Errorf("error in an array type: %v", err)
-	}
-	length, err := file.evaluateIntConstant(astType.Len)
-	if err != nil {
-		return nil, fmt.Errorf("error evaluating length of an array type: %v", err)
+	tv, ok := file.info.Types[expr]
+	if !ok || tv.Value == nil {
+		return 0, fmt.Errorf("not a constant expression: %v", expr)
 	}
-	arr := &WasmTypeArray{
-		length:      uint32(length),
-		elementType: element,
+	if b, ok := tv.Type.Underlying().(*types.Basic); !ok || b.Info()&types.IsInteger == 0 {
+		return 0, fmt.Errorf("not an integer constant: %v", expr)
 	}
-	if length == ellipsisLength {
-		arr.setName(fmt.Sprintf("[...]%s", element.getName()))
-	} else {
-		arr.setName(fmt.Sprintf("[%d]%s", length, element.getName()))
+	i, exact := constant.Int64Val(tv.Value)
+	if !exact {
+		return 0, fmt.Errorf("integer constant overflows: %v", tv.Value)
 	}
-	arr.setAlign(4)
-	arr.setSize(4)
-	return arr, nil
+	return int(i), nil
 }
 
 func (file *WasmGoSourceFile) parseAstType(astType ast.Expr) (WasmType, error) {
-	switch astType := astType.(type) {
-	default:
-		return nil, fmt.Errorf("unsupported type: %v", astType)
-	case *ast.ArrayType:
-		return file.parseArrayType(astType)
-	case *ast.Ident:
-		name := astType.Name
-		t, ok := file.module.types[name]
-		if !ok {
-			var err error
-			t, err = file.convertAstTypeToWasmType(astType)
-			if err != nil {
-				return nil, err
-			}
-			file.module.types[name] = t
-		}
-		return t, nil
-	case *ast.StarExpr:
-		base, err := file.parseAstType(astType.X)
-		if err != nil {
-			return nil, fmt.Errorf("error in a pointer type: %v", err)
-		}
-		return file.createPointerType(base)
+	tv, ok := file.info.Types[astType]
+	if !ok || !tv.IsType() {
+		return nil, fmt.Errorf("not a type: %v", astType)
 	}
+	return file.convertType(tv.Type)
 }
 
 func (file *WasmGoSourceFile) createPointerType(t WasmType) (WasmType, error) {
@@ -340,61 +365,16 @@ func (file *WasmGoSourceFile) parseAstTypeDecl(decl *ast.GenDecl) (WasmType, err
 	}
 	switch spec := decl.Specs[0].(type) {
 	default:
-		return nil, fmt.Errorf("unsupported type declaration with spec: %v at %s", spec, positionString(spec.Pos(), file.fset))
+		return nil, file.ErrorNode(spec, "unsupported type declaration")
 	case *ast.TypeSpec:
 		return file.parseAstTypeSpec(spec)
 	}
 }
 
 func (file *WasmGoSourceFile) parseAstTypeSpec(spec *ast.TypeSpec) (WasmType, error) {
-	name := spec.Name.Name
-	if t, ok := file.module.types[name]; ok {
-		return t, nil
-	}
-	switch astType := spec.Type.(type) {
-	default:
-		return nil, file.ErrorNode(spec, "unsupported type declaration: %v", astType)
-	case *ast.FuncType:
-		ty, err := file.parseAstFuncType(astType)
-		if err != nil {
-			return nil, err
-		}
-		ty.setName(name)
-		file.module.types[name] = ty
-		return ty, nil
-	case *ast.StructType:
-		st := &WasmTypeStruct{}
-		st.setName(name)
-		st.setAlign(8)
-		// Insert incomplete the type declaration now to handle recursive types.
-		file.module.types[name] = st
-		return file.parseAstStructType(st, astType)
-	}
-}
-
-func (file *WasmGoSourceFile) parseAstStructType(t *WasmTypeStruct, astType *ast.StructType) (WasmType, error) {
-	if astType.Fields == nil || astType.Fields.List == nil {
-		return nil, fmt.Errorf("struct types with no fields are not supported (struct %s)", t.getName())
-	}
-	astFields := astType.Fields.List
-	t.fields = make([]*WasmField, len(astFields), len(astFields))
-	var offset int
-	for i, astField := range astFields {
-		if len(astField.Names) != 1 {
-			return nil, fmt.Errorf("struct types with multiple fields per type are not supported (struct %s)", t.getName())
-		}
-		field := &WasmField{
-			name:   astField.Names[0].Name,
-			offset: offset,
-		}
-		t.fields[i] = field
-		ty, err := file.parseAstType(astField.Type)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing type of field %s: %v", field.name, err)
-		}
-		field.t = ty
-		offset += ty.getSize() // TODO: Take alignment into account
+	t, err := file.typeOfObject(spec.Name)
+	if err != nil {
+		return nil, file.ErrorNode(spec, "unsupported type declaration: %v", err)
 	}
-	t.setSize(offset)
 	return t, nil
 }
@@ -0,0 +1,368 @@
+package main
+
+// Validation of the code of a decoded module before it runs, following the
+// algorithm in the appendix of the WebAssembly spec: each instruction is
+// checked against the types of the values on the operand stack, so that the
+// interpreter rejects the modules which an engine would reject.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unknownType is the type of a value popped in unreachable code, which
+// matches any type.
+const unknownType byte = 0
+
+// instrType is the type of an instruction, e.g., [i64] -> [i32].
+type instrType struct {
+	params  []byte
+	results []byte
+}
+
+// numericTypes holds the types of the numeric and memory instructions by
+// opcode, and satTypes those of the saturating truncations by index.
+var numericTypes, satTypes = func() (map[byte]instrType, map[uint32]instrType) {
+	numeric := make(map[byte]instrType)
+	for name, op := range opcodes {
+		if t, ok := numericType(name); ok {
+			numeric[op] = t
+		}
+	}
+	sat := make(map[uint32]instrType)
+	for name, sub := range satOpcodes {
+		sat[sub], _ = numericType(name)
+	}
+	return numeric, sat
+}()
+
+// numericType derives the type of an instruction from its name, e.g.,
+// i32.wrap_i64, which takes an i64 and returns an i32.
+func numericType(name string) (instrType, bool) {
+	dot := strings.Index(name, ".")
+	if dot < 0 {
+		return instrType{}, false
+	}
+	t, ok := valueTypeCodes[name[:dot]]
+	if !ok {
+		// E.g., local.get or memory.size.
+		return instrType{}, false
+	}
+	i32 := valueTypeCodes["i32"]
+	op := name[dot+1:]
+	parts := strings.Split(op, "_")
+	switch parts[0] {
+	case "const":
+		return instrType{results: []byte{t}}, true
+	case "load", "load8", "load16", "load32":
+		return instrType{params: []byte{i32}, results: []byte{t}}, true
+	case "store", "store8", "store16", "store32":
+		return instrType{params: []byte{i32, t}}, true
+	case "eqz":
+		return instrType{params: []byte{t}, results: []byte{i32}}, true
+	case "eq", "ne", "lt", "gt", "le", "ge":
+		return instrType{params: []byte{t, t}, results: []byte{i32}}, true
+	}
+	for _, p := range parts[1:] {
+		if from, ok := valueTypeCodes[p]; ok {
+			// A conversion, e.g., f64.convert_i32_s or i32.trunc_sat_f32_u.
+			return instrType{params: []byte{from}, results: []byte{t}}, true
+		}
+	}
+	switch parts[0] {
+	case "clz", "ctz", "popcnt", "abs", "neg", "ceil", "floor", "trunc", "nearest", "sqrt", "extend8", "extend16", "extend32":
+		return instrType{params: []byte{t}, results: []byte{t}}, true
+	}
+	return instrType{params: []byte{t, t}, results: []byte{t}}, true
+}
+
+// ctrlFrame is a block, loop, if or else on the control stack, or the body
+// of the function.
+type ctrlFrame struct {
+	op          byte
+	start       []byte // types of the values the block consumes
+	end         []byte // types of the values the block produces
+	height      int    // height of the operand stack at block entry
+	unreachable bool   // whether the rest of the block is unreachable
+}
+
+// validator checks the code of a function.
+type validator struct {
+	in     *WasmInterpreter
+	name   string
+	locals []byte
+	vals   []byte
+	ctrls  []ctrlFrame
+	pos    int // offset of the instruction in the code
+}
+
+// validate checks the code of all functions of the module.
+func (in *WasmInterpreter) validate() {
+	for i, f := range in.funcs {
+		if f.host != nil {
+			continue
+		}
+		if int(f.typeIdx) >= len(in.types) {
+			panic(fmt.Errorf("invalid module: unknown type %d of function %d", f.typeIdx, i))
+		}
+		name := f.name
+		if name == "" {
+			name = fmt.Sprintf("%d", i)
+		}
+		v := &validator{in: in, name: name}
+		v.check(f)
+	}
+}
+
+func (v *validator) fail(format string, a ...interface{}) {
+	panic(fmt.Errorf("invalid module: %s in function %s at offset %d", fmt.Sprintf(format, a...), v.name, v.pos))
+}
+
+func (v *validator) push(t byte) {
+	v.vals = append(v.vals, t)
+}
+
+func (v *validator) pushAll(ts []byte) {
+	for _, t := range ts {
+		v.push(t)
+	}
+}
+
+func (v *validator) pop() byte {
+	c := &v.ctrls[len(v.ctrls)-1]
+	if len(v.vals) == c.height {
+		if c.unreachable {
+			return unknownType
+		}
+		v.fail("operand stack underflow")
+	}
+	t := v.vals[len(v.vals)-1]
+	v.vals = v.vals[:len(v.vals)-1]
+	return t
+}
+
+func (v *validator) popExpect(expected byte) byte {
+	actual := v.pop()
+	if actual != expected && actual != unknownType && expected != unknownType {
+		want, _ := valueTypeName(expected)
+		got, _ := valueTypeName(actual)
+		v.fail("type mismatch: expected %s, got %s", want, got)
+	}
+	if actual == unknownType {
+		return expected
+	}
+	return actual
+}
+
+func (v *validator) popAll(ts []byte) {
+	for i := len(ts) - 1; i >= 0; i-- {
+		v.popExpect(ts[i])
+	}
+}
+
+func (v *validator) pushCtrl(op byte, start, end []byte) {
+	v.ctrls = append(v.ctrls, ctrlFrame{op: op, start: start, end: end, height: len(v.vals)})
+	v.pushAll(start)
+}
+
+func (v *validator) popCtrl() ctrlFrame {
+	if len(v.ctrls) == 0 {
+		v.fail("unexpected end")
+	}
+	c := v.ctrls[len(v.ctrls)-1]
+	v.popAll(c.end)
+	if len(v.vals) != c.height {
+		v.fail("values left on the operand stack at the end of a block")
+	}
+	v.ctrls = v.ctrls[:len(v.ctrls)-1]
+	return c
+}
+
+// labelTypes returns the types of the values passed by a branch to the
+// frame at the given depth.
+func (v *validator) labelTypes(depth uint32) []byte {
+	if int(depth) >= len(v.ctrls) {
+		v.fail("unknown label %d", depth)
+	}
+	c := v.ctrls[len(v.ctrls)-1-int(depth)]
+	if c.op == opcodes["loop"] {
+		return c.start
+	}
+	return c.end
+}
+
+func (v *validator) setUnreachable() {
+	c := &v.ctrls[len(v.ctrls)-1]
+	v.vals = v.vals[:c.height]
+	c.unreachable = true
+}
+
+// blockType returns the types of the values a block consumes and produces.
+func (v *validator) blockType(r *interpReader) ([]byte, []byte) {
+	b := r.b[r.pos]
+	if b == blockTypeEmpty {
+		r.byte()
+		return nil, nil
+	}
+	if _, ok := valueTypeName(b); ok {
+		r.byte()
+		return nil, []byte{b}
+	}
+	idx := r.s64()
+	if idx < 0 || int(idx) >= len(v.in.types) {
+		v.fail("unknown block type %d", idx)
+	}
+	t := v.in.types[idx]
+	return t.params, t.results
+}
+
+func (v *validator) funcType(idx uint32) interpFuncType {
+	if int(idx) >= len(v.in.types) {
+		v.fail("unknown type %d", idx)
+	}
+	return v.in.types[idx]
+}
+
+func (v *validator) local(r *interpReader) byte {
+	idx := r.u32()
+	if int(idx) >= len(v.locals) {
+		v.fail("unknown local %d", idx)
+	}
+	return v.locals[idx]
+}
+
+func (v *validator) global(r *interpReader) byte {
+	idx := r.u32()
+	if int(idx) >= len(v.in.globalTypes) {
+		v.fail("unknown global %d", idx)
+	}
+	return v.in.globalTypes[idx]
+}
+
+func (v *validator) check(f *interpFunc) {
+	t := v.in.types[f.typeIdx]
+	v.locals = append(append([]byte{}, t.params...), f.locals...)
+	i32 := valueTypeCodes["i32"]
+	r := &interpReader{b: f.code}
+	v.pushCtrl(opcodes["block"], nil, t.results)
+	for len(v.ctrls) > 0 {
+		v.pos = r.pos
+		op := r.byte()
+		switch op {
+		case prefixSaturating:
+			it, ok := satTypes[r.u32()]
+			if !ok {
+				v.fail("unsupported instruction 0x%02x", op)
+			}
+			v.popAll(it.params)
+			v.pushAll(it.results)
+		default:
+			it, ok := numericTypes[op]
+			if !ok {
+				v.fail("unsupported instruction 0x%02x", op)
+			}
+			if op >= opcodes["i32.load"] && op <= opcodes["i64.store32"] && v.in.memory == nil {
+				v.fail("memory access without a memory")
+			}
+			r.pos = skipImmediates(f.code, v.pos)
+			v.popAll(it.params)
+			v.pushAll(it.results)
+		case opcodes["unreachable"]:
+			v.setUnreachable()
+		case opcodes["nop"]:
+		case opcodes["block"], opcodes["loop"]:
+			start, end := v.blockType(r)
+			v.popAll(start)
+			v.pushCtrl(op, start, end)
+		case opcodes["if"]:
+			start, end := v.blockType(r)
+			v.popExpect(i32)
+			v.popAll(start)
+			v.pushCtrl(op, start, end)
+		case opcodes["else"]:
+			c := v.popCtrl()
+			if c.op != opcodes["if"] {
+				v.fail("else without if")
+			}
+			v.pushCtrl(op, c.start, c.end)
+		case opcodes["end"]:
+			c := v.popCtrl()
+			if c.op == opcodes["if"] && string(c.start) != string(c.end) {
+				v.fail("if without else must not change the operand stack")
+			}
+			v.pushAll(c.end)
+		case opcodes["br"]:
+			v.popAll(v.labelTypes(r.u32()))
+			v.setUnreachable()
+		case opcodes["br_if"]:
+			ts := v.labelTypes(r.u32())
+			v.popExpect(i32)
+			v.popAll(ts)
+			v.pushAll(ts)
+		case opcodes["br_table"]:
+			targets := make([]uint32, r.u32())
+			for i := range targets {
+				targets[i] = r.u32()
+			}
+			def := v.labelTypes(r.u32())
+			v.popExpect(i32)
+			for _, target := range targets {
+				ts := v.labelTypes(target)
+				if len(ts) != len(def) {
+					v.fail("br_table targets with different arities")
+				}
+				v.popAll(ts)
+				v.pushAll(ts)
+			}
+			v.popAll(def)
+			v.setUnreachable()
+		case opcodes["return"]:
+			v.popAll(t.results)
+			v.setUnreachable()
+		case opcodes["call"]:
+			idx := r.u32()
+			if int(idx) >= len(v.in.funcs) {
+				v.fail("unknown function %d", idx)
+			}
+			ft := v.funcType(v.in.funcs[idx].typeIdx)
+			v.popAll(ft.params)
+			v.pushAll(ft.results)
+		case opcodes["call_indirect"]:
+			ft := v.funcType(r.u32())
+			r.u32() // table index
+			v.popExpect(i32)
+			v.popAll(ft.params)
+			v.pushAll(ft.results)
+		case opcodes["drop"]:
+			v.pop()
+		case opcodes["select"]:
+			v.popExpect(i32)
+			t1 := v.pop()
+			t2 := v.popExpect(t1)
+			v.push(t2)
+		case opcodes["local.get"]:
+			v.push(v.local(r))
+		case opcodes["local.set"]:
+			v.popExpect(v.local(r))
+		case opcodes["local.tee"]:
+			lt := v.local(r)
+			v.popExpect(lt)
+			v.push(lt)
+		case opcodes["global.get"]:
+			v.push(v.global(r))
+		case opcodes["global.set"]:
+			v.popExpect(v.global(r))
+		case opcodes["memory.size"]:
+			r.byte()
+			v.push(i32)
+		case opcodes["memory.grow"]:
+			r.byte()
+			v.popExpect(i32)
+			v.push(i32)
+		}
+	}
+	if !r.eof() {
+		v.pos = r.pos
+		v.fail("code after the end of the function")
+	}
+}
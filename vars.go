@@ -3,8 +3,10 @@ package main
 import (
 	"fmt"
 	"go/ast"
+	"go/constant"
 	"go/token"
-	"strconv"
+	"go/types"
+	"math"
 )
 
 type WasmGlobalVar struct {
@@ -56,8 +58,16 @@ func (g *WasmGetGlobal) print(writer FormattingWriter) {
 	g.load.print(writer)
 }
 
+func (g *WasmGetGlobal) encode(writer *WasmBinaryWriter) {
+	g.load.encode(writer)
+}
+
 func (g *WasmGetGlobal) getType() WasmType {
-	return g.f.module.variables[g.astIdent.Obj].getType()
+	return g.def.getType()
+}
+
+func (g *WasmGetGlobal) getFullType() WasmType {
+	return g.def.getFullType()
 }
 
 func (g *WasmGetGlobal) getNode() ast.Node {
@@ -68,6 +78,10 @@ func (s *WasmSetGlobal) print(writer FormattingWriter) {
 	s.store.print(writer)
 }
 
+func (s *WasmSetGlobal) encode(writer *WasmBinaryWriter) {
+	s.store.encode(writer)
+}
+
 func (s *WasmSetGlobal) getType() WasmType {
 	return s.lhs.getType()
 }
@@ -81,16 +95,17 @@ func (s *WasmSetGlobal) getNode() ast.Node {
 }
 
 // TODO: refactor parseAstVarDeclGlobal and parseAstVarDeclLocal to share code.
-func (file *WasmGoSourceFile) parseAstVarDeclGlobal(decl *ast.GenDecl, fset *token.FileSet) (WasmVariable, error) {
-	if len(decl.Specs) != 1 {
-		return nil, fmt.Errorf("unsupported variable declaration with %d specs", len(decl.Specs))
-	}
-	switch spec := decl.Specs[0].(type) {
-	default:
-		return nil, fmt.Errorf("unsupported variable declaration with spec: %v at %s", spec, positionString(spec.Pos(), fset))
-	case *ast.ValueSpec:
-		return file.parseAstVarSpecGlobal(spec, fset)
+func (file *WasmGoSourceFile) parseAstVarDeclGlobal(decl *ast.GenDecl) error {
+	var errs GoWasmErrorList
+	for _, spec := range decl.Specs {
+		switch spec := spec.(type) {
+		default:
+			errs.add(file.ErrorNode(spec, "unsupported variable declaration"))
+		case *ast.ValueSpec:
+			errs.add(file.parseAstVarSpecGlobal(spec))
+		}
 	}
+	return errs.err()
 }
 
 func (s *WasmScope) parseAstVarDeclLocal(decl *ast.GenDecl) (WasmVariable, error) {
@@ -105,70 +120,224 @@ func (s *WasmScope) parseAstVarDeclLocal(decl *ast.GenDecl) (WasmVariable, error
 	}
 }
 
-func (file *WasmGoSourceFile) initializeGlobalVarBasicLit(v *WasmGlobalVar, lit *ast.BasicLit) error {
-	switch lit.Kind {
-	default:
-		return file.ErrorNode(lit, "unsupported variable initialization of kind %v", lit.Kind)
-	case token.INT:
-		switch v.getType().getSize() {
-		default:
-			return file.ErrorNode(lit, "unsupported variable initialization of int of size %d", v.getType().getSize())
-		case 4:
-			val, err := strconv.ParseInt(lit.Value, 10, 64)
-			if err != nil {
-				return file.ErrorNode(lit, "couldn't parse int value '%s': %v", lit.Value, err)
+// parseAstVarSpecGlobal allocates the static storage of the variables of a
+// spec. Initial values that are constants are written into the static memory,
+// the others are assigned when the module is instantiated, see inits.go.
+func (file *WasmGoSourceFile) parseAstVarSpecGlobal(spec *ast.ValueSpec) error {
+	var errs GoWasmErrorList
+	vars := make([]ast.Expr, len(spec.Names))
+	static := len(spec.Values) != 1 || len(spec.Names) == 1
+	for i, ident := range spec.Names {
+		vars[i] = ident
+		var value ast.Expr
+		if len(spec.Values) == len(spec.Names) {
+			value = spec.Values[i]
+		}
+		if ident.Name == "_" {
+			if value != nil && file.info.Types[value].Value == nil {
+				file.module.globalInits[value] = &globalInit{file: file, lhs: vars[i : i+1]}
 			}
-			file.module.memory.writeInt32(int(v.addr), int32(val))
-			return nil
+			continue
 		}
-		return file.ErrorNode(lit, "unsupported INT in variable initialization")
+		t, err := file.typeOfObject(ident)
+		if err != nil {
+			errs.add(file.ErrorNode(ident, "unsupported type for variable %s: %v", ident.Name, err))
+			continue
+		}
+		v := &WasmGlobalVar{
+			name:     ident.Name,
+			t:        t,
+			fullType: t,
+			addr:     int32(file.module.memory.allocGlobal(t.getSize(), t.getAlign())),
+			indent:   1,
+		}
+		file.module.variables[file.objectOf(ident)] = v
+		if !static || !file.isStaticValue(value) {
+			if value != nil {
+				file.module.globalInits[value] = &globalInit{file: file, lhs: vars[i : i+1]}
+			}
+			value = nil
+		}
+		if err := file.initializeGlobalVar(int(v.addr), file.objectOf(ident).Type(), value); err != nil {
+			errs.add(err)
+		}
+		if file.pkgName == runtimePath("gc") {
+			// Magic names of global variables of the heap, which the compiler
+			// initializes when the static memory is complete, see finalize.
+			switch ident.Name {
+			case "freePointer":
+				file.module.freePtrAddr = v.addr
+			case "heapEnd":
+				file.module.heapEndAddr = v.addr
+			case "globalRoots":
+				file.module.rootsAddr = v.addr
+			}
+		}
+	}
+	if !static {
+		// The variables are assigned together the results of a call.
+		file.module.globalInits[spec.Values[0]] = &globalInit{file: file, lhs: vars}
 	}
-	return file.ErrorNode(lit, "unsupported BasicLit in variable initialization")
+	return errs.err()
 }
 
-func (file *WasmGoSourceFile) initializeGlobalVar(v *WasmGlobalVar, spec *ast.ValueSpec) error {
-	values := spec.Values
-	if values == nil {
-		return nil // no initializer
+// isStaticValue returns whether the initial value of a global variable can be
+// written into the static memory: it is a constant, nil, or a composite literal
+// of an array or a struct with such values.
+func (file *WasmGoSourceFile) isStaticValue(expr ast.Expr) bool {
+	if expr == nil {
+		return true
 	}
-	if len(values) != 1 {
-		return file.ErrorNode(spec, fmt.Sprintf("unsupported variable declaration with %d values", len(values)))
+	tv := file.info.Types[expr]
+	if tv.IsNil() {
+		return true
 	}
-
-	switch val := values[0].(type) {
+	if tv.Value != nil {
+		_, ok := tv.Type.Underlying().(*types.Basic)
+		return ok && tv.Value.Kind() != constant.Complex
+	}
+	lit, ok := ast.Unparen(expr).(*ast.CompositeLit)
+	if !ok {
+		return false
+	}
+	switch tv.Type.Underlying().(type) {
 	default:
-		return file.ErrorNode(val, "unsupported variable initialization")
-	case *ast.BasicLit:
-		return file.initializeGlobalVarBasicLit(v, val)
+		return false
+	case *types.Array, *types.Struct:
 	}
+	for _, elt := range lit.Elts {
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			elt = kv.Value
+		}
+		if !file.isStaticValue(elt) {
+			return false
+		}
+	}
+	return true
 }
 
-func (file *WasmGoSourceFile) parseAstVarSpecGlobal(spec *ast.ValueSpec, fset *token.FileSet) (WasmVariable, error) {
-	if len(spec.Names) != 1 {
-		return nil, fmt.Errorf("unsupported variable declaration with %d names", len(spec.Names))
+// initializeGlobalArray writes the elements of an array of type t at elems.
+// They are the values in the composite literal lit, or zero if lit is nil. The
+// elements of an array laid out inline in a struct are inline too, if they are
+// arrays.
+func (file *WasmGoSourceFile) initializeGlobalArray(elems int, t *types.Array, lit *ast.CompositeLit, inline bool) error {
+	size := int(wasmSizes{}.Sizeof(t.Elem()))
+	if _, ok := t.Elem().Underlying().(*types.Array); ok && !inline {
+		size = 4 // the address of the elements
 	}
-	ident := spec.Names[0]
-	name := ident.Name
-	t, err := file.parseAstType(spec.Type)
-	if err != nil {
-		return nil, fmt.Errorf("unsupported type for variable %s", name)
+	values := make([]ast.Expr, t.Len())
+	if lit != nil {
+		i := 0
+		for _, elt := range lit.Elts {
+			if kv, ok := elt.(*ast.KeyValueExpr); ok {
+				var err error
+				i, err = file.evaluateIntConstant(kv.Key)
+				if err != nil {
+					return file.ErrorNode(kv.Key, "%v", err)
+				}
+				elt = kv.Value
+			}
+			values[i] = elt
+			i++
+		}
 	}
-	v := &WasmGlobalVar{
-		name:   name,
-		t:      t,
-		addr:   int32(file.module.memory.allocGlobal(t.getSize(), t.getAlign())),
-		indent: 1,
+	for i, v := range values {
+		if err := file.initializeGlobalElem(elems+i*size, t.Elem(), v, inline); err != nil {
+			return err
+		}
 	}
-	file.module.variables[ident.Obj] = v
-	err = file.initializeGlobalVar(v, spec)
+	return nil
+}
+
+// initializeGlobalElem initializes a field of a struct or an element of an
+// array, which is laid out inline if it is an array and inline is true.
+func (file *WasmGoSourceFile) initializeGlobalElem(addr int, t types.Type, value ast.Expr, inline bool) error {
+	a, ok := t.Underlying().(*types.Array)
+	if !ok || !inline {
+		return file.initializeGlobalVar(addr, t, value)
+	}
+	var lit *ast.CompositeLit
+	if value != nil {
+		lit, _ = ast.Unparen(value).(*ast.CompositeLit)
+	}
+	return file.initializeGlobalArray(addr, a, lit, true)
+}
+
+// initializeGlobalVar writes the initial value of a variable of type t at addr
+// into the static memory. The elements of arrays, which are referenced by
+// their address, are allocated in the static memory too. A nil value stands
+// for the zero value.
+func (file *WasmGoSourceFile) initializeGlobalVar(addr int, t types.Type, value ast.Expr) error {
+	memory := file.module.memory
+	ty, err := file.convertType(t)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if name == "freePointer" {
-		// This is a magic name of a global variable used for allocating memory from the heap.
-		file.module.freePtrAddr = v.addr
+	var lit *ast.CompositeLit
+	if value != nil {
+		lit, _ = ast.Unparen(value).(*ast.CompositeLit)
 	}
-	return v, nil
+	switch u := t.Underlying().(type) {
+	case *types.Array:
+		elemType := ty.(*WasmTypeArray).elementType
+		elems := memory.allocGlobal(int(u.Len())*elemType.getSize(), elemType.getAlign())
+		memory.writeInt32(addr, int32(elems))
+		return file.initializeGlobalArray(elems, u, lit, false)
+	case *types.Struct:
+		st, ok := ty.(*WasmTypeStruct)
+		if !ok {
+			return fmt.Errorf("unsupported type of a global variable: %v", t)
+		}
+		values := make([]ast.Expr, u.NumFields())
+		if lit != nil {
+			for i, elt := range lit.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					name := kv.Key.(*ast.Ident).Name
+					for j := range st.fields {
+						if st.fields[j].name == name {
+							i = j
+						}
+					}
+					elt = kv.Value
+				}
+				values[i] = elt
+			}
+		}
+		for i, v := range values {
+			if err := file.initializeGlobalElem(addr+st.fields[i].offset, u.Field(i).Type(), v, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if value == nil || file.info.Types[value].IsNil() {
+		return nil // the static memory is zeroed
+	}
+	val := file.info.Types[value].Value
+	var bits uint64
+	switch {
+	case val.Kind() == constant.String:
+		bits = uint64(file.module.stringLiteral(constant.StringVal(val)))
+	case val.Kind() == constant.Bool:
+		if constant.BoolVal(val) {
+			bits = 1
+		}
+	case ty.isFloat() && ty.getSize() == 4:
+		f, _ := constant.Float32Val(constant.ToFloat(val))
+		bits = uint64(math.Float32bits(f))
+	case ty.isFloat():
+		f, _ := constant.Float64Val(constant.ToFloat(val))
+		bits = math.Float64bits(f)
+	default:
+		val = constant.ToInt(val)
+		if i, exact := constant.Int64Val(val); exact {
+			bits = uint64(i)
+		} else {
+			bits, _ = constant.Uint64Val(val)
+		}
+	}
+	memory.writeUint(addr, ty.getSize(), bits)
+	return nil
 }
 
 func (s *WasmScope) parseAstVarSpecLocal(spec *ast.ValueSpec) (WasmVariable, error) {
@@ -177,7 +346,7 @@ func (s *WasmScope) parseAstVarSpecLocal(spec *ast.ValueSpec) (WasmVariable, err
 	}
 	ident := spec.Names[0]
 	name := ident.Name
-	t, err := s.f.file.parseAstType(spec.Type)
+	t, err := s.f.file.typeOfObject(ident)
 	if err != nil {
 		return nil, s.f.file.ErrorNode(spec, "unsupported type for variable %s: %v", name, err)
 	}
@@ -185,12 +354,24 @@ func (s *WasmScope) parseAstVarSpecLocal(spec *ast.ValueSpec) (WasmVariable, err
 }
 
 func (s *WasmScope) createLocalVar(ident *ast.Ident, ty WasmType) (WasmVariable, error) {
+	if obj := s.f.file.objectOf(ident); s.f.captured[obj] && isCaptureByCell(obj.Type()) {
+		return s.createCellVar(ident, ty)
+	}
 	v := &WasmLocal{
 		astIdent: ident,
 		name:     astNameToWASM(ident.Name, s),
 		t:        ty,
 	}
-	s.f.module.variables[ident.Obj] = v
+	s.f.module.variables[s.f.file.objectOf(ident)] = v
 	s.f.locals = append(s.f.locals, v)
 	return v, nil
 }
+
+// createTempVar creates a local for a value computed by the compiler, e.g., the
+// tag of a switch statement. It has no counterpart in the Go source.
+func (s *WasmScope) createTempVar(name string, ty WasmType) (WasmVariable, error) {
+	name = fmt.Sprintf("%s%d", name, len(s.f.locals))
+	ident := ast.NewIdent(name)
+	s.f.file.info.Defs[ident] = types.NewVar(token.NoPos, nil, name, nil)
+	return s.createLocalVar(ident, ty)
+}